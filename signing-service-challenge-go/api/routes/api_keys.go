@@ -0,0 +1,140 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+	"net/http"
+	"time"
+)
+
+type CreateAPIKeyRequest struct {
+	Name       string   `json:"name,omitempty"`
+	TenantID   string   `json:"tenant_id,omitempty"` // none = the operator of the service
+	DeviceIDs  []string `json:"device_ids"`          // "*" = every device of the tenant
+	Operations []string `json:"operations"`          // create, sign, verify, list and/or admin
+}
+
+func (request *CreateAPIKeyRequest) UnmarshalJSON(data []byte) error {
+	type Alias CreateAPIKeyRequest // Avoid recursion
+	aux := &struct {
+		*Alias
+	}{
+		Alias: (*Alias)(request),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if len(request.DeviceIDs) == 0 {
+		return errors.New("Device IDs are required")
+	}
+	if len(request.Operations) == 0 {
+		return errors.New("Operations are required")
+	}
+
+	return nil
+}
+
+// APIKeyView is the public representation of an API key, its hash is never shown
+type APIKeyView struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name,omitempty"`
+	TenantID   string    `json:"tenant_id,omitempty"`
+	DeviceIDs  []string  `json:"device_ids"`
+	Operations []string  `json:"operations"`
+	CreatedAt  time.Time `json:"created_at"`
+	// The key itself, only returned on creation
+	Key string `json:"key,omitempty"`
+}
+
+// NewAPIKeyView builds the public representation of @key
+func NewAPIKeyView(key *domain.APIKey) APIKeyView {
+	return APIKeyView{
+		ID:         key.ID,
+		Name:       key.Name,
+		TenantID:   key.TenantID,
+		DeviceIDs:  key.DeviceIDs,
+		Operations: key.Operations,
+		CreatedAt:  key.CreatedAt,
+	}
+}
+
+type ListAPIKeysResponse struct {
+	APIKeys []APIKeyView `json:"api_keys"`
+}
+
+// CreateAPIKey creates an API key, the response is the only time the key itself is shown
+func CreateAPIKey(response http.ResponseWriter, request *http.Request) {
+	var input CreateAPIKeyRequest
+	if err := common.ParseJSONRequestBody(request.Body, &input); err != nil {
+		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
+			err.Error(),
+		})
+		return
+	}
+
+	key, secret, err := service.CreateAPIKey(request.Context(), service.CreateAPIKeyInput{
+		Name:       input.Name,
+		TenantID:   input.TenantID,
+		DeviceIDs:  input.DeviceIDs,
+		Operations: input.Operations,
+	})
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	output := NewAPIKeyView(key)
+	output.Key = secret
+	response.Header().Set("Location", "/api/v1/admin/api-keys/"+key.ID)
+	common.WriteAPIResponse(response, http.StatusCreated, output)
+}
+
+// ListAPIKeys lists all API keys
+func ListAPIKeys(response http.ResponseWriter, request *http.Request) {
+	keys, err := service.ListAPIKeys(request.Context())
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	output := ListAPIKeysResponse{
+		APIKeys: make([]APIKeyView, 0, len(keys)),
+	}
+	for _, key := range keys {
+		output.APIKeys = append(output.APIKeys, NewAPIKeyView(key))
+	}
+	common.WriteAPIResponse(response, http.StatusOK, output)
+}
+
+// GetAPIKey returns the API key {id}
+func GetAPIKey(response http.ResponseWriter, request *http.Request) {
+	key, err := service.GetAPIKey(request.Context(), request.PathValue("id"))
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	common.WriteAPIResponse(response, http.StatusOK, NewAPIKeyView(key))
+}
+
+// DeleteAPIKey revokes the API key {id}
+func DeleteAPIKey(response http.ResponseWriter, request *http.Request) {
+	if err := service.DeleteAPIKey(request.Context(), request.PathValue("id")); err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func init() {
+	common.RegisterRoute("POST /api/v1/admin/api-keys", CreateAPIKey)
+	common.RegisterRoute("GET /api/v1/admin/api-keys", ListAPIKeys)
+	common.RegisterRoute("GET /api/v1/admin/api-keys/{id}", GetAPIKey)
+	common.RegisterRoute("DELETE /api/v1/admin/api-keys/{id}", DeleteAPIKey)
+}
@@ -0,0 +1,59 @@
+package routes
+
+import (
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/chain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+	"net/http"
+)
+
+type AuditBreak struct {
+	Counter int    `json:"counter"`
+	Kind    string `json:"kind"` // gap, fork, tampered or bad_signature
+	Message string `json:"message"`
+}
+
+type AuditChainResponse struct {
+	DeviceID         string      `json:"device_id"`
+	Valid            bool        `json:"valid"`
+	Complete         bool        `json:"complete"` // false = the chain could not be checked from its seed
+	FirstCounter     int         `json:"first_counter"`
+	Checked          int         `json:"checked"`
+	SignatureCounter int         `json:"signature_counter"`
+	Break            *AuditBreak `json:"break,omitempty"` // first broken link, empty if valid
+}
+
+// NewAuditChainResponse builds the public representation of @report
+func NewAuditChainResponse(report *chain.Report) AuditChainResponse {
+	output := AuditChainResponse{
+		DeviceID:         report.DeviceID,
+		Valid:            report.Valid,
+		Complete:         report.Complete,
+		FirstCounter:     report.FirstCounter,
+		Checked:          report.Checked,
+		SignatureCounter: report.SignatureCounter,
+	}
+	if report.Break != nil {
+		output.Break = &AuditBreak{
+			Counter: report.Break.Counter,
+			Kind:    string(report.Break.Kind),
+			Message: report.Break.Message,
+		}
+	}
+	return output
+}
+
+// AuditChain verifies the whole signature chain of device {id}, reporting the first broken link if any
+func AuditChain(response http.ResponseWriter, request *http.Request) {
+	report, err := service.AuditChain(request.Context(), request.PathValue("id"))
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	common.WriteAPIResponse(response, http.StatusOK, NewAuditChainResponse(report))
+}
+
+func init() {
+	common.RegisterRoute("GET /api/v1/devices/{id}/audit", AuditChain)
+}
@@ -0,0 +1,72 @@
+package routes_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/routes"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/chain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+type auditAPIResponse struct {
+	Data routes.AuditChainResponse `json:"data"`
+}
+
+func TestAuditChain(t *testing.T) {
+	Convey("Given a device that signed 3 transactions", t, func() {
+		db := persistence.NewInMemoryDB()
+		persistence.SetInstance(db)
+		So(serveMux(http.MethodPost, "/api/v1/devices/till", `{"algorithm":"ecc"}`).Code, ShouldEqual, http.StatusCreated)
+		for _, data := range []string{"a", "b", "c"} {
+			So(serveMux(http.MethodPost, "/api/v1/devices/till/transactions", `{"data":"`+data+`"}`).Code, ShouldEqual, http.StatusCreated)
+		}
+
+		Convey("the audit reports an intact chain", func() {
+			rec := serveMux(http.MethodGet, "/api/v1/devices/till/audit", "")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			var resp auditAPIResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &resp), ShouldBeNil)
+			So(resp.Data.Valid, ShouldBeTrue)
+			So(resp.Data.Complete, ShouldBeTrue)
+			So(resp.Data.Checked, ShouldEqual, 3)
+			So(resp.Data.Break, ShouldBeNil)
+		})
+
+		Convey("the audit reports the first transaction not signed by the device", func() {
+			device, err := db.Load("till")
+			So(err, ShouldBeNil)
+			expectedVersion := device.Version
+			forged := &domain.Transaction{
+				DeviceID:   "till",
+				Counter:    3,
+				Data:       "forged",
+				SignedData: chain.FormatSignedData(3, "forged", device.LastSignature),
+				Signature:  "c2ln",
+			}
+			device.SignatureCounter++
+			device.LastSignature = forged.Signature
+			So(db.CompareAndSaveWithTransaction("till", device, expectedVersion, forged, nil), ShouldBeNil)
+
+			rec := serveMux(http.MethodGet, "/api/v1/devices/till/audit", "")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			var resp auditAPIResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &resp), ShouldBeNil)
+			So(resp.Data.Valid, ShouldBeFalse)
+			So(resp.Data.Checked, ShouldEqual, 3)
+			So(resp.Data.Break, ShouldNotBeNil)
+			So(resp.Data.Break.Counter, ShouldEqual, 3)
+			So(resp.Data.Break.Kind, ShouldEqual, "bad_signature")
+		})
+
+		Convey("auditing an unknown device is not found", func() {
+			So(serveMux(http.MethodGet, "/api/v1/devices/other/audit", "").Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// BackupChecksumHeader carries the checksum of the backup being downloaded, as in its manifest
+	BackupChecksumHeader = "Backup-Checksum"
+	// BackupPlainPrivateKeysHeader carries the number of private keys in plain in the backup being downloaded
+	BackupPlainPrivateKeysHeader = "Backup-Plain-Private-Keys"
+
+	// Paths of the backup endpoints, only served when admins can authenticate, see api/server
+	BackupPath  = "/api/v1/admin/backup"
+	RestorePath = "/api/v1/admin/restore"
+)
+
+// BackupView is the public representation of the manifest of a backup
+type BackupView struct {
+	Format       string    `json:"format"`
+	Version      int       `json:"version"`
+	CreatedAt    time.Time `json:"created_at"`
+	Devices      int       `json:"devices"`
+	Transactions int       `json:"transactions"`
+	Tenants      int       `json:"tenants"`
+	APIKeys      int       `json:"api_keys"`
+	Checksum     string    `json:"checksum"`
+	// What the archive holds, it has private keys
+	Contents         string `json:"contents,omitempty"`
+	PlainPrivateKeys int    `json:"plain_private_keys"`
+}
+
+// NewBackupView builds the public representation of @manifest
+func NewBackupView(manifest *persistence.BackupManifest) BackupView {
+	return BackupView{
+		Format:       manifest.Format,
+		Version:      manifest.Version,
+		CreatedAt:    manifest.CreatedAt,
+		Devices:      manifest.Devices,
+		Transactions: manifest.Transactions,
+		Tenants:      manifest.Tenants,
+		APIKeys:      manifest.APIKeys,
+		Checksum:     manifest.Checksum,
+
+		Contents:         manifest.Contents,
+		PlainPrivateKeys: manifest.PlainPrivateKeys,
+	}
+}
+
+// CreateBackup downloads a backup archive of every device with its private key and its transactions, of the
+// tenants and of the API keys
+func CreateBackup(response http.ResponseWriter, request *http.Request) {
+	backup, err := service.CreateBackup(request.Context())
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/gzip")
+	response.Header().Set("Content-Disposition", `attachment; filename="signing-service-backup-`+backup.Manifest.CreatedAt.Format("20060102T150405Z")+`.tar.gz"`)
+	response.Header().Set(BackupChecksumHeader, backup.Manifest.Checksum)
+	response.Header().Set(BackupPlainPrivateKeysHeader, strconv.Itoa(backup.Manifest.PlainPrivateKeys))
+	if backup.Manifest.PlainPrivateKeys > 0 {
+		slog.Warn("Backup holds private keys in plain", "devices", backup.Manifest.PlainPrivateKeys)
+	}
+	response.WriteHeader(http.StatusOK)
+	if err := backup.Write(response); err != nil {
+		// too late for an error response, the client gets a truncated archive it cannot read
+		slog.Error("Could not write backup", "error", err)
+	}
+}
+
+// RestoreBackup restores the backup archive in the request body into the storage, which must have no device.
+// Bodies larger than persistence.MaxBackupSize are refused.
+func RestoreBackup(response http.ResponseWriter, request *http.Request) {
+	if request.ContentLength > persistence.MaxBackupSize {
+		common.WriteErrorResponse(response, http.StatusRequestEntityTooLarge, []string{
+			"Backup archive is larger than " + strconv.Itoa(persistence.MaxBackupSize) + " bytes",
+		})
+		return
+	}
+
+	body := http.MaxBytesReader(response, request.Body, persistence.MaxBackupSize)
+	manifest, err := service.RestoreBackup(request.Context(), body)
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	common.WriteAPIResponse(response, http.StatusOK, NewBackupView(manifest))
+}
+
+func init() {
+	common.RegisterRoute("GET "+BackupPath, CreateBackup)
+	common.RegisterRoute("POST "+RestorePath, RestoreBackup)
+}
@@ -0,0 +1,84 @@
+package routes_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/routes"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+// serveMuxAsAdmin is serveMux for a request authenticated with the admin API key
+func serveMuxAsAdmin(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	admin := auth.NewPrincipal("admin API key", []string{auth.AllDevices}, auth.AdminOperations)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), admin))
+	rec := httptest.NewRecorder()
+	common.Mux().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestBackup(t *testing.T) {
+	Convey("Given a device that signed", t, func() {
+		persistence.SetInstance(persistence.NewInMemoryDB())
+		So(serveMux(http.MethodPost, "/api/v1/devices/till-1", `{"algorithm":"ed25519"}`).Code, ShouldEqual, http.StatusCreated)
+		So(serveMux(http.MethodPost, "/api/v1/devices/till-1/transactions", `{"data":"receipt"}`).Code, ShouldEqual, http.StatusCreated)
+
+		Convey("GET /api/v1/admin/backup downloads a backup archive", func() {
+			rec := serveMuxAsAdmin(http.MethodGet, "/api/v1/admin/backup", "")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/gzip")
+			So(rec.Header().Get("Content-Disposition"), ShouldContainSubstring, ".tar.gz")
+			checksum := rec.Header().Get(routes.BackupChecksumHeader)
+			So(checksum, ShouldHaveLength, 64)
+			So(rec.Header().Get(routes.BackupPlainPrivateKeysHeader), ShouldEqual, "1")
+			archive := rec.Body.String()
+
+			Convey("which POST /api/v1/admin/restore restores into an empty storage only", func() {
+				So(serveMuxAsAdmin(http.MethodPost, "/api/v1/admin/restore", archive).Code, ShouldEqual, http.StatusConflict)
+
+				persistence.SetInstance(persistence.NewInMemoryDB())
+				rec := serveMuxAsAdmin(http.MethodPost, "/api/v1/admin/restore", archive)
+				So(rec.Code, ShouldEqual, http.StatusOK)
+				var resp struct {
+					Data routes.BackupView `json:"data"`
+				}
+				So(json.Unmarshal(rec.Body.Bytes(), &resp), ShouldBeNil)
+				So(resp.Data.Devices, ShouldEqual, 1)
+				So(resp.Data.Transactions, ShouldEqual, 1)
+				So(resp.Data.Checksum, ShouldEqual, checksum)
+				So(resp.Data.Contents, ShouldEqual, persistence.BackupContents)
+				So(resp.Data.PlainPrivateKeys, ShouldEqual, 1)
+
+				So(serveMux(http.MethodGet, "/api/v1/devices/till-1/transactions/0", "").Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("both are forbidden to callers that are not authenticated", func() {
+			So(serveMux(http.MethodGet, "/api/v1/admin/backup", "").Code, ShouldEqual, http.StatusForbidden)
+			So(serveMux(http.MethodPost, "/api/v1/admin/restore", "").Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("POST /api/v1/admin/restore rejects what is not a backup archive", func() {
+			persistence.SetInstance(persistence.NewInMemoryDB())
+			rec := serveMuxAsAdmin(http.MethodPost, "/api/v1/admin/restore", "not an archive")
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Body.String(), ShouldContainSubstring, "not a gzipped archive")
+		})
+
+		Convey("POST /api/v1/admin/restore rejects bodies larger than a backup may be", func() {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/restore", bytes.NewBufferString("too large"))
+			req.ContentLength = persistence.MaxBackupSize + 1
+			rec := httptest.NewRecorder()
+			routes.RestoreBackup(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+	})
+}
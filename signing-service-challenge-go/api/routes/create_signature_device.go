@@ -1,21 +1,20 @@
 package routes
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
-	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
-	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
 	"net/http"
 )
 
 type CreateSignatureDeviceRequest struct {
-	DeviceID  string  `json:"device_id"`
-	Algorithm string  `json:"algorithm"`
-	Label     *string `json:"label,omitempty"`
-	Update    *bool   `json:"update,omitempty"` // empty = false, true must be explicitly given
+	DeviceID   string             `json:"device_id"`
+	Algorithm  string             `json:"algorithm"`
+	Parameters *crypto.Parameters `json:"parameters,omitempty"` // empty = algorithm defaults
+	Label      *string            `json:"label,omitempty"`
+	Update     *bool              `json:"update,omitempty"` // empty = false, true must be explicitly given
 }
 
 func (request *CreateSignatureDeviceRequest) UnmarshalJSON(data []byte) error {
@@ -41,6 +40,7 @@ func (request *CreateSignatureDeviceRequest) UnmarshalJSON(data []byte) error {
 }
 
 type CreateSignatureDeviceResponse struct {
+	Device DeviceView `json:"device"`
 }
 
 // CreateSignatureDevice creates a signature device on the system using user selected algorihm, optionally labeling it for display
@@ -60,65 +60,28 @@ func CreateSignatureDevice(response http.ResponseWriter, request *http.Request)
 		return
 	}
 
-	db := persistence.GetInstance()
-	_, err := db.Load(input.DeviceID)
-	if err == nil && (input.Update == nil || !*input.Update) {
+	update := input.Update != nil && *input.Update
+	device, err := service.CreateDevice(request.Context(), service.CreateDeviceInput{
+		ID:         input.DeviceID,
+		Algorithm:  input.Algorithm,
+		Parameters: input.Parameters,
+		Label:      input.Label,
+		Update:     update,
+	})
+	var alreadyExists *service.AlreadyExistsError
+	if errors.As(err, &alreadyExists) {
 		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
-			"Device with ID " + input.DeviceID + `already exists, if you want to update, supply "update":true in the request body`,
+			"Device with ID " + input.DeviceID + ` already exists, if you want to update, supply "update":true in the request body`,
 		})
 		return
 	}
-
-	algo := crypto.GetAlgorithm(input.Algorithm)
-	if algo == nil {
-		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
-			"Algorithm " + input.Algorithm + " not available",
-		})
-		return
-	}
-
-	keyPair, err := algo.GenerateKeyPair()
-	if err != nil {
-		common.WriteErrorResponse(response, http.StatusInternalServerError, []string{
-			"Something is wrong on our side, please try again in a few moments, our development team has been notified",
-		})
-		// log err to system log, email, prometheus, whatever, skipped for brevity
-		return
-	}
-
-	_, serializedPrivateKey, err := keyPair.Serialize()
-	if err != nil {
-		common.WriteErrorResponse(response, http.StatusInternalServerError, []string{
-			"Something is wrong on our side, please try again in a few moments, our development team has been notified",
-		})
-		// log err to system log, email, prometheus, whatever, skipped for brevity
-		return
-	}
-
-	label := ""
-	if input.Label != nil {
-		label = *input.Label
-	}
-	device := domain.Device{
-		ID:               input.DeviceID,
-		Algorithm:        input.Algorithm,
-		PrivateKey:       serializedPrivateKey,
-		Label:            label,
-		SignatureCounter: 0,
-		LastSignature:    base64.StdEncoding.EncodeToString([]byte(input.DeviceID)),
-	}
-
-	err = db.Save(device.ID, &device)
 	if err != nil {
-		common.WriteErrorResponse(response, http.StatusInternalServerError, []string{
-			"Something is wrong on our side, please try again in a few moments, our development team has been notified",
-		})
-		// log err to system log, email, prometheus, whatever, skipped for brevity
+		writeServiceError(response, err)
 		return
 	}
 
 	output := CreateSignatureDeviceResponse{
-		// feel free to add something else if required
+		Device: NewDeviceView(device),
 	}
 	common.WriteAPIResponse(response, http.StatusOK, output)
 }
@@ -66,6 +66,38 @@ func TestCreateSignatureDevice(t *testing.T) {
 			So(rec.Body.String(), ShouldContainSubstring, "not available")
 		})
 
+		Convey("returns 400 if parameters are not supported by the algorithm", func() {
+			mockDB.EXPECT().Load("devParams").Return(nil, errors.New("Device with id devParams not found"))
+
+			body := []byte(`{"device_id":"devParams","algorithm":"RSA","parameters":{"key_size":1024}}`)
+			req := httptest.NewRequest(http.MethodPost, "/api/v0/create_signature_device", bytes.NewBuffer(body))
+			rec := httptest.NewRecorder()
+
+			routes.CreateSignatureDevice(rec, req)
+
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Body.String(), ShouldContainSubstring, "Invalid parameters")
+		})
+
+		Convey("persists the effective parameters of a configurable algorithm", func() {
+			mockDB.EXPECT().Load("devECC").Return(nil, errors.New("Device with id devECC not found"))
+			var saved *domain.Device
+			mockDB.EXPECT().CompareAndSave("devECC", gomock.Any(), 0).DoAndReturn(func(id string, device *domain.Device, expectedVersion int) error {
+				saved = device
+				return nil
+			})
+
+			body := []byte(`{"device_id":"devECC","algorithm":"ecc","parameters":{"curve":"P-256"}}`)
+			req := httptest.NewRequest(http.MethodPost, "/api/v0/create_signature_device", bytes.NewBuffer(body))
+			rec := httptest.NewRecorder()
+
+			routes.CreateSignatureDevice(rec, req)
+
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(saved, ShouldNotBeNil)
+			So(saved.Parameters, ShouldResemble, crypto.Parameters{Curve: "P-256", Hash: "SHA-256"})
+		})
+
 		Convey("returns 400 if device_id given but algorithm missing", func() {
 			body := []byte(`{"device_id":"devOnly"}`)
 			req := httptest.NewRequest(http.MethodPost, "/api/v0/create_signature_device", bytes.NewBuffer(body))
@@ -92,7 +124,7 @@ func TestCreateSignatureDevice(t *testing.T) {
 			mockDB.EXPECT().Load("devOK").Return(nil, errors.New("Device with id devOK not found"))
 			mockAlgo.EXPECT().GenerateKeyPair().Return(mockKeyPair, nil)
 			mockKeyPair.EXPECT().Serialize().Return([]byte("pub"), []byte("priv"), nil)
-			mockDB.EXPECT().Save("devOK", gomock.Any()).Return(nil)
+			mockDB.EXPECT().CompareAndSave("devOK", gomock.Any(), 0).Return(nil)
 
 			body := []byte(`{"device_id":"devOK","algorithm":"RSA"}`)
 			req := httptest.NewRequest(http.MethodPost, "/api/v0/create_signature_device", bytes.NewBuffer(body))
@@ -108,7 +140,7 @@ func TestCreateSignatureDevice(t *testing.T) {
 			mockDB.EXPECT().Load("devOK").Return(nil, errors.New("Device with id devOK not found"))
 			mockAlgo.EXPECT().GenerateKeyPair().Return(mockKeyPair, nil)
 			mockKeyPair.EXPECT().Serialize().Return([]byte("pub"), []byte("priv"), nil)
-			mockDB.EXPECT().Save("devOK", gomock.Any()).Return(nil)
+			mockDB.EXPECT().CompareAndSave("devOK", gomock.Any(), 0).Return(nil)
 
 			body := []byte(`{"device_id":"devOK","algorithm":"RSA","label":"XXX"}`)
 			req := httptest.NewRequest(http.MethodPost, "/api/v0/create_signature_device", bytes.NewBuffer(body))
@@ -138,7 +170,7 @@ func TestCreateSignatureDevice(t *testing.T) {
 			mockDB.EXPECT().Load("devSerializeErr").Return(nil, errors.New("Device with id devOK not found"))
 			mockAlgo.EXPECT().GenerateKeyPair().Return(mockKeyPair, nil)
 			mockKeyPair.EXPECT().Serialize().Return(nil, nil, errors.New("serialize fail"))
-			// Save should NOT be called because Serialize fails before Save; no Save expectation
+			// CompareAndSave should NOT be called because Serialize fails before it; no CompareAndSave expectation
 
 			body := []byte(`{"device_id":"devSerializeErr","algorithm":"RSA"}`)
 			req := httptest.NewRequest(http.MethodPost, "/api/v0/create_signature_device", bytes.NewBuffer(body))
@@ -150,11 +182,57 @@ func TestCreateSignatureDevice(t *testing.T) {
 			So(rec.Body.String(), ShouldContainSubstring, "please try again")
 		})
 
-		Convey("returns 500 if db.Save fails", func() {
+		Convey("returns 500 if the key pair has no public key", func() {
+			mockDB.EXPECT().Load("devNoPub").Return(nil, errors.New("Device with id devNoPub not found"))
+			mockAlgo.EXPECT().GenerateKeyPair().Return(mockKeyPair, nil)
+			mockKeyPair.EXPECT().Serialize().Return(nil, []byte("priv"), nil)
+
+			body := []byte(`{"device_id":"devNoPub","algorithm":"RSA"}`)
+			req := httptest.NewRequest(http.MethodPost, "/api/v0/create_signature_device", bytes.NewBuffer(body))
+			rec := httptest.NewRecorder()
+
+			routes.CreateSignatureDevice(rec, req)
+
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+			So(rec.Body.String(), ShouldContainSubstring, "please try again")
+		})
+
+		Convey("returns 409 if the device appeared concurrently", func() {
+			mockDB.EXPECT().Load("devRace").Return(nil, errors.New("Device with id devRace not found"))
+			mockAlgo.EXPECT().GenerateKeyPair().Return(mockKeyPair, nil)
+			mockKeyPair.EXPECT().Serialize().Return([]byte("pub"), []byte("priv"), nil)
+			mockDB.EXPECT().CompareAndSave("devRace", gomock.Any(), 0).Return(&persistence.VersionConflictError{ID: "devRace", Actual: 1})
+
+			body := []byte(`{"device_id":"devRace","algorithm":"RSA"}`)
+			req := httptest.NewRequest(http.MethodPost, "/api/v0/create_signature_device", bytes.NewBuffer(body))
+			rec := httptest.NewRecorder()
+
+			routes.CreateSignatureDevice(rec, req)
+
+			So(rec.Code, ShouldEqual, http.StatusConflict)
+			So(rec.Body.String(), ShouldContainSubstring, "modified concurrently")
+		})
+
+		Convey("updates an existing device expecting its current version", func() {
+			mockDB.EXPECT().Load("devUpd").Return(&domain.Device{ID: "devUpd", Status: domain.DeviceActive, Version: 3}, nil)
+			mockAlgo.EXPECT().GenerateKeyPair().Return(mockKeyPair, nil)
+			mockKeyPair.EXPECT().Serialize().Return([]byte("pub"), []byte("priv"), nil)
+			mockDB.EXPECT().CompareAndSave("devUpd", gomock.Any(), 3).Return(nil)
+
+			body := []byte(`{"device_id":"devUpd","algorithm":"RSA","update":true}`)
+			req := httptest.NewRequest(http.MethodPost, "/api/v0/create_signature_device", bytes.NewBuffer(body))
+			rec := httptest.NewRecorder()
+
+			routes.CreateSignatureDevice(rec, req)
+
+			So(rec.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("returns 500 if db.CompareAndSave fails", func() {
 			mockDB.EXPECT().Load("devSaveErr").Return(nil, errors.New("Device with id devOK not found"))
 			mockAlgo.EXPECT().GenerateKeyPair().Return(mockKeyPair, nil)
 			mockKeyPair.EXPECT().Serialize().Return([]byte("pub"), []byte("priv"), nil)
-			mockDB.EXPECT().Save("devSaveErr", gomock.Any()).Return(errors.New("disk full"))
+			mockDB.EXPECT().CompareAndSave("devSaveErr", gomock.Any(), 0).Return(errors.New("disk full"))
 
 			body := []byte(`{"device_id":"devSaveErr","algorithm":"RSA"}`)
 			req := httptest.NewRequest(http.MethodPost, "/api/v0/create_signature_device", bytes.NewBuffer(body))
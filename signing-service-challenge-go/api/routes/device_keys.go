@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+	"net/http"
+	"time"
+)
+
+type RotateDeviceKeyRequest struct {
+	Algorithm  string             `json:"algorithm,omitempty"`  // empty = the algorithm of the current key
+	Parameters *crypto.Parameters `json:"parameters,omitempty"` // empty = the current parameters if the algorithm stays, otherwise algorithm defaults
+}
+
+// KeyVersionView is the public representation of a key of a device
+type KeyVersionView struct {
+	Version      int               `json:"version"`
+	Algorithm    string            `json:"algorithm"`
+	Parameters   crypto.Parameters `json:"parameters"`
+	PublicKey    string            `json:"public_key,omitempty"`
+	FirstCounter int               `json:"first_counter"`
+	// Counter of the last transaction signed with the key, first_counter - 1 if none
+	LastCounter int        `json:"last_counter"`
+	Current     bool       `json:"current"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	RotatedAt   *time.Time `json:"rotated_at,omitempty"`
+}
+
+type ListDeviceKeysResponse struct {
+	Keys []KeyVersionView `json:"keys"`
+}
+
+// NewKeyVersionView builds the public representation of @key, the current key of its device if @current
+func NewKeyVersionView(key domain.KeyVersion, current bool) KeyVersionView {
+	view := KeyVersionView{
+		Version:      key.Version,
+		Algorithm:    key.Algorithm,
+		Parameters:   key.Parameters,
+		PublicKey:    string(key.PublicKey),
+		FirstCounter: key.FirstCounter,
+		LastCounter:  key.LastCounter,
+		Current:      current,
+	}
+	if !key.CreatedAt.IsZero() {
+		createdAt := key.CreatedAt
+		view.CreatedAt = &createdAt
+	}
+	if !key.RotatedAt.IsZero() {
+		rotatedAt := key.RotatedAt
+		view.RotatedAt = &rotatedAt
+	}
+	return view
+}
+
+// RotateDeviceKey gives device {id} a new key pair, its signature chain goes on with the next transaction
+func RotateDeviceKey(response http.ResponseWriter, request *http.Request) {
+	var input RotateDeviceKeyRequest
+	if request.ContentLength != 0 {
+		if err := common.ParseJSONRequestBody(request.Body, &input); err != nil {
+			common.WriteErrorResponse(response, http.StatusBadRequest, []string{
+				err.Error(),
+			})
+			return
+		}
+	}
+
+	device, err := service.RotateDeviceKey(request.Context(), request.PathValue("id"), service.RotateKeyInput{
+		Algorithm:  input.Algorithm,
+		Parameters: input.Parameters,
+	})
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	response.Header().Set("Location", "/api/v1/devices/"+device.ID+"/keys")
+	common.WriteAPIResponse(response, http.StatusCreated, NewDeviceView(device))
+}
+
+// ListDeviceKeys lists every key of device {id}, oldest first, the current one last
+func ListDeviceKeys(response http.ResponseWriter, request *http.Request) {
+	keys, err := service.ListDeviceKeys(request.Context(), request.PathValue("id"))
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	output := ListDeviceKeysResponse{
+		Keys: make([]KeyVersionView, 0, len(keys)),
+	}
+	for i, key := range keys {
+		output.Keys = append(output.Keys, NewKeyVersionView(key, i == len(keys)-1))
+	}
+	common.WriteAPIResponse(response, http.StatusOK, output)
+}
+
+func init() {
+	common.RegisterRoute("POST /api/v1/devices/{id}/keys", RotateDeviceKey)
+	common.RegisterRoute("GET /api/v1/devices/{id}/keys", ListDeviceKeys)
+}
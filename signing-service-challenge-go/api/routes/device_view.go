@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+)
+
+// DeviceView is the public representation of a device, the only one any API response may contain.
+// It deliberately has no way to carry private key material.
+type DeviceView struct {
+	ID               string            `json:"id"`
+	TenantID         string            `json:"tenant_id,omitempty"`
+	Label            string            `json:"label,omitempty"`
+	Algorithm        string            `json:"algorithm"`
+	Status           string            `json:"status"`
+	Parameters       crypto.Parameters `json:"parameters"`
+	SignatureCounter int               `json:"signature_counter"`
+	LastSignature    string            `json:"last_signature"`
+	PublicKey        string            `json:"public_key,omitempty"`
+	KeyFingerprint   string            `json:"key_fingerprint,omitempty"`
+	KeyVersion       int               `json:"key_version"`
+	CreatedAt        *time.Time        `json:"created_at,omitempty"`
+	UpdatedAt        *time.Time        `json:"updated_at,omitempty"`
+	// Every status change, oldest first
+	StatusHistory []StatusChangeView `json:"status_history,omitempty"`
+}
+
+// StatusChangeView is the public representation of a status change of a device
+type StatusChangeView struct {
+	Action string    `json:"action"`
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to"`
+	At     time.Time `json:"at"`
+	By     string    `json:"by,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// NewDeviceView builds the public representation of @device
+func NewDeviceView(device *domain.Device) DeviceView {
+	view := DeviceView{
+		ID:               device.ID,
+		TenantID:         device.TenantID,
+		Label:            device.Label,
+		Algorithm:        device.Algorithm,
+		Status:           string(device.Status),
+		Parameters:       device.Parameters,
+		SignatureCounter: device.SignatureCounter,
+		LastSignature:    device.LastSignature,
+		KeyVersion:       device.KeyVersion,
+	}
+
+	if block, _ := pem.Decode(device.PublicKey); block != nil {
+		fingerprint := sha256.Sum256(block.Bytes)
+		view.PublicKey = string(device.PublicKey)
+		view.KeyFingerprint = "SHA256:" + hex.EncodeToString(fingerprint[:])
+	}
+
+	for _, change := range device.StatusHistory {
+		view.StatusHistory = append(view.StatusHistory, StatusChangeView{
+			Action: string(change.Action),
+			From:   string(change.From),
+			To:     string(change.To),
+			At:     change.At,
+			By:     change.By,
+			Reason: change.Reason,
+		})
+	}
+
+	if !device.CreatedAt.IsZero() {
+		createdAt := device.CreatedAt
+		view.CreatedAt = &createdAt
+	}
+	if !device.UpdatedAt.IsZero() {
+		updatedAt := device.UpdatedAt
+		view.UpdatedAt = &updatedAt
+	}
+
+	return view
+}
+
+// NewDeviceViews builds the public representation of every device in @devices
+func NewDeviceViews(devices []*domain.Device) []DeviceView {
+	views := make([]DeviceView, 0, len(devices))
+	for _, device := range devices {
+		views = append(views, NewDeviceView(device))
+	}
+	return views
+}
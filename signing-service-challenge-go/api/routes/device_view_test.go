@@ -0,0 +1,157 @@
+package routes_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/routes"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+func TestDeviceView(t *testing.T) {
+	Convey("Given a device with a real key pair", t, func() {
+		kp, _ := crypto.GetAlgorithm("ed25519").GenerateKeyPair()
+		pub, priv, _ := kp.Serialize()
+		device := &domain.Device{ID: "view", Algorithm: "ed25519", PrivateKey: priv, PublicKey: pub, SignatureCounter: 2}
+
+		Convey("the view carries the public key and its fingerprint", func() {
+			view := routes.NewDeviceView(device)
+
+			So(view.ID, ShouldEqual, "view")
+			So(view.SignatureCounter, ShouldEqual, 2)
+			So(view.PublicKey, ShouldEqual, string(pub))
+			So(view.KeyFingerprint, ShouldStartWith, "SHA256:")
+			So(view.CreatedAt, ShouldBeNil)
+		})
+	})
+}
+
+func TestNoPrivateKeyLeak(t *testing.T) {
+	Convey("Given devices of every algorithm created through the API", t, func() {
+		db := persistence.NewInMemoryDB()
+		persistence.SetInstance(db)
+
+		var outputs []string
+		serve := func(method, path, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+			rec := httptest.NewRecorder()
+			common.Mux().ServeHTTP(rec, req)
+			outputs = append(outputs, rec.Body.String())
+			return rec
+		}
+
+		for _, algorithm := range []string{"rsa", "rsa-pss", "ecc", "ed25519"} {
+			id := "leak-" + algorithm
+			rec := serve(http.MethodPost, "/api/v0/create_signature_device", `{"device_id":"`+id+`","algorithm":"`+algorithm+`","label":"till"}`)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			rec = serve(http.MethodPost, "/api/v0/sign_transaction", `{"device_id":"`+id+`","data":"receipt"}`)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			var signed signAPIResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &signed), ShouldBeNil)
+			verifyBody, _ := json.Marshal(map[string]string{"device_id": id, "data": signed.Data.SignedData, "signature": signed.Data.Signature})
+			rec = serve(http.MethodPost, "/api/v0/verify_signature", string(verifyBody))
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			serve(http.MethodPost, "/api/v0/create_signature_device", `{"device_id":"`+id+`","algorithm":"`+algorithm+`"}`)
+		}
+		serve(http.MethodGet, "/api/v0/list_devices", "")
+		serve(http.MethodGet, "/api/v0/health", "")
+
+		Convey("no route output contains any private key material", func() {
+			devices := db.List()
+			So(devices, ShouldHaveLength, 4)
+
+			for _, output := range outputs {
+				So(output, ShouldNotContainSubstring, "PRIVATE")
+				So(strings.ToLower(output), ShouldNotContainSubstring, "privatekey")
+				So(strings.ToLower(output), ShouldNotContainSubstring, "private_key")
+
+				for _, device := range devices {
+					So(output, ShouldNotContainSubstring, base64.StdEncoding.EncodeToString(device.PrivateKey))
+					for _, line := range strings.Split(string(device.PrivateKey), "\n") {
+						if len(line) > 16 && !strings.HasPrefix(line, "-----") {
+							So(output, ShouldNotContainSubstring, line)
+						}
+					}
+				}
+			}
+		})
+
+		Convey("but list_devices exposes the public keys", func() {
+			rec := serve(http.MethodGet, "/api/v0/list_devices", "")
+
+			var resp apiResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &resp), ShouldBeNil)
+			So(resp.Data.Devices, ShouldHaveLength, 4)
+			for _, device := range resp.Data.Devices {
+				So(device.PublicKey, ShouldContainSubstring, "PUBLIC")
+				So(device.KeyFingerprint, ShouldNotBeEmpty)
+				So(device.CreatedAt, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestEncryptedPrivateKeys(t *testing.T) {
+	Convey("Given encryption at rest is enabled", t, func() {
+		db := persistence.NewInMemoryDB()
+		persistence.SetInstance(db)
+
+		encoded, _ := crypto.GenerateMasterKey()
+		masterKey, err := crypto.ParseMasterKey(encoded)
+		So(err, ShouldBeNil)
+		crypto.SetEnvelope(crypto.NewEnvelope(masterKey))
+		defer crypto.SetEnvelope(nil)
+
+		serve := func(path, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+			rec := httptest.NewRecorder()
+			common.Mux().ServeHTTP(rec, req)
+			return rec
+		}
+
+		for _, algorithm := range []string{"rsa", "rsa-pss", "ecc", "ed25519"} {
+			id := "sealed-" + algorithm
+			rec := serve("/api/v0/create_signature_device", `{"device_id":"`+id+`","algorithm":"`+algorithm+`"}`)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			Convey("the storage of "+algorithm+" devices only sees ciphertext", func() {
+				device, err := db.Load(id)
+				So(err, ShouldBeNil)
+				So(device.MasterKeyID, ShouldEqual, masterKey.ID)
+				So(device.WrappedDataKey, ShouldNotBeEmpty)
+				So(string(device.PrivateKey), ShouldNotContainSubstring, "PRIVATE")
+			})
+
+			Convey("and "+algorithm+" devices still sign and verify", func() {
+				rec := serve("/api/v0/sign_transaction", `{"device_id":"`+id+`","data":"receipt"}`)
+				So(rec.Code, ShouldEqual, http.StatusOK)
+
+				var signed signAPIResponse
+				So(json.Unmarshal(rec.Body.Bytes(), &signed), ShouldBeNil)
+				verifyBody, _ := json.Marshal(map[string]string{"device_id": id, "data": signed.Data.SignedData, "signature": signed.Data.Signature})
+				rec = serve("/api/v0/verify_signature", string(verifyBody))
+				So(rec.Code, ShouldEqual, http.StatusOK)
+				So(rec.Body.String(), ShouldContainSubstring, `"verified": true`)
+			})
+		}
+
+		Convey("without the master key, signing fails instead of using the ciphertext", func() {
+			crypto.SetEnvelope(nil)
+			rec := serve("/api/v0/sign_transaction", `{"device_id":"sealed-ecc","data":"receipt"}`)
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+		})
+	})
+}
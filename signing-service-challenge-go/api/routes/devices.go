@@ -0,0 +1,158 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+	"net/http"
+)
+
+type CreateDeviceRequest struct {
+	Algorithm  string             `json:"algorithm"`
+	Parameters *crypto.Parameters `json:"parameters,omitempty"` // empty = algorithm defaults
+	Label      *string            `json:"label,omitempty"`
+}
+
+func (request *CreateDeviceRequest) UnmarshalJSON(data []byte) error {
+	type Alias CreateDeviceRequest // Avoid recursion
+	aux := &struct {
+		*Alias
+	}{
+		Alias: (*Alias)(request),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if request.Algorithm == "" {
+		return errors.New("Algorithm is required")
+	}
+
+	return nil
+}
+
+type UpdateDeviceRequest struct {
+	Label *string `json:"label,omitempty"` // empty = unchanged
+}
+
+type TransitionDeviceRequest struct {
+	Reason string `json:"reason,omitempty"` // recorded in the status history
+}
+
+// CreateDevice creates the signature device {id} using user selected algorithm, an existing device is never replaced
+func CreateDevice(response http.ResponseWriter, request *http.Request) {
+	var input CreateDeviceRequest
+	if err := common.ParseJSONRequestBody(request.Body, &input); err != nil {
+		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
+			err.Error(),
+		})
+		return
+	}
+
+	device, err := service.CreateDevice(request.Context(), service.CreateDeviceInput{
+		ID:         request.PathValue("id"),
+		Algorithm:  input.Algorithm,
+		Parameters: input.Parameters,
+		Label:      input.Label,
+	})
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	response.Header().Set("Location", "/api/v1/devices/"+device.ID)
+	common.WriteAPIResponse(response, http.StatusCreated, NewDeviceView(device))
+}
+
+// GetDevice returns the device {id}
+func GetDevice(response http.ResponseWriter, request *http.Request) {
+	device, err := service.GetDevice(request.Context(), request.PathValue("id"))
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	common.WriteAPIResponse(response, http.StatusOK, NewDeviceView(device))
+}
+
+// UpdateDevice changes the label of device {id}, its key pair and signature counter are kept
+func UpdateDevice(response http.ResponseWriter, request *http.Request) {
+	var input UpdateDeviceRequest
+	if err := common.ParseJSONRequestBody(request.Body, &input); err != nil {
+		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
+			err.Error(),
+		})
+		return
+	}
+
+	device, err := service.UpdateDevice(request.Context(), request.PathValue("id"), service.UpdateDeviceInput{
+		Label: input.Label,
+	})
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	common.WriteAPIResponse(response, http.StatusOK, NewDeviceView(device))
+}
+
+// DeleteDevice deletes the device {id} together with its key pair
+func DeleteDevice(response http.ResponseWriter, request *http.Request) {
+	if err := service.DeleteDevice(request.Context(), request.PathValue("id")); err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// SuspendDevice suspends device {id}, it does not sign until it is resumed
+func SuspendDevice(response http.ResponseWriter, request *http.Request) {
+	transitionDevice(response, request, domain.ActionSuspend)
+}
+
+// ResumeDevice makes the suspended device {id} sign again
+func ResumeDevice(response http.ResponseWriter, request *http.Request) {
+	transitionDevice(response, request, domain.ActionResume)
+}
+
+// RetireDevice decommissions device {id} for good, its signatures stay verifiable
+func RetireDevice(response http.ResponseWriter, request *http.Request) {
+	transitionDevice(response, request, domain.ActionRetire)
+}
+
+// transitionDevice does @action to device {id}, the request body with a reason is optional
+func transitionDevice(response http.ResponseWriter, request *http.Request, action domain.DeviceAction) {
+	var input TransitionDeviceRequest
+	if request.ContentLength != 0 {
+		if err := common.ParseJSONRequestBody(request.Body, &input); err != nil {
+			common.WriteErrorResponse(response, http.StatusBadRequest, []string{
+				err.Error(),
+			})
+			return
+		}
+	}
+
+	device, err := service.TransitionDevice(request.Context(), request.PathValue("id"), action, input.Reason)
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	common.WriteAPIResponse(response, http.StatusOK, NewDeviceView(device))
+}
+
+func init() {
+	common.RegisterRoute("GET /api/v1/devices", ListDevicesPage)
+	common.RegisterRoute("POST /api/v1/devices/{id}", CreateDevice)
+	common.RegisterRoute("GET /api/v1/devices/{id}", GetDevice)
+	common.RegisterRoute("PATCH /api/v1/devices/{id}", UpdateDevice)
+	common.RegisterRoute("DELETE /api/v1/devices/{id}", DeleteDevice)
+	common.RegisterRoute("POST /api/v1/devices/{id}/suspend", SuspendDevice)
+	common.RegisterRoute("POST /api/v1/devices/{id}/resume", ResumeDevice)
+	common.RegisterRoute("POST /api/v1/devices/{id}/retire", RetireDevice)
+}
@@ -0,0 +1,170 @@
+package routes_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/routes"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+type deviceAPIResponse struct {
+	Data routes.DeviceView `json:"data"`
+}
+
+// serveMux sends a request through the real router, so path patterns and methods are exercised too
+func serveMux(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	common.Mux().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDevicesV1(t *testing.T) {
+	Convey("Given an empty InMemoryDB", t, func() {
+		persistence.SetInstance(persistence.NewInMemoryDB())
+
+		Convey("POST /api/v1/devices/{id} creates a device", func() {
+			rec := serveMux(http.MethodPost, "/api/v1/devices/till-1", `{"algorithm":"ecc","label":"Till 1"}`)
+
+			So(rec.Code, ShouldEqual, http.StatusCreated)
+			So(rec.Header().Get("Location"), ShouldEqual, "/api/v1/devices/till-1")
+			var resp deviceAPIResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &resp), ShouldBeNil)
+			So(resp.Data.ID, ShouldEqual, "till-1")
+			So(resp.Data.Label, ShouldEqual, "Till 1")
+			So(resp.Data.PublicKey, ShouldContainSubstring, "PUBLIC")
+
+			Convey("and creating it again is a conflict", func() {
+				rec := serveMux(http.MethodPost, "/api/v1/devices/till-1", `{"algorithm":"ecc"}`)
+				So(rec.Code, ShouldEqual, http.StatusConflict)
+				So(rec.Body.String(), ShouldContainSubstring, "already exists")
+			})
+
+			Convey("GET returns it", func() {
+				rec := serveMux(http.MethodGet, "/api/v1/devices/till-1", "")
+				So(rec.Code, ShouldEqual, http.StatusOK)
+
+				var got deviceAPIResponse
+				So(json.Unmarshal(rec.Body.Bytes(), &got), ShouldBeNil)
+				So(got.Data.KeyFingerprint, ShouldEqual, resp.Data.KeyFingerprint)
+			})
+
+			Convey("PATCH changes the label but keeps the key", func() {
+				rec := serveMux(http.MethodPatch, "/api/v1/devices/till-1", `{"label":"Till One"}`)
+				So(rec.Code, ShouldEqual, http.StatusOK)
+
+				var got deviceAPIResponse
+				So(json.Unmarshal(rec.Body.Bytes(), &got), ShouldBeNil)
+				So(got.Data.Label, ShouldEqual, "Till One")
+				So(got.Data.KeyFingerprint, ShouldEqual, resp.Data.KeyFingerprint)
+			})
+
+			Convey("DELETE removes it", func() {
+				rec := serveMux(http.MethodDelete, "/api/v1/devices/till-1", "")
+				So(rec.Code, ShouldEqual, http.StatusNoContent)
+
+				rec = serveMux(http.MethodGet, "/api/v1/devices/till-1", "")
+				So(rec.Code, ShouldEqual, http.StatusNotFound)
+			})
+
+			Convey("POST .../suspend, .../resume and .../retire change its status", func() {
+				So(resp.Data.Status, ShouldEqual, "active")
+
+				rec := serveMux(http.MethodPost, "/api/v1/devices/till-1/suspend", `{"reason":"till stolen"}`)
+				So(rec.Code, ShouldEqual, http.StatusOK)
+				var got deviceAPIResponse
+				So(json.Unmarshal(rec.Body.Bytes(), &got), ShouldBeNil)
+				So(got.Data.Status, ShouldEqual, "suspended")
+				So(got.Data.StatusHistory, ShouldHaveLength, 2)
+				So(got.Data.StatusHistory[1].Reason, ShouldEqual, "till stolen")
+
+				rec = serveMux(http.MethodPost, "/api/v0/sign_transaction", `{"device_id":"till-1","data":"receipt"}`)
+				So(rec.Code, ShouldEqual, http.StatusConflict)
+				So(rec.Body.String(), ShouldContainSubstring, "only active devices can sign")
+
+				So(serveMux(http.MethodPost, "/api/v1/devices/till-1/resume", "").Code, ShouldEqual, http.StatusOK)
+				So(serveMux(http.MethodPost, "/api/v1/devices/till-1/retire", "").Code, ShouldEqual, http.StatusOK)
+				So(serveMux(http.MethodPost, "/api/v1/devices/till-1/resume", "").Code, ShouldEqual, http.StatusConflict)
+				So(serveMux(http.MethodDelete, "/api/v1/devices/till-1", "").Code, ShouldEqual, http.StatusConflict)
+				So(serveMux(http.MethodPost, "/api/v1/devices/till-1/retire", "{").Code, ShouldEqual, http.StatusBadRequest)
+			})
+
+			Convey("POST .../keys rotates its key and GET .../keys lists every key", func() {
+				So(resp.Data.KeyVersion, ShouldEqual, 1)
+				So(serveMux(http.MethodPost, "/api/v0/sign_transaction", `{"device_id":"till-1","data":"receipt"}`).Code, ShouldEqual, http.StatusOK)
+
+				rec := serveMux(http.MethodPost, "/api/v1/devices/till-1/keys", `{"algorithm":"ed25519"}`)
+				So(rec.Code, ShouldEqual, http.StatusCreated)
+				var got deviceAPIResponse
+				So(json.Unmarshal(rec.Body.Bytes(), &got), ShouldBeNil)
+				So(got.Data.KeyVersion, ShouldEqual, 2)
+				So(got.Data.SignatureCounter, ShouldEqual, 1)
+				So(got.Data.KeyFingerprint, ShouldNotEqual, resp.Data.KeyFingerprint)
+
+				rec = serveMux(http.MethodGet, "/api/v1/devices/till-1/keys", "")
+				So(rec.Code, ShouldEqual, http.StatusOK)
+				var keys struct {
+					Data routes.ListDeviceKeysResponse `json:"data"`
+				}
+				So(json.Unmarshal(rec.Body.Bytes(), &keys), ShouldBeNil)
+				So(keys.Data.Keys, ShouldHaveLength, 2)
+				So(keys.Data.Keys[0].Algorithm, ShouldEqual, "ecc")
+				So(keys.Data.Keys[0].LastCounter, ShouldEqual, 0)
+				So(keys.Data.Keys[0].Current, ShouldBeFalse)
+				So(keys.Data.Keys[1].FirstCounter, ShouldEqual, 1)
+				So(keys.Data.Keys[1].Current, ShouldBeTrue)
+
+				So(serveMux(http.MethodPost, "/api/v1/devices/till-1/keys", "").Code, ShouldEqual, http.StatusCreated)
+				So(serveMux(http.MethodPost, "/api/v1/devices/till-1/keys", `{"algorithm":"nope"}`).Code, ShouldEqual, http.StatusBadRequest)
+				So(serveMux(http.MethodGet, "/api/v1/devices/till-2/keys", "").Code, ShouldEqual, http.StatusNotFound)
+			})
+
+			Convey("it is visible to v0 as well", func() {
+				rec := serveMux(http.MethodGet, "/api/v0/list_devices", "")
+				So(rec.Code, ShouldEqual, http.StatusOK)
+				So(rec.Body.String(), ShouldContainSubstring, "till-1")
+			})
+		})
+
+		Convey("GET /api/v1/devices lists devices created through v0", func() {
+			rec := serveMux(http.MethodPost, "/api/v0/create_signature_device", `{"device_id":"legacy","algorithm":"rsa"}`)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			rec = serveMux(http.MethodGet, "/api/v1/devices", "")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			var resp apiResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &resp), ShouldBeNil)
+			So(resp.Data.Devices, ShouldHaveLength, 1)
+			So(resp.Data.Devices[0].ID, ShouldEqual, "legacy")
+		})
+
+		Convey("creating without an algorithm is a bad request", func() {
+			rec := serveMux(http.MethodPost, "/api/v1/devices/x", `{}`)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Body.String(), ShouldContainSubstring, "Algorithm is required")
+		})
+
+		Convey("creating with an unknown algorithm is a bad request", func() {
+			rec := serveMux(http.MethodPost, "/api/v1/devices/x", `{"algorithm":"nope"}`)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Body.String(), ShouldContainSubstring, "not available")
+		})
+
+		Convey("PATCH and DELETE of an unknown device are not found", func() {
+			So(serveMux(http.MethodPatch, "/api/v1/devices/x", `{"label":"x"}`).Code, ShouldEqual, http.StatusNotFound)
+			So(serveMux(http.MethodDelete, "/api/v1/devices/x", "").Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("unsupported methods are rejected by the router", func() {
+			So(serveMux(http.MethodPut, "/api/v1/devices/x", "").Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
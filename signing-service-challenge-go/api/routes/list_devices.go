@@ -1,18 +1,50 @@
 package routes
 
 import (
+	"errors"
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
-	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
 	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// Page size of ListDevicesPage when no limit is given
+	DefaultDevicePageSize = 100
+	// Largest page size ListDevices and ListDevicesPage accept
+	MaxDevicePageSize = 1000
 )
 
 type ListDevicesResponse struct {
-	Devices []*domain.Device `json:"devices"`
+	Devices    []DeviceView `json:"devices"`
+	NextCursor string       `json:"next_cursor,omitempty"` // empty = no more devices
+	Next       string       `json:"next,omitempty"`        // link to the next page, same filters
 }
 
-// ListDevices lists all devices on the system, no filter
+// ListDevices lists every device on the system the caller may use ordered by ID, as it always did. Given
+// ?limit= or ?cursor=, it returns pages as ListDevicesPage does. They can be filtered as by ListDevicesPage.
 func ListDevices(response http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	if query.Has("limit") || query.Has("cursor") {
+		listDevices(response, request, DefaultDevicePageSize)
+		return
+	}
+	listDevices(response, request, 0)
+}
+
+// ListDevicesPage lists the devices on the system the caller may use ordered by ID, a page of at most ?limit=
+// (default DefaultDevicePageSize) of them starting after ?cursor= (the next_cursor of the previous page).
+// They can be filtered by ?algorithm=, ?label_prefix=, ?status= and creation time, ?created_from=
+// (inclusive) and ?created_to= (exclusive) in RFC 3339.
+func ListDevicesPage(response http.ResponseWriter, request *http.Request) {
+	listDevices(response, request, DefaultDevicePageSize)
+}
+
+// listDevices answers @request listing devices in pages of @defaultLimit devices unless ?limit= is given,
+// 0 = all of them
+func listDevices(response http.ResponseWriter, request *http.Request, defaultLimit int) {
 	if request.Method != http.MethodGet {
 		common.WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
 			http.StatusText(http.StatusMethodNotAllowed),
@@ -20,14 +52,71 @@ func ListDevices(response http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	db := persistence.GetInstance()
+	input, err := listDevicesInput(request, defaultLimit)
+	if err != nil {
+		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
+			err.Error(),
+		})
+		return
+	}
+
+	page, err := service.ListDevicesPage(request.Context(), input)
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
 	output := ListDevicesResponse{
-		Devices: db.List(),
+		Devices:    NewDeviceViews(page.Devices),
+		NextCursor: page.NextCursor,
+	}
+	if page.NextCursor != "" {
+		query := request.URL.Query()
+		query.Set("cursor", page.NextCursor)
+		output.Next = request.URL.Path + "?" + query.Encode()
 	}
 
 	common.WriteAPIResponse(response, http.StatusOK, output)
 }
 
+// listDevicesInput reads the filters and page of listDevices from the query parameters of @request, with a
+// page of @defaultLimit devices unless ?limit= is given
+func listDevicesInput(request *http.Request, defaultLimit int) (service.ListDevicesInput, error) {
+	query := request.URL.Query()
+	input := service.ListDevicesInput{
+		Algorithm:   query.Get("algorithm"),
+		LabelPrefix: query.Get("label_prefix"),
+		Status:      domain.DeviceStatus(query.Get("status")),
+		Cursor:      query.Get("cursor"),
+	}
+
+	var err error
+	input.Limit, err = queryInt(request, "limit", defaultLimit)
+	if err != nil || input.Limit > MaxDevicePageSize || (input.Limit < 1 && request.URL.Query().Has("limit")) {
+		return input, errors.New("Limit must be a number from 1 to " + strconv.Itoa(MaxDevicePageSize))
+	}
+	if input.CreatedFrom, err = queryTime(request, "created_from"); err != nil {
+		return input, err
+	}
+	if input.CreatedTo, err = queryTime(request, "created_to"); err != nil {
+		return input, err
+	}
+	return input, nil
+}
+
+// queryTime returns query parameter @name of @request as an RFC 3339 time, zero if it is not given
+func queryTime(request *http.Request, name string) (time.Time, error) {
+	value := request.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, errors.New("Query parameter " + name + " must be a time in RFC 3339 format")
+	}
+	return t, nil
+}
+
 func init() {
 	common.RegisterRoute("/api/v0/list_devices", ListDevices)
 }
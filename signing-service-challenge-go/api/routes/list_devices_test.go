@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
@@ -44,7 +45,7 @@ func TestListDevices(t *testing.T) {
 		})
 
 		Convey("returns empty list if no devices", func() {
-			mockDB.EXPECT().List().Return([]*domain.Device{})
+			mockDB.EXPECT().ListDevices(gomock.Any()).Return([]*domain.Device{}, nil)
 
 			req := httptest.NewRequest(http.MethodGet, "/list_devices", nil)
 			rec := httptest.NewRecorder()
@@ -61,7 +62,7 @@ func TestListDevices(t *testing.T) {
 
 		Convey("returns one device", func() {
 			dev := &domain.Device{ID: "dev123", Algorithm: "RSA"}
-			mockDB.EXPECT().List().Return([]*domain.Device{dev})
+			mockDB.EXPECT().ListDevices(gomock.Any()).Return([]*domain.Device{dev}, nil)
 
 			req := httptest.NewRequest(http.MethodGet, "/list_devices", nil)
 			rec := httptest.NewRecorder()
@@ -83,7 +84,7 @@ func TestListDevices(t *testing.T) {
 				{ID: "dev1", Algorithm: "RSA"},
 				{ID: "dev2", Algorithm: "ECC"},
 			}
-			mockDB.EXPECT().List().Return(devices)
+			mockDB.EXPECT().ListDevices(gomock.Any()).Return(devices, nil)
 
 			req := httptest.NewRequest(http.MethodGet, "/list_devices", nil)
 			rec := httptest.NewRecorder()
@@ -98,6 +99,88 @@ func TestListDevices(t *testing.T) {
 			So(resp.Data.Devices, ShouldHaveLength, 2)
 			So(resp.Data.Devices[0].ID, ShouldEqual, "dev1")
 			So(resp.Data.Devices[1].ID, ShouldEqual, "dev2")
+			So(resp.Data.NextCursor, ShouldBeEmpty)
+		})
+
+		Convey("passes filters and page to the storage", func() {
+			mockDB.EXPECT().ListDevices(domain.DeviceQuery{
+				Algorithm:   "ecc",
+				LabelPrefix: "Till",
+				Status:      domain.DeviceSuspended,
+				CreatedFrom: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+				CreatedTo:   time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+				After:       "dev1",
+				Limit:       3,
+			}).Return([]*domain.Device{{ID: "dev2"}, {ID: "dev3"}, {ID: "dev4"}}, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/list_devices?algorithm=ecc&label_prefix=Till&status=suspended"+
+				"&created_from=2024-05-01T00:00:00Z&created_to=2024-06-01T00:00:00Z&cursor=ZGV2MQ&limit=2", nil)
+			rec := httptest.NewRecorder()
+
+			routes.ListDevices(rec, req)
+
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			var resp apiResponse
+			err := json.Unmarshal(rec.Body.Bytes(), &resp)
+			So(err, ShouldBeNil)
+			So(resp.Data.Devices, ShouldHaveLength, 2)
+			So(resp.Data.NextCursor, ShouldEqual, "ZGV2Mw")
+			So(resp.Data.Next, ShouldStartWith, "/list_devices?")
+			So(resp.Data.Next, ShouldContainSubstring, "cursor=ZGV2Mw")
+			So(resp.Data.Next, ShouldContainSubstring, "status=suspended")
+		})
+
+		Convey("lists every device unless a limit or cursor is given", func() {
+			mockDB.EXPECT().ListDevices(domain.DeviceQuery{}).Return([]*domain.Device{{ID: "dev1"}}, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/list_devices", nil)
+			rec := httptest.NewRecorder()
+
+			routes.ListDevices(rec, req)
+
+			So(rec.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("pages from a cursor with the default page size", func() {
+			mockDB.EXPECT().ListDevices(domain.DeviceQuery{After: "dev1", Limit: routes.DefaultDevicePageSize + 1}).Return([]*domain.Device{}, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/list_devices?cursor=ZGV2MQ", nil)
+			rec := httptest.NewRecorder()
+
+			routes.ListDevices(rec, req)
+
+			So(rec.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("returns 400 for invalid query parameters", func() {
+			for _, query := range []string{"limit=0", "limit=1001", "limit=x", "created_from=yesterday", "status=lost", "cursor=%25"} {
+				req := httptest.NewRequest(http.MethodGet, "/list_devices?"+query, nil)
+				rec := httptest.NewRecorder()
+
+				routes.ListDevices(rec, req)
+
+				So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+	})
+
+	Convey("The paged ListDevices endpoint", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := mocks.NewMockStorage(ctrl)
+		persistence.SetInstance(mockDB)
+
+		Convey("returns the default page size without a limit", func() {
+			mockDB.EXPECT().ListDevices(domain.DeviceQuery{Limit: routes.DefaultDevicePageSize + 1}).Return([]*domain.Device{}, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/devices", nil)
+			rec := httptest.NewRecorder()
+
+			routes.ListDevicesPage(rec, req)
+
+			So(rec.Code, ShouldEqual, http.StatusOK)
 		})
 	})
 }
@@ -0,0 +1,100 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+	"net/http"
+)
+
+// MigratePath is the path of the migration endpoint, only served when admins can authenticate, see api/server
+const MigratePath = "/api/v1/admin/migrate"
+
+type MigrateRequest struct {
+	Backend string `json:"backend"`
+	Path    string `json:"path"`
+}
+
+func (request *MigrateRequest) UnmarshalJSON(data []byte) error {
+	type Alias MigrateRequest // Avoid recursion
+	aux := &struct {
+		*Alias
+	}{
+		Alias: (*Alias)(request),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if request.Backend == "" || request.Path == "" {
+		return errors.New("Storage backend and path are required")
+	}
+
+	return nil
+}
+
+// MigrationMismatchView is the public representation of a device that was not migrated
+type MigrationMismatchView struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// MigrationView is the public representation of what a migration did
+type MigrationView struct {
+	Devices      int                     `json:"devices"`
+	Copied       int                     `json:"copied"`
+	Resumed      int                     `json:"resumed"`
+	Skipped      int                     `json:"skipped"`
+	Transactions int                     `json:"transactions"`
+	Tenants      int                     `json:"tenants"`
+	APIKeys      int                     `json:"api_keys"`
+	Mismatches   []MigrationMismatchView `json:"mismatches"`
+}
+
+// NewMigrationView builds the public representation of @report
+func NewMigrationView(report *persistence.MigrationReport) MigrationView {
+	view := MigrationView{
+		Devices:      report.Devices,
+		Copied:       report.Copied,
+		Resumed:      report.Resumed,
+		Skipped:      report.Skipped,
+		Transactions: report.Transactions,
+		Tenants:      report.Tenants,
+		APIKeys:      report.APIKeys,
+		Mismatches:   make([]MigrationMismatchView, 0, len(report.Mismatches)),
+	}
+	for _, mismatch := range report.Mismatches {
+		view.Mismatches = append(view.Mismatches, MigrationMismatchView{Key: mismatch.Key, Reason: mismatch.Reason})
+	}
+	return view
+}
+
+// MigrateStorage copies every tenant, device and API key into the file or sqlite storage in the request body,
+// see service.MigrateTo. Devices that were not migrated are listed in the response.
+func MigrateStorage(response http.ResponseWriter, request *http.Request) {
+	var input MigrateRequest
+	if err := common.ParseJSONRequestBody(request.Body, &input); err != nil {
+		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
+			err.Error(),
+		})
+		return
+	}
+
+	report, err := service.MigrateTo(request.Context(), service.MigrateToInput{
+		Backend: input.Backend,
+		Path:    input.Path,
+	}, nil)
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	common.WriteAPIResponse(response, http.StatusOK, NewMigrationView(report))
+}
+
+func init() {
+	common.RegisterRoute("POST "+MigratePath, MigrateStorage)
+}
@@ -0,0 +1,59 @@
+package routes_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/routes"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+)
+
+func TestMigrateStorage(t *testing.T) {
+	Convey("Given a device that signed in the memory storage", t, func() {
+		persistence.SetInstance(persistence.NewInMemoryDB())
+		persistence.SetTenantStore(persistence.NewInMemoryTenantStore())
+		persistence.SetKeyStore(persistence.NewInMemoryKeyStore())
+		So(serveMux(http.MethodPost, "/api/v1/devices/till-1", `{"algorithm":"ed25519"}`).Code, ShouldEqual, http.StatusCreated)
+		So(serveMux(http.MethodPost, "/api/v1/devices/till-1/transactions", `{"data":"receipt"}`).Code, ShouldEqual, http.StatusCreated)
+
+		service.SetStorageOpener(func(backend string, path string) (persistence.Storage, error) {
+			return persistence.NewSQLiteDB(filepath.Join(path, "signing.db"))
+		})
+		defer service.SetStorageOpener(nil)
+
+		Convey("POST /api/v1/admin/migrate copies it into the storage in the body", func() {
+			dir := t.TempDir()
+			rec := serveMuxAsAdmin(http.MethodPost, "/api/v1/admin/migrate", `{"backend":"sqlite","path":"`+dir+`"}`)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			var resp struct {
+				Data routes.MigrationView `json:"data"`
+			}
+			So(json.Unmarshal(rec.Body.Bytes(), &resp), ShouldBeNil)
+			So(resp.Data.Devices, ShouldEqual, 1)
+			So(resp.Data.Copied, ShouldEqual, 1)
+			So(resp.Data.Transactions, ShouldEqual, 1)
+			So(resp.Data.Mismatches, ShouldBeEmpty)
+
+			migrated, err := persistence.NewSQLiteDB(filepath.Join(dir, "signing.db"))
+			So(err, ShouldBeNil)
+			defer migrated.Close()
+			transactions, err := migrated.ListTransactions("till-1", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldHaveLength, 1)
+		})
+
+		Convey("POST /api/v1/admin/migrate rejects a missing or memory destination", func() {
+			So(serveMuxAsAdmin(http.MethodPost, "/api/v1/admin/migrate", `{"backend":"sqlite"}`).Code, ShouldEqual, http.StatusBadRequest)
+			So(serveMuxAsAdmin(http.MethodPost, "/api/v1/admin/migrate", `{"backend":"memory","path":"data"}`).Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("POST /api/v1/admin/migrate is forbidden to callers that are not authenticated", func() {
+			So(serveMux(http.MethodPost, "/api/v1/admin/migrate", `{"backend":"sqlite","path":"data"}`).Code, ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
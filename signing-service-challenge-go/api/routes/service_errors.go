@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+)
+
+// writeServiceError writes @err returned by the service layer as an error response with the matching status
+// code, errors of no known type are our own fault and written as an internal server error
+func writeServiceError(response http.ResponseWriter, err error) {
+	var notFound *service.NotFoundError
+	var invalidInput *service.InvalidInputError
+	var alreadyExists *service.AlreadyExistsError
+	var conflict *service.ConflictError
+	var invalidState *service.InvalidStateError
+	var busy *service.BusyError
+	var forbidden *service.ForbiddenError
+
+	code := http.StatusInternalServerError
+	switch {
+	case errors.As(err, &notFound):
+		code = http.StatusNotFound
+	case errors.As(err, &invalidInput):
+		code = http.StatusBadRequest
+	case errors.As(err, &alreadyExists), errors.As(err, &conflict), errors.As(err, &invalidState):
+		code = http.StatusConflict
+	case errors.As(err, &busy):
+		code = http.StatusServiceUnavailable
+	case errors.As(err, &forbidden):
+		code = http.StatusForbidden
+	default:
+		common.WriteErrorResponse(response, http.StatusInternalServerError, []string{
+			"Something is wrong on our side, please try again in a few moments, our development team has been notified",
+		})
+		// log err to system log, email, prometheus, whatever, skipped for brevity
+		return
+	}
+
+	common.WriteErrorResponse(response, code, []string{
+		err.Error(),
+	})
+}
@@ -1,19 +1,24 @@
 package routes
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
-	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
-	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
 	"net/http"
 )
 
+// IdempotencyKeyHeader carries the idempotency key of a signing request, see service.SignTransactionOnce
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is set on the response to a repeated signing request, which signed nothing
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
 type SignTransactionRequest struct {
 	DeviceID string `json:"device_id"`
 	Data     string `json:"data"`
+	// Alternative to the Idempotency-Key header
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
 func (request *SignTransactionRequest) UnmarshalJSON(data []byte) error {
@@ -59,68 +64,40 @@ func SignTransaction(response http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	db := persistence.GetInstance()
-	as := persistence.NewAtomicStorage(db)
-	as.Lock(input.DeviceID)
-	defer as.Unlock(input.DeviceID)
-
-	device, err := as.Load(input.DeviceID)
+	key, err := idempotencyKey(request, input.IdempotencyKey)
 	if err != nil {
-		common.WriteErrorResponse(response, http.StatusNotFound, []string{
+		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
 			err.Error(),
 		})
 		return
 	}
 
-	algo := crypto.GetAlgorithm(device.Algorithm)
-	if algo == nil {
-		// shouldn't happen, but possible to happen, so we still handle it,
-		// however this is not user error and therefore, an internal server error
-		common.WriteErrorResponse(response, http.StatusInternalServerError, []string{
-			"Something is wrong on our side, please try again in a few moments, our development team has been notified",
-		})
-		// log err to system log, email, prometheus, whatever, skipped for brevity
-		return
-	}
-
-	kp, err := algo.ConstructKeyPair(device.PrivateKey)
+	transaction, replayed, err := service.SignTransactionOnce(request.Context(), input.DeviceID, input.Data, key)
 	if err != nil {
-		common.WriteErrorResponse(response, http.StatusInternalServerError, []string{
-			"Something is wrong on our side, please try again in a few moments, our development team has been notified",
-		})
-		// log err to system log, email, prometheus, whatever, skipped for brevity
+		writeServiceError(response, err)
 		return
 	}
-
-	data := fmt.Sprintf("%d_%s_%s", device.SignatureCounter, input.Data, device.LastSignature)
-	signature, err := algo.Sign(kp.PrivateKey(), []byte(data))
-	if err != nil {
-		common.WriteErrorResponse(response, http.StatusInternalServerError, []string{
-			"Something is wrong on our side, please try again in a few moments, our development team has been notified",
-		})
-		// log err to system log, email, prometheus, whatever, skipped for brevity
-		return
+	if replayed {
+		response.Header().Set(IdempotentReplayedHeader, "true")
 	}
 
-	base64encodedSignature := base64.StdEncoding.EncodeToString([]byte(signature))
 	output := SignTransactionResponse{
-		Signature:  base64encodedSignature,
-		SignedData: data,
+		Signature:  transaction.Signature,
+		SignedData: transaction.SignedData,
 	}
+	common.WriteAPIResponse(response, http.StatusOK, output)
+}
 
-	device.SignatureCounter++
-	device.LastSignature = base64encodedSignature
-
-	err = as.Save(device.ID, device)
-	if err != nil {
-		common.WriteErrorResponse(response, http.StatusInternalServerError, []string{
-			"Something is wrong on our side, please try again in a few moments, our development team has been notified",
-		})
-		// log err to system log, email, prometheus, whatever, skipped for brevity
-		return
+// idempotencyKey returns the idempotency key of @request, given by header or as @fromBody, empty if none
+func idempotencyKey(request *http.Request, fromBody string) (string, error) {
+	fromHeader := request.Header.Get(IdempotencyKeyHeader)
+	if fromHeader != "" && fromBody != "" && fromHeader != fromBody {
+		return "", errors.New("Idempotency key of the header and of the body differ")
 	}
-
-	common.WriteAPIResponse(response, http.StatusOK, output)
+	if fromHeader != "" {
+		return fromHeader, nil
+	}
+	return fromBody, nil
 }
 
 func init() {
@@ -2,12 +2,17 @@ package routes_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
@@ -17,6 +22,7 @@ import (
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/testutil/mocks"
 )
 
@@ -90,8 +96,23 @@ func TestSignTransaction(t *testing.T) {
 			So(rec.Body.String(), ShouldContainSubstring, "not found")
 		})
 
+		Convey("returns 503 if the device stays locked by another request", func() {
+			persistence.GetLockManager().Lock("busy")
+			defer persistence.GetLockManager().Unlock("busy")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			req := httptest.NewRequest(http.MethodPost, "/api/v0/sign_transaction", bytes.NewBuffer([]byte(`{"device_id":"busy","data":"payload"}`)))
+			rec := httptest.NewRecorder()
+
+			routes.SignTransaction(rec, req.WithContext(ctx))
+
+			So(rec.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(rec.Body.String(), ShouldContainSubstring, "is busy")
+		})
+
 		Convey("returns 500 if algorithm not available", func() {
-			dev := &domain.Device{ID: "dev123", Algorithm: "RSA"}
+			dev := &domain.Device{ID: "dev123", Algorithm: "RSA", Status: domain.DeviceActive}
 			mockDB.EXPECT().Load("dev123").Return(dev, nil)
 			crypto.RegisterAlgorithm("RSA", nil)
 
@@ -105,7 +126,7 @@ func TestSignTransaction(t *testing.T) {
 		})
 
 		Convey("returns 500 if ConstructKeyPair fails", func() {
-			dev := &domain.Device{ID: "dev123", Algorithm: "RSA"}
+			dev := &domain.Device{ID: "dev123", Algorithm: "RSA", Status: domain.DeviceActive}
 			mockDB.EXPECT().Load("dev123").Return(dev, nil)
 			crypto.RegisterAlgorithm("RSA", mockAlgo)
 			mockAlgo.EXPECT().ConstructKeyPair(gomock.Any()).Return(nil, errors.New("keypair fail"))
@@ -120,7 +141,7 @@ func TestSignTransaction(t *testing.T) {
 		})
 
 		Convey("returns 500 if Sign fails", func() {
-			dev := &domain.Device{ID: "dev123", Algorithm: "RSA"}
+			dev := &domain.Device{ID: "dev123", Algorithm: "RSA", Status: domain.DeviceActive}
 			mockDB.EXPECT().Load("dev123").Return(dev, nil)
 			crypto.RegisterAlgorithm("RSA", mockAlgo)
 			mockAlgo.EXPECT().ConstructKeyPair(gomock.Any()).Return(mockKeyPair, nil)
@@ -136,10 +157,10 @@ func TestSignTransaction(t *testing.T) {
 			So(rec.Body.String(), ShouldContainSubstring, "Something is wrong on our side")
 		})
 
-		Convey("returns 500 if Save fails", func() {
-			dev := &domain.Device{ID: "dev123", Algorithm: "RSA"}
+		Convey("returns 500 if CompareAndSaveWithTransaction fails", func() {
+			dev := &domain.Device{ID: "dev123", Algorithm: "RSA", Status: domain.DeviceActive}
 			mockDB.EXPECT().Load("dev123").Return(dev, nil)
-			mockDB.EXPECT().Save("dev123", gomock.Any()).Return(errors.New("save fail"))
+			mockDB.EXPECT().CompareAndSaveWithTransaction("dev123", gomock.Any(), 0, gomock.Any(), gomock.Any()).Return(errors.New("save fail"))
 
 			crypto.RegisterAlgorithm("RSA", mockAlgo)
 			mockAlgo.EXPECT().ConstructKeyPair(gomock.Any()).Return(mockKeyPair, nil)
@@ -155,16 +176,59 @@ func TestSignTransaction(t *testing.T) {
 			So(rec.Body.String(), ShouldContainSubstring, "Something is wrong on our side")
 		})
 
+		Convey("retries when the device was saved concurrently, then succeeds", func() {
+			mockDB.EXPECT().Load("dev123").DoAndReturn(func(id string) (*domain.Device, error) {
+				return &domain.Device{ID: id, Algorithm: "RSA", Status: domain.DeviceActive, Version: 4}, nil
+			}).Times(2)
+			gomock.InOrder(
+				mockDB.EXPECT().CompareAndSaveWithTransaction("dev123", gomock.Any(), 4, gomock.Any(), gomock.Any()).Return(&persistence.VersionConflictError{ID: "dev123", Expected: 4, Actual: 5}),
+				mockDB.EXPECT().CompareAndSaveWithTransaction("dev123", gomock.Any(), 4, gomock.Any(), gomock.Any()).Return(nil),
+			)
+
+			crypto.RegisterAlgorithm("RSA", mockAlgo)
+			mockAlgo.EXPECT().ConstructKeyPair(gomock.Any()).Return(mockKeyPair, nil).Times(2)
+			mockKeyPair.EXPECT().PrivateKey().Return("priv").Times(2)
+			mockAlgo.EXPECT().Sign("priv", gomock.Any()).Return([]byte("sig"), nil).Times(2)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v0/sign_transaction", bytes.NewBuffer([]byte(`{"device_id":"dev123","data":"payload"}`)))
+			rec := httptest.NewRecorder()
+
+			routes.SignTransaction(rec, req)
+
+			So(rec.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("returns 409 when every attempt conflicts", func() {
+			mockDB.EXPECT().Load("dev123").DoAndReturn(func(id string) (*domain.Device, error) {
+				return &domain.Device{ID: id, Algorithm: "RSA", Status: domain.DeviceActive}, nil
+			}).Times(service.MaxSignAttempts)
+			mockDB.EXPECT().CompareAndSaveWithTransaction("dev123", gomock.Any(), 0, gomock.Any(), gomock.Any()).Return(&persistence.VersionConflictError{ID: "dev123", Actual: 1}).Times(service.MaxSignAttempts)
+
+			crypto.RegisterAlgorithm("RSA", mockAlgo)
+			mockAlgo.EXPECT().ConstructKeyPair(gomock.Any()).Return(mockKeyPair, nil).Times(service.MaxSignAttempts)
+			mockKeyPair.EXPECT().PrivateKey().Return("priv").Times(service.MaxSignAttempts)
+			mockAlgo.EXPECT().Sign("priv", gomock.Any()).Return([]byte("sig"), nil).Times(service.MaxSignAttempts)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v0/sign_transaction", bytes.NewBuffer([]byte(`{"device_id":"dev123","data":"payload"}`)))
+			rec := httptest.NewRecorder()
+
+			routes.SignTransaction(rec, req)
+
+			So(rec.Code, ShouldEqual, http.StatusConflict)
+			So(rec.Body.String(), ShouldContainSubstring, "modified concurrently")
+		})
+
 		Convey("returns 200 on success", func() {
 			dev := &domain.Device{
 				ID:               "dev123",
 				Algorithm:        "RSA",
+				Status:           domain.DeviceActive,
 				SignatureCounter: 0,
 				LastSignature:    "",
 			}
 
 			mockDB.EXPECT().Load("dev123").Return(dev, nil)
-			mockDB.EXPECT().Save("dev123", gomock.Any()).Return(nil)
+			mockDB.EXPECT().CompareAndSaveWithTransaction("dev123", gomock.Any(), 0, gomock.Any(), gomock.Any()).Return(nil)
 
 			crypto.RegisterAlgorithm("RSA", mockAlgo)
 			mockAlgo.EXPECT().ConstructKeyPair(gomock.Any()).Return(mockKeyPair, nil)
@@ -187,3 +251,94 @@ func TestSignTransaction(t *testing.T) {
 		})
 	})
 }
+
+func TestSignTransactionConcurrency(t *testing.T) {
+	Convey("Given a real device in an InMemoryDB", t, func() {
+		db := persistence.NewInMemoryDB()
+		persistence.SetInstance(db)
+
+		algo := crypto.GetAlgorithm("ecc")
+		kp, err := algo.GenerateKeyPair()
+		So(err, ShouldBeNil)
+		_, priv, err := kp.Serialize()
+		So(err, ShouldBeNil)
+
+		So(db.Save("race", &domain.Device{
+			ID:            "race",
+			Algorithm:     "ecc",
+			Status:        domain.DeviceActive,
+			PrivateKey:    priv,
+			LastSignature: base64.StdEncoding.EncodeToString([]byte("race")),
+		}), ShouldBeNil)
+
+		// waiting is bounded generously here, so a slow or loaded machine queues the requests instead of
+		// answering them with 503
+		service.SetLockWait(time.Minute)
+		defer service.SetLockWait(persistence.DefaultLockTimeout)
+
+		Convey("when hundreds of sign requests hit it concurrently", func() {
+			const requests = 300
+			var wg sync.WaitGroup
+			results := make(chan routes.SignTransactionResponse, requests)
+			codes := make(chan int, requests)
+
+			for i := 0; i < requests; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					body := []byte(`{"device_id":"race","data":"payload"}`)
+					req := httptest.NewRequest(http.MethodPost, "/api/v0/sign_transaction", bytes.NewBuffer(body))
+					rec := httptest.NewRecorder()
+					routes.SignTransaction(rec, req)
+					codes <- rec.Code
+
+					var resp signAPIResponse
+					if json.Unmarshal(rec.Body.Bytes(), &resp) == nil {
+						results <- resp.Data
+					}
+				}()
+			}
+			wg.Wait()
+			close(results)
+			close(codes)
+
+			Convey("every request signs, counters run from 0 to 299 and each signature chains from the previous one", func() {
+				for code := range codes {
+					So(code, ShouldEqual, http.StatusOK)
+				}
+				So(results, ShouldHaveLength, requests)
+
+				byCounter := make(map[int]routes.SignTransactionResponse)
+				for result := range results {
+					counter, err := strconv.Atoi(strings.SplitN(result.SignedData, "_", 2)[0])
+					So(err, ShouldBeNil)
+					_, duplicate := byCounter[counter]
+					So(duplicate, ShouldBeFalse)
+					byCounter[counter] = result
+				}
+				So(byCounter, ShouldHaveLength, requests)
+				for counter := 0; counter < requests; counter++ {
+					So(byCounter, ShouldContainKey, counter)
+				}
+
+				for counter := 1; counter < requests; counter++ {
+					So(byCounter[counter].SignedData, ShouldEndWith, "_"+byCounter[counter-1].Signature)
+				}
+
+				device, err := db.Load("race")
+				So(err, ShouldBeNil)
+				So(device.SignatureCounter, ShouldEqual, requests)
+				So(device.LastSignature, ShouldEqual, byCounter[requests-1].Signature)
+
+				transactions, err := db.ListTransactions("race", 0, 0)
+				So(err, ShouldBeNil)
+				So(transactions, ShouldHaveLength, requests)
+				for _, transaction := range transactions {
+					So(transaction.Signature, ShouldEqual, byCounter[transaction.Counter].Signature)
+				}
+				So(persistence.GetLockManager().Len(), ShouldEqual, 0)
+			})
+		})
+	})
+}
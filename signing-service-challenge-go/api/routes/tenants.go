@@ -0,0 +1,145 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+	"net/http"
+	"time"
+)
+
+type CreateTenantRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+func (request *CreateTenantRequest) UnmarshalJSON(data []byte) error {
+	type Alias CreateTenantRequest // Avoid recursion
+	aux := &struct {
+		*Alias
+	}{
+		Alias: (*Alias)(request),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if request.ID == "" {
+		return errors.New("Tenant ID is required")
+	}
+
+	return nil
+}
+
+// TenantView is the public representation of a tenant
+type TenantView struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewTenantView builds the public representation of @tenant
+func NewTenantView(tenant *domain.Tenant) TenantView {
+	return TenantView{
+		ID:        tenant.ID,
+		Name:      tenant.Name,
+		Status:    string(tenant.Status),
+		CreatedAt: tenant.CreatedAt,
+		UpdatedAt: tenant.UpdatedAt,
+	}
+}
+
+type ListTenantsResponse struct {
+	Tenants []TenantView `json:"tenants"`
+}
+
+// CreateTenant creates a tenant
+func CreateTenant(response http.ResponseWriter, request *http.Request) {
+	var input CreateTenantRequest
+	if err := common.ParseJSONRequestBody(request.Body, &input); err != nil {
+		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
+			err.Error(),
+		})
+		return
+	}
+
+	tenant, err := service.CreateTenant(request.Context(), service.CreateTenantInput{
+		ID:   input.ID,
+		Name: input.Name,
+	})
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	response.Header().Set("Location", "/api/v1/admin/tenants/"+tenant.ID)
+	common.WriteAPIResponse(response, http.StatusCreated, NewTenantView(tenant))
+}
+
+// ListTenants lists all tenants
+func ListTenants(response http.ResponseWriter, request *http.Request) {
+	tenants, err := service.ListTenants(request.Context())
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	output := ListTenantsResponse{
+		Tenants: make([]TenantView, 0, len(tenants)),
+	}
+	for _, tenant := range tenants {
+		output.Tenants = append(output.Tenants, NewTenantView(tenant))
+	}
+	common.WriteAPIResponse(response, http.StatusOK, output)
+}
+
+// GetTenant returns the tenant {id}
+func GetTenant(response http.ResponseWriter, request *http.Request) {
+	writeTenant(response, request, service.GetTenant)
+}
+
+// SuspendTenant suspends the tenant {id}, its devices cannot be used until it is resumed
+func SuspendTenant(response http.ResponseWriter, request *http.Request) {
+	writeTenant(response, request, service.SuspendTenant)
+}
+
+// ResumeTenant makes the devices of the suspended tenant {id} usable again
+func ResumeTenant(response http.ResponseWriter, request *http.Request) {
+	writeTenant(response, request, service.ResumeTenant)
+}
+
+// DeleteTenant deletes the tenant {id} with all its devices and API keys
+func DeleteTenant(response http.ResponseWriter, request *http.Request) {
+	if err := service.DeleteTenant(request.Context(), request.PathValue("id")); err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// writeTenant responds with the tenant {id} as returned by @operation
+func writeTenant(response http.ResponseWriter, request *http.Request, operation func(context.Context, string) (*domain.Tenant, error)) {
+	tenant, err := operation(request.Context(), request.PathValue("id"))
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	common.WriteAPIResponse(response, http.StatusOK, NewTenantView(tenant))
+}
+
+func init() {
+	common.RegisterRoute("POST /api/v1/admin/tenants", CreateTenant)
+	common.RegisterRoute("GET /api/v1/admin/tenants", ListTenants)
+	common.RegisterRoute("GET /api/v1/admin/tenants/{id}", GetTenant)
+	common.RegisterRoute("POST /api/v1/admin/tenants/{id}/suspend", SuspendTenant)
+	common.RegisterRoute("POST /api/v1/admin/tenants/{id}/resume", ResumeTenant)
+	common.RegisterRoute("DELETE /api/v1/admin/tenants/{id}", DeleteTenant)
+}
@@ -0,0 +1,235 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// Page size of ListTransactions when no limit is given
+	DefaultTransactionPageSize = 100
+	// Largest page size ListTransactions accepts
+	MaxTransactionPageSize = 1000
+)
+
+type CreateTransactionRequest struct {
+	Data string `json:"data"`
+	// Alternative to the Idempotency-Key header
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+func (request *CreateTransactionRequest) UnmarshalJSON(data []byte) error {
+	type Alias CreateTransactionRequest // Avoid recursion
+	aux := &struct {
+		*Alias
+	}{
+		Alias: (*Alias)(request),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if request.Data == "" {
+		return errors.New("Data is required")
+	}
+
+	return nil
+}
+
+type CreateVerificationRequest struct {
+	Data      string `json:"data"`
+	Signature string `json:"signature"`
+	// Position in the chain to verify at, @Data is then the original data instead of the signed data
+	Counter *int `json:"counter,omitempty"`
+}
+
+func (request *CreateVerificationRequest) UnmarshalJSON(data []byte) error {
+	type Alias CreateVerificationRequest // Avoid recursion
+	aux := &struct {
+		*Alias
+	}{
+		Alias: (*Alias)(request),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if request.Data == "" {
+		return errors.New("Data is required")
+	}
+	if request.Signature == "" {
+		return errors.New("Signature is required")
+	}
+	if request.Counter != nil && *request.Counter < 0 {
+		return errors.New("Counter must not be negative")
+	}
+
+	return nil
+}
+
+// TransactionView is the public representation of a signed transaction
+type TransactionView struct {
+	DeviceID   string     `json:"device_id"`
+	Counter    int        `json:"counter"`
+	Data       string     `json:"data,omitempty"`
+	SignedData string     `json:"signed_data,omitempty"`
+	Signature  string     `json:"signature"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+}
+
+type ListTransactionsResponse struct {
+	Transactions []TransactionView `json:"transactions"`
+	NextFrom     *int              `json:"next_from,omitempty"` // empty = no more transactions
+}
+
+// NewTransactionView builds the public representation of @transaction
+func NewTransactionView(transaction *domain.Transaction) TransactionView {
+	view := TransactionView{
+		DeviceID:   transaction.DeviceID,
+		Counter:    transaction.Counter,
+		Data:       transaction.Data,
+		SignedData: transaction.SignedData,
+		Signature:  transaction.Signature,
+	}
+	if !transaction.CreatedAt.IsZero() {
+		createdAt := transaction.CreatedAt
+		view.CreatedAt = &createdAt
+	}
+	return view
+}
+
+// CreateTransaction signs the given data with device {id}, only once per idempotency key if one is given
+func CreateTransaction(response http.ResponseWriter, request *http.Request) {
+	var input CreateTransactionRequest
+	if err := common.ParseJSONRequestBody(request.Body, &input); err != nil {
+		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
+			err.Error(),
+		})
+		return
+	}
+
+	key, err := idempotencyKey(request, input.IdempotencyKey)
+	if err != nil {
+		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
+			err.Error(),
+		})
+		return
+	}
+
+	transaction, replayed, err := service.SignTransactionOnce(request.Context(), request.PathValue("id"), input.Data, key)
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+	if replayed {
+		response.Header().Set(IdempotentReplayedHeader, "true")
+	}
+
+	response.Header().Set("Location", "/api/v1/devices/"+transaction.DeviceID+"/transactions/"+strconv.Itoa(transaction.Counter))
+	common.WriteAPIResponse(response, http.StatusCreated, NewTransactionView(transaction))
+}
+
+// GetTransaction returns transaction {counter} of device {id}
+func GetTransaction(response http.ResponseWriter, request *http.Request) {
+	counter, err := strconv.Atoi(request.PathValue("counter"))
+	if err != nil {
+		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
+			"Transaction counter must be a number",
+		})
+		return
+	}
+
+	transaction, err := service.GetTransaction(request.Context(), request.PathValue("id"), counter)
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	common.WriteAPIResponse(response, http.StatusOK, NewTransactionView(transaction))
+}
+
+// ListTransactions pages through the transactions of device {id} in signing order, starting at counter
+// ?from= (default 0) and returning at most ?limit= (default DefaultTransactionPageSize) of them
+func ListTransactions(response http.ResponseWriter, request *http.Request) {
+	from, err := queryInt(request, "from", 0)
+	if err != nil {
+		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
+			err.Error(),
+		})
+		return
+	}
+	limit, err := queryInt(request, "limit", DefaultTransactionPageSize)
+	if err != nil || limit < 1 || limit > MaxTransactionPageSize {
+		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
+			"Limit must be a number from 1 to " + strconv.Itoa(MaxTransactionPageSize),
+		})
+		return
+	}
+
+	// one more than asked tells whether there is a next page
+	transactions, err := service.ListTransactions(request.Context(), request.PathValue("id"), from, limit+1)
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	output := ListTransactionsResponse{
+		Transactions: make([]TransactionView, 0, len(transactions)),
+	}
+	if len(transactions) > limit {
+		nextFrom := transactions[limit].Counter
+		output.NextFrom = &nextFrom
+		transactions = transactions[:limit]
+	}
+	for _, transaction := range transactions {
+		output.Transactions = append(output.Transactions, NewTransactionView(transaction))
+	}
+	common.WriteAPIResponse(response, http.StatusOK, output)
+}
+
+// CreateVerification verifies the given data and signature against device {id}, at the given counter if any
+func CreateVerification(response http.ResponseWriter, request *http.Request) {
+	var input CreateVerificationRequest
+	if err := common.ParseJSONRequestBody(request.Body, &input); err != nil {
+		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
+			err.Error(),
+		})
+		return
+	}
+
+	result, err := verifySignature(request, request.PathValue("id"), input.Data, input.Signature, input.Counter)
+	if err != nil {
+		writeServiceError(response, err)
+		return
+	}
+
+	common.WriteAPIResponse(response, http.StatusOK, NewVerifySignatureResponse(result))
+}
+
+func init() {
+	common.RegisterRoute("POST /api/v1/devices/{id}/transactions", CreateTransaction)
+	common.RegisterRoute("GET /api/v1/devices/{id}/transactions", ListTransactions)
+	common.RegisterRoute("GET /api/v1/devices/{id}/transactions/{counter}", GetTransaction)
+	common.RegisterRoute("POST /api/v1/devices/{id}/verifications", CreateVerification)
+}
+
+// queryInt returns query parameter @name of @request as a number, or @fallback if it is not given
+func queryInt(request *http.Request, name string, fallback int) (int, error) {
+	value := request.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("Query parameter " + name + " must be a number")
+	}
+	return number, nil
+}
@@ -0,0 +1,194 @@
+package routes_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/routes"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+type transactionAPIResponse struct {
+	Data routes.TransactionView `json:"data"`
+}
+
+type listTransactionsAPIResponse struct {
+	Data routes.ListTransactionsResponse `json:"data"`
+}
+
+func TestTransactionsV1(t *testing.T) {
+	Convey("Given a device created through v1", t, func() {
+		persistence.SetInstance(persistence.NewInMemoryDB())
+		rec := serveMux(http.MethodPost, "/api/v1/devices/till", `{"algorithm":"ed25519"}`)
+		So(rec.Code, ShouldEqual, http.StatusCreated)
+
+		Convey("POST transactions signs data, chaining from the previous signature", func() {
+			rec := serveMux(http.MethodPost, "/api/v1/devices/till/transactions", `{"data":"receipt 1"}`)
+			So(rec.Code, ShouldEqual, http.StatusCreated)
+			So(rec.Header().Get("Location"), ShouldEqual, "/api/v1/devices/till/transactions/0")
+
+			var first transactionAPIResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &first), ShouldBeNil)
+			So(first.Data.Counter, ShouldEqual, 0)
+			So(first.Data.SignedData, ShouldEqual, "0_receipt 1_dGlsbA==")
+			So(first.Data.CreatedAt, ShouldNotBeNil)
+
+			rec = serveMux(http.MethodPost, "/api/v0/sign_transaction", `{"device_id":"till","data":"receipt 2"}`)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			var second signAPIResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &second), ShouldBeNil)
+			So(second.Data.SignedData, ShouldEqual, "1_receipt 2_"+first.Data.Signature)
+
+			Convey("and every transaction can be fetched by its counter", func() {
+				rec := serveMux(http.MethodGet, "/api/v1/devices/till/transactions/0", "")
+				So(rec.Code, ShouldEqual, http.StatusOK)
+
+				var got transactionAPIResponse
+				So(json.Unmarshal(rec.Body.Bytes(), &got), ShouldBeNil)
+				So(got.Data, ShouldResemble, first.Data)
+
+				rec = serveMux(http.MethodGet, "/api/v1/devices/till/transactions/1", "")
+				So(rec.Code, ShouldEqual, http.StatusOK)
+				So(json.Unmarshal(rec.Body.Bytes(), &got), ShouldBeNil)
+				So(got.Data.Data, ShouldEqual, "receipt 2")
+				So(got.Data.Signature, ShouldEqual, second.Data.Signature)
+
+				rec = serveMux(http.MethodGet, "/api/v1/devices/till/transactions/2", "")
+				So(rec.Code, ShouldEqual, http.StatusNotFound)
+			})
+
+			Convey("and the history can be paged through", func() {
+				rec := serveMux(http.MethodGet, "/api/v1/devices/till/transactions?limit=1", "")
+				So(rec.Code, ShouldEqual, http.StatusOK)
+
+				var page listTransactionsAPIResponse
+				So(json.Unmarshal(rec.Body.Bytes(), &page), ShouldBeNil)
+				So(page.Data.Transactions, ShouldHaveLength, 1)
+				So(page.Data.Transactions[0].Counter, ShouldEqual, 0)
+				So(page.Data.NextFrom, ShouldNotBeNil)
+				So(*page.Data.NextFrom, ShouldEqual, 1)
+
+				rec = serveMux(http.MethodGet, "/api/v1/devices/till/transactions?limit=1&from=1", "")
+				So(rec.Code, ShouldEqual, http.StatusOK)
+
+				page = listTransactionsAPIResponse{}
+				So(json.Unmarshal(rec.Body.Bytes(), &page), ShouldBeNil)
+				So(page.Data.Transactions, ShouldHaveLength, 1)
+				So(page.Data.Transactions[0].Signature, ShouldEqual, second.Data.Signature)
+				So(page.Data.NextFrom, ShouldBeNil)
+			})
+
+			Convey("and invalid paging parameters are a bad request", func() {
+				So(serveMux(http.MethodGet, "/api/v1/devices/till/transactions?limit=0", "").Code, ShouldEqual, http.StatusBadRequest)
+				So(serveMux(http.MethodGet, "/api/v1/devices/till/transactions?limit=100000", "").Code, ShouldEqual, http.StatusBadRequest)
+				So(serveMux(http.MethodGet, "/api/v1/devices/till/transactions?from=x", "").Code, ShouldEqual, http.StatusBadRequest)
+				So(serveMux(http.MethodGet, "/api/v1/devices/till/transactions?from=-1", "").Code, ShouldEqual, http.StatusBadRequest)
+			})
+
+			Convey("and a counter that is not a number is a bad request", func() {
+				rec := serveMux(http.MethodGet, "/api/v1/devices/till/transactions/first", "")
+				So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			})
+
+			Convey("and the signature verifies", func() {
+				body, _ := json.Marshal(map[string]string{"data": first.Data.SignedData, "signature": first.Data.Signature})
+				rec := serveMux(http.MethodPost, "/api/v1/devices/till/verifications", string(body))
+				So(rec.Code, ShouldEqual, http.StatusOK)
+
+				var resp verifyAPIResponse
+				So(json.Unmarshal(rec.Body.Bytes(), &resp), ShouldBeNil)
+				So(resp.Data.Verified, ShouldBeTrue)
+			})
+
+			Convey("and verifying at a counter reconstructs the signed data from the chain", func() {
+				verify := func(counter int, data string, signature string) verifyAPIResponse {
+					body, _ := json.Marshal(map[string]any{"counter": counter, "data": data, "signature": signature})
+					rec := serveMux(http.MethodPost, "/api/v1/devices/till/verifications", string(body))
+					So(rec.Code, ShouldEqual, http.StatusOK)
+
+					var resp verifyAPIResponse
+					So(json.Unmarshal(rec.Body.Bytes(), &resp), ShouldBeNil)
+					return resp
+				}
+
+				resp := verify(1, "receipt 2", second.Data.Signature)
+				So(resp.Data.Verified, ShouldBeTrue)
+				So(resp.Data.Result, ShouldEqual, "verified")
+				So(resp.Data.SignedData, ShouldEqual, second.Data.SignedData)
+
+				resp = verify(1, "receipt 1", first.Data.Signature)
+				So(resp.Data.Verified, ShouldBeFalse)
+				So(resp.Data.Result, ShouldEqual, "wrong_position")
+				So(resp.Data.ActualCounter, ShouldNotBeNil)
+				So(*resp.Data.ActualCounter, ShouldEqual, 0)
+
+				resp = verify(0, "receipt 1 altered", first.Data.Signature)
+				So(resp.Data.Verified, ShouldBeFalse)
+				So(resp.Data.Result, ShouldEqual, "bad_signature")
+				So(resp.Data.ActualCounter, ShouldBeNil)
+
+				resp = verify(2, "receipt 3", second.Data.Signature)
+				So(resp.Data.Verified, ShouldBeFalse)
+				So(resp.Data.Result, ShouldEqual, "unknown_counter")
+
+				rec := serveMux(http.MethodPost, "/api/v1/devices/till/verifications", `{"counter":-1,"data":"x","signature":"eA=="}`)
+				So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Convey("a request repeated with the same idempotency key returns the original transaction", func() {
+			sign := func(body string, key string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/till/transactions", bytes.NewBufferString(body))
+				if key != "" {
+					req.Header.Set(routes.IdempotencyKeyHeader, key)
+				}
+				rec := httptest.NewRecorder()
+				common.Mux().ServeHTTP(rec, req)
+				return rec
+			}
+
+			rec := sign(`{"data":"receipt 1"}`, "pos-1")
+			So(rec.Code, ShouldEqual, http.StatusCreated)
+			So(rec.Header().Get(routes.IdempotentReplayedHeader), ShouldBeEmpty)
+			var first transactionAPIResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &first), ShouldBeNil)
+
+			rec = sign(`{"data":"receipt 1"}`, "pos-1")
+			So(rec.Code, ShouldEqual, http.StatusCreated)
+			So(rec.Header().Get(routes.IdempotentReplayedHeader), ShouldEqual, "true")
+			var again transactionAPIResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &again), ShouldBeNil)
+			So(again.Data, ShouldResemble, first.Data)
+
+			rec = serveMux(http.MethodPost, "/api/v0/sign_transaction", `{"device_id":"till","data":"receipt 1","idempotency_key":"pos-1"}`)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get(routes.IdempotentReplayedHeader), ShouldEqual, "true")
+
+			rec = sign(`{"data":"receipt 2"}`, "pos-1")
+			So(rec.Code, ShouldEqual, http.StatusConflict)
+			So(rec.Body.String(), ShouldContainSubstring, "Idempotency key pos-1 was already used")
+			rec = sign(`{"data":"receipt 2","idempotency_key":"pos-2"}`, "pos-3")
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+
+			rec = serveMux(http.MethodGet, "/api/v1/devices/till", "")
+			So(rec.Body.String(), ShouldContainSubstring, `"signature_counter": 1`)
+		})
+
+		Convey("POST transactions without data is a bad request", func() {
+			rec := serveMux(http.MethodPost, "/api/v1/devices/till/transactions", `{}`)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Body.String(), ShouldContainSubstring, "Data is required")
+		})
+
+		Convey("transactions of an unknown device are not found", func() {
+			So(serveMux(http.MethodPost, "/api/v1/devices/other/transactions", `{"data":"x"}`).Code, ShouldEqual, http.StatusNotFound)
+			So(serveMux(http.MethodGet, "/api/v1/devices/other/transactions", "").Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
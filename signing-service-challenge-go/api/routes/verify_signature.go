@@ -1,12 +1,10 @@
 package routes
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
-	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
-	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
 	"net/http"
 )
 
@@ -14,6 +12,8 @@ type VerifySignatureRequest struct {
 	DeviceID  string `json:"device_id"`
 	Data      string `json:"data"`
 	Signature string `json:"signature"`
+	// Position in the chain to verify at, @Data is then the original data instead of the signed data
+	Counter *int `json:"counter,omitempty"`
 }
 
 func (request *VerifySignatureRequest) UnmarshalJSON(data []byte) error {
@@ -37,13 +37,41 @@ func (request *VerifySignatureRequest) UnmarshalJSON(data []byte) error {
 	if request.Signature == "" {
 		return errors.New("Signature is required")
 	}
+	if request.Counter != nil && *request.Counter < 0 {
+		return errors.New("Counter must not be negative")
+	}
 
 	return nil
 }
 
 type VerifySignatureResponse struct {
-	Verified bool   `json:"verified"`
-	Reason   string `json:"reason,omitempty"`
+	Verified      bool   `json:"verified"`
+	Result        string `json:"result"` // verified, bad_signature, wrong_position or unknown_counter
+	Reason        string `json:"reason,omitempty"`
+	SignedData    string `json:"signed_data,omitempty"`    // only when verifying at a counter
+	ActualCounter *int   `json:"actual_counter,omitempty"` // only for wrong_position
+}
+
+// NewVerifySignatureResponse builds the public representation of @result
+func NewVerifySignatureResponse(result *service.VerifyResult) VerifySignatureResponse {
+	output := VerifySignatureResponse{
+		Verified:   result.Verified,
+		Result:     string(result.Outcome),
+		Reason:     result.Reason,
+		SignedData: result.SignedData,
+	}
+	if result.Outcome == service.VerifyWrongPosition {
+		output.ActualCounter = &result.ActualCounter
+	}
+	return output
+}
+
+// verifySignature verifies @signature of @data against device @id, at its position in the chain if @counter is given
+func verifySignature(request *http.Request, id string, data string, signature string, counter *int) (*service.VerifyResult, error) {
+	if counter != nil {
+		return service.VerifyTransaction(request.Context(), id, *counter, data, signature)
+	}
+	return service.VerifySignature(request.Context(), id, data, signature)
 }
 
 func VerifySignature(response http.ResponseWriter, request *http.Request) {
@@ -62,55 +90,13 @@ func VerifySignature(response http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	db := persistence.GetInstance()
-	as := persistence.NewAtomicStorage(db)
-	as.Lock(input.DeviceID)
-	defer as.Unlock(input.DeviceID)
-
-	device, err := as.Load(input.DeviceID)
-	if err != nil {
-		common.WriteErrorResponse(response, http.StatusNotFound, []string{
-			err.Error(),
-		})
-		return
-	}
-
-	algo := crypto.GetAlgorithm(device.Algorithm)
-	if algo == nil {
-		// shouldn't happen, but possible to happen, so we still handle it,
-		// however this is not user error and therefore, an internal server error
-		common.WriteErrorResponse(response, http.StatusInternalServerError, []string{
-			"Something is wrong on our side, please try again in a few moments, our development team has been notified",
-		})
-		// log err to system log, email, prometheus, whatever, skipped for brevity
-		return
-	}
-
-	kp, err := algo.ConstructKeyPair(device.PrivateKey)
-	if err != nil {
-		common.WriteErrorResponse(response, http.StatusInternalServerError, []string{
-			"Something is wrong on our side, please try again in a few moments, our development team has been notified",
-		})
-		// log err to system log, email, prometheus, whatever, skipped for brevity
-		return
-	}
-
-	base64decodedSignature, err := base64.StdEncoding.DecodeString(input.Signature)
+	result, err := verifySignature(request, input.DeviceID, input.Data, input.Signature, input.Counter)
 	if err != nil {
-		common.WriteErrorResponse(response, http.StatusBadRequest, []string{
-			err.Error(),
-		})
+		writeServiceError(response, err)
 		return
 	}
 
-	err = algo.Verify(kp.PublicKey(), []byte(input.Data), base64decodedSignature)
-	output := VerifySignatureResponse{
-		Verified: err == nil,
-	}
-	if err != nil {
-		output.Reason = err.Error()
-	}
-	common.WriteAPIResponse(response, http.StatusOK, output)
+	common.WriteAPIResponse(response, http.StatusOK, NewVerifySignatureResponse(result))
 }
 
 func init() {
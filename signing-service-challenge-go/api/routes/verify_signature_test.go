@@ -137,7 +137,6 @@ func TestVerifySignature(t *testing.T) {
 			dev := &domain.Device{ID: "dev123", Algorithm: "RSA"}
 			mockDB.EXPECT().Load("dev123").Return(dev, nil)
 			crypto.RegisterAlgorithm("RSA", mockAlgo)
-			mockAlgo.EXPECT().ConstructKeyPair(gomock.Any()).Return(mockKeyPair, nil)
 
 			body := []byte(`{"device_id":"dev123","data":"payload","signature":"invalid-base64=="}`)
 			req := httptest.NewRequest(http.MethodPost, "/api/v0/verify_signature", bytes.NewBuffer(body))
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+)
+
+// Path served without API key, so load balancers and orchestrators can check the service
+const healthPath = "/api/v0/health"
+
+// authenticateAPIKey makes the holder of the API key given as "Authorization: Bearer <key>" or
+// "X-API-Key: <key>" a principal of every request passed on to @next, requests without valid key are
+// rejected with 401. The configured admin key is permitted everything.
+func (s *Server) authenticateAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == healthPath {
+			next.ServeHTTP(response, request)
+			return
+		}
+
+		key := request.Header.Get("X-API-Key")
+		if bearer, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer "); ok {
+			key = strings.TrimSpace(bearer)
+		}
+		if key == "" {
+			response.Header().Set("WWW-Authenticate", "Bearer")
+			common.WriteErrorResponse(response, http.StatusUnauthorized, []string{
+				"An API key is required",
+			})
+			return
+		}
+
+		var principal *auth.Principal
+		if hash := s.config.APIKeys.AdminKeyHash; hash != "" && auth.MatchesAPIKeyHash(key, hash) {
+			principal = auth.NewPrincipal("admin API key", []string{auth.AllDevices}, auth.AdminOperations)
+		} else if holder, ok := service.Authenticate(request.Context(), key); ok {
+			principal = holder
+		} else {
+			response.Header().Set("WWW-Authenticate", "Bearer")
+			common.WriteErrorResponse(response, http.StatusUnauthorized, []string{
+				"Invalid API key",
+			})
+			return
+		}
+
+		next.ServeHTTP(response, request.WithContext(auth.WithPrincipal(request.Context(), principal)))
+	})
+}
@@ -0,0 +1,183 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/server"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/config"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+func TestAPIKeys(t *testing.T) {
+	Convey("Given a server requiring API keys", t, func() {
+		persistence.SetInstance(persistence.NewInMemoryDB())
+		persistence.SetKeyStore(persistence.NewInMemoryKeyStore())
+		persistence.SetTenantStore(persistence.NewInMemoryTenantStore())
+
+		_, adminKey, err := auth.GenerateAPIKey()
+		So(err, ShouldBeNil)
+		cfg := config.Default()
+		cfg.APIKeys.Enabled = true
+		cfg.APIKeys.AdminKeyHash = auth.HashAPIKey(adminKey)
+		So(cfg.Validate(), ShouldBeNil)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		url := "http://" + listener.Addr().String()
+
+		s := server.NewServer(cfg)
+		served := make(chan error, 1)
+		go func() { served <- s.Serve(listener) }()
+		defer func() {
+			So(s.Shutdown(context.Background()), ShouldBeNil)
+			So(<-served, ShouldBeNil)
+		}()
+
+		do := func(key string, method string, path string, body string) (int, string) {
+			request, err := http.NewRequest(method, url+path, strings.NewReader(body))
+			So(err, ShouldBeNil)
+			if key != "" {
+				request.Header.Set("Authorization", "Bearer "+key)
+			}
+			response, err := http.DefaultClient.Do(request)
+			So(err, ShouldBeNil)
+			defer response.Body.Close()
+			content, err := io.ReadAll(response.Body)
+			So(err, ShouldBeNil)
+			return response.StatusCode, string(content)
+		}
+		createKey := func(body string) string {
+			code, response := do(adminKey, http.MethodPost, "/api/v1/admin/api-keys", body)
+			So(code, ShouldEqual, http.StatusCreated)
+			var created struct {
+				Data struct {
+					Key string `json:"key"`
+				} `json:"data"`
+			}
+			So(json.Unmarshal([]byte(response), &created), ShouldBeNil)
+			return created.Data.Key
+		}
+
+		for _, id := range []string{"till-1", "till-2"} {
+			code, _ := do(adminKey, http.MethodPost, "/api/v1/devices/"+id, `{"algorithm":"ed25519"}`)
+			So(code, ShouldEqual, http.StatusCreated)
+		}
+
+		Convey("the health check needs no key, everything else does", func() {
+			code, _ := do("", http.MethodGet, "/api/v0/health", "")
+			So(code, ShouldEqual, http.StatusOK)
+
+			code, body := do("", http.MethodGet, "/api/v1/devices", "")
+			So(code, ShouldEqual, http.StatusUnauthorized)
+			So(body, ShouldContainSubstring, "An API key is required")
+
+			code, body = do("0123456789abcdef.invalid", http.MethodGet, "/api/v1/devices", "")
+			So(code, ShouldEqual, http.StatusUnauthorized)
+			So(body, ShouldContainSubstring, "Invalid API key")
+		})
+
+		Convey("a key created by the admin is limited to its devices and operations", func() {
+			code, body := do(adminKey, http.MethodPost, "/api/v1/admin/api-keys", `{"name":"till 1","device_ids":["till-1"],"operations":["sign"]}`)
+			So(code, ShouldEqual, http.StatusCreated)
+			var created struct {
+				Data struct {
+					ID  string `json:"id"`
+					Key string `json:"key"`
+				} `json:"data"`
+			}
+			So(json.Unmarshal([]byte(body), &created), ShouldBeNil)
+			key := created.Data.Key
+			So(key, ShouldNotBeEmpty)
+
+			code, body = do(adminKey, http.MethodGet, "/api/v1/admin/api-keys/"+created.Data.ID, "")
+			So(code, ShouldEqual, http.StatusOK)
+			So(body, ShouldNotContainSubstring, key)
+
+			request, err := http.NewRequest(http.MethodPost, url+"/api/v1/devices/till-1/transactions", strings.NewReader(`{"data":"receipt"}`))
+			So(err, ShouldBeNil)
+			request.Header.Set("X-API-Key", key)
+			response, err := http.DefaultClient.Do(request)
+			So(err, ShouldBeNil)
+			response.Body.Close()
+			So(response.StatusCode, ShouldEqual, http.StatusCreated)
+
+			code, body = do(key, http.MethodPost, "/api/v0/sign_transaction", `{"device_id":"till-2","data":"receipt"}`)
+			So(code, ShouldEqual, http.StatusForbidden)
+			So(body, ShouldContainSubstring, "Not allowed to use device till-2")
+
+			code, _ = do(key, http.MethodGet, "/api/v1/devices/till-1", "")
+			So(code, ShouldEqual, http.StatusForbidden)
+			code, _ = do(key, http.MethodGet, "/api/v1/admin/api-keys", "")
+			So(code, ShouldEqual, http.StatusForbidden)
+
+			Convey("until it is revoked", func() {
+				code, _ := do(adminKey, http.MethodDelete, "/api/v1/admin/api-keys/"+created.Data.ID, "")
+				So(code, ShouldEqual, http.StatusNoContent)
+
+				code, _ = do(key, http.MethodPost, "/api/v0/sign_transaction", `{"device_id":"till-1","data":"receipt"}`)
+				So(code, ShouldEqual, http.StatusUnauthorized)
+			})
+		})
+
+		Convey("only the admin downloads backups", func() {
+			code, body := do(adminKey, http.MethodGet, "/api/v1/admin/backup", "")
+			So(code, ShouldEqual, http.StatusOK)
+			So(body, ShouldNotBeEmpty)
+
+			key := createKey(`{"name":"till 1","device_ids":["till-1"],"operations":["sign"]}`)
+			code, _ = do(key, http.MethodGet, "/api/v1/admin/backup", "")
+			So(code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("tenants have devices of their own, managed by the admin", func() {
+			keys := map[string]string{}
+			for _, tenant := range []string{"shop-1", "shop-2"} {
+				code, _ := do(adminKey, http.MethodPost, "/api/v1/admin/tenants", `{"id":"`+tenant+`","name":"Shop"}`)
+				So(code, ShouldEqual, http.StatusCreated)
+				keys[tenant] = createKey(`{"tenant_id":"` + tenant + `","device_ids":["*"],"operations":["create","sign","list"]}`)
+
+				code, _ = do(keys[tenant], http.MethodPost, "/api/v1/devices/till-1", `{"algorithm":"ed25519"}`)
+				So(code, ShouldEqual, http.StatusCreated)
+			}
+
+			code, body := do(keys["shop-1"], http.MethodGet, "/api/v0/list_devices", "")
+			So(code, ShouldEqual, http.StatusOK)
+			So(body, ShouldContainSubstring, `"tenant_id": "shop-1"`)
+			So(body, ShouldNotContainSubstring, "shop-2")
+			So(body, ShouldNotContainSubstring, "till-2")
+
+			code, _ = do(keys["shop-1"], http.MethodGet, "/api/v1/admin/tenants", "")
+			So(code, ShouldEqual, http.StatusForbidden)
+
+			code, body = do(adminKey, http.MethodPost, "/api/v1/admin/tenants/shop-1/suspend", "")
+			So(code, ShouldEqual, http.StatusOK)
+			So(body, ShouldContainSubstring, `"status": "suspended"`)
+			code, body = do(keys["shop-1"], http.MethodPost, "/api/v0/sign_transaction", `{"device_id":"till-1","data":"receipt"}`)
+			So(code, ShouldEqual, http.StatusForbidden)
+			So(body, ShouldContainSubstring, "Tenant shop-1 is suspended")
+
+			code, _ = do(adminKey, http.MethodPost, "/api/v1/admin/tenants/shop-1/resume", "")
+			So(code, ShouldEqual, http.StatusOK)
+			code, _ = do(keys["shop-1"], http.MethodPost, "/api/v0/sign_transaction", `{"device_id":"till-1","data":"receipt"}`)
+			So(code, ShouldEqual, http.StatusOK)
+
+			code, _ = do(adminKey, http.MethodDelete, "/api/v1/admin/tenants/shop-1", "")
+			So(code, ShouldEqual, http.StatusNoContent)
+			code, _ = do(keys["shop-1"], http.MethodGet, "/api/v1/devices", "")
+			So(code, ShouldEqual, http.StatusUnauthorized)
+			code, _ = do(adminKey, http.MethodGet, "/api/v1/admin/tenants/shop-1", "")
+			So(code, ShouldEqual, http.StatusNotFound)
+			code, _ = do(keys["shop-2"], http.MethodGet, "/api/v1/devices/till-1", "")
+			So(code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
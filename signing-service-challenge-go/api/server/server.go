@@ -1,25 +1,104 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
-	_ "github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/routes" // we only need to call init() functions in files inside the package
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/routes" // registers every route in its init() functions
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/config"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"io"
+	"net"
 	"net/http"
+	"time"
 )
 
 // Server manages HTTP requests and dispatches them to the appropriate services.
 type Server struct {
-	listenAddress string
+	config *config.Config
+	http   *http.Server
 }
 
-// NewServer is a factory to instantiate a new Server.
-func NewServer(listenAddress string) *Server {
-	return &Server{
-		listenAddress: listenAddress,
+// NewServer is a factory to instantiate a new Server configured by @cfg.
+func NewServer(cfg *config.Config) *Server {
+	s := &Server{
+		config: cfg,
+		http: &http.Server{
+			Addr:              cfg.ListenAddress,
+			ReadHeaderTimeout: time.Duration(cfg.Timeouts.ReadHeader),
+			ReadTimeout:       time.Duration(cfg.Timeouts.Read),
+			WriteTimeout:      time.Duration(cfg.Timeouts.Write),
+			IdleTimeout:       time.Duration(cfg.Timeouts.Idle),
+		},
 		// TODO: add services / further dependencies here ...
 	}
+
+	var handler http.Handler = common.Mux()
+	if cfg.APIKeys.Enabled {
+		handler = s.authenticateAPIKey(handler)
+	} else {
+		handler = withoutBackups(handler)
+	}
+	if cfg.ClientAuthEnabled() {
+		handler = s.authenticateClient(handler)
+	}
+	s.http.Handler = handler
+	return s
 }
 
-// Run starts the Server.
+// withoutBackups answers requests for the backup and migration endpoints as unknown and passes the others on
+// to @next. Both hand out the private key of every device, so they are not served at all when no admin can
+// authenticate.
+func withoutBackups(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case routes.BackupPath, routes.RestorePath, routes.MigratePath:
+			http.NotFound(response, request)
+			return
+		}
+		next.ServeHTTP(response, request)
+	})
+}
+
+// Run listens on the configured address and serves until Shutdown, see Serve.
 func (s *Server) Run() error {
-	return http.ListenAndServe(s.listenAddress, common.Mux())
+	listener, err := net.Listen("tcp", s.config.ListenAddress)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves requests accepted on @listener, over HTTPS if a certificate is configured, then authenticating
+// clients by their certificate if a client CA is configured. It returns nil once Shutdown is called, which
+// closes @listener.
+func (s *Server) Serve(listener net.Listener) error {
+	var err error
+	if s.config.TLSEnabled() {
+		s.http.TLSConfig, err = s.tlsConfig()
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		err = s.http.ServeTLS(listener, s.config.TLS.CertFile, s.config.TLS.KeyFile)
+	} else {
+		err = s.http.Serve(listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting requests and waits for those in flight to finish until @ctx is done, so no signed
+// transaction is left half saved. The Storage is flushed and closed afterwards, even when @ctx expired first:
+// requests still running then fail to save rather than losing what was acknowledged already.
+func (s *Server) Shutdown(ctx context.Context) error {
+	shutdownErr := s.http.Shutdown(ctx)
+
+	var closeErr error
+	if closer, ok := persistence.GetInstance().(io.Closer); ok {
+		closeErr = closer.Close()
+	}
+	return errors.Join(shutdownErr, closeErr)
 }
@@ -0,0 +1,143 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/server"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/config"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+// Requests to /test/slow block until a value is sent to release, entered tells they arrived
+var (
+	entered = make(chan struct{})
+	release = make(chan struct{})
+)
+
+func init() {
+	common.RegisterRoute("/test/slow", func(response http.ResponseWriter, request *http.Request) {
+		entered <- struct{}{}
+		<-release
+		common.WriteAPIResponse(response, http.StatusOK, "done")
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	Convey("Given a server running on a FileDB", t, func() {
+		dir := t.TempDir()
+		db, err := persistence.NewFileDB(dir, persistence.DefaultFileDBOptions())
+		So(err, ShouldBeNil)
+		persistence.SetInstance(db)
+		defer persistence.SetInstance(persistence.NewInMemoryDB())
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		url := "http://" + listener.Addr().String()
+
+		s := server.NewServer(config.Default())
+		served := make(chan error, 1)
+		go func() { served <- s.Serve(listener) }()
+
+		response, err := http.Post(url+"/api/v0/create_signature_device", "application/json", strings.NewReader(`{"device_id":"a","algorithm":"ed25519"}`))
+		So(err, ShouldBeNil)
+		response.Body.Close()
+		So(response.StatusCode, ShouldEqual, http.StatusOK)
+
+		slow := make(chan string, 1)
+		go func() {
+			response, err := http.Get(url + "/test/slow")
+			if err != nil {
+				slow <- err.Error()
+				return
+			}
+			defer response.Body.Close()
+			body, _ := io.ReadAll(response.Body)
+			slow <- string(body)
+		}()
+		<-entered
+
+		Convey("Shutdown waits for requests in flight, then closes the storage", func() {
+			shutdown := make(chan error, 1)
+			go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+			select {
+			case <-shutdown:
+				t.Fatal("Shutdown returned while a request was in flight")
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			release <- struct{}{}
+			So(<-slow, ShouldContainSubstring, "done")
+			So(<-shutdown, ShouldBeNil)
+			So(<-served, ShouldBeNil)
+
+			_, err := http.Get(url + "/api/v0/list_devices")
+			So(err, ShouldNotBeNil)
+
+			// closed, but everything acknowledged survives
+			So(db.Save("b", &domain.Device{ID: "b"}), ShouldNotBeNil)
+			reopened, err := persistence.NewFileDB(dir, persistence.DefaultFileDBOptions())
+			So(err, ShouldBeNil)
+			defer reopened.Close()
+			_, err = reopened.Load("a")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Shutdown gives up waiting once its context is done, the storage is closed anyway", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			So(errors.Is(s.Shutdown(ctx), context.DeadlineExceeded), ShouldBeTrue)
+			So(<-served, ShouldBeNil)
+			So(db.Save("b", &domain.Device{ID: "b"}), ShouldNotBeNil)
+
+			release <- struct{}{}
+			<-slow
+		})
+	})
+}
+
+func TestBackupRoutes(t *testing.T) {
+	Convey("Given a server without API keys", t, func() {
+		persistence.SetInstance(persistence.NewInMemoryDB())
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		url := "http://" + listener.Addr().String()
+
+		s := server.NewServer(config.Default())
+		served := make(chan error, 1)
+		go func() { served <- s.Serve(listener) }()
+		defer func() {
+			So(s.Shutdown(context.Background()), ShouldBeNil)
+			So(<-served, ShouldBeNil)
+		}()
+
+		Convey("backups and migrations are not served at all, as no admin could authenticate", func() {
+			response, err := http.Get(url + "/api/v1/admin/backup")
+			So(err, ShouldBeNil)
+			response.Body.Close()
+			So(response.StatusCode, ShouldEqual, http.StatusNotFound)
+
+			response, err = http.Post(url+"/api/v1/admin/restore", "application/gzip", strings.NewReader(""))
+			So(err, ShouldBeNil)
+			response.Body.Close()
+			So(response.StatusCode, ShouldEqual, http.StatusNotFound)
+
+			response, err = http.Post(url+"/api/v1/admin/migrate", "application/json", strings.NewReader(`{"backend":"file","path":"data"}`))
+			So(err, ShouldBeNil)
+			response.Body.Close()
+			So(response.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
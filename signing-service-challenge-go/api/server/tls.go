@@ -0,0 +1,73 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/common"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+)
+
+// tlsConfig returns the TLS configuration of the Server, which requires client certificates issued by the
+// configured client CA if any
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if !s.config.ClientAuthEnabled() {
+		return config, nil
+	}
+
+	bundle, err := os.ReadFile(s.config.TLS.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, errors.New("No certificate found in client CA file " + s.config.TLS.ClientCAFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+// authenticateClient makes the subject of the verified client certificate the principal of every request
+// passed on to @next, allowed to use the devices configured for it
+func (s *Server) authenticateClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 {
+			common.WriteErrorResponse(response, http.StatusUnauthorized, []string{
+				"A client certificate is required",
+			})
+			return
+		}
+
+		certificate := request.TLS.VerifiedChains[0][0]
+		subject := certificate.Subject.String()
+		deviceIDs, ok := s.config.TLS.ClientDevices[subject]
+		if !ok {
+			deviceIDs = s.config.TLS.ClientDevices["CN="+certificate.Subject.CommonName]
+		}
+
+		// a certificate restricts devices only, operations are up to API keys if any. It never permits
+		// OperationAdmin on its own, which needs the admin API key or one permitted it.
+		principal := auth.NewPrincipal(subject, clientDeviceKeys(deviceIDs), auth.AllOperations)
+		next.ServeHTTP(response, request.WithContext(auth.WithPrincipal(request.Context(), principal)))
+	})
+}
+
+// clientDeviceKeys returns the keys of the devices configured for a client certificate as @deviceIDs, each
+// either the ID of a device of the default tenant or "<tenant ID>/<device ID>"
+func clientDeviceKeys(deviceIDs []string) []string {
+	keys := make([]string, 0, len(deviceIDs))
+	for _, id := range deviceIDs {
+		if tenantID, deviceID, qualified := strings.Cut(id, "/"); qualified {
+			id = domain.DeviceKey(tenantID, deviceID)
+		}
+		keys = append(keys, id)
+	}
+	return keys
+}
@@ -0,0 +1,201 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/server"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/config"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+// issuer issues certificates for tests, self-signed if parent is nil
+type issuer struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+func newCertificate(parent *issuer, subject pkix.Name, isCA bool) *issuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer := &issuer{certificate: template, key: key}
+	if parent != nil {
+		signer = parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.certificate, &key.PublicKey, signer.key)
+	So(err, ShouldBeNil)
+	certificate, err := x509.ParseCertificate(der)
+	So(err, ShouldBeNil)
+	return &issuer{certificate: certificate, key: key}
+}
+
+func (i *issuer) certificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: i.certificate.Raw})
+}
+
+func (i *issuer) keyPEM() []byte {
+	der, err := x509.MarshalECPrivateKey(i.key)
+	So(err, ShouldBeNil)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (i *issuer) tlsCertificate() tls.Certificate {
+	certificate, err := tls.X509KeyPair(i.certificatePEM(), i.keyPEM())
+	So(err, ShouldBeNil)
+	return certificate
+}
+
+func TestMutualTLS(t *testing.T) {
+	Convey("Given a server requiring client certificates", t, func() {
+		persistence.SetInstance(persistence.NewInMemoryDB())
+		dir := t.TempDir()
+		write := func(name string, content []byte) string {
+			path := filepath.Join(dir, name)
+			So(os.WriteFile(path, content, 0o600), ShouldBeNil)
+			return path
+		}
+
+		serverCA := newCertificate(nil, pkix.Name{CommonName: "server CA"}, true)
+		serverCertificate := newCertificate(serverCA, pkix.Name{CommonName: "127.0.0.1"}, false)
+		clientCA := newCertificate(nil, pkix.Name{CommonName: "client CA"}, true)
+
+		cfg := config.Default()
+		cfg.TLS = config.TLS{
+			CertFile:     write("server.crt", serverCertificate.certificatePEM()),
+			KeyFile:      write("server.key", serverCertificate.keyPEM()),
+			ClientCAFile: write("client-ca.crt", clientCA.certificatePEM()),
+			ClientDevices: map[string][]string{
+				"CN=till-1":              {"till-1"},
+				"CN=backoffice,O=Shop":   {"*"},
+				"CN=backoffice":          {"till-2"},
+				"CN=backoffice,O=Rogues": {},
+			},
+		}
+		So(cfg.Validate(), ShouldBeNil)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		url := "https://" + listener.Addr().String()
+
+		s := server.NewServer(cfg)
+		served := make(chan error, 1)
+		go func() { served <- s.Serve(listener) }()
+		defer func() {
+			So(s.Shutdown(context.Background()), ShouldBeNil)
+			So(<-served, ShouldBeNil)
+		}()
+
+		trusted := x509.NewCertPool()
+		trusted.AddCert(serverCA.certificate)
+		client := func(subject *pkix.Name) *http.Client {
+			config := &tls.Config{RootCAs: trusted}
+			if subject != nil {
+				config.Certificates = []tls.Certificate{newCertificate(clientCA, *subject, false).tlsCertificate()}
+			}
+			return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		}
+		do := func(client *http.Client, method string, path string, body string) (int, string) {
+			request, err := http.NewRequest(method, url+path, strings.NewReader(body))
+			So(err, ShouldBeNil)
+			response, err := client.Do(request)
+			So(err, ShouldBeNil)
+			defer response.Body.Close()
+			content, err := io.ReadAll(response.Body)
+			So(err, ShouldBeNil)
+			return response.StatusCode, string(content)
+		}
+
+		backoffice := client(&pkix.Name{CommonName: "backoffice", Organization: []string{"Shop"}})
+		for _, id := range []string{"till-1", "till-2"} {
+			code, _ := do(backoffice, http.MethodPost, "/api/v1/devices/"+id, `{"algorithm":"ed25519"}`)
+			So(code, ShouldEqual, http.StatusCreated)
+		}
+
+		Convey("a till may only use the devices mapped to its certificate subject", func() {
+			till := client(&pkix.Name{CommonName: "till-1", Organization: []string{"Shop"}})
+
+			code, _ := do(till, http.MethodPost, "/api/v1/devices/till-1/transactions", `{"data":"receipt"}`)
+			So(code, ShouldEqual, http.StatusCreated)
+
+			code, body := do(till, http.MethodPost, "/api/v0/sign_transaction", `{"device_id":"till-2","data":"receipt"}`)
+			So(code, ShouldEqual, http.StatusForbidden)
+			So(body, ShouldContainSubstring, "Not allowed to use device till-2")
+
+			code, body = do(till, http.MethodGet, "/api/v1/devices", "")
+			So(code, ShouldEqual, http.StatusOK)
+			var list struct {
+				Data struct {
+					Devices []struct {
+						ID string `json:"id"`
+					} `json:"devices"`
+				} `json:"data"`
+			}
+			So(json.Unmarshal([]byte(body), &list), ShouldBeNil)
+			So(list.Data.Devices, ShouldHaveLength, 1)
+			So(list.Data.Devices[0].ID, ShouldEqual, "till-1")
+		})
+
+		Convey("a subject matched in full takes precedence over its common name", func() {
+			rogue := client(&pkix.Name{CommonName: "backoffice", Organization: []string{"Rogues"}})
+			code, _ := do(rogue, http.MethodGet, "/api/v1/devices/till-2", "")
+			So(code, ShouldEqual, http.StatusForbidden)
+
+			other := client(&pkix.Name{CommonName: "backoffice", Organization: []string{"Elsewhere"}})
+			code, _ = do(other, http.MethodGet, "/api/v1/devices/till-2", "")
+			So(code, ShouldEqual, http.StatusOK)
+			code, _ = do(other, http.MethodGet, "/api/v1/devices/till-1", "")
+			So(code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("a subject that is not mapped may not use any device", func() {
+			stranger := client(&pkix.Name{CommonName: "stranger"})
+			code, _ := do(stranger, http.MethodGet, "/api/v1/devices/till-1", "")
+			So(code, ShouldEqual, http.StatusForbidden)
+			code, _ = do(stranger, http.MethodPost, "/api/v1/devices/stranger", `{"algorithm":"ed25519"}`)
+			So(code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("a client without certificate, or one from another CA, is turned away", func() {
+			_, err := client(nil).Get(url + "/api/v1/devices")
+			So(err, ShouldNotBeNil)
+
+			otherCA := newCertificate(nil, pkix.Name{CommonName: "other CA"}, true)
+			config := &tls.Config{
+				RootCAs:      trusted,
+				Certificates: []tls.Certificate{newCertificate(otherCA, pkix.Name{CommonName: "till-1"}, false).tlsCertificate()},
+			}
+			_, err = (&http.Client{Transport: &http.Transport{TLSClientConfig: config}}).Get(url + "/api/v1/devices")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// API keys look like "<id>.<secret>", the ID identifies the key without revealing anything about the secret
+
+// GenerateAPIKey returns a new random API key and its ID
+func GenerateAPIKey() (id string, key string, err error) {
+	idBytes := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	id = hex.EncodeToString(idBytes)
+	return id, id + "." + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// APIKeyID returns the ID part of @key, ok is false if @key is not shaped like an API key
+func APIKeyID(key string) (id string, ok bool) {
+	id, secret, found := strings.Cut(key, ".")
+	if !found || id == "" || secret == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// HashAPIKey returns the hex encoded SHA-256 of @key, what is kept instead of the key itself
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// MatchesAPIKeyHash tells whether @key is the key hashed to @hash, in constant time
+func MatchesAPIKeyHash(key string, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(strings.ToLower(hash))) == 1
+}
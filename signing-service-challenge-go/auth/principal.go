@@ -0,0 +1,148 @@
+package auth
+
+import "context"
+
+// AllDevices in the device keys of a Principal grants access to every device of every tenant
+const AllDevices = "*"
+
+// Operation is something a Principal may be permitted to do
+type Operation string
+
+const (
+	// Create, update and delete devices
+	OperationCreate Operation = "create"
+	// Sign transactions
+	OperationSign Operation = "sign"
+	// Verify signatures and audit signature chains
+	OperationVerify Operation = "verify"
+	// List and read devices and their transactions
+	OperationList Operation = "list"
+	// Manage API keys and tenants
+	OperationAdmin Operation = "admin"
+)
+
+// AllOperations are all operations on devices. OperationAdmin is not among them, it is only ever permitted
+// explicitly, see AdminOperations.
+var AllOperations = []Operation{OperationCreate, OperationSign, OperationVerify, OperationList}
+
+// AdminOperations are all operations there are, OperationAdmin included
+var AdminOperations = append(AllOperations[:len(AllOperations):len(AllOperations)], OperationAdmin)
+
+// ParseOperation returns the Operation named @name, ok is false if there is no such operation
+func ParseOperation(name string) (operation Operation, ok bool) {
+	switch operation := Operation(name); operation {
+	case OperationCreate, OperationSign, OperationVerify, OperationList, OperationAdmin:
+		return operation, true
+	}
+	return "", false
+}
+
+// Principal is an authenticated caller of a request, restricted to a set of devices and operations
+type Principal struct {
+	// Who the caller is, e.g. the subject of its client certificate
+	Name string
+	// Tenant the caller belongs to, empty if the principal does not tell, see Tenant
+	Tenant     string
+	devices    map[string]bool // by the key the device is stored with, see domain.DeviceKey, nil = every device
+	operations map[Operation]bool
+}
+
+// NewPrincipal returns principal @name allowed to do @operations with the devices stored with keys @deviceKeys,
+// which tell their tenant, see domain.DeviceKey. AllDevices among them allows every device, none allows no
+// device at all.
+func NewPrincipal(name string, deviceKeys []string, operations []Operation) *Principal {
+	principal := &Principal{Name: name, devices: make(map[string]bool), operations: make(map[Operation]bool)}
+	for _, key := range deviceKeys {
+		if key == AllDevices {
+			principal.devices = nil
+			break
+		}
+		principal.devices[key] = true
+	}
+	for _, operation := range operations {
+		principal.operations[operation] = true
+	}
+	return principal
+}
+
+// CanAccessDevice tells whether the principal may use the device stored with key @key
+func (p *Principal) CanAccessDevice(key string) bool {
+	return p.devices == nil || p.devices[key]
+}
+
+// CanAccessAllDevices tells whether the principal may use every device, not only some
+func (p *Principal) CanAccessAllDevices() bool {
+	return p.devices == nil
+}
+
+// CanPerform tells whether the principal may do @operation
+func (p *Principal) CanPerform(operation Operation) bool {
+	return p.operations[operation]
+}
+
+type principalsKey struct{}
+
+// WithPrincipal returns a copy of @ctx carrying @principal in addition to the principals @ctx carries already.
+// Every principal must allow what the request does, e.g. both its client certificate and its API key.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	existing := Principals(ctx)
+	principals := make([]*Principal, 0, len(existing)+1)
+	principals = append(append(principals, existing...), principal)
+	return context.WithValue(ctx, principalsKey{}, principals)
+}
+
+// Principals returns the principals carried by @ctx, none if the caller is not authenticated at all, in
+// which case no restriction applies
+func Principals(ctx context.Context) []*Principal {
+	principals, _ := ctx.Value(principalsKey{}).([]*Principal)
+	return principals
+}
+
+// CanAccessDevice tells whether the caller of @ctx may use the device stored with key @key
+func CanAccessDevice(ctx context.Context, key string) bool {
+	for _, principal := range Principals(ctx) {
+		if !principal.CanAccessDevice(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// Tenant returns the tenant the caller of @ctx belongs to: the one its principals tell, empty if none tells.
+// ok is false if its principals tell different tenants.
+func Tenant(ctx context.Context) (tenant string, ok bool) {
+	for _, principal := range Principals(ctx) {
+		if principal.Tenant == "" {
+			continue
+		}
+		if tenant != "" && tenant != principal.Tenant {
+			return "", false
+		}
+		tenant = principal.Tenant
+	}
+	return tenant, true
+}
+
+// CanPerform tells whether the caller of @ctx may do @operation
+func CanPerform(ctx context.Context, operation Operation) bool {
+	for _, principal := range Principals(ctx) {
+		if !principal.CanPerform(operation) {
+			return false
+		}
+	}
+	return true
+}
+
+// CanAdminister tells whether the caller of @ctx may do OperationAdmin: one of its principals must be
+// permitted it and none may be restricted to some devices. Unlike for other operations, a caller that is not
+// authenticated at all may not.
+func CanAdminister(ctx context.Context) bool {
+	admin := false
+	for _, principal := range Principals(ctx) {
+		if !principal.CanAccessAllDevices() {
+			return false
+		}
+		admin = admin || principal.CanPerform(OperationAdmin)
+	}
+	return admin
+}
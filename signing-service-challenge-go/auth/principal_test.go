@@ -0,0 +1,112 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+)
+
+func TestPrincipal(t *testing.T) {
+	Convey("Given a context", t, func() {
+		ctx := context.Background()
+
+		Convey("without principal everything is allowed", func() {
+			So(auth.Principals(ctx), ShouldBeEmpty)
+			So(auth.CanAccessDevice(ctx, "a"), ShouldBeTrue)
+			So(auth.CanPerform(ctx, auth.OperationAdmin), ShouldBeTrue)
+		})
+
+		Convey("administering needs a principal permitted it and none restricted to some devices", func() {
+			So(auth.CanAdminister(ctx), ShouldBeFalse)
+			So(auth.AllOperations, ShouldNotContain, auth.OperationAdmin)
+
+			admin := auth.WithPrincipal(ctx, auth.NewPrincipal("admin API key", []string{auth.AllDevices}, auth.AdminOperations))
+			So(auth.CanAdminister(admin), ShouldBeTrue)
+			So(auth.CanAdminister(auth.WithPrincipal(admin, auth.NewPrincipal("CN=backoffice", []string{auth.AllDevices}, auth.AllOperations))), ShouldBeTrue)
+			So(auth.CanAdminister(auth.WithPrincipal(admin, auth.NewPrincipal("CN=till-1", []string{"a"}, auth.AllOperations))), ShouldBeFalse)
+			So(auth.CanAdminister(auth.WithPrincipal(ctx, auth.NewPrincipal("CN=backoffice", []string{auth.AllDevices}, auth.AllOperations))), ShouldBeFalse)
+			So(auth.CanAdminister(auth.WithPrincipal(ctx, auth.NewPrincipal("API key", []string{"a"}, auth.AdminOperations))), ShouldBeFalse)
+		})
+
+		Convey("with a principal only its devices and operations are allowed", func() {
+			ctx := auth.WithPrincipal(ctx, auth.NewPrincipal("CN=till-1", []string{"a", "b"}, []auth.Operation{auth.OperationSign}))
+			So(auth.Principals(ctx)[0].Name, ShouldEqual, "CN=till-1")
+			So(auth.CanAccessDevice(ctx, "a"), ShouldBeTrue)
+			So(auth.CanAccessDevice(ctx, "b"), ShouldBeTrue)
+			So(auth.CanAccessDevice(ctx, "c"), ShouldBeFalse)
+			So(auth.CanPerform(ctx, auth.OperationSign), ShouldBeTrue)
+			So(auth.CanPerform(ctx, auth.OperationCreate), ShouldBeFalse)
+		})
+
+		Convey("a principal without devices may use none", func() {
+			ctx := auth.WithPrincipal(ctx, auth.NewPrincipal("CN=stranger", nil, auth.AllOperations))
+			So(auth.CanAccessDevice(ctx, "a"), ShouldBeFalse)
+		})
+
+		Convey("a principal with all devices may use any", func() {
+			ctx := auth.WithPrincipal(ctx, auth.NewPrincipal("CN=backoffice", []string{"a", auth.AllDevices}, nil))
+			So(auth.CanAccessDevice(ctx, "z"), ShouldBeTrue)
+			So(auth.CanPerform(ctx, auth.OperationList), ShouldBeFalse)
+		})
+
+		Convey("with several principals every one of them must allow", func() {
+			certificate := auth.WithPrincipal(ctx, auth.NewPrincipal("CN=till-1", []string{"a", "b"}, auth.AllOperations))
+			both := auth.WithPrincipal(certificate, auth.NewPrincipal("API key", []string{"*"}, []auth.Operation{auth.OperationSign}))
+			So(auth.Principals(both), ShouldHaveLength, 2)
+			So(auth.Principals(certificate), ShouldHaveLength, 1)
+			So(auth.CanAccessDevice(both, "a"), ShouldBeTrue)
+			So(auth.CanAccessDevice(both, "c"), ShouldBeFalse)
+			So(auth.CanPerform(both, auth.OperationSign), ShouldBeTrue)
+			So(auth.CanPerform(both, auth.OperationList), ShouldBeFalse)
+		})
+
+		Convey("the tenant is the one told by the principals", func() {
+			tenant, ok := auth.Tenant(ctx)
+			So(ok, ShouldBeTrue)
+			So(tenant, ShouldBeEmpty)
+
+			key := auth.NewPrincipal("API key", []string{"*"}, auth.AllOperations)
+			key.Tenant = "shop"
+			certificate := auth.NewPrincipal("CN=till-1", []string{"a"}, auth.AllOperations)
+			tenant, ok = auth.Tenant(auth.WithPrincipal(auth.WithPrincipal(ctx, certificate), key))
+			So(ok, ShouldBeTrue)
+			So(tenant, ShouldEqual, "shop")
+
+			other := auth.NewPrincipal("API key", []string{"*"}, auth.AllOperations)
+			other.Tenant = "other shop"
+			_, ok = auth.Tenant(auth.WithPrincipal(auth.WithPrincipal(ctx, key), other))
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestAPIKey(t *testing.T) {
+	Convey("A generated API key", t, func() {
+		id, key, err := auth.GenerateAPIKey()
+		So(err, ShouldBeNil)
+
+		Convey("carries its ID", func() {
+			parsed, ok := auth.APIKeyID(key)
+			So(ok, ShouldBeTrue)
+			So(parsed, ShouldEqual, id)
+
+			_, ok = auth.APIKeyID("no-dot")
+			So(ok, ShouldBeFalse)
+			_, ok = auth.APIKeyID(id + ".")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("matches its hash only", func() {
+			hash := auth.HashAPIKey(key)
+			So(hash, ShouldHaveLength, 64)
+			So(auth.MatchesAPIKeyHash(key, hash), ShouldBeTrue)
+
+			_, other, err := auth.GenerateAPIKey()
+			So(err, ShouldBeNil)
+			So(auth.MatchesAPIKeyHash(other, hash), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/config"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+)
+
+// runBackup writes a backup archive of the configured storage to the file given first in @args ("-" =
+// stdout), the other arguments configure the storage as for the server
+func runBackup(name string, args []string) error {
+	path, cfg, err := commandConfig(name, args)
+	if err != nil {
+		return err
+	}
+	storage, err := openCommandStorage(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStorage(storage)
+
+	backup, err := service.CreateBackup(operatorContext())
+	if err != nil {
+		return err
+	}
+
+	if path == "-" {
+		err = backup.Write(os.Stdout)
+	} else {
+		err = writeFile(path, backup.Write)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Backed up %d devices, %d transactions, %d tenants and %d API keys, checksum %s\n",
+		backup.Manifest.Devices, backup.Manifest.Transactions, backup.Manifest.Tenants, backup.Manifest.APIKeys,
+		backup.Manifest.Checksum)
+	return nil
+}
+
+// runRestore restores the backup archive in the file given first in @args ("-" = stdin) into the configured
+// storage, which must have no device. The other arguments configure the storage as for the server.
+func runRestore(name string, args []string) error {
+	path, cfg, err := commandConfig(name, args)
+	if err != nil {
+		return err
+	}
+	storage, err := openCommandStorage(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStorage(storage)
+
+	// devices lacking a stored public key need their private key to have their signatures checked
+	envelope, err := loadEnvelope(cfg.MasterKey)
+	if err != nil {
+		return err
+	}
+	if envelope != nil {
+		crypto.SetEnvelope(envelope)
+	}
+
+	in := io.Reader(os.Stdin)
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	manifest, err := service.RestoreBackup(operatorContext(), in)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Restored %d devices, %d transactions, %d tenants and %d API keys from backup of %s\n",
+		manifest.Devices, manifest.Transactions, manifest.Tenants, manifest.APIKeys,
+		manifest.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+	return nil
+}
+
+// writeFile creates file @path, which must not exist yet, and writes it with @write, synced to disk
+func writeFile(path string, write func(w io.Writer) error) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// commandConfig splits @args of command @name into the archive path and the configuration
+func commandConfig(name string, args []string) (string, *config.Config, error) {
+	if len(args) == 0 || args[0] == "" || (args[0] != "-" && strings.HasPrefix(args[0], "-")) {
+		return "", nil, errors.New("Usage: " + name + " <archive file or -> [flags as for the server]")
+	}
+	cfg, _, err := loadConfig(name, args[1:])
+	if err != nil {
+		return "", nil, err
+	}
+	return args[0], cfg, nil
+}
+
+// operatorContext returns the context commands call the service with: whoever runs them on the host reads
+// the storage files anyway, so they act as the admin
+func operatorContext() context.Context {
+	operator := auth.NewPrincipal("command line", []string{auth.AllDevices}, auth.AdminOperations)
+	return auth.WithPrincipal(context.Background(), operator)
+}
+
+// errMemoryStorage is returned by openCommandStorage for the "memory" backend
+var errMemoryStorage = errors.New("The memory storage only lives inside the running server, a command cannot open it. " +
+	"Have the server migrate it with POST /api/v1/admin/migrate, or download a backup with GET /api/v1/admin/backup " +
+	"and restore it into a file or sqlite storage instead")
+
+// openCommandStorage opens the storage, the API key and the tenant stores configured in @cfg and makes them the
+// instances the service works on. A storage a running server holds is refused, rather than both writing it,
+// and so is the memory storage, which a command would only see empty.
+func openCommandStorage(cfg *config.Config) (persistence.Storage, error) {
+	if cfg.Storage.Backend == "memory" {
+		return nil, errMemoryStorage
+	}
+	crypto.SetDefaultParameters(cfg.Algorithms)
+	storage, err := newStorage(cfg.Storage)
+	if errors.Is(err, persistence.ErrStorageLocked) {
+		return nil, fmt.Errorf("%w. Is the server running? Stop it first, or use the admin API meanwhile", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := openStores(cfg); err != nil {
+		closeStorage(storage)
+		return nil, err
+	}
+	persistence.SetInstance(storage)
+	return storage, nil
+}
+
+// closeStorage flushes and releases @storage if it holds anything
+func closeStorage(storage persistence.Storage) {
+	if closer, ok := storage.(io.Closer); ok {
+		closer.Close()
+	}
+}
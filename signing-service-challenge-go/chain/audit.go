@@ -0,0 +1,167 @@
+package chain
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+)
+
+// BreakKind tells how a signature chain is broken
+type BreakKind string
+
+const (
+	// A transaction is missing, the chain skips a counter or ends before the device counter
+	BreakGap BreakKind = "gap"
+	// A transaction does not chain from the signature before it, or the device continues from another signature
+	BreakFork BreakKind = "fork"
+	// A transaction is inconsistent in itself: its signed data does not match its counter and data, its
+	// signature is not even base64 or it belongs to another device
+	BreakTampered BreakKind = "tampered"
+	// A transaction is consistent but its signature was not made by the device over its signed data
+	BreakBadSignature BreakKind = "bad_signature"
+)
+
+// Verifier checks that @signature over @signedData was made by the device for transaction @counter
+type Verifier func(counter int, signedData []byte, signature []byte) error
+
+// Break describes the first broken link of a chain
+type Break struct {
+	// Counter of the transaction where the chain breaks
+	Counter int
+	Kind    BreakKind
+	Message string
+}
+
+// Report is the result of auditing the signature chain of a device
+type Report struct {
+	DeviceID string
+	// Whether no broken link was found
+	Valid bool
+	// Whether the chain was checked all the way from the seed, false for devices that signed before
+	// transactions were kept
+	Complete bool
+	// Counter of the first transaction checked
+	FirstCounter int
+	// Number of transactions checked before the chain broke, or all of them
+	Checked int
+	// Signature counter of the device
+	SignatureCounter int
+	// First broken link, nil if Valid
+	Break *Break
+}
+
+// Audit walks @transactions of @device, ordered by counter, from the seed of the device to its LastSignature
+// and verifies every signature with @verify. It stops at the first broken link.
+func Audit(device *domain.Device, transactions []*domain.Transaction, verify Verifier) *Report {
+	report := &Report{
+		DeviceID:         device.ID,
+		FirstCounter:     device.SignatureCounter,
+		SignatureCounter: device.SignatureCounter,
+	}
+	if len(transactions) > 0 {
+		report.FirstCounter = transactions[0].Counter
+	}
+
+	// the signature before the first transaction is only known if the chain starts at the seed
+	previousSignature := ""
+	if report.FirstCounter == 0 {
+		previousSignature = Seed(device.ID)
+		report.Complete = true
+	}
+
+	counter := report.FirstCounter
+	for _, transaction := range transactions {
+		if brk := checkTransaction(device, transaction, counter, &previousSignature, verify); brk != nil {
+			report.Break = brk
+			return report
+		}
+		report.Checked++
+		counter++
+	}
+
+	switch {
+	case counter < device.SignatureCounter:
+		report.Break = &Break{
+			Counter: counter,
+			Kind:    BreakGap,
+			Message: fmt.Sprintf("Transactions from %d to %d are missing", counter, device.SignatureCounter-1),
+		}
+	case counter > device.SignatureCounter:
+		report.Break = &Break{
+			Counter: device.SignatureCounter,
+			Kind:    BreakFork,
+			Message: fmt.Sprintf("Transactions go on past the signature counter %d of the device", device.SignatureCounter),
+		}
+	case previousSignature != "" && previousSignature != device.LastSignature:
+		report.Break = &Break{
+			Counter: counter - 1,
+			Kind:    BreakFork,
+			Message: "The last signature of the device is not the signature of its last transaction",
+		}
+	}
+
+	report.Valid = report.Break == nil
+	return report
+}
+
+// checkTransaction checks @transaction is transaction @counter of @device chaining from *@previousSignature,
+// which is then advanced to its signature. Returns the broken link, if any.
+func checkTransaction(device *domain.Device, transaction *domain.Transaction, counter int, previousSignature *string, verify Verifier) *Break {
+	if transaction.Counter > counter {
+		return &Break{
+			Counter: counter,
+			Kind:    BreakGap,
+			Message: fmt.Sprintf("Transactions from %d to %d are missing", counter, transaction.Counter-1),
+		}
+	}
+	if transaction.Counter < counter {
+		return &Break{
+			Counter: transaction.Counter,
+			Kind:    BreakFork,
+			Message: fmt.Sprintf("Transaction %d appears more than once", transaction.Counter),
+		}
+	}
+	if transaction.DeviceID != device.ID {
+		return &Break{
+			Counter: counter,
+			Kind:    BreakTampered,
+			Message: fmt.Sprintf("Transaction %d belongs to device %s", counter, transaction.DeviceID),
+		}
+	}
+
+	chainedFrom, ok := previousSignatureOf(transaction.SignedData, counter, transaction.Data)
+	if !ok {
+		return &Break{
+			Counter: counter,
+			Kind:    BreakTampered,
+			Message: fmt.Sprintf("Signed data of transaction %d does not match its counter and data", counter),
+		}
+	}
+	if *previousSignature != "" && chainedFrom != *previousSignature {
+		return &Break{
+			Counter: counter,
+			Kind:    BreakFork,
+			Message: fmt.Sprintf("Transaction %d does not chain from the signature of transaction %d", counter, counter-1),
+		}
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(transaction.Signature)
+	if err != nil {
+		return &Break{
+			Counter: counter,
+			Kind:    BreakTampered,
+			Message: fmt.Sprintf("Signature of transaction %d is not valid base64", counter),
+		}
+	}
+	if err := verify(counter, []byte(transaction.SignedData), signature); err != nil {
+		return &Break{
+			Counter: counter,
+			Kind:    BreakBadSignature,
+			Message: fmt.Sprintf("Signature of transaction %d does not verify: %s", counter, err.Error()),
+		}
+	}
+
+	*previousSignature = transaction.Signature
+	return nil
+}
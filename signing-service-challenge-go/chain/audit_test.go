@@ -0,0 +1,126 @@
+package chain_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/chain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+)
+
+// signChain signs @data in order with a fresh ed25519 key the way the service does, returning the resulting
+// device, its transactions and a Verifier for its key
+func signChain(data ...string) (*domain.Device, []*domain.Transaction, chain.Verifier) {
+	algo := crypto.GetAlgorithm("ed25519")
+	kp, err := algo.GenerateKeyPair()
+	So(err, ShouldBeNil)
+
+	device := &domain.Device{ID: "till", LastSignature: chain.Seed("till")}
+	var transactions []*domain.Transaction
+	for _, d := range data {
+		signedData := chain.FormatSignedData(device.SignatureCounter, d, device.LastSignature)
+		signature, err := algo.Sign(kp.PrivateKey(), []byte(signedData))
+		So(err, ShouldBeNil)
+
+		transaction := &domain.Transaction{
+			DeviceID:   device.ID,
+			Counter:    device.SignatureCounter,
+			Data:       d,
+			SignedData: signedData,
+			Signature:  base64.StdEncoding.EncodeToString(signature),
+		}
+		transactions = append(transactions, transaction)
+		device.SignatureCounter++
+		device.LastSignature = transaction.Signature
+	}
+
+	return device, transactions, func(counter int, signedData []byte, signature []byte) error {
+		return algo.Verify(kp.PublicKey(), signedData, signature)
+	}
+}
+
+func TestFormatSignedData(t *testing.T) {
+	Convey("FormatSignedData joins counter, data and previous signature", t, func() {
+		So(chain.FormatSignedData(3, "data", "c2ln"), ShouldEqual, "3_data_c2ln")
+		So(chain.Seed("till"), ShouldEqual, "dGlsbA==")
+	})
+}
+
+func TestAudit(t *testing.T) {
+	Convey("Given a device that signed 4 transactions", t, func() {
+		device, transactions, verify := signChain("a", "b", "c", "d")
+
+		Convey("an intact chain is valid and complete", func() {
+			report := chain.Audit(device, transactions, verify)
+			So(report.Valid, ShouldBeTrue)
+			So(report.Complete, ShouldBeTrue)
+			So(report.Checked, ShouldEqual, 4)
+			So(report.Break, ShouldBeNil)
+		})
+
+		Convey("a device that never signed is valid", func() {
+			fresh, none, verify := signChain()
+			report := chain.Audit(fresh, none, verify)
+			So(report.Valid, ShouldBeTrue)
+			So(report.Checked, ShouldEqual, 0)
+		})
+
+		Convey("a missing transaction is a gap", func() {
+			report := chain.Audit(device, append(transactions[:1:1], transactions[2:]...), verify)
+			So(report.Valid, ShouldBeFalse)
+			So(report.Break.Kind, ShouldEqual, chain.BreakGap)
+			So(report.Break.Counter, ShouldEqual, 1)
+			So(report.Checked, ShouldEqual, 1)
+		})
+
+		Convey("missing trailing transactions are a gap", func() {
+			report := chain.Audit(device, transactions[:3], verify)
+			So(report.Break.Kind, ShouldEqual, chain.BreakGap)
+			So(report.Break.Counter, ShouldEqual, 3)
+		})
+
+		Convey("a transaction chaining from another signature is a fork", func() {
+			_, other, _ := signChain("x", "y")
+			forked := []*domain.Transaction{transactions[0], other[1]}
+			device.SignatureCounter, device.LastSignature = 2, other[1].Signature
+
+			report := chain.Audit(device, forked, verify)
+			So(report.Break.Kind, ShouldEqual, chain.BreakFork)
+			So(report.Break.Counter, ShouldEqual, 1)
+		})
+
+		Convey("a device whose last signature is not the last transaction's is a fork", func() {
+			device.LastSignature = transactions[2].Signature
+			report := chain.Audit(device, transactions, verify)
+			So(report.Break.Kind, ShouldEqual, chain.BreakFork)
+			So(report.Break.Counter, ShouldEqual, 3)
+		})
+
+		Convey("a transaction whose data was changed is tampered", func() {
+			transactions[2].Data = "forged"
+			report := chain.Audit(device, transactions, verify)
+			So(report.Break.Kind, ShouldEqual, chain.BreakTampered)
+			So(report.Break.Counter, ShouldEqual, 2)
+		})
+
+		Convey("a transaction whose data and signed data were changed consistently has a bad signature", func() {
+			transactions[2].Data = "forged"
+			transactions[2].SignedData = chain.FormatSignedData(2, "forged", transactions[1].Signature)
+			report := chain.Audit(device, transactions, verify)
+			So(report.Break.Kind, ShouldEqual, chain.BreakBadSignature)
+			So(report.Break.Counter, ShouldEqual, 2)
+			So(report.Checked, ShouldEqual, 2)
+		})
+
+		Convey("a ledger starting past 0 is checked but incomplete", func() {
+			report := chain.Audit(device, transactions[2:], verify)
+			So(report.Valid, ShouldBeTrue)
+			So(report.Complete, ShouldBeFalse)
+			So(report.FirstCounter, ShouldEqual, 2)
+			So(report.Checked, ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,37 @@
+package chain
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+)
+
+// Seed returns what the first signature of device @deviceID chains from: its base64 encoded ID
+func Seed(deviceID string) string {
+	return base64.StdEncoding.EncodeToString([]byte(deviceID))
+}
+
+// FormatSignedData returns what a device actually signs for transaction @counter over @data, chaining it to
+// @previousSignature: "<counter>_<data>_<previous signature>"
+func FormatSignedData(counter int, data string, previousSignature string) string {
+	return fmt.Sprintf("%d_%s_%s", counter, data, previousSignature)
+}
+
+// PreviousSignatureOf returns the signature @transaction chains from according to its signed data, ok is false
+// if its signed data does not match its counter and data
+func PreviousSignatureOf(transaction *domain.Transaction) (previousSignature string, ok bool) {
+	return previousSignatureOf(transaction.SignedData, transaction.Counter, transaction.Data)
+}
+
+// previousSignatureOf extracts the previous signature from @signedData formatted by FormatSignedData for
+// transaction @counter over @data, ok is false if @signedData does not start as expected
+func previousSignatureOf(signedData string, counter int, data string) (previousSignature string, ok bool) {
+	prefix := strconv.Itoa(counter) + "_" + data + "_"
+	if !strings.HasPrefix(signedData, prefix) {
+		return "", false
+	}
+	return signedData[len(prefix):], true
+}
@@ -0,0 +1,281 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+)
+
+// Config holds every setting of the server binary
+type Config struct {
+	// Host and port to listen on, e.g. ":8080"
+	ListenAddress string `json:"listen_address"`
+	// Minimum level of logged messages: debug, info, warn or error
+	LogLevel  string    `json:"log_level"`
+	Storage   Storage   `json:"storage"`
+	TLS       TLS       `json:"tls"`
+	Timeouts  Timeouts  `json:"timeouts"`
+	MasterKey MasterKey `json:"master_key"`
+	APIKeys   APIKeys   `json:"api_keys"`
+	Tenants   Tenants   `json:"tenants"`
+	// How long the idempotency key of a signing request is remembered, see service.SetIdempotencyWindow
+	IdempotencyWindow Duration `json:"idempotency_window"`
+	// Parameters of devices created without any, keyed by algorithm name, see crypto.SetDefaultParameters.
+	// Checked by the binary, which knows the available algorithms.
+	Algorithms map[string]crypto.Parameters `json:"algorithms,omitempty"`
+}
+
+// Storage selects and tunes the persistence.Storage
+type Storage struct {
+	// Either "memory" (lost on restart), "file" (durable, kept in Path) or "sqlite" (durable, an SQLite
+	// database in Path)
+	Backend string `json:"backend"`
+	// Directory holding the snapshot and write-ahead log of the "file" backend, or the database of the
+	// "sqlite" backend
+	Path string `json:"path"`
+	// When the "file" backend fsyncs: always, interval or never, checked by the binary along with the other
+	// options of the "file" backend
+	SyncMode string `json:"sync_mode"`
+	// How often the "file" backend fsyncs when SyncMode is interval
+	SyncInterval Duration `json:"sync_interval"`
+	// Number of write-ahead log records after which a compacted snapshot is written, 0 disables compaction
+	SnapshotThreshold int `json:"snapshot_threshold"`
+}
+
+// TLS holds the certificate served over HTTPS, both empty serves plain HTTP
+type TLS struct {
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// CA bundle client certificates must be issued by, empty accepts clients without certificate
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+	// IDs of the devices each client certificate subject may use, auth.AllDevices ("*") for every device.
+	// Devices of a tenant are given as "<tenant ID>/<device ID>", bare IDs are of the default tenant.
+	// Subjects are matched in full as in "CN=till-1,O=Shop", then by common name alone as in "CN=till-1".
+	// Clients whose subject is not listed may not use any device.
+	ClientDevices map[string][]string `json:"client_devices,omitempty"`
+}
+
+// Timeouts of the HTTP server, 0 means no timeout
+type Timeouts struct {
+	ReadHeader Duration `json:"read_header"`
+	Read       Duration `json:"read"`
+	Write      Duration `json:"write"`
+	Idle       Duration `json:"idle"`
+	// How long requests in flight may take to finish when the server is shut down
+	Shutdown Duration `json:"shutdown"`
+}
+
+// MasterKey locates the master keys that encrypt device private keys at rest, see crypto.Envelope
+type MasterKey struct {
+	// File holding the base64 encoded master key, empty disables encryption unless Key is set. Must exist
+	// unless it is DefaultMasterKeyFile, which may be missing for deployments not encrypting yet.
+	File string `json:"file"`
+	// Base64 encoded master key, takes precedence over File
+	Key Secret `json:"key,omitempty"`
+	// Base64 encoded master keys replaced by a rotation, needed until every device has been rewrapped
+	PreviousKeys []Secret `json:"previous_keys,omitempty"`
+}
+
+// APIKeys configures authentication of callers by API key
+type APIKeys struct {
+	// Require an API key on every request but the health check
+	Enabled bool `json:"enabled"`
+	// File the API keys created through the admin API are kept in, only their hashes, empty keeps them in
+	// memory only
+	File string `json:"file"`
+	// Hex encoded SHA-256 of the key permitted everything, to create the first API keys with
+	AdminKeyHash string `json:"admin_key_hash,omitempty"`
+}
+
+// Tenants configures where tenants are kept, their devices are kept by the Storage
+type Tenants struct {
+	// File the tenants created through the admin API are kept in, empty keeps them in memory only
+	File string `json:"file"`
+}
+
+// Duration is a time.Duration written as "5s", "1m30s" and so on
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Secret is a string that is never written out, so printing a Config does not leak it
+type Secret string
+
+func (s Secret) MarshalText() ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return []byte("<redacted>"), nil
+}
+
+// DefaultIdempotencyWindow is how long idempotency keys are remembered unless configured otherwise, the same
+// as service.DefaultIdempotencyWindow
+const DefaultIdempotencyWindow = Duration(24 * time.Hour)
+
+// DefaultMasterKeyFile is where the master key is looked for when no other file is configured
+const DefaultMasterKeyFile = "master.key"
+
+// Default returns the configuration used for anything not configured otherwise
+func Default() *Config {
+	return &Config{
+		ListenAddress: ":8080",
+		LogLevel:      "info",
+		Storage: Storage{
+			Backend:           "memory",
+			Path:              "data",
+			SyncMode:          "always",
+			SyncInterval:      Duration(time.Second),
+			SnapshotThreshold: 1000,
+		},
+		Timeouts: Timeouts{
+			ReadHeader: Duration(10 * time.Second),
+			Read:       Duration(30 * time.Second),
+			Write:      Duration(30 * time.Second),
+			Idle:       Duration(2 * time.Minute),
+			Shutdown:   Duration(30 * time.Second),
+		},
+		MasterKey: MasterKey{
+			File: DefaultMasterKeyFile,
+		},
+		APIKeys: APIKeys{
+			File: "api_keys.json",
+		},
+		Tenants: Tenants{
+			File: "tenants.json",
+		},
+		IdempotencyWindow: DefaultIdempotencyWindow,
+	}
+}
+
+// Validate checks every setting, returning all problems found at once
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.ListenAddress == "" {
+		fail("Listen address is required")
+	}
+	if _, err := c.SlogLevel(); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch c.Storage.Backend {
+	case "memory":
+	case "file":
+		if c.Storage.Path == "" {
+			fail("Storage path is required for the file backend")
+		}
+		if c.Storage.SnapshotThreshold < 0 {
+			fail("Storage snapshot threshold must not be negative")
+		}
+	case "sqlite":
+		if c.Storage.Path == "" {
+			fail("Storage path is required for the sqlite backend")
+		}
+	default:
+		fail(`Unknown storage backend %s, expected "memory", "file" or "sqlite"`, c.Storage.Backend)
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("TLS certificate and key files must be given together")
+	}
+	if c.TLS.ClientCAFile != "" && !c.TLSEnabled() {
+		fail("TLS client CA file needs the TLS certificate and key files")
+	}
+	if len(c.TLS.ClientDevices) > 0 && c.TLS.ClientCAFile == "" {
+		fail("TLS client devices need the TLS client CA file")
+	}
+	for subject, deviceIDs := range c.TLS.ClientDevices {
+		for _, id := range deviceIDs {
+			tenantID, deviceID, qualified := strings.Cut(id, "/")
+			if id == "" || qualified && (tenantID == "" || deviceID == "" || strings.Contains(deviceID, "/")) {
+				fail("TLS client device %q of %s must be a device ID or <tenant ID>/<device ID>", id, subject)
+			}
+		}
+	}
+
+	for _, timeout := range []struct {
+		name  string
+		value Duration
+	}{
+		{"Read header", c.Timeouts.ReadHeader},
+		{"Read", c.Timeouts.Read},
+		{"Write", c.Timeouts.Write},
+		{"Idle", c.Timeouts.Idle},
+		{"Shutdown", c.Timeouts.Shutdown},
+	} {
+		if timeout.value < 0 {
+			fail("%s timeout must not be negative", timeout.name)
+		}
+	}
+
+	if c.IdempotencyWindow <= 0 {
+		fail("Idempotency window must be positive")
+	}
+
+	if hash := c.APIKeys.AdminKeyHash; hash != "" {
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			fail("Admin API key hash must be a hex encoded SHA-256")
+		}
+		if !c.APIKeys.Enabled {
+			fail("Admin API key hash needs API keys enabled")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SlogLevel returns LogLevel as a slog.Level
+func (c *Config) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil || strings.ContainsAny(c.LogLevel, "+-") {
+		return 0, errors.New("Unknown log level " + c.LogLevel + `, expected "debug", "info", "warn" or "error"`)
+	}
+	return level, nil
+}
+
+// TLSEnabled tells whether HTTPS is served
+func (c *Config) TLSEnabled() bool {
+	return c.TLS.CertFile != "" && c.TLS.KeyFile != ""
+}
+
+// ClientAuthEnabled tells whether clients must present a certificate issued by the client CA
+func (c *Config) ClientAuthEnabled() bool {
+	return c.TLSEnabled() && c.TLS.ClientCAFile != ""
+}
+
+// SQLiteFile returns the database file of the "sqlite" backend
+func (s Storage) SQLiteFile() string {
+	return filepath.Join(s.Path, "signing.db")
+}
+
+// Print writes @c to @w as JSON, which is valid YAML too, so the output can serve as a configuration file.
+// Secrets are redacted.
+func Print(w io.Writer, c *Config) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c)
+}
@@ -0,0 +1,188 @@
+package config_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/config"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+)
+
+func TestLoad(t *testing.T) {
+	Convey("Given a configuration", t, func() {
+		env := map[string]string{}
+		getenv := func(name string) string { return env[name] }
+		dir := t.TempDir()
+		writeFile := func(name string, content string) string {
+			path := filepath.Join(dir, name)
+			So(os.WriteFile(path, []byte(content), 0o600), ShouldBeNil)
+			return path
+		}
+
+		Convey("nothing configured gives the valid defaults", func() {
+			cfg, printConfig, err := config.Load("test", nil, getenv)
+			So(err, ShouldBeNil)
+			So(printConfig, ShouldBeFalse)
+			So(cfg, ShouldResemble, config.Default())
+			So(cfg.ListenAddress, ShouldEqual, ":8080")
+			So(cfg.TLSEnabled(), ShouldBeFalse)
+			So(cfg.Storage.Backend, ShouldEqual, "memory")
+		})
+
+		Convey("a YAML file overrides the defaults it mentions", func() {
+			path := writeFile("config.yaml", `
+listen_address: ":9090"
+storage:
+  backend: file
+timeouts:
+  write: 1m
+algorithms:
+  ecc:
+    curve: P-256
+`)
+			cfg, _, err := config.Load("test", []string{"-config", path}, getenv)
+			So(err, ShouldBeNil)
+			So(cfg.ListenAddress, ShouldEqual, ":9090")
+			So(cfg.Storage.Backend, ShouldEqual, "file")
+			So(cfg.Storage.Path, ShouldEqual, "data")
+			So(cfg.Timeouts.Write, ShouldEqual, config.Duration(time.Minute))
+			So(cfg.Timeouts.Read, ShouldEqual, config.Default().Timeouts.Read)
+			So(cfg.Algorithms, ShouldResemble, map[string]crypto.Parameters{"ecc": {Curve: "P-256"}})
+		})
+
+		Convey("client certificate subjects are mapped to device IDs in the file", func() {
+			path := writeFile("config.yaml", `
+tls:
+  cert_file: server.crt
+  key_file: server.key
+  client_ca_file: clients.crt
+  client_devices:
+    "CN=till-1,O=Shop": [till-1]
+    CN=backoffice: ["*"]
+`)
+			cfg, _, err := config.Load("test", []string{"-config", path}, getenv)
+			So(err, ShouldBeNil)
+			So(cfg.ClientAuthEnabled(), ShouldBeTrue)
+			So(cfg.TLS.ClientDevices, ShouldResemble, map[string][]string{
+				"CN=till-1,O=Shop": {"till-1"},
+				"CN=backoffice":    {"*"},
+			})
+		})
+
+		Convey("a JSON file named by the environment works the same", func() {
+			env["SIGNING_SERVICE_CONFIG"] = writeFile("config.json", `{"storage":{"sync_mode":"interval","sync_interval":"250ms"}}`)
+			cfg, _, err := config.Load("test", nil, getenv)
+			So(err, ShouldBeNil)
+			So(cfg.Storage.SyncMode, ShouldEqual, "interval")
+			So(cfg.Storage.SyncInterval, ShouldEqual, config.Duration(250*time.Millisecond))
+		})
+
+		Convey("environment variables override the file and flags override both", func() {
+			path := writeFile("config.yaml", "listen_address: \":9090\"\nlog_level: warn\nstorage:\n  path: from-file\n")
+			env["SIGNING_SERVICE_LISTEN_ADDRESS"] = ":7070"
+			env["SIGNING_SERVICE_STORAGE_PATH"] = "from-env"
+			env["SIGNING_SERVICE_IDEMPOTENCY_WINDOW"] = "1h"
+			cfg, _, err := config.Load("test", []string{"-config", path, "-listen", ":6060", "-read-timeout", "5s"}, getenv)
+			So(err, ShouldBeNil)
+			So(cfg.IdempotencyWindow, ShouldEqual, config.Duration(time.Hour))
+			So(cfg.ListenAddress, ShouldEqual, ":6060")
+			So(cfg.Storage.Path, ShouldEqual, "from-env")
+			So(cfg.LogLevel, ShouldEqual, "warn")
+			So(cfg.Timeouts.Read, ShouldEqual, config.Duration(5*time.Second))
+		})
+
+		Convey("master keys come from the environment only", func() {
+			env["SIGNING_SERVICE_MASTER_KEY"] = "current"
+			env["SIGNING_SERVICE_PREVIOUS_MASTER_KEYS"] = "old1, old2,"
+			cfg, _, err := config.Load("test", nil, getenv)
+			So(err, ShouldBeNil)
+			So(cfg.MasterKey.Key, ShouldEqual, config.Secret("current"))
+			So(cfg.MasterKey.PreviousKeys, ShouldResemble, []config.Secret{"old1", "old2"})
+
+			_, _, err = config.Load("test", []string{"-master-key", "current"}, getenv)
+			So(err, ShouldNotBeNil)
+
+			Convey("and are redacted when the configuration is printed", func() {
+				var out bytes.Buffer
+				So(config.Print(&out, cfg), ShouldBeNil)
+				So(out.String(), ShouldNotContainSubstring, "current")
+				So(out.String(), ShouldNotContainSubstring, "old1")
+				So(out.String(), ShouldContainSubstring, `"key": "<redacted>"`)
+				So(out.String(), ShouldContainSubstring, `"write": "30s"`)
+			})
+		})
+
+		Convey("API keys are switched on by a flag without value", func() {
+			hash := strings.Repeat("ab", 32)
+			env["SIGNING_SERVICE_ADMIN_KEY_HASH"] = hash
+			cfg, _, err := config.Load("test", []string{"-api-keys", "-api-keys-file", "keys.json"}, getenv)
+			So(err, ShouldBeNil)
+			So(cfg.APIKeys, ShouldResemble, config.APIKeys{Enabled: true, File: "keys.json", AdminKeyHash: hash})
+		})
+
+		Convey("the sqlite backend keeps its database in the storage path", func() {
+			cfg, _, err := config.Load("test", []string{"-storage-backend", "sqlite", "-storage-path", "/var/lib/signing"}, getenv)
+			So(err, ShouldBeNil)
+			So(cfg.Storage.SQLiteFile(), ShouldEqual, filepath.Join("/var/lib/signing", "signing.db"))
+		})
+
+		Convey("-print-config is reported", func() {
+			_, printConfig, err := config.Load("test", []string{"-print-config"}, getenv)
+			So(err, ShouldBeNil)
+			So(printConfig, ShouldBeTrue)
+		})
+
+		Convey("the printed configuration can be loaded back", func() {
+			cfg := config.Default()
+			cfg.ListenAddress = ":1234"
+			cfg.Algorithms = map[string]crypto.Parameters{"rsa": {KeySize: 4096}}
+			var out bytes.Buffer
+			So(config.Print(&out, cfg), ShouldBeNil)
+
+			loaded, _, err := config.Load("test", []string{"-config", writeFile("printed.yaml", out.String())}, getenv)
+			So(err, ShouldBeNil)
+			So(loaded, ShouldResemble, cfg)
+		})
+
+		Convey("invalid configurations are rejected", func() {
+			for _, args := range [][]string{
+				{"-storage-backend", "tape"},
+				{"-storage-backend", "file", "-storage-path", ""},
+				{"-storage-backend", "sqlite", "-storage-path", ""},
+				{"-storage-snapshot-threshold", "many"},
+				{"-storage-backend", "file", "-storage-snapshot-threshold", "-1"},
+				{"-log-level", "chatty"},
+				{"-listen", ""},
+				{"-tls-cert", "cert.pem"},
+				{"-tls-client-ca", "ca.pem"},
+				{"-config", writeFile("clients.yaml", "tls:\n  client_devices:\n    CN=till-1: [till-1]\n")},
+				{"-config", writeFile("tenants.yaml", "tls:\n  cert_file: a\n  key_file: b\n  client_ca_file: c\n  client_devices:\n    CN=till-1: [shop/]\n")},
+				{"-idle-timeout", "-1s"},
+				{"-write-timeout", "soon"},
+				{"-idempotency-window", "0s"},
+				{"-admin-key-hash", "not-a-hash"},
+				{"-admin-key-hash", strings.Repeat("ab", 32)},
+				{"-api-keys=maybe"},
+				{"unexpected"},
+				{"-config", filepath.Join(dir, "missing.yaml")},
+				{"-config", writeFile("typo.yaml", "listen_adress: \":9090\"\n")},
+			} {
+				_, _, err := config.Load("test", args, getenv)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("every problem is reported at once", func() {
+			_, _, err := config.Load("test", []string{"-listen", "", "-log-level", "chatty"}, getenv)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Listen address is required")
+			So(err.Error(), ShouldContainSubstring, "Unknown log level chatty")
+		})
+	})
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix of every environment variable read by Load
+const EnvPrefix = "SIGNING_SERVICE_"
+
+// setting is a single value settable from the environment (EnvPrefix + env) and the command line (-flag)
+type setting struct {
+	flag  string
+	env   string // empty = command line only
+	usage string
+	set   func(c *Config, value string) error
+	// A boolean flag may be given without value
+	boolean bool
+}
+
+var settings = []setting{
+	{"listen", "LISTEN_ADDRESS", "host and port to listen on", stringValue(func(c *Config) *string { return &c.ListenAddress }), false},
+	{"log-level", "LOG_LEVEL", "minimum level of logged messages: debug, info, warn or error", stringValue(func(c *Config) *string { return &c.LogLevel }), false},
+	{"storage-backend", "STORAGE_BACKEND", "storage backend: memory, file or sqlite", stringValue(func(c *Config) *string { return &c.Storage.Backend }), false},
+	{"storage-path", "STORAGE_PATH", "directory of the file or sqlite storage backend", stringValue(func(c *Config) *string { return &c.Storage.Path }), false},
+	{"storage-sync-mode", "STORAGE_SYNC_MODE", "when the file storage backend fsyncs: always, interval or never", stringValue(func(c *Config) *string { return &c.Storage.SyncMode }), false},
+	{"storage-sync-interval", "STORAGE_SYNC_INTERVAL", "how often the file storage backend fsyncs with sync mode interval", durationValue(func(c *Config) *Duration { return &c.Storage.SyncInterval }), false},
+	{"storage-snapshot-threshold", "STORAGE_SNAPSHOT_THRESHOLD", "write-ahead log records after which the file storage backend compacts, 0 = never", intValue(func(c *Config) *int { return &c.Storage.SnapshotThreshold }), false},
+	{"tls-cert", "TLS_CERT_FILE", "certificate file to serve HTTPS with", stringValue(func(c *Config) *string { return &c.TLS.CertFile }), false},
+	{"tls-key", "TLS_KEY_FILE", "private key file of the certificate", stringValue(func(c *Config) *string { return &c.TLS.KeyFile }), false},
+	{"tls-client-ca", "TLS_CLIENT_CA_FILE", "CA bundle client certificates must be issued by", stringValue(func(c *Config) *string { return &c.TLS.ClientCAFile }), false},
+	{"read-header-timeout", "READ_HEADER_TIMEOUT", "how long reading request headers may take, 0 = no timeout", durationValue(func(c *Config) *Duration { return &c.Timeouts.ReadHeader }), false},
+	{"read-timeout", "READ_TIMEOUT", "how long reading a request may take, 0 = no timeout", durationValue(func(c *Config) *Duration { return &c.Timeouts.Read }), false},
+	{"write-timeout", "WRITE_TIMEOUT", "how long writing a response may take, 0 = no timeout", durationValue(func(c *Config) *Duration { return &c.Timeouts.Write }), false},
+	{"idle-timeout", "IDLE_TIMEOUT", "how long an idle keep-alive connection is kept, 0 = no timeout", durationValue(func(c *Config) *Duration { return &c.Timeouts.Idle }), false},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "how long requests in flight may take to finish on shutdown, 0 = no timeout", durationValue(func(c *Config) *Duration { return &c.Timeouts.Shutdown }), false},
+	{"api-keys", "API_KEYS_ENABLED", "require an API key on every request but the health check", boolValue(func(c *Config) *bool { return &c.APIKeys.Enabled }), true},
+	{"api-keys-file", "API_KEYS_FILE", "file API keys are kept in, only their hashes, empty = memory", stringValue(func(c *Config) *string { return &c.APIKeys.File }), false},
+	{"admin-key-hash", "ADMIN_KEY_HASH", "hex encoded SHA-256 of the API key permitted everything", stringValue(func(c *Config) *string { return &c.APIKeys.AdminKeyHash }), false},
+	{"tenants-file", "TENANTS_FILE", "file tenants are kept in, empty = memory", stringValue(func(c *Config) *string { return &c.Tenants.File }), false},
+	{"idempotency-window", "IDEMPOTENCY_WINDOW", "how long the idempotency key of a signing request is remembered", durationValue(func(c *Config) *Duration { return &c.IdempotencyWindow }), false},
+	{"master-key-file", "MASTER_KEY_FILE", "file holding the base64 encoded master key", stringValue(func(c *Config) *string { return &c.MasterKey.File }), false},
+	// secrets are not accepted on the command line, where every local user can see them
+	{"", "MASTER_KEY", "", func(c *Config, value string) error {
+		c.MasterKey.Key = Secret(value)
+		return nil
+	}, false},
+	{"", "PREVIOUS_MASTER_KEYS", "", func(c *Config, value string) error {
+		c.MasterKey.PreviousKeys = nil
+		for _, key := range strings.Split(value, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				c.MasterKey.PreviousKeys = append(c.MasterKey.PreviousKeys, Secret(key))
+			}
+		}
+		return nil
+	}, false},
+}
+
+// Load builds the configuration of program @name from, in increasing order of precedence: Default, the
+// configuration file (-config or SIGNING_SERVICE_CONFIG), environment variables looked up with @getenv and
+// command line @args, then validates it. @printConfig tells whether -print-config was given.
+func Load(name string, args []string, getenv func(string) string) (c *Config, printConfig bool, err error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := flags.String("config", getenv(EnvPrefix+"CONFIG"), "YAML or JSON configuration `file`")
+	flags.BoolVar(&printConfig, "print-config", false, "print the effective configuration and exit")
+	given := make(map[string]string)
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		flagName := s.flag
+		usage := s.usage
+		if s.env != "" {
+			usage += " (env " + EnvPrefix + s.env + ")"
+		}
+		remember := func(value string) error {
+			given[flagName] = value
+			return nil
+		}
+		if s.boolean {
+			flags.BoolFunc(flagName, usage, remember)
+		} else {
+			flags.Func(flagName, usage, remember)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, false, err
+	}
+	if flags.NArg() > 0 {
+		return nil, false, fmt.Errorf("Unexpected argument %s", flags.Arg(0))
+	}
+
+	c = Default()
+	if *configFile != "" {
+		if err := loadFile(c, *configFile); err != nil {
+			return nil, false, err
+		}
+	}
+
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		if value := getenv(EnvPrefix + s.env); value != "" {
+			if err := s.set(c, value); err != nil {
+				return nil, false, fmt.Errorf("Invalid %s%s: %v", EnvPrefix, s.env, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := given[s.flag]; ok {
+			if err := s.set(c, value); err != nil {
+				return nil, false, fmt.Errorf("Invalid -%s: %v", s.flag, err)
+			}
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, false, err
+	}
+	return c, printConfig, nil
+}
+
+// loadFile overlays @c with configuration file @path. YAML is decoded into generic values first and then
+// through the JSON tags, so both formats share the field names and unknown fields are rejected in both.
+func loadFile(c *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var document any
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return fmt.Errorf("Invalid configuration file %s: %v", path, err)
+	}
+	if document == nil {
+		return nil
+	}
+	asJSON, err := json.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("Invalid configuration file %s: %v", path, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(asJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("Invalid configuration file %s: %v", path, err)
+	}
+	return nil
+}
+
+func stringValue(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func durationValue(field func(c *Config) *Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		return field(c).UnmarshalText([]byte(value))
+	}
+}
+
+func boolValue(field func(c *Config) *bool) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = parsed
+		return nil
+	}
+}
+
+func intValue(field func(c *Config) *int) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = parsed
+		return nil
+	}
+}
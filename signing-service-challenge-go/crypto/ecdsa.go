@@ -1,13 +1,14 @@
 package crypto
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 )
 
 // ECCKeyPair is a DTO that holds ECC private and public keys
@@ -63,13 +64,59 @@ func (kp *ECCKeyPair) Deserialize(privateKeyBytes []byte) error {
 	return nil
 }
 
-// ECCAlgorithm implements methods to generate ECC key pair as well as to sign and verify data
+// Default curve for new ECC keys
+const defaultCurve = "P-384"
+
+// ECCAlgorithm implements methods to generate ECC key pair as well as to sign and verify data.
+// Zero valued fields select the defaults: P-384 and SHA-256.
 type ECCAlgorithm struct {
+	// Curve of generated keys
+	Curve elliptic.Curve
+	// Hash applied to data before signing
+	Hash crypto.Hash
+}
+
+func (algo *ECCAlgorithm) Configure(params Parameters) (Algorithm, error) {
+	if params.KeySize != 0 {
+		return nil, errors.New("ECC does not accept a key size, choose a curve instead")
+	}
+	if params.SaltLength != 0 {
+		return nil, errors.New("ECC does not accept a salt length")
+	}
+
+	curveName := params.Curve
+	if curveName == "" {
+		curveName = defaultCurve
+	}
+	curve, ok := curves[curveName]
+	if !ok {
+		return nil, fmt.Errorf("Curve %s is not supported, expected one of P-256, P-384, P-521", curveName)
+	}
+
+	hash, err := parseHash(params.Hash, defaultHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ECCAlgorithm{Curve: curve, Hash: hash}, nil
+}
+
+func (algo *ECCAlgorithm) Parameters() Parameters {
+	curve := algo.curve()
+	hash, _ := digest(algo.Hash, nil)
+
+	return Parameters{Curve: curve.Params().Name, Hash: hashName(hash)}
+}
+
+func (algo *ECCAlgorithm) curve() elliptic.Curve {
+	if algo.Curve == nil {
+		return curves[defaultCurve]
+	}
+	return algo.Curve
 }
 
 func (algo *ECCAlgorithm) GenerateKeyPair() (KeyPair, error) {
-	// Security has been ignored for the sake of simplicity.
-	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	key, err := ecdsa.GenerateKey(algo.curve(), rand.Reader)
 	if err != nil {
 		return nil, err
 	}
@@ -86,21 +133,37 @@ func (algo *ECCAlgorithm) ConstructKeyPair(priv []byte) (KeyPair, error) {
 	return kp, err
 }
 
+func (algo *ECCAlgorithm) ParsePublicKey(pub []byte) (Key, error) {
+	block, _ := pem.Decode(pub)
+	if block == nil {
+		return nil, errors.New("Given public key is not a valid PEM encoded key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	eccPublicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Given public key is not an ECC public key")
+	}
+	return eccPublicKey, nil
+}
+
 func (algo *ECCAlgorithm) Sign(priv Key, data []byte) ([]byte, error) {
-	hashed := sha256.Sum256(data)
+	_, hashed := digest(algo.Hash, data)
 	eccPrivateKey, ok := priv.(*ecdsa.PrivateKey)
 	if ok {
-		return ecdsa.SignASN1(rand.Reader, eccPrivateKey, hashed[:])
+		return ecdsa.SignASN1(rand.Reader, eccPrivateKey, hashed)
 	} else {
 		return nil, errors.New("Given private key is not an ECC private key")
 	}
 }
 
 func (algo *ECCAlgorithm) Verify(pub Key, data []byte, signature []byte) error {
-	hashed := sha256.Sum256(data)
+	_, hashed := digest(algo.Hash, data)
 	eccPublicKey, ok := pub.(*ecdsa.PublicKey)
 	if ok {
-		verified := ecdsa.VerifyASN1(eccPublicKey, hashed[:], signature[:])
+		verified := ecdsa.VerifyASN1(eccPublicKey, hashed, signature[:])
 		if verified {
 			return nil
 		} else {
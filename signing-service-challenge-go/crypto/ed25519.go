@@ -0,0 +1,131 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+)
+
+// Ed25519KeyPair is a DTO that holds Ed25519 private and public keys
+type Ed25519KeyPair struct {
+	Public  ed25519.PublicKey
+	Private ed25519.PrivateKey
+}
+
+func (kp *Ed25519KeyPair) PublicKey() Key {
+	return kp.Public
+}
+
+func (kp *Ed25519KeyPair) PrivateKey() Key {
+	return kp.Private
+}
+
+func (kp *Ed25519KeyPair) Serialize() ([]byte, []byte, error) {
+	privateKeyBytes, err := x509.MarshalPKCS8PrivateKey(kp.Private)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(kp.Public)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encodedPrivate := pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: privateKeyBytes,
+	})
+
+	encodedPublic := pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: publicKeyBytes,
+	})
+
+	return encodedPublic, encodedPrivate, nil
+}
+
+func (kp *Ed25519KeyPair) Deserialize(privateKeyBytes []byte) error {
+	block, _ := pem.Decode(privateKeyBytes)
+	if block == nil {
+		return errors.New("Given private key is not a valid PEM encoded key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return errors.New("Given private key is not an Ed25519 private key")
+	}
+
+	kp.Private = privateKey
+	kp.Public = privateKey.Public().(ed25519.PublicKey)
+	return nil
+}
+
+// Ed25519Algorithm implements methods to generate Ed25519 key pair as well as to sign and verify data.
+// Unlike the other algorithms, data is signed as is because Ed25519 does its own hashing.
+type Ed25519Algorithm struct {
+}
+
+func (algo *Ed25519Algorithm) GenerateKeyPair() (KeyPair, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ed25519KeyPair{
+		Public:  public,
+		Private: private,
+	}, nil
+}
+
+func (algo *Ed25519Algorithm) ConstructKeyPair(priv []byte) (KeyPair, error) {
+	kp := &Ed25519KeyPair{}
+	err := kp.Deserialize(priv)
+	return kp, err
+}
+
+func (algo *Ed25519Algorithm) ParsePublicKey(pub []byte) (Key, error) {
+	block, _ := pem.Decode(pub)
+	if block == nil {
+		return nil, errors.New("Given public key is not a valid PEM encoded key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ed25519PublicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("Given public key is not an Ed25519 public key")
+	}
+	return ed25519PublicKey, nil
+}
+
+func (algo *Ed25519Algorithm) Sign(priv Key, data []byte) ([]byte, error) {
+	ed25519PrivateKey, ok := priv.(ed25519.PrivateKey)
+	if ok && len(ed25519PrivateKey) == ed25519.PrivateKeySize {
+		return ed25519.Sign(ed25519PrivateKey, data), nil
+	} else {
+		return nil, errors.New("Given private key is not an Ed25519 private key")
+	}
+}
+
+func (algo *Ed25519Algorithm) Verify(pub Key, data []byte, signature []byte) error {
+	ed25519PublicKey, ok := pub.(ed25519.PublicKey)
+	if ok && len(ed25519PublicKey) == ed25519.PublicKeySize {
+		if ed25519.Verify(ed25519PublicKey, data, signature) {
+			return nil
+		} else {
+			return errors.New("Verification failed")
+		}
+	} else {
+		return errors.New("Given public key is not an Ed25519 public key")
+	}
+}
+
+func init() {
+	RegisterAlgorithm("ed25519", &Ed25519Algorithm{})
+}
@@ -0,0 +1,155 @@
+package crypto_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEd25519Algorithm(t *testing.T) {
+	Convey("Given an Ed25519Algorithm", t, func() {
+		algo := &crypto.Ed25519Algorithm{}
+
+		Convey("It should be registered as ed25519", func() {
+			So(crypto.GetAlgorithm("ed25519"), ShouldHaveSameTypeAs, algo)
+		})
+
+		Convey("It should generate a valid Ed25519 key pair", func() {
+			kp, err := algo.GenerateKeyPair()
+
+			So(err, ShouldBeNil)
+			So(kp, ShouldNotBeNil)
+
+			edKp, ok := kp.(*crypto.Ed25519KeyPair)
+			So(ok, ShouldBeTrue)
+			So(edKp.Public, ShouldNotBeEmpty)
+			So(edKp.Private, ShouldNotBeEmpty)
+		})
+
+		Convey("ConstructKeyPair should rebuild the same key pair from serialized private key", func() {
+			kp, _ := algo.GenerateKeyPair()
+			_, priv, _ := kp.(*crypto.Ed25519KeyPair).Serialize()
+
+			reconstructed, err := algo.ConstructKeyPair(priv)
+			So(err, ShouldBeNil)
+			So(reconstructed, ShouldNotBeNil)
+
+			newKp := reconstructed.(*crypto.Ed25519KeyPair)
+			So(newKp.Private.Equal(kp.(*crypto.Ed25519KeyPair).Private), ShouldBeTrue)
+			So(newKp.Public.Equal(kp.(*crypto.Ed25519KeyPair).Public), ShouldBeTrue)
+		})
+
+		Convey("ConstructKeyPair should fail with invalid PEM", func() {
+			reconstructed, err := algo.ConstructKeyPair([]byte("INVALID PEM DATA"))
+			So(reconstructed, ShouldNotBeNil) // still returns *Ed25519KeyPair
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not a valid PEM")
+		})
+
+		Convey("Serialize should produce PKCS#8 and PKIX PEM blocks", func() {
+			kp, _ := algo.GenerateKeyPair()
+			pub, priv, err := kp.Serialize()
+			So(err, ShouldBeNil)
+
+			privBlock, _ := pem.Decode(priv)
+			So(privBlock, ShouldNotBeNil)
+			So(privBlock.Type, ShouldEqual, "PRIVATE KEY")
+			_, err = x509.ParsePKCS8PrivateKey(privBlock.Bytes)
+			So(err, ShouldBeNil)
+
+			pubBlock, _ := pem.Decode(pub)
+			So(pubBlock, ShouldNotBeNil)
+			So(pubBlock.Type, ShouldEqual, "PUBLIC KEY")
+			_, err = x509.ParsePKIXPublicKey(pubBlock.Bytes)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Serialize and Deserialize should preserve key values", func() {
+			kp, _ := algo.GenerateKeyPair()
+			pub, priv, err := kp.(*crypto.Ed25519KeyPair).Serialize()
+
+			So(err, ShouldBeNil)
+			So(pub, ShouldNotBeEmpty)
+			So(priv, ShouldNotBeEmpty)
+
+			newKp := &crypto.Ed25519KeyPair{}
+			err = newKp.Deserialize(priv)
+			So(err, ShouldBeNil)
+
+			So(newKp.Private.Equal(kp.(*crypto.Ed25519KeyPair).Private), ShouldBeTrue)
+			So(newKp.Public.Equal(kp.(*crypto.Ed25519KeyPair).Public), ShouldBeTrue)
+		})
+
+		Convey("Deserialize should fail on invalid PEM", func() {
+			kp := &crypto.Ed25519KeyPair{}
+			err := kp.Deserialize([]byte("INVALID PEM DATA"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not a valid PEM")
+		})
+
+		Convey("Deserialize should fail on invalid private key bytes", func() {
+			kp := &crypto.Ed25519KeyPair{}
+			invalidPem := pem.EncodeToMemory(&pem.Block{
+				Type:  "PRIVATE KEY",
+				Bytes: []byte("INVALID KEY"),
+			})
+			err := kp.Deserialize(invalidPem)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Deserialize should fail on a PKCS#8 key of another algorithm", func() {
+			ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			der, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+			otherPem := pem.EncodeToMemory(&pem.Block{
+				Type:  "PRIVATE KEY",
+				Bytes: der,
+			})
+
+			kp := &crypto.Ed25519KeyPair{}
+			err := kp.Deserialize(otherPem)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not an Ed25519")
+		})
+
+		Convey("Sign and Verify should succeed on valid data", func() {
+			kp, _ := algo.GenerateKeyPair()
+			data := []byte("fiskaly ed25519 test")
+
+			signature, err := algo.Sign(kp.PrivateKey(), data)
+			So(err, ShouldBeNil)
+			So(signature, ShouldNotBeEmpty)
+
+			err = algo.Verify(kp.PublicKey(), data, signature)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Verify should fail on tampered data", func() {
+			kp, _ := algo.GenerateKeyPair()
+			data := []byte("original data")
+			tampered := []byte("different data")
+
+			signature, _ := algo.Sign(kp.PrivateKey(), data)
+			err := algo.Verify(kp.PublicKey(), tampered, signature)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Verification failed")
+		})
+
+		Convey("Sign should fail on non-Ed25519 private key", func() {
+			_, err := algo.Sign("not-a-key", []byte("data"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not an Ed25519")
+		})
+
+		Convey("Verify should fail on non-Ed25519 public key", func() {
+			err := algo.Verify("not-a-key", []byte("data"), []byte("sig"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not an Ed25519")
+		})
+	})
+}
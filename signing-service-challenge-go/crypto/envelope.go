@@ -0,0 +1,225 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Size in bytes of master and data keys, both are AES-256 keys
+const envelopeKeySize = 32
+
+// MasterKey is a key-encryption key, it only ever encrypts (wraps) per device data keys
+type MasterKey struct {
+	// Derived from the key itself, so the same key always gets the same ID and nothing has to be configured
+	ID  string
+	key []byte
+}
+
+// NewMasterKey wraps 32 raw bytes @key into a MasterKey
+func NewMasterKey(key []byte) (*MasterKey, error) {
+	if len(key) != envelopeKeySize {
+		return nil, fmt.Errorf("Master key must be %d bytes, got %d", envelopeKeySize, len(key))
+	}
+
+	fingerprint := sha256.Sum256(key)
+	return &MasterKey{
+		ID:  hex.EncodeToString(fingerprint[:8]),
+		key: append([]byte(nil), key...),
+	}, nil
+}
+
+// ParseMasterKey decodes a base64 encoded 32 byte master key, surrounding whitespace is ignored
+func ParseMasterKey(encoded string) (*MasterKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.New("Master key is not valid base64: " + err.Error())
+	}
+	return NewMasterKey(key)
+}
+
+// LoadMasterKeyFile reads a base64 encoded master key from file @path
+func LoadMasterKeyFile(path string) (*MasterKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMasterKey(string(data))
+}
+
+// GenerateMasterKey returns a new random master key, base64 encoded, ready to be stored in a file or environment variable
+func GenerateMasterKey() (string, error) {
+	key := make([]byte, envelopeKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// SealedData is data encrypted with its own data key, which in turn is encrypted by a master key
+type SealedData struct {
+	// Nonce followed by the AES-GCM encrypted data
+	Ciphertext []byte
+	// Nonce followed by the AES-GCM encrypted data key
+	WrappedKey []byte
+	// ID of the MasterKey that wrapped the data key
+	MasterKeyID string
+}
+
+// Envelope implements envelope encryption: data is encrypted with a random per item data key, and only
+// the data key is encrypted with the master key. Rotating the master key thus only rewraps data keys.
+type Envelope struct {
+	current  *MasterKey
+	previous map[string]*MasterKey
+}
+
+// NewEnvelope creates an Envelope sealing with @current, @previous keys are only used to open data sealed
+// before a rotation
+func NewEnvelope(current *MasterKey, previous ...*MasterKey) *Envelope {
+	e := &Envelope{
+		current:  current,
+		previous: make(map[string]*MasterKey),
+	}
+	for _, key := range previous {
+		e.previous[key.ID] = key
+	}
+	return e
+}
+
+// CurrentKeyID returns the ID of the master key new data is sealed with
+func (e *Envelope) CurrentKeyID() string {
+	return e.current.ID
+}
+
+// Seal encrypts @plaintext with a fresh data key bound to @context (e.g. the owner ID), so the result
+// cannot be opened in another context
+func (e *Envelope) Seal(plaintext []byte, context string) (SealedData, error) {
+	dataKey := make([]byte, envelopeKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return SealedData{}, err
+	}
+
+	ciphertext, err := gcmSeal(dataKey, plaintext, []byte(context))
+	if err != nil {
+		return SealedData{}, err
+	}
+
+	wrappedKey, err := gcmSeal(e.current.key, dataKey, []byte(e.current.ID))
+	if err != nil {
+		return SealedData{}, err
+	}
+
+	return SealedData{
+		Ciphertext:  ciphertext,
+		WrappedKey:  wrappedKey,
+		MasterKeyID: e.current.ID,
+	}, nil
+}
+
+// Open decrypts @sealed previously sealed with the same @context
+func (e *Envelope) Open(sealed SealedData, context string) ([]byte, error) {
+	dataKey, err := e.unwrap(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcmOpen(dataKey, sealed.Ciphertext, []byte(context))
+	if err != nil {
+		return nil, errors.New("Sealed data cannot be decrypted, it has been tampered with or belongs elsewhere")
+	}
+	return plaintext, nil
+}
+
+// Rewrap re-encrypts the data key of @sealed with the current master key, the ciphertext is left untouched
+func (e *Envelope) Rewrap(sealed SealedData) (SealedData, error) {
+	if sealed.MasterKeyID == e.current.ID {
+		return sealed, nil
+	}
+
+	dataKey, err := e.unwrap(sealed)
+	if err != nil {
+		return SealedData{}, err
+	}
+
+	wrappedKey, err := gcmSeal(e.current.key, dataKey, []byte(e.current.ID))
+	if err != nil {
+		return SealedData{}, err
+	}
+
+	return SealedData{
+		Ciphertext:  sealed.Ciphertext,
+		WrappedKey:  wrappedKey,
+		MasterKeyID: e.current.ID,
+	}, nil
+}
+
+func (e *Envelope) unwrap(sealed SealedData) ([]byte, error) {
+	masterKey := e.current
+	if sealed.MasterKeyID != e.current.ID {
+		var ok bool
+		if masterKey, ok = e.previous[sealed.MasterKeyID]; !ok {
+			return nil, errors.New("Master key " + sealed.MasterKeyID + " is not available")
+		}
+	}
+
+	dataKey, err := gcmOpen(masterKey.key, sealed.WrappedKey, []byte(masterKey.ID))
+	if err != nil {
+		return nil, errors.New("Data key cannot be unwrapped with master key " + masterKey.ID)
+	}
+	return dataKey, nil
+}
+
+func gcmSeal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func gcmOpen(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("Ciphertext is too short")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Process-wide Envelope, nil when encryption at rest is disabled
+var envelope *Envelope
+
+// GetEnvelope returns the process-wide Envelope, or nil if encryption at rest is disabled
+func GetEnvelope() *Envelope {
+	return envelope
+}
+
+// SetEnvelope replaces the process-wide Envelope, nil disables encryption of newly stored keys
+func SetEnvelope(e *Envelope) {
+	envelope = e
+}
@@ -24,3 +24,10 @@ type Algorithm interface {
 	// Verify ensures authenticity of @data given a @signature with a @pub key
 	Verify(pub Key, data []byte, signature []byte) error
 }
+
+// PublicKeyParser is implemented by Algorithm-s able to reconstruct a public key on its own, which lets
+// verification work without ever touching the private key
+type PublicKeyParser interface {
+	// Given PEM encoded public key, return the public Key
+	ParsePublicKey(pub []byte) (Key, error)
+}
@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	_ "crypto/sha256" // register SHA-224/256 implementations for crypto.Hash
+	_ "crypto/sha512" // register SHA-384/512 implementations for crypto.Hash
+	"errors"
+	"fmt"
+)
+
+// Parameters tunes key generation and signing of an Algorithm, zero values select the algorithm defaults
+type Parameters struct {
+	// Key size in bits, RSA only
+	KeySize int `json:"key_size,omitempty"`
+	// Elliptic curve name: P-256, P-384 or P-521, ECC only
+	Curve string `json:"curve,omitempty"`
+	// Hash function name: SHA-256, SHA-384 or SHA-512
+	Hash string `json:"hash,omitempty"`
+	// Salt length in bytes, RSA-PSS only, 0 means as long as the hash output
+	SaltLength int `json:"salt_length,omitempty"`
+}
+
+// IsZero tells whether no parameter is set at all
+func (p Parameters) IsZero() bool {
+	return p == Parameters{}
+}
+
+// ConfigurableAlgorithm is implemented by Algorithm-s whose key generation or signing can be tuned
+type ConfigurableAlgorithm interface {
+	Algorithm
+	// Configure returns a copy of the algorithm tuned with @params, or an error if @params is not supported
+	Configure(params Parameters) (Algorithm, error)
+	// Parameters returns the effective parameters, defaults filled in
+	Parameters() Parameters
+}
+
+// Configure tunes @algo with @params. Algorithms that are not configurable only accept zero Parameters.
+func Configure(algo Algorithm, params Parameters) (Algorithm, error) {
+	if configurable, ok := algo.(ConfigurableAlgorithm); ok {
+		return configurable.Configure(params)
+	}
+	if !params.IsZero() {
+		return nil, errors.New("Algorithm does not accept any parameters")
+	}
+	return algo, nil
+}
+
+// ParametersOf returns the effective parameters of @algo, zero Parameters if it is not configurable.
+// Persist these rather than the requested ones, so changing a default never affects existing keys.
+func ParametersOf(algo Algorithm) Parameters {
+	if configurable, ok := algo.(ConfigurableAlgorithm); ok {
+		return configurable.Parameters()
+	}
+	return Parameters{}
+}
+
+// Parameters of devices created without any, keyed by algorithm name
+var defaultParameters map[string]Parameters
+
+// DefaultParameters returns the parameters a device of algorithm @name created without any gets, zero Parameters
+// (the algorithm defaults) unless configured otherwise with SetDefaultParameters
+func DefaultParameters(name string) Parameters {
+	return defaultParameters[name]
+}
+
+// SetDefaultParameters replaces the parameters devices created without any get, keyed by algorithm name
+func SetDefaultParameters(defaults map[string]Parameters) {
+	defaultParameters = defaults
+}
+
+// Default hash of both RSA and ECC, must stay SHA-256 as devices created before parameters existed rely on it
+const defaultHash = crypto.SHA256
+
+var hashes = map[string]crypto.Hash{
+	"SHA-256": crypto.SHA256,
+	"SHA-384": crypto.SHA384,
+	"SHA-512": crypto.SHA512,
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// parseHash returns the hash named @name, or @fallback if @name is empty
+func parseHash(name string, fallback crypto.Hash) (crypto.Hash, error) {
+	if name == "" {
+		return fallback, nil
+	}
+	if hash, ok := hashes[name]; ok {
+		return hash, nil
+	}
+	return 0, fmt.Errorf("Hash %s is not supported, expected one of SHA-256, SHA-384, SHA-512", name)
+}
+
+// hashName is the inverse of parseHash
+func hashName(hash crypto.Hash) string {
+	for name, h := range hashes {
+		if h == hash {
+			return name
+		}
+	}
+	return hash.String()
+}
+
+// digest hashes @data with @hash, falling back to SHA-256 for the zero value
+func digest(hash crypto.Hash, data []byte) (crypto.Hash, []byte) {
+	if hash == 0 {
+		hash = defaultHash
+	}
+	h := hash.New()
+	h.Write(data)
+	return hash, h.Sum(nil)
+}
@@ -0,0 +1,122 @@
+package crypto_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConfigure(t *testing.T) {
+	Convey("Given the RSA algorithm", t, func() {
+		algo := crypto.GetAlgorithm("rsa")
+
+		Convey("zero parameters select a 2048 bit key with SHA-256", func() {
+			configured, err := crypto.Configure(algo, crypto.Parameters{})
+			So(err, ShouldBeNil)
+			So(crypto.ParametersOf(configured), ShouldResemble, crypto.Parameters{KeySize: 2048, Hash: "SHA-256"})
+
+			kp, err := configured.GenerateKeyPair()
+			So(err, ShouldBeNil)
+			So(kp.PublicKey().(*rsa.PublicKey).N.BitLen(), ShouldEqual, 2048)
+		})
+
+		Convey("supported key sizes and hashes are accepted", func() {
+			for _, keySize := range []int{2048, 3072, 4096} {
+				for _, hash := range []string{"SHA-256", "SHA-384", "SHA-512"} {
+					configured, err := crypto.Configure(algo, crypto.Parameters{KeySize: keySize, Hash: hash})
+					So(err, ShouldBeNil)
+					So(crypto.ParametersOf(configured), ShouldResemble, crypto.Parameters{KeySize: keySize, Hash: hash})
+				}
+			}
+		})
+
+		Convey("weak key sizes, unknown hashes and curves are rejected", func() {
+			_, err := crypto.Configure(algo, crypto.Parameters{KeySize: 1024})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "key size 1024 is not supported")
+
+			_, err = crypto.Configure(algo, crypto.Parameters{Hash: "MD5"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Hash MD5 is not supported")
+
+			_, err = crypto.Configure(algo, crypto.Parameters{Curve: "P-256"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("signatures are made and verified with the configured hash", func() {
+			sha512, _ := crypto.Configure(algo, crypto.Parameters{Hash: "SHA-512"})
+			sha256, _ := crypto.Configure(algo, crypto.Parameters{Hash: "SHA-256"})
+			kp, _ := sha512.GenerateKeyPair()
+			data := []byte("fiskaly test data")
+
+			signature, err := sha512.Sign(kp.PrivateKey(), data)
+			So(err, ShouldBeNil)
+			So(sha512.Verify(kp.PublicKey(), data, signature), ShouldBeNil)
+			So(sha256.Verify(kp.PublicKey(), data, signature), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given the ECC algorithm", t, func() {
+		algo := crypto.GetAlgorithm("ecc")
+
+		Convey("zero parameters select P-384 with SHA-256", func() {
+			configured, err := crypto.Configure(algo, crypto.Parameters{})
+			So(err, ShouldBeNil)
+			So(crypto.ParametersOf(configured), ShouldResemble, crypto.Parameters{Curve: "P-384", Hash: "SHA-256"})
+		})
+
+		Convey("every supported curve generates keys on that curve and round-trips signatures", func() {
+			for name, curve := range map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()} {
+				configured, err := crypto.Configure(algo, crypto.Parameters{Curve: name, Hash: "SHA-512"})
+				So(err, ShouldBeNil)
+
+				kp, err := configured.GenerateKeyPair()
+				So(err, ShouldBeNil)
+				So(kp.PublicKey().(*ecdsa.PublicKey).Curve, ShouldEqual, curve)
+
+				signature, err := configured.Sign(kp.PrivateKey(), []byte("data"))
+				So(err, ShouldBeNil)
+				So(configured.Verify(kp.PublicKey(), []byte("data"), signature), ShouldBeNil)
+			}
+		})
+
+		Convey("unknown curves and key sizes are rejected", func() {
+			_, err := crypto.Configure(algo, crypto.Parameters{Curve: "P-224"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Curve P-224 is not supported")
+
+			_, err = crypto.Configure(algo, crypto.Parameters{KeySize: 2048})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("signatures of devices created before parameters existed still verify with the defaults", func() {
+			legacy := &crypto.ECCAlgorithm{}
+			configured, _ := crypto.Configure(algo, crypto.Parameters{})
+			kp, _ := legacy.GenerateKeyPair()
+
+			signature, _ := legacy.Sign(kp.PrivateKey(), []byte("data"))
+			So(configured.Verify(kp.PublicKey(), []byte("data"), signature), ShouldBeNil)
+		})
+	})
+
+	Convey("Given an algorithm without parameters", t, func() {
+		algo := crypto.GetAlgorithm("ed25519")
+
+		Convey("zero parameters return the algorithm as is", func() {
+			configured, err := crypto.Configure(algo, crypto.Parameters{})
+			So(err, ShouldBeNil)
+			So(configured, ShouldEqual, algo)
+			So(crypto.ParametersOf(configured).IsZero(), ShouldBeTrue)
+		})
+
+		Convey("any parameter is rejected", func() {
+			_, err := crypto.Configure(algo, crypto.Parameters{Hash: "SHA-512"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "does not accept any parameters")
+		})
+	})
+}
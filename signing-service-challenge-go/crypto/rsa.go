@@ -4,10 +4,10 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 )
 
 // RSAKeyPair is a DTO that holds RSA private and public keys
@@ -56,13 +56,79 @@ func (kp *RSAKeyPair) Deserialize(privateKeyBytes []byte) error {
 	return nil
 }
 
-// RSAAlgorithm implements methods to generate RSA key pair as well as to sign and verify data
+func parseRSAPublicKey(publicKeyBytes []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(publicKeyBytes)
+	if block == nil {
+		return nil, errors.New("Given public key is not a valid PEM encoded key")
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+// Key sizes RSAAlgorithm accepts for new keys
+var rsaKeySizes = []int{2048, 3072, 4096}
+
+// Default key size for new RSA keys
+const defaultRSAKeySize = 2048
+
+func supportedRSAKeySize(keySize int) bool {
+	for _, supported := range rsaKeySizes {
+		if keySize == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// RSAAlgorithm implements methods to generate RSA key pair as well as to sign and verify data.
+// Zero valued fields select the defaults: 2048 bit keys and SHA-256.
 type RSAAlgorithm struct {
+	// Size of generated keys in bits
+	KeySize int
+	// Hash applied to data before signing
+	Hash crypto.Hash
+}
+
+func (algo *RSAAlgorithm) Configure(params Parameters) (Algorithm, error) {
+	if params.Curve != "" {
+		return nil, errors.New("RSA does not accept a curve")
+	}
+	if params.SaltLength != 0 {
+		return nil, errors.New("RSA does not accept a salt length, use rsa-pss instead")
+	}
+
+	keySize := params.KeySize
+	if keySize == 0 {
+		keySize = defaultRSAKeySize
+	}
+	if !supportedRSAKeySize(keySize) {
+		return nil, fmt.Errorf("RSA key size %d is not supported, expected one of 2048, 3072, 4096", keySize)
+	}
+
+	hash, err := parseHash(params.Hash, defaultHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RSAAlgorithm{KeySize: keySize, Hash: hash}, nil
+}
+
+func (algo *RSAAlgorithm) Parameters() Parameters {
+	keySize := algo.KeySize
+	if keySize == 0 {
+		keySize = defaultRSAKeySize
+	}
+	hash, _ := digest(algo.Hash, nil)
+
+	return Parameters{KeySize: keySize, Hash: hashName(hash)}
 }
 
 func (algo *RSAAlgorithm) GenerateKeyPair() (KeyPair, error) {
-	// Security has been ignored for the sake of simplicity.
-	key, err := rsa.GenerateKey(rand.Reader, 512)
+	keySize := algo.KeySize
+	if keySize == 0 {
+		keySize = defaultRSAKeySize
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, keySize)
 	if err != nil {
 		return nil, err
 	}
@@ -79,21 +145,25 @@ func (algo *RSAAlgorithm) ConstructKeyPair(priv []byte) (KeyPair, error) {
 	return kp, err
 }
 
+func (algo *RSAAlgorithm) ParsePublicKey(pub []byte) (Key, error) {
+	return parseRSAPublicKey(pub)
+}
+
 func (algo *RSAAlgorithm) Sign(priv Key, data []byte) ([]byte, error) {
-	hashed := sha256.Sum256(data)
+	hash, hashed := digest(algo.Hash, data)
 	rsaPrivateKey, ok := priv.(*rsa.PrivateKey)
 	if ok {
-		return rsa.SignPKCS1v15(rand.Reader, rsaPrivateKey, crypto.SHA256, hashed[:])
+		return rsa.SignPKCS1v15(rand.Reader, rsaPrivateKey, hash, hashed)
 	} else {
 		return nil, errors.New("Given private key is not a RSA private key")
 	}
 }
 
 func (algo *RSAAlgorithm) Verify(pub Key, data []byte, signature []byte) error {
-	hashed := sha256.Sum256(data)
+	hash, hashed := digest(algo.Hash, data)
 	rsaPublicKey, ok := pub.(*rsa.PublicKey)
 	if ok {
-		return rsa.VerifyPKCS1v15(rsaPublicKey, crypto.SHA256, hashed[:], signature[:])
+		return rsa.VerifyPKCS1v15(rsaPublicKey, hash, hashed, signature[:])
 	} else {
 		return errors.New("Given private key is not a RSA private key")
 	}
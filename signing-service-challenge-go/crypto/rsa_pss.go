@@ -0,0 +1,109 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+)
+
+// RSAPSSAlgorithm implements methods to generate RSA key pair as well as to sign and verify data using
+// the RSASSA-PSS signature scheme. Keys are plain RSA keys, serialized exactly like RSAAlgorithm does.
+// Zero valued fields select the defaults: 2048 bit keys, SHA-256 and a salt as long as the hash output.
+type RSAPSSAlgorithm struct {
+	// Size of generated keys in bits
+	KeySize int
+	// Hash applied to data before signing, also used by the mask generation function
+	Hash crypto.Hash
+	// Salt length in bytes, 0 means as long as the hash output
+	SaltLength int
+}
+
+func (algo *RSAPSSAlgorithm) Configure(params Parameters) (Algorithm, error) {
+	if params.Curve != "" {
+		return nil, errors.New("RSA-PSS does not accept a curve")
+	}
+
+	keySize := params.KeySize
+	if keySize == 0 {
+		keySize = defaultRSAKeySize
+	}
+	if !supportedRSAKeySize(keySize) {
+		return nil, fmt.Errorf("RSA-PSS key size %d is not supported, expected one of 2048, 3072, 4096", keySize)
+	}
+
+	hash, err := parseHash(params.Hash, defaultHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// the encoded message must fit the salt, the hash and 2 bytes of padding
+	maxSaltLength := keySize/8 - hash.Size() - 2
+	if params.SaltLength < 0 || params.SaltLength > maxSaltLength {
+		return nil, fmt.Errorf("RSA-PSS salt length %d is not supported, expected 0 to %d for this key size and hash", params.SaltLength, maxSaltLength)
+	}
+
+	return &RSAPSSAlgorithm{KeySize: keySize, Hash: hash, SaltLength: params.SaltLength}, nil
+}
+
+func (algo *RSAPSSAlgorithm) Parameters() Parameters {
+	keySize := algo.KeySize
+	if keySize == 0 {
+		keySize = defaultRSAKeySize
+	}
+	hash, _ := digest(algo.Hash, nil)
+
+	return Parameters{KeySize: keySize, Hash: hashName(hash), SaltLength: algo.options(hash).SaltLength}
+}
+
+// options returns the PSS options for @hash, resolving the default salt length
+func (algo *RSAPSSAlgorithm) options(hash crypto.Hash) *rsa.PSSOptions {
+	saltLength := algo.SaltLength
+	if saltLength == 0 {
+		saltLength = hash.Size()
+	}
+
+	return &rsa.PSSOptions{
+		SaltLength: saltLength,
+		Hash:       hash,
+	}
+}
+
+func (algo *RSAPSSAlgorithm) GenerateKeyPair() (KeyPair, error) {
+	return (&RSAAlgorithm{KeySize: algo.KeySize}).GenerateKeyPair()
+}
+
+func (algo *RSAPSSAlgorithm) ConstructKeyPair(priv []byte) (KeyPair, error) {
+	kp := &RSAKeyPair{}
+	err := kp.Deserialize(priv)
+	return kp, err
+}
+
+func (algo *RSAPSSAlgorithm) ParsePublicKey(pub []byte) (Key, error) {
+	return parseRSAPublicKey(pub)
+}
+
+func (algo *RSAPSSAlgorithm) Sign(priv Key, data []byte) ([]byte, error) {
+	hash, hashed := digest(algo.Hash, data)
+	rsaPrivateKey, ok := priv.(*rsa.PrivateKey)
+	if ok {
+		return rsa.SignPSS(rand.Reader, rsaPrivateKey, hash, hashed, algo.options(hash))
+	} else {
+		return nil, errors.New("Given private key is not a RSA private key")
+	}
+}
+
+func (algo *RSAPSSAlgorithm) Verify(pub Key, data []byte, signature []byte) error {
+	hash, hashed := digest(algo.Hash, data)
+	rsaPublicKey, ok := pub.(*rsa.PublicKey)
+	if ok {
+		return rsa.VerifyPSS(rsaPublicKey, hash, hashed, signature, algo.options(hash))
+	} else {
+		return errors.New("Given public key is not a RSA public key")
+	}
+}
+
+func init() {
+	RegisterAlgorithm("rsa-pss", &RSAPSSAlgorithm{})
+}
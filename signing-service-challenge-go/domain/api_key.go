@@ -0,0 +1,23 @@
+package domain
+
+import "time"
+
+// APIKey is an API key callers authenticate with. Only its hash is kept, the key itself is shown once on
+// creation and never again.
+type APIKey struct {
+	// Public part of the key, the part before the dot
+	ID string
+	// Optional name, for UI display
+	Name string
+	// Hex encoded SHA-256 of the whole key
+	Hash string
+	// Tenant the key belongs to, it may only use devices of that tenant. DefaultTenant for keys of the
+	// operator of the service.
+	TenantID string
+	// IDs of the devices the key may use, "*" for every device
+	DeviceIDs []string
+	// Operations the key is permitted, see auth.Operation
+	Operations []string
+	// When the key was created
+	CreatedAt time.Time
+}
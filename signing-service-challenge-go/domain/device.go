@@ -1,16 +1,117 @@
 package domain
 
+import (
+	"errors"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+)
+
+// Device is the internal representation of a signature device. It holds private key material, so it
+// must never be handed out to API clients as is.
 type Device struct {
-	// Device ID, suggestion: use UUID, but any string is OK
+	// Device ID, suggestion: use UUID, but any string without '/' is OK. Unique within its tenant only.
 	ID string
-	// Name of the algorithm chosen for this device
+	// ID of the tenant owning the device, DefaultTenant for devices of callers not belonging to any
+	TenantID string
+	// Name of the algorithm of the current key
 	Algorithm string
-	// PEM encoded private key, this is enough for reconstructing the whole key pair
+	// Effective parameters of the algorithm of the current key, zero for devices created before parameters
+	// were introduced
+	Parameters crypto.Parameters
+	// PEM encoded private key, this is enough for reconstructing the whole key pair. Encrypted when
+	// MasterKeyID is set, use OpenPrivateKey rather than reading it directly.
 	PrivateKey []byte
+	// Data key that encrypted PrivateKey, itself encrypted with the master key MasterKeyID
+	WrappedDataKey []byte
+	// ID of the master key that wrapped WrappedDataKey, empty if PrivateKey is stored in plain
+	MasterKeyID string
+	// PEM encoded public key of the current key
+	PublicKey []byte
+	// Version of the current key, 1 for the key the device was created with, see KeyVersion
+	KeyVersion int
+	// Counter of the first transaction signed with the current key
+	KeyFirstCounter int
+	// Keys the device signed with before the current one, oldest first, see KeyVersion
+	PreviousKeys []KeyVersion
 	// Optional label, for UI display
 	Label string
 	// Tracks number of call to Sign() with the same Algorithm
 	SignatureCounter int
 	// Signature of the last call to Sign() with this device, or simply base64 encoded device ID initially
 	LastSignature string
+	// What may be done with the device, empty only while it is being created, see Transition
+	Status DeviceStatus
+	// Every status change of the device, oldest first
+	StatusHistory []StatusChange
+	// Revision of the stored Device, incremented by the Storage on every save, 0 means never saved
+	Version int
+	// When the device was created, zero for devices created before it was kept
+	CreatedAt time.Time
+	// When the device was last modified, including by signing
+	UpdatedAt time.Time
+}
+
+// Key returns the key the device is stored with, see DeviceKey
+func (d *Device) Key() string {
+	return DeviceKey(d.TenantID, d.ID)
+}
+
+// SealPrivateKey stores PEM encoded @privateKey, encrypted with @envelope unless it is nil
+func (d *Device) SealPrivateKey(envelope *crypto.Envelope, privateKey []byte) error {
+	if envelope == nil {
+		d.PrivateKey, d.WrappedDataKey, d.MasterKeyID = privateKey, nil, ""
+		return nil
+	}
+
+	sealed, err := envelope.Seal(privateKey, d.Key())
+	if err != nil {
+		return err
+	}
+	d.PrivateKey, d.WrappedDataKey, d.MasterKeyID = sealed.Ciphertext, sealed.WrappedKey, sealed.MasterKeyID
+	return nil
+}
+
+// OpenPrivateKey returns the PEM encoded private key, decrypting it with @envelope if it is encrypted
+func (d *Device) OpenPrivateKey(envelope *crypto.Envelope) ([]byte, error) {
+	if d.MasterKeyID == "" {
+		return d.PrivateKey, nil
+	}
+	if envelope == nil {
+		return nil, errors.New("Private key of device " + d.ID + " is encrypted but no master key is configured")
+	}
+	return envelope.Open(d.sealedPrivateKey(), d.Key())
+}
+
+// RewrapPrivateKey makes the private key encrypted under the current master key of @envelope, encrypting
+// it first if it is stored in plain. The private key itself does not change. Returns whether anything changed.
+func (d *Device) RewrapPrivateKey(envelope *crypto.Envelope) (bool, error) {
+	if d.MasterKeyID == "" {
+		return true, d.SealPrivateKey(envelope, d.PrivateKey)
+	}
+	if d.MasterKeyID == envelope.CurrentKeyID() {
+		return false, nil
+	}
+
+	sealed, err := envelope.Rewrap(d.sealedPrivateKey())
+	if err != nil {
+		return false, err
+	}
+	d.PrivateKey, d.WrappedDataKey, d.MasterKeyID = sealed.Ciphertext, sealed.WrappedKey, sealed.MasterKeyID
+	return true, nil
+}
+
+func (d *Device) sealedPrivateKey() crypto.SealedData {
+	return crypto.SealedData{
+		Ciphertext:  d.PrivateKey,
+		WrappedKey:  d.WrappedDataKey,
+		MasterKeyID: d.MasterKeyID,
+	}
+}
+
+// appendCopy returns @items appended to a copy of @slice, never in place: a Device is copied by value, e.g. by
+// the in-memory storage and by callers modifying a loaded device, and the copies share the backing arrays of
+// their slices, so appending in place would change a stored copy behind its storage's back
+func appendCopy[T any](slice []T, items ...T) []T {
+	return append(slice[:len(slice):len(slice)], items...)
 }
@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+// DeviceQuery selects a page of the devices of one tenant, ordered by device ID. Empty filters match any device.
+type DeviceQuery struct {
+	// Tenant of the devices, empty = the default tenant
+	TenantID string
+	// Exact algorithm name
+	Algorithm string
+	// Start of the label, case sensitive
+	LabelPrefix string
+	// Current status, see Device.Status
+	Status DeviceStatus
+	// Creation time range, CreatedFrom inclusive and CreatedTo exclusive, zero = unbounded
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Only devices with an ID after this one, i.e. the last ID of the previous page, empty = from the first
+	After string
+	// Maximum number of devices, 0 = no limit
+	Limit int
+}
+
+// Matches tells whether @device is selected by the filters of the query, After and Limit aside
+func (q *DeviceQuery) Matches(device *Device) bool {
+	return device.TenantID == q.TenantID &&
+		(q.Algorithm == "" || device.Algorithm == q.Algorithm) &&
+		strings.HasPrefix(device.Label, q.LabelPrefix) &&
+		(q.Status == "" || device.Status == q.Status) &&
+		(q.CreatedFrom.IsZero() || !device.CreatedAt.Before(q.CreatedFrom)) &&
+		(q.CreatedTo.IsZero() || device.CreatedAt.Before(q.CreatedTo))
+}
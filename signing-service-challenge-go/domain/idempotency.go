@@ -0,0 +1,23 @@
+package domain
+
+import "time"
+
+// IdempotencyRecord remembers a signing request made with an idempotency key, so that repeating the
+// request returns the transaction it signed instead of signing again. It is stored together with that
+// transaction.
+type IdempotencyRecord struct {
+	// Key chosen by the client, unique per device
+	Key string
+	// Hex encoded SHA-256 of the data the request signed, to tell a repeated request from another one
+	// reusing the key
+	RequestHash string
+	// Counter of the transaction the request signed
+	Counter int
+	// When the key may be used for another request again
+	ExpiresAt time.Time
+}
+
+// Expired tells whether the key of the record may be used again at @now
+func (r *IdempotencyRecord) Expired(now time.Time) bool {
+	return !now.Before(r.ExpiresAt)
+}
@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+)
+
+// KeyVersion is a key pair a device signs or signed with. Keys of a device follow each other along its
+// signature chain: each one signs the transactions from its FirstCounter on, until the next one takes over.
+type KeyVersion struct {
+	// 1 for the key the device was created with, incremented on every rotation
+	Version int
+	// Name of the algorithm of the key
+	Algorithm string
+	// Effective parameters of the algorithm
+	Parameters crypto.Parameters
+	// PEM encoded public key
+	PublicKey []byte
+	// Counter of the first transaction signed with the key
+	FirstCounter int
+	// Counter of the last transaction signed with the key, FirstCounter - 1 if it signed none yet
+	LastCounter int
+	// When the key became current, zero if not known
+	CreatedAt time.Time
+	// When the key was replaced by the next one, zero for the current key
+	RotatedAt time.Time
+}
+
+// CurrentKey returns the current key of the device, its private key aside
+func (d *Device) CurrentKey() KeyVersion {
+	createdAt := d.CreatedAt
+	if len(d.PreviousKeys) > 0 {
+		createdAt = d.PreviousKeys[len(d.PreviousKeys)-1].RotatedAt
+	}
+	return KeyVersion{
+		Version:      d.KeyVersion,
+		Algorithm:    d.Algorithm,
+		Parameters:   d.Parameters,
+		PublicKey:    d.PublicKey,
+		FirstCounter: d.KeyFirstCounter,
+		LastCounter:  d.SignatureCounter - 1,
+		CreatedAt:    createdAt,
+	}
+}
+
+// Keys returns every key of the device, oldest first, the current one last
+func (d *Device) Keys() []KeyVersion {
+	keys := make([]KeyVersion, 0, len(d.PreviousKeys)+1)
+	keys = append(keys, d.PreviousKeys...)
+	return append(keys, d.CurrentKey())
+}
+
+// KeyFor returns the key that signed, or signs, transaction @counter, ok is false if no key of the device
+// did, e.g. for a key that was dropped as it never signed anything
+func (d *Device) KeyFor(counter int) (key KeyVersion, ok bool) {
+	for _, key := range d.Keys() {
+		if counter >= key.FirstCounter && (counter <= key.LastCounter || key.Version == d.KeyVersion) {
+			return key, true
+		}
+	}
+	return KeyVersion{}, false
+}
+
+// RotateKey makes the key pair @publicKey/@privateKey of @algorithm with @parameters the current key from the
+// next transaction on, the signature chain goes on undisturbed. The replaced key is kept as a previous key,
+// public key @previousPublicKey only, to verify the signatures it made, unless it made none.
+func (d *Device) RotateKey(envelope *crypto.Envelope, algorithm string, parameters crypto.Parameters, publicKey []byte, privateKey []byte, previousPublicKey []byte, at time.Time) error {
+	previous := d.CurrentKey()
+	if previous.LastCounter >= previous.FirstCounter {
+		previous.PublicKey, previous.RotatedAt = previousPublicKey, at
+		d.PreviousKeys = appendCopy(d.PreviousKeys, previous)
+	}
+
+	d.KeyVersion = previous.Version + 1
+	d.KeyFirstCounter = d.SignatureCounter
+	d.Algorithm, d.Parameters, d.PublicKey = algorithm, parameters, publicKey
+	return d.SealPrivateKey(envelope, privateKey)
+}
@@ -0,0 +1,88 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+// DeviceStatus tells what may be done with a device
+type DeviceStatus string
+
+const (
+	// The device signs and verifies
+	DeviceActive DeviceStatus = "active"
+	// The device does not sign until it is resumed, its signatures still verify
+	DeviceSuspended DeviceStatus = "suspended"
+	// The device never signs again, its signatures still verify, forever
+	DeviceRetired DeviceStatus = "retired"
+)
+
+// DeviceAction changes the status of a device
+type DeviceAction string
+
+const (
+	// Creates the device, active
+	ActionActivate DeviceAction = "activate"
+	// Gives an active device a new key pair, its signature chain goes on
+	ActionRotateKey DeviceAction = "rotate_key"
+	ActionSuspend   DeviceAction = "suspend"
+	ActionResume    DeviceAction = "resume"
+	// Decommissions an active or suspended device for good
+	ActionRetire DeviceAction = "retire"
+)
+
+// StatusChange records a status change of a device
+type StatusChange struct {
+	Action DeviceAction
+	// Status before the change, empty for ActionActivate
+	From DeviceStatus
+	To   DeviceStatus
+	// When the change happened
+	At time.Time
+	// Who did the change, empty if the caller was not authenticated
+	By string
+	// Optional reason given for the change
+	Reason string
+}
+
+// deviceTransitions maps every action to the statuses it may be done in and the status it leads to
+var deviceTransitions = map[DeviceAction]struct {
+	from []DeviceStatus
+	to   DeviceStatus
+	done string // for error messages
+}{
+	ActionActivate:  {from: []DeviceStatus{""}, to: DeviceActive, done: "activated"},
+	ActionRotateKey: {from: []DeviceStatus{DeviceActive}, to: DeviceActive, done: "given a new key"},
+	ActionSuspend:   {from: []DeviceStatus{DeviceActive}, to: DeviceSuspended, done: "suspended"},
+	ActionResume:    {from: []DeviceStatus{DeviceSuspended}, to: DeviceActive, done: "resumed"},
+	ActionRetire:    {from: []DeviceStatus{DeviceActive, DeviceSuspended}, to: DeviceRetired, done: "retired"},
+}
+
+// Transition does @action to the device at @at, recording who did it (@by) and why (@reason) in its
+// StatusHistory. Returns an error without changing anything if @action may not be done in its current status.
+func (d *Device) Transition(action DeviceAction, at time.Time, by string, reason string) error {
+	transition, ok := deviceTransitions[action]
+	if !ok {
+		return errors.New("Unknown device action " + string(action))
+	}
+
+	from := d.Status
+	allowed := false
+	for _, status := range transition.from {
+		allowed = allowed || status == from
+	}
+	if !allowed {
+		return errors.New("Device " + d.ID + " is " + string(from) + ", it cannot be " + transition.done)
+	}
+
+	d.Status = transition.to
+	d.StatusHistory = appendCopy(d.StatusHistory, StatusChange{
+		Action: action,
+		From:   from,
+		To:     transition.to,
+		At:     at,
+		By:     by,
+		Reason: reason,
+	})
+	return nil
+}
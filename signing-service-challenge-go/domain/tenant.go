@@ -0,0 +1,39 @@
+package domain
+
+import "time"
+
+// DefaultTenant is the tenant of devices created by callers not belonging to any tenant, including every
+// device created before tenants were introduced. It is implicit, it cannot be created, suspended or deleted.
+const DefaultTenant = ""
+
+// TenantStatus tells whether the devices of a tenant may be used
+type TenantStatus string
+
+const (
+	TenantActive TenantStatus = "active"
+	// Nothing may be done with the devices of a suspended tenant until it is resumed
+	TenantSuspended TenantStatus = "suspended"
+)
+
+// Tenant is an organization, e.g. a merchant, owning its own namespace of devices: device IDs only need to
+// be unique within their tenant
+type Tenant struct {
+	// Tenant ID, letters, digits, '-', '_' and '.' only
+	ID string
+	// Optional name, for UI display
+	Name   string
+	Status TenantStatus
+	// When the tenant was created
+	CreatedAt time.Time
+	// When the tenant was last modified
+	UpdatedAt time.Time
+}
+
+// DeviceKey returns the key the device with ID @id of tenant @tenantID is stored with. Devices of
+// DefaultTenant keep their bare ID, so storages written before tenants were introduced stay valid.
+func DeviceKey(tenantID string, id string) string {
+	if tenantID == DefaultTenant {
+		return id
+	}
+	return tenantID + "/" + id
+}
@@ -0,0 +1,21 @@
+package domain
+
+import (
+	"time"
+)
+
+// Transaction is a single signature made by a device
+type Transaction struct {
+	// ID of the device that made the signature
+	DeviceID string
+	// Signature counter of the device at the time of signing, the first transaction of a device is 0
+	Counter int
+	// Data as given by the client
+	Data string
+	// What was actually signed: "<counter>_<data>_<previous signature>", empty if not known
+	SignedData string
+	// Base64 encoded signature of SignedData
+	Signature string
+	// When the signature was made, zero if not known
+	CreatedAt time.Time
+}
@@ -1,20 +1,282 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/api/server"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/config"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
 )
 
-const (
-	ListenAddress = ":8080"
-	// TODO: add further configuration parameters here ...
-)
+// loadConfig loads the configuration of program @name from @args and the environment, see config.Load, then
+// checks what the config package cannot know about: the options of the storage backend and the algorithms
+func loadConfig(name string, args []string) (*config.Config, bool, error) {
+	cfg, printConfig, err := config.Load(name, args, os.Getenv)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := checkConfig(cfg); err != nil {
+		return nil, false, err
+	}
+	return cfg, printConfig, nil
+}
+
+// checkConfig checks the settings of @cfg that need the persistence and crypto packages, returning all problems
+// found at once
+func checkConfig(cfg *config.Config) error {
+	var errs []error
+	if cfg.Storage.Backend == "file" {
+		if _, err := fileDBOptions(cfg.Storage); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for name, params := range cfg.Algorithms {
+		algo := crypto.GetAlgorithm(name)
+		if algo == nil {
+			errs = append(errs, fmt.Errorf("Algorithm %s not available", name))
+			continue
+		}
+		if _, err := crypto.Configure(algo, params); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid default parameters for algorithm %s: %v", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fileDBOptions returns the options of the "file" backend configured in @cfg
+func fileDBOptions(cfg config.Storage) (persistence.FileDBOptions, error) {
+	syncMode, err := persistence.ParseSyncMode(cfg.SyncMode)
+	if err != nil {
+		return persistence.FileDBOptions{}, err
+	}
+	if syncMode == persistence.SyncInterval && cfg.SyncInterval <= 0 {
+		return persistence.FileDBOptions{}, errors.New("Storage sync interval must be positive with sync mode interval")
+	}
+	return persistence.FileDBOptions{
+		SyncMode:          syncMode,
+		SyncInterval:      time.Duration(cfg.SyncInterval),
+		SnapshotThreshold: cfg.SnapshotThreshold,
+	}, nil
+}
+
+// newStorage builds the Storage selected by @cfg
+func newStorage(cfg config.Storage) (persistence.Storage, error) {
+	switch cfg.Backend {
+	case "file":
+		options, err := fileDBOptions(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return persistence.NewFileDB(cfg.Path, options)
+	case "sqlite":
+		if err := os.MkdirAll(cfg.Path, 0o700); err != nil {
+			return nil, err
+		}
+		return persistence.NewSQLiteDB(cfg.SQLiteFile())
+	default:
+		return persistence.NewInMemoryDB(), nil
+	}
+}
+
+// openStores opens the API key and tenant stores configured in @cfg and makes them the instances the service
+// works on
+func openStores(cfg *config.Config) error {
+	if cfg.APIKeys.File != "" {
+		keyStore, err := persistence.NewFileKeyStore(cfg.APIKeys.File)
+		if err != nil {
+			return errors.New("Could not open API key store: " + err.Error())
+		}
+		persistence.SetKeyStore(keyStore)
+	}
+	if cfg.Tenants.File != "" {
+		tenantStore, err := persistence.NewFileTenantStore(cfg.Tenants.File)
+		if err != nil {
+			return errors.New("Could not open tenant store: " + err.Error())
+		}
+		persistence.SetTenantStore(tenantStore)
+	}
+	return nil
+}
+
+// storageOpener returns the service.StorageOpener opening storages with the options of @cfg
+func storageOpener(cfg config.Storage) service.StorageOpener {
+	return func(backend string, path string) (persistence.Storage, error) {
+		cfg.Backend, cfg.Path = backend, path
+		return newStorage(cfg)
+	}
+}
+
+// loadEnvelope builds the Envelope from the master keys configured in @cfg, nil if no master key is configured.
+// A configured master key file that is missing is an error, but for config.DefaultMasterKeyFile.
+func loadEnvelope(cfg config.MasterKey) (*crypto.Envelope, error) {
+	var current *crypto.MasterKey
+	var err error
+	if cfg.Key != "" {
+		current, err = crypto.ParseMasterKey(string(cfg.Key))
+	} else if cfg.File != "" {
+		_, statErr := os.Stat(cfg.File)
+		if statErr == nil {
+			current, err = crypto.LoadMasterKeyFile(cfg.File)
+		} else if !errors.Is(statErr, os.ErrNotExist) || cfg.File != config.DefaultMasterKeyFile {
+			err = fmt.Errorf("Master key file %s is unusable: %w", cfg.File, statErr)
+		}
+	}
+	if err != nil || current == nil {
+		return nil, err
+	}
+
+	var previous []*crypto.MasterKey
+	for _, encoded := range cfg.PreviousKeys {
+		key, err := crypto.ParseMasterKey(string(encoded))
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+	return crypto.NewEnvelope(current, previous...), nil
+}
+
+// sealedDevice returns the key of a device of @storage whose private key is encrypted, empty if there is none
+func sealedDevice(storage persistence.Storage) string {
+	for _, device := range storage.List() {
+		if device.MasterKeyID != "" {
+			return device.Key()
+		}
+	}
+	return ""
+}
 
 func main() {
-	s := server.NewServer(ListenAddress)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "generate-master-key":
+			key, err := crypto.GenerateMasterKey()
+			if err != nil {
+				log.Fatal("Could not generate master key: ", err)
+			}
+			fmt.Println(key)
+			return
+		case "generate-api-key":
+			// the admin key is configured by its hash only, so it never has to be stored in plain
+			_, key, err := auth.GenerateAPIKey()
+			if err != nil {
+				log.Fatal("Could not generate API key: ", err)
+			}
+			fmt.Println("API key:", key)
+			fmt.Println("SHA-256:", auth.HashAPIKey(key))
+			return
+		case "backup":
+			if err := runBackup(os.Args[0]+" backup", os.Args[2:]); err != nil {
+				log.Fatal("Could not back up: ", err)
+			}
+			return
+		case "restore":
+			if err := runRestore(os.Args[0]+" restore", os.Args[2:]); err != nil {
+				log.Fatal("Could not restore: ", err)
+			}
+			return
+		case "migrate":
+			if err := runMigrate(os.Args[0]+" migrate", os.Args[2:]); err != nil {
+				log.Fatal("Could not migrate: ", err)
+			}
+			return
+		}
+	}
+
+	cfg, printConfig, err := loadConfig(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	if printConfig {
+		if err := config.Print(os.Stdout, cfg); err != nil {
+			log.Fatal("Could not print configuration: ", err)
+		}
+		return
+	}
+
+	level, _ := cfg.SlogLevel() // validated by loadConfig
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	crypto.SetDefaultParameters(cfg.Algorithms)
+	service.SetIdempotencyWindow(time.Duration(cfg.IdempotencyWindow))
+
+	storage, err := newStorage(cfg.Storage)
+	if err != nil {
+		log.Fatal("Could not open storage: ", err)
+	}
+	persistence.SetInstance(storage)
+	service.SetStorageOpener(storageOpener(cfg.Storage))
 
-	if err := s.Run(); err != nil {
-		log.Fatal("Could not start server on ", ListenAddress)
+	if err := openStores(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	envelope, err := loadEnvelope(cfg.MasterKey)
+	if err != nil {
+		log.Fatal("Could not load master key: ", err)
+	}
+	if envelope == nil {
+		if key := sealedDevice(storage); key != "" {
+			log.Fatal("Could not load master key: none is configured, but the private key of device ", key, " is encrypted")
+		}
+		slog.Warn("No master key configured, private keys are stored unencrypted", "master_key_file", cfg.MasterKey.File)
+	} else {
+		crypto.SetEnvelope(envelope)
+		// encrypts devices stored in plain and completes a master key rotation
+		rewrapped, err := persistence.RewrapPrivateKeys(storage, envelope)
+		if err != nil {
+			log.Fatal("Could not rewrap private keys: ", err)
+		}
+		if rewrapped > 0 {
+			slog.Info("Rewrapped private keys", "devices", rewrapped, "master_key", envelope.CurrentKeyID())
+		}
+	}
+
+	s := server.NewServer(cfg)
+
+	stopped, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	served := make(chan error, 1)
+	go func() {
+		slog.Info("Listening", "address", cfg.ListenAddress, "tls", cfg.TLSEnabled())
+		served <- s.Run()
+	}()
+
+	select {
+	case err := <-served:
+		s.Shutdown(context.Background()) // flushes the storage
+		log.Fatal("Could not start server on ", cfg.ListenAddress, ": ", err)
+	case <-stopped.Done():
+		stop() // a second signal kills right away
+	}
+
+	slog.Info("Shutting down, waiting for requests in flight", "timeout", time.Duration(cfg.Timeouts.Shutdown))
+	ctx := context.Background()
+	if cfg.Timeouts.Shutdown > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.Timeouts.Shutdown))
+		defer cancel()
+	}
+	if err := s.Shutdown(ctx); err != nil {
+		log.Fatal("Could not shut down cleanly: ", err)
+	}
+	if err := <-served; err != nil {
+		log.Fatal("Server stopped with error: ", err)
 	}
+	slog.Info("Shut down")
 }
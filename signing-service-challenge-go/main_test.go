@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+func TestLoadConfig(t *testing.T) {
+	Convey("Given configurations the config package accepts", t, func() {
+		dir := t.TempDir()
+		writeConfig := func(name string, content string) string {
+			path := filepath.Join(dir, name)
+			So(os.WriteFile(path, []byte(content), 0o600), ShouldBeNil)
+			return path
+		}
+
+		Convey("the options of the file backend are built from them", func() {
+			cfg, _, err := loadConfig("test", []string{"-storage-backend", "file", "-storage-sync-mode", "interval", "-storage-sync-interval", "250ms"})
+			So(err, ShouldBeNil)
+
+			options, err := fileDBOptions(cfg.Storage)
+			So(err, ShouldBeNil)
+			So(options.SyncMode, ShouldEqual, persistence.SyncInterval)
+			So(options.SyncInterval, ShouldEqual, 250*time.Millisecond)
+			So(options.SnapshotThreshold, ShouldEqual, persistence.DefaultFileDBOptions().SnapshotThreshold)
+		})
+
+		Convey("default parameters of available algorithms are accepted", func() {
+			_, _, err := loadConfig("test", []string{"-config", writeConfig("algorithms.yaml", "algorithms:\n  ecc:\n    curve: P-256\n")})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("unknown sync modes, algorithms and unsupported parameters are rejected", func() {
+			for _, args := range [][]string{
+				{"-storage-backend", "file", "-storage-sync-mode", "sometimes"},
+				{"-storage-backend", "file", "-storage-sync-mode", "interval", "-storage-sync-interval", "0s"},
+				{"-config", writeConfig("algorithm.yaml", "algorithms:\n  dsa: {}\n")},
+				{"-config", writeConfig("parameters.yaml", "algorithms:\n  rsa:\n    key_size: 1024\n")},
+			} {
+				_, _, err := loadConfig("test", args)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
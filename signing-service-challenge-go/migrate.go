@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+)
+
+// errMigrationMismatches is returned by runMigrate when some devices were not copied or verified
+var errMigrationMismatches = errors.New("Some devices were not migrated")
+
+// runMigrate copies every tenant, device and API key of the configured storage into the storage of the
+// backend and path given first in @args, see service.MigrateTo, the other arguments configure the source
+// storage as for the server. Running it again after an interruption continues where it stopped. The source
+// cannot be the memory storage of a running server, see openCommandStorage.
+func runMigrate(name string, args []string) error {
+	usage := errors.New("Usage: " + name + " <file or sqlite> <destination path> [flags as for the server]")
+	if len(args) < 2 || strings.HasPrefix(args[0], "-") || strings.HasPrefix(args[1], "-") || args[1] == "" {
+		return usage
+	}
+	cfg, _, err := loadConfig(name, args[2:])
+	if err != nil {
+		return err
+	}
+	to := cfg.Storage
+	to.Backend, to.Path = args[0], args[1]
+	if to.Backend != "file" && to.Backend != "sqlite" {
+		return usage
+	}
+	if to.Backend == cfg.Storage.Backend && filepath.Clean(to.Path) == filepath.Clean(cfg.Storage.Path) {
+		return errors.New("The destination is the configured storage itself")
+	}
+
+	source, err := openCommandStorage(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStorage(source)
+	service.SetStorageOpener(storageOpener(cfg.Storage))
+
+	// copied devices are checked by signing, which needs their private key
+	envelope, err := loadEnvelope(cfg.MasterKey)
+	if err != nil {
+		return err
+	}
+	if envelope != nil {
+		crypto.SetEnvelope(envelope)
+	}
+
+	input := service.MigrateToInput{Backend: to.Backend, Path: to.Path}
+	report, err := service.MigrateTo(operatorContext(), input, func(report *persistence.MigrationReport) {
+		if report.Devices%1000 == 0 {
+			fmt.Fprintf(os.Stderr, "%d devices done\n", report.Devices)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Migrated %d devices: %d copied (%d resumed), %d already copied, %d transactions copied, %d mismatches\n",
+		report.Devices, report.Copied, report.Resumed, report.Skipped, report.Transactions, len(report.Mismatches))
+	fmt.Fprintf(os.Stderr, "Copied %d tenants into %s and %d API keys into %s\n",
+		report.Tenants, filepath.Join(to.Path, service.MigratedTenantsFile),
+		report.APIKeys, filepath.Join(to.Path, service.MigratedAPIKeysFile))
+	for _, mismatch := range report.Mismatches {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", mismatch.Key, mismatch.Reason)
+	}
+	if len(report.Mismatches) > 0 {
+		return errMigrationMismatches
+	}
+	return nil
+}
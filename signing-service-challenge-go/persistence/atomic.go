@@ -1,32 +1,40 @@
 package persistence
 
 import (
+	"context"
+	"time"
+
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
-	"sync"
 )
 
 // AtomicStorage wraps Storage with per id locking mechanism which allows concurrent access over
 // different IDs, but will mutex concurrent access over the same ID
 type AtomicStorage struct {
 	base  Storage
-	locks sync.Map // map[id]*sync.Mutex
+	locks *LockManager
 }
 
 // Locks id @id so there's only one thread capable of accessing until Unlock with the same id is called
 func (s *AtomicStorage) Lock(id string) {
-	mu, _ := s.locks.LoadOrStore(id, &sync.Mutex{})
-	mu.(*sync.Mutex).Lock()
+	s.locks.Lock(id)
+}
+
+// LockContext is Lock that gives up when @ctx is done, returning ctx.Err() without holding the lock
+func (s *AtomicStorage) LockContext(ctx context.Context, id string) error {
+	return s.locks.LockContext(ctx, id)
 }
 
 // Unlocks id @id, after the call concurrent access to the id will be allowed again
 func (s *AtomicStorage) Unlock(id string) {
-	if mu, ok := s.locks.Load(id); ok {
-		mu.(*sync.Mutex).Unlock()
-	}
+	s.locks.Unlock(id)
 }
 
 // Fulfill Storage interface so it can be used as a Storage, too
 
+func (s *AtomicStorage) CompareAndSaveWithTransaction(id string, data *domain.Device, expectedVersion int, transaction *domain.Transaction, idempotency *domain.IdempotencyRecord) error {
+	return s.base.CompareAndSaveWithTransaction(id, data, expectedVersion, transaction, idempotency)
+}
+
 func (s *AtomicStorage) Load(id string) (*domain.Device, error) {
 	return s.base.Load(id)
 }
@@ -35,11 +43,45 @@ func (s *AtomicStorage) Save(id string, data *domain.Device) error {
 	return s.base.Save(id, data)
 }
 
+func (s *AtomicStorage) CompareAndSave(id string, data *domain.Device, expectedVersion int) error {
+	return s.base.CompareAndSave(id, data, expectedVersion)
+}
+
 func (s *AtomicStorage) List() []*domain.Device {
 	return s.base.List()
 }
 
-// NewAtomicStorage wraps any Storage with per-ID concurrency protection
+func (s *AtomicStorage) ListDevices(query domain.DeviceQuery) ([]*domain.Device, error) {
+	return s.base.ListDevices(query)
+}
+
+func (s *AtomicStorage) Delete(id string) error {
+	return s.base.Delete(id)
+}
+
+func (s *AtomicStorage) LoadTransaction(id string, counter int) (*domain.Transaction, error) {
+	return s.base.LoadTransaction(id, counter)
+}
+
+func (s *AtomicStorage) ListTransactions(id string, from int, limit int) ([]*domain.Transaction, error) {
+	return s.base.ListTransactions(id, from, limit)
+}
+
+func (s *AtomicStorage) LoadIdempotencyRecord(id string, key string, now time.Time) (*domain.IdempotencyRecord, error) {
+	return s.base.LoadIdempotencyRecord(id, key, now)
+}
+
+func (s *AtomicStorage) ListIdempotencyRecords(id string, now time.Time) ([]*domain.IdempotencyRecord, error) {
+	return s.base.ListIdempotencyRecords(id, now)
+}
+
+// NewAtomicStorage wraps any Storage with per-ID concurrency protection, using the process-wide
+// LockManager so that every AtomicStorage in the process excludes each other
 func NewAtomicStorage(base Storage) *AtomicStorage {
-	return &AtomicStorage{base: base}
+	return NewAtomicStorageWithLocks(base, GetLockManager())
+}
+
+// NewAtomicStorageWithLocks wraps any Storage with per-ID concurrency protection provided by @locks
+func NewAtomicStorageWithLocks(base Storage, locks *LockManager) *AtomicStorage {
+	return &AtomicStorage{base: base, locks: locks}
 }
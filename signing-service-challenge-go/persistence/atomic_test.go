@@ -94,9 +94,28 @@ func TestAtomicStorageDelegation(t *testing.T) {
 			storage.Save("id2", nil)
 		})
 
+		Convey("CompareAndSave delegates to base storage", func() {
+			mockDB.EXPECT().CompareAndSave("id3", gomock.Any(), 7).Return(nil).Times(1)
+			storage.CompareAndSave("id3", nil, 7)
+		})
+
 		Convey("List delegates to base storage", func() {
 			mockDB.EXPECT().List().Return(nil).Times(1)
 			storage.List()
 		})
+
+		Convey("Delete delegates to base storage", func() {
+			mockDB.EXPECT().Delete("id4").Return(nil).Times(1)
+			storage.Delete("id4")
+		})
+
+		Convey("transaction methods delegate to base storage", func() {
+			mockDB.EXPECT().CompareAndSaveWithTransaction("id5", gomock.Any(), 2, gomock.Any(), gomock.Any()).Return(nil).Times(1)
+			mockDB.EXPECT().LoadTransaction("id5", 1).Return(nil, nil).Times(1)
+			mockDB.EXPECT().ListTransactions("id5", 0, 10).Return(nil, nil).Times(1)
+			storage.CompareAndSaveWithTransaction("id5", nil, 2, nil, nil)
+			storage.LoadTransaction("id5", 1)
+			storage.ListTransactions("id5", 0, 10)
+		})
 	})
 }
@@ -0,0 +1,472 @@
+package persistence
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/chain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+)
+
+const (
+	// BackupFormat identifies backup archives
+	BackupFormat = "signing-service-backup"
+	// BackupVersion is the version of the archive layout written, older ones are read as well. Version 2 added
+	// the tenants and API keys files.
+	BackupVersion = 2
+	// MaxBackupSize is the largest size of the files of a backup archive altogether ReadBackup reads, as it
+	// holds them in memory
+	MaxBackupSize = 1 << 30
+
+	// BackupContents is what every manifest tells about the archive
+	BackupContents = "Signature devices with their private keys and transactions, tenants and API key hashes. Keep " +
+		"this archive as secret as the keys: private keys not encrypted with a master key are in plain."
+
+	backupManifestFile = "manifest.json"
+	backupDevicesFile  = "devices.jsonl"
+	backupTenantsFile  = "tenants.json"
+	backupAPIKeysFile  = "api_keys.json"
+)
+
+// BackupManifest describes a backup archive, it is the first file of the archive
+type BackupManifest struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// Number of devices and of transactions of all of them
+	Devices      int `json:"devices"`
+	Transactions int `json:"transactions"`
+	// Hex encoded SHA-256 of the devices file
+	Checksum string `json:"checksum"`
+	// BackupContents, empty in archives written before it was
+	Contents string `json:"contents,omitempty"`
+	// Number of devices whose private key is not encrypted with a master key
+	PlainPrivateKeys int `json:"plain_private_keys"`
+	// Number of tenants and API keys, and hex encoded SHA-256 of their files, empty before version 2
+	Tenants         int    `json:"tenants"`
+	TenantsChecksum string `json:"tenants_checksum,omitempty"`
+	APIKeys         int    `json:"api_keys"`
+	APIKeysChecksum string `json:"api_keys_checksum,omitempty"`
+}
+
+// BackupDevice is a device in a backup together with its transactions, one per line of the devices file
+type BackupDevice struct {
+	// Storage key, see domain.DeviceKey
+	Key          string                `json:"key"`
+	Device       *domain.Device        `json:"device"`
+	Transactions []*domain.Transaction `json:"transactions,omitempty"`
+	// Idempotency records of the transactions not expired when the backup was taken
+	IdempotencyKeys []*domain.IdempotencyRecord `json:"idempotency_keys,omitempty"`
+}
+
+// Backup is a snapshot of every device of a Storage together with the tenants and API keys, written as a
+// gzipped tar archive of a manifest, a devices file, a tenants file and an API keys file
+type Backup struct {
+	Manifest BackupManifest
+	// Ordered by storage key
+	Devices []*BackupDevice
+	// Ordered by ID
+	Tenants []*domain.Tenant
+	APIKeys []*domain.APIKey
+
+	// content of the devices, tenants and API keys files, which the checksums of Manifest are of
+	devices []byte
+	tenants []byte
+	apiKeys []byte
+}
+
+// CreateBackup takes a snapshot of every device of @db and its transactions, of every tenant of @tenants and of
+// every API key of @keys. Each device is read under its lock, so it is consistent with its transactions even
+// while devices keep signing. The snapshot holds the private keys of the devices as stored, so in plain for
+// devices not encrypted with a master key, and API keys by their hash only.
+func CreateBackup(db Storage, tenants TenantStore, keys KeyStore, now time.Time) (*Backup, error) {
+	as := NewAtomicStorage(db)
+	backup := &Backup{
+		Manifest: BackupManifest{Format: BackupFormat, Version: BackupVersion, CreatedAt: now, Contents: BackupContents},
+		Devices:  []*BackupDevice{},
+		Tenants:  tenants.ListTenants(),
+		APIKeys:  keys.ListKeys(),
+	}
+
+	for _, listed := range db.List() {
+		entry, err := backupDevice(as, listed.Key(), now)
+		if err != nil {
+			return nil, errors.New("Could not back up device " + listed.Key() + ": " + err.Error())
+		}
+		if entry == nil {
+			continue // deleted meanwhile
+		}
+		backup.Devices = append(backup.Devices, entry)
+		backup.Manifest.Transactions += len(entry.Transactions)
+		if entry.Device.MasterKeyID == "" && len(entry.Device.PrivateKey) > 0 {
+			backup.Manifest.PlainPrivateKeys++
+		}
+	}
+	backup.Manifest.Devices = len(backup.Devices)
+
+	var devices bytes.Buffer
+	encoder := json.NewEncoder(&devices)
+	for _, entry := range backup.Devices {
+		if err := encoder.Encode(entry); err != nil {
+			return nil, err
+		}
+	}
+	backup.devices = devices.Bytes()
+	backup.Manifest.Checksum = checksum(backup.devices)
+
+	var err error
+	if backup.tenants, err = json.MarshalIndent(backup.Tenants, "", "  "); err != nil {
+		return nil, err
+	}
+	if backup.apiKeys, err = json.MarshalIndent(backup.APIKeys, "", "  "); err != nil {
+		return nil, err
+	}
+	backup.Manifest.Tenants = len(backup.Tenants)
+	backup.Manifest.TenantsChecksum = checksum(backup.tenants)
+	backup.Manifest.APIKeys = len(backup.APIKeys)
+	backup.Manifest.APIKeysChecksum = checksum(backup.apiKeys)
+	return backup, nil
+}
+
+// backupDevice reads device @key, its transactions and its idempotency records not expired before @now under
+// its lock, nil if it does not exist anymore
+func backupDevice(as *AtomicStorage, key string, now time.Time) (*BackupDevice, error) {
+	as.Lock(key)
+	defer as.Unlock(key)
+
+	device, err := as.Load(key)
+	if err != nil {
+		return nil, nil
+	}
+	transactions, err := as.ListTransactions(key, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	records, err := as.ListIdempotencyRecords(key, now)
+	if err != nil {
+		return nil, err
+	}
+	entry := &BackupDevice{Key: key, Device: device}
+	if len(transactions) > 0 {
+		entry.Transactions = transactions
+	}
+	if len(records) > 0 {
+		entry.IdempotencyKeys = records
+	}
+	return entry, nil
+}
+
+// Write writes the archive of the backup to @w
+func (b *Backup) Write(w io.Writer) error {
+	manifest, err := json.MarshalIndent(&b.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	for _, file := range []struct {
+		name    string
+		content []byte
+	}{
+		{backupManifestFile, manifest},
+		{backupDevicesFile, b.devices},
+		{backupTenantsFile, b.tenants},
+		{backupAPIKeysFile, b.apiKeys},
+	} {
+		if file.content == nil && file.name != backupDevicesFile {
+			continue // read from an archive before version 2
+		}
+		header := &tar.Header{
+			Name:    file.name,
+			Mode:    0o600,
+			Size:    int64(len(file.content)),
+			ModTime: b.Manifest.CreatedAt,
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := archive.Write(file.content); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ReadBackup reads a backup archive from @r, checking its format, version and checksums, that every device is
+// stored under its own key with contiguous transactions ending at its signature counter, and that no tenant or
+// API key is there twice. Signatures are not verified. Archives whose files are larger than MaxBackupSize
+// altogether are refused.
+func ReadBackup(r io.Reader) (*Backup, error) {
+	gz, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, errors.New("Backup is not a gzipped archive: " + err.Error())
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	var size int64
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("Backup archive is damaged: " + err.Error())
+		}
+		switch header.Name {
+		case backupManifestFile, backupDevicesFile, backupTenantsFile, backupAPIKeysFile:
+		default:
+			return nil, errors.New("Backup archive has unexpected file " + header.Name)
+		}
+		// the tar reader never reads past the size in the header
+		if size += header.Size; header.Size < 0 || size > MaxBackupSize {
+			return nil, fmt.Errorf("Backup archive is larger than %d bytes", MaxBackupSize)
+		}
+		content, err := io.ReadAll(archive)
+		if err != nil {
+			return nil, errors.New("Backup archive is damaged: " + err.Error())
+		}
+		files[header.Name] = content
+	}
+
+	manifest, ok := files[backupManifestFile]
+	if !ok {
+		return nil, errors.New("Backup archive has no " + backupManifestFile)
+	}
+	backup := &Backup{Devices: []*BackupDevice{}, devices: files[backupDevicesFile]}
+	if err := json.Unmarshal(manifest, &backup.Manifest); err != nil {
+		return nil, errors.New("Backup manifest is invalid: " + err.Error())
+	}
+	if backup.Manifest.Format != BackupFormat {
+		return nil, errors.New("Not a backup archive, format is " + backup.Manifest.Format)
+	}
+	if backup.Manifest.Version < 1 || backup.Manifest.Version > BackupVersion {
+		return nil, fmt.Errorf("Backup version %d is not supported, the latest is %d", backup.Manifest.Version, BackupVersion)
+	}
+	if checksum(backup.devices) != backup.Manifest.Checksum {
+		return nil, errors.New("Backup checksum does not match, the archive is corrupted")
+	}
+	if err := readBackupTenantsAndKeys(backup, files); err != nil {
+		return nil, err
+	}
+
+	transactions := 0
+	keys := make(map[string]bool)
+	decoder := json.NewDecoder(bytes.NewReader(backup.devices))
+	for decoder.More() {
+		var entry BackupDevice
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, errors.New("Backup devices are invalid: " + err.Error())
+		}
+		if err := checkBackupDevice(&entry); err != nil {
+			return nil, err
+		}
+		if keys[entry.Key] {
+			return nil, errors.New("Backup has device " + entry.Key + " more than once")
+		}
+		keys[entry.Key] = true
+		backup.Devices = append(backup.Devices, &entry)
+		transactions += len(entry.Transactions)
+	}
+	if len(backup.Devices) != backup.Manifest.Devices || transactions != backup.Manifest.Transactions {
+		return nil, fmt.Errorf("Backup has %d devices and %d transactions, its manifest tells %d and %d",
+			len(backup.Devices), transactions, backup.Manifest.Devices, backup.Manifest.Transactions)
+	}
+	return backup, nil
+}
+
+// readBackupTenantsAndKeys reads the tenants and API keys files of @files into @backup, checking their
+// checksums and that no ID is there twice. Archives before version 2 have neither.
+func readBackupTenantsAndKeys(backup *Backup, files map[string][]byte) error {
+	backup.Tenants = []*domain.Tenant{}
+	backup.APIKeys = []*domain.APIKey{}
+	tenants, hasTenants := files[backupTenantsFile]
+	apiKeys, hasAPIKeys := files[backupAPIKeysFile]
+	if backup.Manifest.Version < 2 {
+		if hasTenants || hasAPIKeys {
+			return fmt.Errorf("Backup archive of version %d has tenants or API keys", backup.Manifest.Version)
+		}
+		return nil
+	}
+
+	if !hasTenants || !hasAPIKeys {
+		return errors.New("Backup archive has no " + backupTenantsFile + " or no " + backupAPIKeysFile)
+	}
+	if checksum(tenants) != backup.Manifest.TenantsChecksum || checksum(apiKeys) != backup.Manifest.APIKeysChecksum {
+		return errors.New("Backup checksum does not match, the archive is corrupted")
+	}
+	if err := json.Unmarshal(tenants, &backup.Tenants); err != nil {
+		return errors.New("Backup tenants are invalid: " + err.Error())
+	}
+	if err := json.Unmarshal(apiKeys, &backup.APIKeys); err != nil {
+		return errors.New("Backup API keys are invalid: " + err.Error())
+	}
+	backup.tenants, backup.apiKeys = tenants, apiKeys
+
+	tenantIDs := make(map[string]bool)
+	for _, tenant := range backup.Tenants {
+		if tenant == nil || tenant.ID == "" || tenantIDs[tenant.ID] {
+			return errors.New("Backup has a tenant without ID or more than once")
+		}
+		tenantIDs[tenant.ID] = true
+	}
+	keyIDs := make(map[string]bool)
+	for _, key := range backup.APIKeys {
+		if key == nil || key.ID == "" || keyIDs[key.ID] {
+			return errors.New("Backup has an API key without ID or more than once")
+		}
+		keyIDs[key.ID] = true
+	}
+	if len(backup.Tenants) != backup.Manifest.Tenants || len(backup.APIKeys) != backup.Manifest.APIKeys {
+		return fmt.Errorf("Backup has %d tenants and %d API keys, its manifest tells %d and %d",
+			len(backup.Tenants), len(backup.APIKeys), backup.Manifest.Tenants, backup.Manifest.APIKeys)
+	}
+	return nil
+}
+
+// checkBackupDevice checks @entry is stored under the key of its device, its transactions are the ones of the
+// device, contiguous and ending right before its signature counter, and its idempotency records are of them
+func checkBackupDevice(entry *BackupDevice) error {
+	if entry.Device == nil || entry.Key != entry.Device.Key() {
+		return errors.New("Backup device " + entry.Key + " is not stored under its own key")
+	}
+	device := entry.Device
+	if len(entry.Transactions) == 0 {
+		if len(entry.IdempotencyKeys) > 0 {
+			return errors.New("Backup device " + entry.Key + " has idempotency records but no transactions")
+		}
+		return nil
+	}
+
+	first := entry.Transactions[0].Counter
+	for i, transaction := range entry.Transactions {
+		if transaction.DeviceID != device.ID || transaction.Counter != first+i {
+			return fmt.Errorf("Backup transactions of device %s are not contiguous at counter %d", entry.Key, first+i)
+		}
+	}
+	if first < 0 || first+len(entry.Transactions) != device.SignatureCounter {
+		return fmt.Errorf("Backup transactions of device %s do not end at its signature counter %d", entry.Key, device.SignatureCounter)
+	}
+	for _, record := range entry.IdempotencyKeys {
+		if record.Counter < first || record.Counter >= device.SignatureCounter {
+			return fmt.Errorf("Backup idempotency key %s of device %s is of no transaction of the backup", record.Key, entry.Key)
+		}
+	}
+	return nil
+}
+
+// RestoreBackup saves every tenant of @backup into @tenants, every device with its transactions into @db and
+// every API key into @keys. @db must have no device, and neither @tenants nor @keys one with the ID of one of
+// the backup. Either everything is restored, or on error whatever was restored so far is deleted again.
+func RestoreBackup(db Storage, tenants TenantStore, keys KeyStore, backup *Backup) (err error) {
+	if len(db.List()) > 0 {
+		return ErrStorageNotEmpty
+	}
+	for _, tenant := range backup.Tenants {
+		if _, err := tenants.LoadTenant(tenant.ID); err == nil {
+			return fmt.Errorf("%w, tenant %s exists", ErrStorageNotEmpty, tenant.ID)
+		}
+	}
+	for _, key := range backup.APIKeys {
+		if _, err := keys.LoadKey(key.ID); err == nil {
+			return fmt.Errorf("%w, API key %s exists", ErrStorageNotEmpty, key.ID)
+		}
+	}
+
+	var restoredTenants, restoredDevices, restoredKeys []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		// API keys go first and tenants last, so nothing is usable while devices are deleted
+		var errs []error
+		for _, id := range restoredKeys {
+			errs = append(errs, keys.DeleteKey(id))
+		}
+		for _, key := range restoredDevices {
+			if deleteErr := db.Delete(key); deleteErr != nil {
+				if _, loadErr := db.Load(key); loadErr == nil {
+					errs = append(errs, deleteErr)
+				}
+			}
+		}
+		for _, id := range restoredTenants {
+			errs = append(errs, tenants.DeleteTenant(id))
+		}
+		if rollbackErr := errors.Join(errs...); rollbackErr != nil {
+			err = errors.Join(err, errors.New("Could not delete what was restored: "+rollbackErr.Error()))
+		}
+	}()
+
+	for _, tenant := range backup.Tenants {
+		if err := tenants.SaveTenant(tenant); err != nil {
+			return errors.New("Could not restore tenant " + tenant.ID + ": " + err.Error())
+		}
+		restoredTenants = append(restoredTenants, tenant.ID)
+	}
+	for _, entry := range backup.Devices {
+		// a device that failed midway exists already, so it is deleted as well
+		restoredDevices = append(restoredDevices, entry.Key)
+		if err := restoreDevice(db, entry); err != nil {
+			return errors.New("Could not restore device " + entry.Key + ": " + err.Error())
+		}
+	}
+	for _, key := range backup.APIKeys {
+		if err := keys.SaveKey(key); err != nil {
+			return errors.New("Could not restore API key " + key.ID + ": " + err.Error())
+		}
+		restoredKeys = append(restoredKeys, key.ID)
+	}
+	return nil
+}
+
+// restoreDevice saves @entry into @db, where its device must not exist yet
+func restoreDevice(db Storage, entry *BackupDevice) error {
+	// a Storage only appends a transaction together with the device it brought to its counter, so the device
+	// is saved as it was before its first transaction, then once per transaction
+	step := *entry.Device
+	step.Version = 0
+	if len(entry.Transactions) > 0 {
+		first := entry.Transactions[0]
+		step.SignatureCounter = first.Counter
+		if previous, ok := chain.PreviousSignatureOf(first); ok {
+			step.LastSignature = previous
+		}
+	}
+	if err := db.CompareAndSave(entry.Key, &step, 0); err != nil {
+		return err
+	}
+
+	idempotency := make(map[int]*domain.IdempotencyRecord, len(entry.IdempotencyKeys))
+	for _, record := range entry.IdempotencyKeys {
+		idempotency[record.Counter] = record
+	}
+	for _, transaction := range entry.Transactions {
+		if err := replayTransaction(db, entry.Key, &step, transaction, idempotency[transaction.Counter]); err != nil {
+			return err
+		}
+	}
+
+	device := *entry.Device
+	return db.CompareAndSave(entry.Key, &device, step.Version)
+}
+
+// checksum returns the hex encoded SHA-256 of @content
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,256 @@
+package persistence
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// saveSigned saves device @id of tenant @tenantID with @signatures transactions into @db
+func saveSigned(db Storage, tenantID string, id string, signatures int) {
+	device := &domain.Device{ID: id, TenantID: tenantID, Algorithm: "ecc", PublicKey: []byte("public key"), LastSignature: "seed"}
+	So(db.CompareAndSave(device.Key(), device, 0), ShouldBeNil)
+	for i := 0; i < signatures; i++ {
+		transaction := &domain.Transaction{
+			DeviceID:   id,
+			Counter:    i,
+			Data:       "data",
+			SignedData: fmt.Sprintf("%d_data_%s", i, device.LastSignature),
+			Signature:  fmt.Sprintf("signature %d", i),
+			CreatedAt:  time.Date(2024, 5, 17, 10, 0, i, 0, time.UTC),
+		}
+		device.SignatureCounter++
+		device.LastSignature = transaction.Signature
+		So(db.CompareAndSaveWithTransaction(device.Key(), device, device.Version, transaction, nil), ShouldBeNil)
+	}
+}
+
+// failingStorage fails to save the transactions of device failKey
+type failingStorage struct {
+	Storage
+	failKey string
+}
+
+func (s *failingStorage) CompareAndSaveWithTransaction(id string, data *domain.Device, expectedVersion int, transaction *domain.Transaction, idempotency *domain.IdempotencyRecord) error {
+	if id == s.failKey {
+		return errors.New("disk full")
+	}
+	return s.Storage.CompareAndSaveWithTransaction(id, data, expectedVersion, transaction, idempotency)
+}
+
+func TestBackup(t *testing.T) {
+	Convey("Given a Storage with devices of two tenants", t, func() {
+		db := NewInMemoryDB()
+		saveSigned(db, "", "a", 3)
+		saveSigned(db, "shop", "a", 0)
+		saveSigned(db, "", "b", 1)
+		tenants := NewInMemoryTenantStore()
+		So(tenants.SaveTenant(&domain.Tenant{ID: "shop", Name: "Shop"}), ShouldBeNil)
+		keys := NewInMemoryKeyStore()
+		So(keys.SaveKey(&domain.APIKey{ID: "key-1", Name: "till", TenantID: "shop", DeviceIDs: []string{"a"}}), ShouldBeNil)
+		now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+		backup, err := CreateBackup(db, tenants, keys, now)
+		So(err, ShouldBeNil)
+
+		Convey("its manifest counts the private keys in plain", func() {
+			So(db.Save("plain", &domain.Device{ID: "plain", PrivateKey: []byte("private key")}), ShouldBeNil)
+			So(db.Save("sealed", &domain.Device{ID: "sealed", PrivateKey: []byte("ciphertext"), MasterKeyID: "master-1"}), ShouldBeNil)
+
+			backup, err := CreateBackup(db, tenants, keys, now)
+			So(err, ShouldBeNil)
+			So(backup.Manifest.PlainPrivateKeys, ShouldEqual, 1)
+		})
+
+		Convey("its backup has every device with its transactions, ordered by key", func() {
+			So(backup.Manifest.Format, ShouldEqual, BackupFormat)
+			So(backup.Manifest.Version, ShouldEqual, BackupVersion)
+			So(backup.Manifest.CreatedAt, ShouldEqual, now)
+			So(backup.Manifest.Devices, ShouldEqual, 3)
+			So(backup.Manifest.Transactions, ShouldEqual, 4)
+			So(backup.Manifest.Checksum, ShouldHaveLength, 64)
+			So(backup.Manifest.Contents, ShouldEqual, BackupContents)
+			So(backup.Manifest.PlainPrivateKeys, ShouldEqual, 0)
+			So(backup.Devices[0].Key, ShouldEqual, "a")
+			So(backup.Devices[0].Transactions, ShouldHaveLength, 3)
+			So(backup.Devices[2].Key, ShouldEqual, "shop/a")
+			So(backup.Manifest.Tenants, ShouldEqual, 1)
+			So(backup.Manifest.APIKeys, ShouldEqual, 1)
+			So(backup.Tenants, ShouldResemble, tenants.ListTenants())
+			So(backup.APIKeys, ShouldResemble, keys.ListKeys())
+		})
+
+		Convey("its archive is read back as it was written", func() {
+			var archive bytes.Buffer
+			So(backup.Write(&archive), ShouldBeNil)
+
+			read, err := ReadBackup(&archive)
+			So(err, ShouldBeNil)
+			So(read.Manifest, ShouldResemble, backup.Manifest)
+			So(read.Devices, ShouldResemble, backup.Devices)
+			So(read.Tenants, ShouldResemble, backup.Tenants)
+			So(read.APIKeys, ShouldResemble, backup.APIKeys)
+
+			Convey("and restored into an empty Storage, transactions, tenants and API keys included", func() {
+				restored, err := NewSQLiteDB(filepath.Join(t.TempDir(), "signing.db"))
+				So(err, ShouldBeNil)
+				defer restored.Close()
+				restoredTenants, restoredKeys := NewInMemoryTenantStore(), NewInMemoryKeyStore()
+				So(RestoreBackup(restored, restoredTenants, restoredKeys, read), ShouldBeNil)
+				So(restoredTenants.ListTenants(), ShouldResemble, tenants.ListTenants())
+				So(restoredKeys.ListKeys(), ShouldResemble, keys.ListKeys())
+
+				for _, entry := range backup.Devices {
+					device, err := restored.Load(entry.Key)
+					So(err, ShouldBeNil)
+					expected := *entry.Device
+					expected.Version = device.Version
+					So(device, ShouldResemble, &expected)
+
+					transactions, err := restored.ListTransactions(entry.Key, 0, 0)
+					So(err, ShouldBeNil)
+					if len(entry.Transactions) == 0 {
+						So(transactions, ShouldBeEmpty)
+					} else {
+						So(transactions, ShouldResemble, entry.Transactions)
+					}
+				}
+
+				Convey("but not into one that has devices", func() {
+					So(RestoreBackup(restored, NewInMemoryTenantStore(), NewInMemoryKeyStore(), read), ShouldEqual, ErrStorageNotEmpty)
+				})
+			})
+
+			Convey("but not next to a tenant or API key of the backup", func() {
+				err := RestoreBackup(NewInMemoryDB(), tenants, NewInMemoryKeyStore(), read)
+				So(errors.Is(err, ErrStorageNotEmpty), ShouldBeTrue)
+				err = RestoreBackup(NewInMemoryDB(), NewInMemoryTenantStore(), keys, read)
+				So(errors.Is(err, ErrStorageNotEmpty), ShouldBeTrue)
+			})
+
+			Convey("and when a device cannot be restored, nothing restored so far is left", func() {
+				restored := NewInMemoryDB()
+				restoredTenants, restoredKeys := NewInMemoryTenantStore(), NewInMemoryKeyStore()
+				err := RestoreBackup(&failingStorage{Storage: restored, failKey: "b"}, restoredTenants, restoredKeys, read)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "Could not restore device b: disk full")
+				So(restored.List(), ShouldBeEmpty)
+				_, err = restored.ListTransactions("a", 0, 0)
+				So(err, ShouldNotBeNil)
+				So(restoredTenants.ListTenants(), ShouldBeEmpty)
+				So(restoredKeys.ListKeys(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("an archive of version 1, without tenants and API keys, is read", func() {
+			backup.Manifest.Version = 1
+			backup.Manifest.Tenants, backup.Manifest.TenantsChecksum = 0, ""
+			backup.Manifest.APIKeys, backup.Manifest.APIKeysChecksum = 0, ""
+			backup.tenants, backup.apiKeys = nil, nil
+			var archive bytes.Buffer
+			So(backup.Write(&archive), ShouldBeNil)
+
+			read, err := ReadBackup(&archive)
+			So(err, ShouldBeNil)
+			So(read.Devices, ShouldResemble, backup.Devices)
+			So(read.Tenants, ShouldBeEmpty)
+			So(read.APIKeys, ShouldBeEmpty)
+		})
+
+		Convey("an archive with altered API keys is rejected", func() {
+			backup.apiKeys = bytes.Replace(backup.apiKeys, []byte(`"a"`), []byte(`"*"`), 1)
+			var archive bytes.Buffer
+			So(backup.Write(&archive), ShouldBeNil)
+
+			_, err := ReadBackup(&archive)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "checksum does not match")
+		})
+
+		Convey("a device whose ledger starts later is restored from there", func() {
+			legacy := &domain.Device{ID: "legacy", Algorithm: "rsa", SignatureCounter: 5, LastSignature: "old"}
+			So(db.Save("legacy", legacy), ShouldBeNil)
+			backup, err := CreateBackup(db, tenants, keys, now)
+			So(err, ShouldBeNil)
+
+			restored := NewInMemoryDB()
+			So(RestoreBackup(restored, NewInMemoryTenantStore(), NewInMemoryKeyStore(), backup), ShouldBeNil)
+			device, err := restored.Load("legacy")
+			So(err, ShouldBeNil)
+			So(device.SignatureCounter, ShouldEqual, 5)
+			So(device.LastSignature, ShouldEqual, "old")
+		})
+
+		Convey("a corrupted archive is rejected", func() {
+			backup.devices = bytes.Replace(backup.devices, []byte("signature 1"), []byte("signature X"), 1)
+			var archive bytes.Buffer
+			So(backup.Write(&archive), ShouldBeNil)
+
+			_, err := ReadBackup(&archive)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "checksum does not match")
+		})
+
+		Convey("an archive of a newer version is rejected", func() {
+			backup.Manifest.Version = BackupVersion + 1
+			var archive bytes.Buffer
+			So(backup.Write(&archive), ShouldBeNil)
+
+			_, err := ReadBackup(&archive)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not supported")
+		})
+
+		Convey("an archive whose devices do not match its manifest is rejected", func() {
+			backup.Manifest.Devices++
+			var archive bytes.Buffer
+			So(backup.Write(&archive), ShouldBeNil)
+
+			_, err := ReadBackup(&archive)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a device with a missing transaction is rejected, even with a matching checksum", func() {
+			transactions := backup.Devices[0].Transactions
+			backup.Devices[0].Transactions = []*domain.Transaction{transactions[0], transactions[2]}
+			var devices bytes.Buffer
+			for _, entry := range backup.Devices {
+				So(json.NewEncoder(&devices).Encode(entry), ShouldBeNil)
+			}
+			backup.devices = devices.Bytes()
+			backup.Manifest.Transactions--
+			backup.Manifest.Checksum = checksum(backup.devices)
+			var archive bytes.Buffer
+			So(backup.Write(&archive), ShouldBeNil)
+
+			_, err := ReadBackup(&archive)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not contiguous at counter 1")
+		})
+
+		Convey("anything but a backup archive is rejected", func() {
+			_, err := ReadBackup(bytes.NewBufferString("not an archive"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("an archive larger than MaxBackupSize is rejected before it is read", func() {
+			var archive bytes.Buffer
+			gz := gzip.NewWriter(&archive)
+			So(tar.NewWriter(gz).WriteHeader(&tar.Header{Name: backupDevicesFile, Mode: 0o600, Size: MaxBackupSize + 1}), ShouldBeNil)
+			So(gz.Close(), ShouldBeNil)
+
+			_, err := ReadBackup(&archive)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "larger than")
+		})
+	})
+}
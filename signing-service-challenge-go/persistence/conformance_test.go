@@ -0,0 +1,45 @@
+package persistence_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence/storagetest"
+)
+
+// Every Storage backend must pass the same contract, a new backend gets a test here
+
+func TestInMemoryDBConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) persistence.Storage {
+		return persistence.NewInMemoryDB()
+	})
+}
+
+func TestFileDBConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) persistence.Storage {
+		db, err := persistence.NewFileDB(t.TempDir(), persistence.DefaultFileDBOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	})
+}
+
+func TestSQLiteDBConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) persistence.Storage {
+		db, err := persistence.NewSQLiteDB(filepath.Join(t.TempDir(), "signing.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	})
+}
+
+func TestAtomicStorageConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) persistence.Storage {
+		return persistence.NewAtomicStorage(persistence.NewInMemoryDB())
+	})
+}
@@ -0,0 +1,26 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+)
+
+// VersionConflictError is returned by CompareAndSave when the stored Device is not at the expected version,
+// meaning someone else saved it in between. The caller should reload and retry.
+type VersionConflictError struct {
+	ID       string
+	Expected int
+	Actual   int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("Device with id %s is at version %d, expected version %d", e.ID, e.Actual, e.Expected)
+}
+
+// ErrStorageNotEmpty is returned by RestoreBackup when the Storage already has devices, and wrapped when a
+// tenant or API key of the backup exists already
+var ErrStorageNotEmpty = errors.New("Backups are only restored into a storage without devices")
+
+// ErrIdempotencyKeyNotFound is wrapped by the error of LoadIdempotencyRecord when the device has no record of
+// the key, or only an expired one
+var ErrIdempotencyKeyNotFound = errors.New("Idempotency key not found")
@@ -0,0 +1,628 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+)
+
+const (
+	snapshotFileName = "snapshot.json"
+	walFileName      = "wal.log"
+	lockFileName     = "lock"
+	// Version 2 added transactions, version 3 idempotency records, older snapshots are still readable
+	snapshotVersion = 3
+)
+
+// SyncMode controls when FileDB forces written data down to stable storage
+type SyncMode int
+
+const (
+	// SyncAlways fsyncs after every write, no acknowledged write is ever lost
+	SyncAlways SyncMode = iota
+	// SyncInterval fsyncs periodically, a crash may lose writes of the last interval
+	SyncInterval
+	// SyncNever leaves flushing to the operating system
+	SyncNever
+)
+
+// ParseSyncMode converts "always", "interval" or "never" to its SyncMode
+func ParseSyncMode(s string) (SyncMode, error) {
+	switch s {
+	case "always":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "never":
+		return SyncNever, nil
+	default:
+		return SyncAlways, errors.New("Unknown sync mode " + s + `, expected "always", "interval" or "never"`)
+	}
+}
+
+// FileDBOptions tunes durability and compaction of a FileDB
+type FileDBOptions struct {
+	// When to fsync the write-ahead log
+	SyncMode SyncMode
+	// How often to fsync when SyncMode is SyncInterval
+	SyncInterval time.Duration
+	// Number of write-ahead log records after which a compacted snapshot is written, 0 disables compaction
+	SnapshotThreshold int
+}
+
+// DefaultFileDBOptions returns the safest options: fsync on every write and compaction every 1000 writes
+func DefaultFileDBOptions() FileDBOptions {
+	return FileDBOptions{
+		SyncMode:          SyncAlways,
+		SyncInterval:      time.Second,
+		SnapshotThreshold: 1000,
+	}
+}
+
+// walRecord is a single entry of the write-ahead log, stored as "<crc32 hex> <json>\n"
+type walRecord struct {
+	Op          string                    `json:"op"`
+	ID          string                    `json:"id"`
+	Device      *domain.Device            `json:"device,omitempty"`
+	Transaction *domain.Transaction       `json:"transaction,omitempty"`
+	Idempotency *domain.IdempotencyRecord `json:"idempotency,omitempty"`
+}
+
+// snapshotFile is the compacted state of all devices at the time the write-ahead log was last truncated
+type snapshotFile struct {
+	Version      int                       `json:"version"`
+	Devices      map[string]*domain.Device `json:"devices"`
+	Transactions map[string]ledger         `json:"transactions,omitempty"`
+	// Idempotency records not expired when the snapshot was written
+	IdempotencyKeys map[string][]*domain.IdempotencyRecord `json:"idempotency_keys,omitempty"`
+}
+
+// FileDB is a durable Storage keeping all devices in memory, backed by an append-only write-ahead log
+// and a periodically compacted snapshot on local disk. On open, the snapshot is loaded and the log is
+// replayed on top of it, a torn last record left behind by a crash is truncated away.
+type FileDB struct {
+	dir     string
+	options FileDBOptions
+
+	mu          sync.RWMutex
+	devices     map[string]*domain.Device
+	ledgers     map[string]ledger
+	idempotency idempotencyIndex
+	wal         *os.File
+	lock        *os.File // held while open, see lockStorageFile
+	walRecords  int
+	dirty       bool
+	closed      bool
+
+	// size of the intact part of the write-ahead log, where the next record is written
+	walSize int64
+	// why the write-ahead log cannot be written anymore, nil if it can
+	failed error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewFileDB opens (creating if necessary) a FileDB in directory @dir and recovers its state. Only one FileDB
+// opens a directory at a time, another one fails with ErrStorageLocked until it is closed.
+func NewFileDB(dir string, options FileDBOptions) (*FileDB, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	lock, err := lockStorageFile(filepath.Join(dir, lockFileName))
+	if err != nil {
+		return nil, err
+	}
+	db, err := recoverFileDB(dir, options)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	db.lock = lock
+	return db, nil
+}
+
+// recoverFileDB recovers the state of the FileDB in directory @dir, whose lock the caller holds
+func recoverFileDB(dir string, options FileDBOptions) (*FileDB, error) {
+	db := &FileDB{
+		dir:         dir,
+		options:     options,
+		devices:     make(map[string]*domain.Device),
+		ledgers:     make(map[string]ledger),
+		idempotency: make(idempotencyIndex),
+	}
+
+	if err := db.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	db.wal = wal
+
+	if err := db.replay(); err != nil {
+		wal.Close()
+		return nil, err
+	}
+
+	if options.SyncMode == SyncInterval && options.SyncInterval > 0 {
+		db.stop = make(chan struct{})
+		db.done = make(chan struct{})
+		go db.syncLoop()
+	}
+
+	return db, nil
+}
+
+func (db *FileDB) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(db.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snapshot snapshotFile
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("Snapshot %s is corrupt: %w", snapshotFileName, err)
+	}
+	if snapshot.Version < 1 || snapshot.Version > snapshotVersion {
+		return fmt.Errorf("Snapshot %s has unsupported version %d", snapshotFileName, snapshot.Version)
+	}
+
+	for id, device := range snapshot.Devices {
+		db.devices[id] = device
+	}
+	for id, transactions := range snapshot.Transactions {
+		db.ledgers[id] = transactions
+	}
+	for id, records := range snapshot.IdempotencyKeys {
+		for _, record := range records {
+			db.idempotency.put(id, record, time.Time{})
+		}
+	}
+	return nil
+}
+
+// replay applies every intact record of the write-ahead log. A damaged last record is a write torn by a
+// crash and is cut away, a damaged record followed by others means the log itself is corrupt: dropping the
+// records after it would roll devices back, so the log is left alone and opening fails.
+func (db *FileDB) replay() error {
+	if _, err := db.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(db.wal)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		// a line without its newline is a write torn by a crash
+		if err == io.EOF || !db.applyLine(line) {
+			if _, peekErr := reader.Peek(1); err != io.EOF && peekErr != io.EOF {
+				return fmt.Errorf("Write-ahead log %s is corrupt at offset %d, records follow the damaged one", walFileName, offset)
+			}
+			break
+		}
+		offset += int64(len(line))
+		db.walRecords++
+	}
+
+	if err := db.wal.Truncate(offset); err != nil {
+		return err
+	}
+	db.walSize = offset
+	_, err := db.wal.Seek(offset, io.SeekStart)
+	return err
+}
+
+// applyLine decodes and applies a single write-ahead log line, returning false if it is damaged
+func (db *FileDB) applyLine(line []byte) bool {
+	checksum, payload, found := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	if !found {
+		return false
+	}
+
+	var expected uint32
+	if _, err := fmt.Sscanf(string(checksum), "%08x", &expected); err != nil {
+		return false
+	}
+	if crc32.ChecksumIEEE(payload) != expected {
+		return false
+	}
+
+	var record walRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return false
+	}
+
+	switch record.Op {
+	case "save":
+		if record.Device == nil {
+			return false
+		}
+		db.apply(record.ID, record.Device, nil, nil)
+	case "sign":
+		if record.Device == nil || record.Transaction == nil {
+			return false
+		}
+		db.apply(record.ID, record.Device, record.Transaction, record.Idempotency)
+	case "delete":
+		delete(db.devices, record.ID)
+		delete(db.ledgers, record.ID)
+		delete(db.idempotency, record.ID)
+	default:
+		return false
+	}
+	return true
+}
+
+// append writes @record to the write-ahead log honoring the configured SyncMode, caller must hold the write lock
+func (db *FileDB) append(record walRecord) error {
+	if db.closed {
+		return errors.New("FileDB is closed")
+	}
+	if db.failed != nil {
+		return db.failed
+	}
+
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(payload), payload)
+	_, err = db.wal.WriteString(line)
+	if err == nil && db.options.SyncMode == SyncAlways {
+		err = db.wal.Sync()
+	}
+	if err != nil {
+		// a record that may be partly written must not stay in front of the next ones
+		db.discardFailedAppend()
+		return err
+	}
+	if db.options.SyncMode != SyncAlways {
+		db.dirty = true
+	}
+
+	db.walSize += int64(len(line))
+	db.walRecords++
+	return nil
+}
+
+// discardFailedAppend cuts the write-ahead log back to its last intact record. Should that fail too, the
+// FileDB refuses any further write rather than logging records after a damaged one. Caller must hold the
+// write lock.
+func (db *FileDB) discardFailedAppend() {
+	err := db.wal.Truncate(db.walSize)
+	if err == nil {
+		_, err = db.wal.Seek(db.walSize, io.SeekStart)
+	}
+	if err != nil {
+		db.failed = fmt.Errorf("FileDB is unusable, its write-ahead log could not be repaired: %w", err)
+	}
+}
+
+// compact writes a snapshot of all devices if the write-ahead log grew past the threshold, caller must hold the write lock
+func (db *FileDB) compact() error {
+	if db.options.SnapshotThreshold <= 0 || db.walRecords < db.options.SnapshotThreshold {
+		return nil
+	}
+	return db.snapshot()
+}
+
+// snapshot atomically replaces the snapshot file with the current state and truncates the write-ahead log.
+// Should a crash happen between both steps, replaying the log over the new snapshot is harmless as every
+// record carries the full state of its device, and a transaction applied again replaces itself.
+func (db *FileDB) snapshot() error {
+	data, err := json.Marshal(snapshotFile{
+		Version:         snapshotVersion,
+		Devices:         db.devices,
+		Transactions:    db.ledgers,
+		IdempotencyKeys: db.idempotency.all(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := replaceFile(filepath.Join(db.dir, snapshotFileName), data); err != nil {
+		return err
+	}
+
+	if err := db.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := db.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := db.wal.Sync(); err != nil {
+		return err
+	}
+
+	db.walRecords = 0
+	db.walSize = 0
+	db.dirty = false
+	return nil
+}
+
+func (db *FileDB) syncLoop() {
+	defer close(db.done)
+
+	ticker := time.NewTicker(db.options.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			db.Sync()
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+// Sync forces any buffered write-ahead log data to stable storage
+func (db *FileDB) Sync() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed || !db.dirty {
+		return nil
+	}
+	if err := db.wal.Sync(); err != nil {
+		return err
+	}
+	db.dirty = false
+	return nil
+}
+
+// Snapshot forces compaction regardless of the configured threshold
+func (db *FileDB) Snapshot() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return errors.New("FileDB is closed")
+	}
+	return db.snapshot()
+}
+
+// Close flushes the write-ahead log and releases the underlying files, the FileDB is unusable afterwards
+func (db *FileDB) Close() error {
+	if db.stop != nil {
+		close(db.stop)
+		<-db.done
+		db.stop = nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+
+	syncErr := db.wal.Sync()
+	closeErr := db.wal.Close()
+	// only once everything is written
+	unlockErr := db.lock.Close()
+	return errors.Join(syncErr, closeErr, unlockErr)
+}
+
+func (db *FileDB) Save(id string, data *domain.Device) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.saveLocked(id, data, db.currentVersion(id), nil, nil)
+}
+
+func (db *FileDB) CompareAndSave(id string, data *domain.Device, expectedVersion int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	currentVersion := db.currentVersion(id)
+	if currentVersion != expectedVersion {
+		return &VersionConflictError{ID: id, Expected: expectedVersion, Actual: currentVersion}
+	}
+	return db.saveLocked(id, data, currentVersion, nil, nil)
+}
+
+func (db *FileDB) CompareAndSaveWithTransaction(id string, data *domain.Device, expectedVersion int, transaction *domain.Transaction, idempotency *domain.IdempotencyRecord) error {
+	if err := checkTransaction(id, data, transaction); err != nil {
+		return err
+	}
+	if err := checkIdempotencyRecord(id, transaction, idempotency); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	currentVersion := db.currentVersion(id)
+	if currentVersion != expectedVersion {
+		return &VersionConflictError{ID: id, Expected: expectedVersion, Actual: currentVersion}
+	}
+	return db.saveLocked(id, data, currentVersion, transaction, idempotency)
+}
+
+// currentVersion returns the stored version of @id or 0 if it does not exist, caller must hold the lock
+func (db *FileDB) currentVersion(id string) int {
+	if current, ok := db.devices[id]; ok {
+		return current.Version
+	}
+	return 0
+}
+
+// saveLocked logs and stores a copy of @data as the next version together with @transaction and @idempotency
+// unless they are nil, caller must hold the write lock
+func (db *FileDB) saveLocked(id string, data *domain.Device, currentVersion int, transaction *domain.Transaction, idempotency *domain.IdempotencyRecord) error {
+	device := *data
+	device.Version = currentVersion + 1
+
+	record := walRecord{Op: "save", ID: id, Device: &device}
+	if transaction != nil {
+		record.Op, record.Transaction, record.Idempotency = "sign", transaction, idempotency
+	}
+	if err := db.append(record); err != nil {
+		return err
+	}
+	db.apply(id, &device, transaction, idempotency)
+	data.Version = device.Version
+
+	// the write is durable in the log already, a failed compaction is simply retried on the next write
+	db.compact()
+	return nil
+}
+
+// apply stores @device, @transaction and @idempotency unless they are nil in memory, caller must hold the
+// write lock
+func (db *FileDB) apply(id string, device *domain.Device, transaction *domain.Transaction, idempotency *domain.IdempotencyRecord) {
+	db.devices[id] = device
+	updateLedger(db.ledgers, id, device, transaction)
+	db.idempotency.truncate(id, device.SignatureCounter)
+	if idempotency != nil {
+		db.idempotency.put(id, idempotency, transaction.CreatedAt)
+	}
+}
+
+func (db *FileDB) Load(id string) (*domain.Device, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if data, ok := db.devices[id]; ok {
+		device := *data
+		return &device, nil
+	} else {
+		return nil, errors.New("Device with id " + id + " not found")
+	}
+}
+
+func (db *FileDB) List() []*domain.Device {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return sortedDevices(db.devices)
+}
+
+func (db *FileDB) ListDevices(query domain.DeviceQuery) ([]*domain.Device, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return queryDevices(db.devices, query), nil
+}
+
+func (db *FileDB) Delete(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.devices[id]; !ok {
+		return errors.New("Device with id " + id + " not found")
+	}
+	if err := db.append(walRecord{Op: "delete", ID: id}); err != nil {
+		return err
+	}
+	delete(db.devices, id)
+	delete(db.ledgers, id)
+	delete(db.idempotency, id)
+
+	db.compact()
+	return nil
+}
+
+func (db *FileDB) LoadTransaction(id string, counter int) (*domain.Transaction, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if transaction, ok := db.ledgers[id].find(counter); ok {
+		return transaction, nil
+	}
+	return nil, transactionNotFound(id, counter)
+}
+
+func (db *FileDB) ListTransactions(id string, from int, limit int) ([]*domain.Transaction, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if _, ok := db.devices[id]; !ok {
+		return nil, errors.New("Device with id " + id + " not found")
+	}
+	return db.ledgers[id].page(from, limit), nil
+}
+
+func (db *FileDB) LoadIdempotencyRecord(id string, key string, now time.Time) (*domain.IdempotencyRecord, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if record, ok := db.idempotency.find(id, key, now); ok {
+		return record, nil
+	}
+	return nil, idempotencyRecordNotFound(id, key)
+}
+
+func (db *FileDB) ListIdempotencyRecords(id string, now time.Time) ([]*domain.IdempotencyRecord, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if _, ok := db.devices[id]; !ok {
+		return nil, errors.New("Device with id " + id + " not found")
+	}
+	return db.idempotency.list(id, now), nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// replaceFile atomically and durably replaces the content of file @path with @data
+func replaceFile(path string, data []byte) error {
+	if err := writeFileSync(path+".tmp", data); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes a rename inside @dir durable, a no-op where directories cannot be opened for syncing
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+package persistence
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func openFileDB(dir string, options FileDBOptions) *FileDB {
+	db, err := NewFileDB(dir, options)
+	So(err, ShouldBeNil)
+	return db
+}
+
+func TestFileDBLock(t *testing.T) {
+	Convey("Given a FileDB open in a directory", t, func() {
+		dir := t.TempDir()
+		db := openFileDB(dir, DefaultFileDBOptions())
+
+		Convey("another one cannot open it until it is closed", func() {
+			_, err := NewFileDB(dir, DefaultFileDBOptions())
+			So(errors.Is(err, ErrStorageLocked), ShouldBeTrue)
+
+			So(db.Close(), ShouldBeNil)
+			db := openFileDB(dir, DefaultFileDBOptions())
+			So(db.Close(), ShouldBeNil)
+		})
+	})
+}
+
+func TestFileDBSaveLoad(t *testing.T) {
+	Convey("Given a FileDB in an empty directory", t, func() {
+		dir := t.TempDir()
+		db := openFileDB(dir, DefaultFileDBOptions())
+		defer db.Close()
+
+		Convey(`and a device with ID "hello"`, func() {
+			d := &domain.Device{
+				ID:               "hello",
+				Algorithm:        "ecc",
+				PrivateKey:       []byte("private"),
+				SignatureCounter: 3,
+				LastSignature:    "c2ln",
+			}
+
+			Convey("when the device is saved", func() {
+				err := db.Save(d.ID, d)
+
+				Convey("it returns no error", func() {
+					So(err, ShouldBeNil)
+				})
+
+				Convey("and the same device will be loaded", func() {
+					d1, err := db.Load(d.ID)
+					So(err, ShouldBeNil)
+					So(d1, ShouldResemble, d)
+				})
+
+				Convey("and modifying the loaded device does not modify the stored one", func() {
+					d1, _ := db.Load(d.ID)
+					d1.SignatureCounter++
+
+					d2, _ := db.Load(d.ID)
+					So(d2.SignatureCounter, ShouldEqual, 3)
+				})
+
+				Convey("but loading a different id returns an error", func() {
+					_, err := db.Load(d.ID + ", world")
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("and 3 saved devices, List returns all of them", func() {
+			for _, id := range []string{"a", "b", "c"} {
+				So(db.Save(id, &domain.Device{ID: id}), ShouldBeNil)
+			}
+			So(len(db.List()), ShouldEqual, 3)
+		})
+
+		Convey("CompareAndSave only succeeds at the expected version, also after reopening", func() {
+			d := &domain.Device{ID: "a"}
+			So(db.CompareAndSave(d.ID, d, 0), ShouldBeNil)
+			So(db.CompareAndSave(d.ID, d, 0), ShouldHaveSameTypeAs, &VersionConflictError{})
+			So(db.CompareAndSave(d.ID, d, 1), ShouldBeNil)
+			So(d.Version, ShouldEqual, 2)
+
+			So(db.Close(), ShouldBeNil)
+			db = openFileDB(dir, DefaultFileDBOptions())
+			defer db.Close()
+
+			So(db.CompareAndSave(d.ID, d, 1), ShouldHaveSameTypeAs, &VersionConflictError{})
+			So(db.CompareAndSave(d.ID, d, 2), ShouldBeNil)
+		})
+
+		Convey("a deleted device stays deleted after reopening", func() {
+			So(db.Save("a", &domain.Device{ID: "a"}), ShouldBeNil)
+			So(db.Save("b", &domain.Device{ID: "b"}), ShouldBeNil)
+			So(db.Delete("a"), ShouldBeNil)
+			So(db.Delete("a"), ShouldNotBeNil)
+
+			So(db.Close(), ShouldBeNil)
+			db = openFileDB(dir, DefaultFileDBOptions())
+			defer db.Close()
+
+			_, err := db.Load("a")
+			So(err, ShouldNotBeNil)
+			So(len(db.List()), ShouldEqual, 1)
+		})
+
+		Convey("once closed, Save returns an error", func() {
+			So(db.Close(), ShouldBeNil)
+			So(db.Save("a", &domain.Device{ID: "a"}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestFileDBRecovery(t *testing.T) {
+	Convey("Given a FileDB with saved devices", t, func() {
+		dir := t.TempDir()
+		db := openFileDB(dir, DefaultFileDBOptions())
+
+		So(db.Save("a", &domain.Device{ID: "a", SignatureCounter: 1}), ShouldBeNil)
+		So(db.Save("b", &domain.Device{ID: "b"}), ShouldBeNil)
+		retired := &domain.Device{ID: "a", SignatureCounter: 2, Status: domain.DeviceActive}
+		So(retired.Transition(domain.ActionRetire, time.Unix(1700000000, 0).UTC(), "admin", "sold"), ShouldBeNil)
+		So(db.Save("a", retired), ShouldBeNil)
+
+		Convey("when it is reopened, the latest state is recovered from the write-ahead log", func() {
+			So(db.Close(), ShouldBeNil)
+			db = openFileDB(dir, DefaultFileDBOptions())
+			defer db.Close()
+
+			a, err := db.Load("a")
+			So(err, ShouldBeNil)
+			So(a.SignatureCounter, ShouldEqual, 2)
+			So(a.Status, ShouldEqual, domain.DeviceRetired)
+			So(a.StatusHistory, ShouldResemble, retired.StatusHistory)
+			So(len(db.List()), ShouldEqual, 2)
+		})
+
+		Convey("when a snapshot is taken and it is reopened, the state is recovered from the snapshot", func() {
+			So(db.Snapshot(), ShouldBeNil)
+			So(db.Save("c", &domain.Device{ID: "c"}), ShouldBeNil)
+			So(db.Close(), ShouldBeNil)
+
+			info, err := os.Stat(filepath.Join(dir, snapshotFileName))
+			So(err, ShouldBeNil)
+			So(info.Size(), ShouldBeGreaterThan, 0)
+
+			db = openFileDB(dir, DefaultFileDBOptions())
+			defer db.Close()
+
+			a, err := db.Load("a")
+			So(err, ShouldBeNil)
+			So(a.SignatureCounter, ShouldEqual, 2)
+			So(len(db.List()), ShouldEqual, 3)
+		})
+
+		Convey("when the last write was torn by a crash, it is discarded and the log stays usable", func() {
+			So(db.Close(), ShouldBeNil)
+
+			wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_WRONLY|os.O_APPEND, 0o600)
+			So(err, ShouldBeNil)
+			_, err = wal.WriteString(`0badc0de {"op":"save","id":"a","dev`)
+			So(err, ShouldBeNil)
+			So(wal.Close(), ShouldBeNil)
+
+			db = openFileDB(dir, DefaultFileDBOptions())
+			a, err := db.Load("a")
+			So(err, ShouldBeNil)
+			So(a.SignatureCounter, ShouldEqual, 2)
+
+			So(db.Save("d", &domain.Device{ID: "d"}), ShouldBeNil)
+			So(db.Close(), ShouldBeNil)
+
+			db = openFileDB(dir, DefaultFileDBOptions())
+			defer db.Close()
+			So(len(db.List()), ShouldEqual, 3)
+		})
+
+		Convey("when a record fails its checksum, replay stops there", func() {
+			So(db.Close(), ShouldBeNil)
+
+			wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_WRONLY|os.O_APPEND, 0o600)
+			So(err, ShouldBeNil)
+			_, err = wal.WriteString("00000000 {\"op\":\"save\",\"id\":\"x\",\"device\":{\"ID\":\"x\"}}\n")
+			So(err, ShouldBeNil)
+			So(wal.Close(), ShouldBeNil)
+
+			db = openFileDB(dir, DefaultFileDBOptions())
+			defer db.Close()
+
+			_, err = db.Load("x")
+			So(err, ShouldNotBeNil)
+			So(len(db.List()), ShouldEqual, 2)
+		})
+
+		Convey("when a damaged record is followed by intact ones, it refuses to open and keeps the log", func() {
+			So(db.Close(), ShouldBeNil)
+
+			path := filepath.Join(dir, walFileName)
+			records, err := os.ReadFile(path)
+			So(err, ShouldBeNil)
+			intact, _, _ := bytes.Cut(records, []byte("\n"))
+			damaged := "00000000 {\"op\":\"save\",\"id\":\"x\",\"device\":{\"ID\":\"x\"}}\n"
+			before := append(append(records, damaged...), append(intact, '\n')...)
+			So(os.WriteFile(path, before, 0o600), ShouldBeNil)
+
+			_, err = NewFileDB(dir, DefaultFileDBOptions())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "corrupt")
+			after, err := os.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(after, ShouldResemble, before)
+		})
+
+		Convey("when a write fails and the log cannot be repaired, later writes are refused", func() {
+			So(db.wal.Close(), ShouldBeNil)
+
+			So(db.Save("c", &domain.Device{ID: "c"}), ShouldNotBeNil)
+			err := db.Save("d", &domain.Device{ID: "d"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "could not be repaired")
+		})
+	})
+}
+
+func TestFileDBTransactions(t *testing.T) {
+	Convey("Given a FileDB with a device that signed 3 times with idempotency keys", t, func() {
+		dir := t.TempDir()
+		db := openFileDB(dir, DefaultFileDBOptions())
+
+		d := &domain.Device{ID: "a"}
+		So(db.CompareAndSave(d.ID, d, 0), ShouldBeNil)
+		sign := func() {
+			expectedVersion := d.Version
+			transaction := &domain.Transaction{DeviceID: "a", Counter: d.SignatureCounter, Signature: "c2ln"}
+			record := &domain.IdempotencyRecord{Key: fmt.Sprintf("receipt-%d", d.SignatureCounter), Counter: d.SignatureCounter, ExpiresAt: time.Now().Add(time.Hour)}
+			d.SignatureCounter++
+			So(db.CompareAndSaveWithTransaction(d.ID, d, expectedVersion, transaction, record), ShouldBeNil)
+		}
+		sign()
+		sign()
+		sign()
+
+		Convey("the transactions survive reopening from the write-ahead log", func() {
+			So(db.Close(), ShouldBeNil)
+			db = openFileDB(dir, DefaultFileDBOptions())
+			defer db.Close()
+
+			transactions, err := db.ListTransactions("a", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldHaveLength, 3)
+			So(transactions[2].Counter, ShouldEqual, 2)
+			record, err := db.LoadIdempotencyRecord("a", "receipt-2", time.Now())
+			So(err, ShouldBeNil)
+			So(record.Counter, ShouldEqual, 2)
+		})
+
+		Convey("the transactions survive a snapshot", func() {
+			So(db.Snapshot(), ShouldBeNil)
+			sign()
+			So(db.Close(), ShouldBeNil)
+			db = openFileDB(dir, DefaultFileDBOptions())
+			defer db.Close()
+
+			transactions, err := db.ListTransactions("a", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldHaveLength, 4)
+			records, err := db.ListIdempotencyRecords("a", time.Now())
+			So(err, ShouldBeNil)
+			So(records, ShouldHaveLength, 4)
+		})
+
+		Convey("replaying the write-ahead log over a snapshot already holding it changes nothing", func() {
+			So(db.Close(), ShouldBeNil)
+			wal, err := os.ReadFile(filepath.Join(dir, walFileName))
+			So(err, ShouldBeNil)
+
+			db = openFileDB(dir, DefaultFileDBOptions())
+			So(db.Close(), ShouldBeNil)
+			// as if a crash happened right after the snapshot was written but before the log was truncated
+			So(os.WriteFile(filepath.Join(dir, walFileName), wal, 0o600), ShouldBeNil)
+
+			db = openFileDB(dir, DefaultFileDBOptions())
+			defer db.Close()
+
+			transactions, err := db.ListTransactions("a", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldHaveLength, 3)
+			a, _ := db.Load("a")
+			So(a.SignatureCounter, ShouldEqual, 3)
+		})
+	})
+
+	Convey("Given a snapshot written before transactions were kept", t, func() {
+		dir := t.TempDir()
+		legacy := `{"version":1,"devices":{"a":{"ID":"a","SignatureCounter":5,"Version":3}}}`
+		So(os.WriteFile(filepath.Join(dir, snapshotFileName), []byte(legacy), 0o600), ShouldBeNil)
+
+		Convey("it is loaded and the device continues its chain in a ledger starting at its counter", func() {
+			db := openFileDB(dir, DefaultFileDBOptions())
+			defer db.Close()
+
+			d, err := db.Load("a")
+			So(err, ShouldBeNil)
+			d.SignatureCounter++
+			So(db.CompareAndSaveWithTransaction("a", d, 3, &domain.Transaction{DeviceID: "a", Counter: 5}, nil), ShouldBeNil)
+
+			transaction, err := db.LoadTransaction("a", 5)
+			So(err, ShouldBeNil)
+			So(transaction.Counter, ShouldEqual, 5)
+			_, err = db.LoadTransaction("a", 4)
+			So(err, ShouldNotBeNil)
+
+			transactions, err := db.ListTransactions("a", 0, 10)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldHaveLength, 1)
+		})
+	})
+}
+
+func TestFileDBCompaction(t *testing.T) {
+	Convey("Given a FileDB compacting every 3 writes", t, func() {
+		dir := t.TempDir()
+		options := DefaultFileDBOptions()
+		options.SnapshotThreshold = 3
+		db := openFileDB(dir, options)
+		defer db.Close()
+
+		Convey("when 3 writes are made, the write-ahead log is truncated into a snapshot", func() {
+			for i := 0; i < 3; i++ {
+				So(db.Save("a", &domain.Device{ID: "a", SignatureCounter: i}), ShouldBeNil)
+			}
+
+			info, err := os.Stat(filepath.Join(dir, walFileName))
+			So(err, ShouldBeNil)
+			So(info.Size(), ShouldEqual, 0)
+
+			_, err = os.Stat(filepath.Join(dir, snapshotFileName))
+			So(err, ShouldBeNil)
+
+			a, err := db.Load("a")
+			So(err, ShouldBeNil)
+			So(a.SignatureCounter, ShouldEqual, 2)
+		})
+	})
+}
+
+func TestFileDBSyncModes(t *testing.T) {
+	Convey("ParseSyncMode", t, func() {
+		Convey("accepts the known modes", func() {
+			for name, mode := range map[string]SyncMode{"always": SyncAlways, "interval": SyncInterval, "never": SyncNever} {
+				parsed, err := ParseSyncMode(name)
+				So(err, ShouldBeNil)
+				So(parsed, ShouldEqual, mode)
+			}
+		})
+
+		Convey("rejects anything else", func() {
+			_, err := ParseSyncMode("sometimes")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a FileDB syncing on an interval", t, func() {
+		dir := t.TempDir()
+		options := DefaultFileDBOptions()
+		options.SyncMode = SyncInterval
+		db := openFileDB(dir, options)
+
+		Convey("writes are durable after Sync and Close", func() {
+			So(db.Save("a", &domain.Device{ID: "a"}), ShouldBeNil)
+			So(db.Sync(), ShouldBeNil)
+			So(db.Close(), ShouldBeNil)
+
+			db = openFileDB(dir, options)
+			defer db.Close()
+			_, err := db.Load("a")
+			So(err, ShouldBeNil)
+		})
+	})
+}
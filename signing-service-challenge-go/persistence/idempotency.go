@@ -0,0 +1,121 @@
+package persistence
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+)
+
+// minIdempotencyPrune is the number of records of a device from which its expired records are dropped
+const minIdempotencyPrune = 16
+
+// idempotencyIndex keeps the idempotency records of every device by storage key, for the storages keeping
+// everything in memory. Expired records are dropped once a device has twice as many records as after the
+// last time, so remembering a record costs the same however many there are.
+type idempotencyIndex map[string]*deviceIdempotency
+
+// deviceIdempotency are the idempotency records of a single device
+type deviceIdempotency struct {
+	records map[string]*domain.IdempotencyRecord // by idempotency key
+	// highest counter of the records, to tell cheaply whether discarded transactions had any
+	maxCounter int
+	// number of records from which expired ones are dropped
+	pruneAt int
+}
+
+// put stores a copy of @record of device @id, replacing any record of the same key. Records expired before @now
+// may be dropped, the zero time drops none.
+func (index idempotencyIndex) put(id string, record *domain.IdempotencyRecord, now time.Time) {
+	device, ok := index[id]
+	if !ok {
+		device = &deviceIdempotency{records: make(map[string]*domain.IdempotencyRecord), pruneAt: minIdempotencyPrune}
+		index[id] = device
+	}
+	stored := *record
+	device.records[stored.Key] = &stored
+	device.maxCounter = max(device.maxCounter, stored.Counter)
+
+	if len(device.records) >= device.pruneAt {
+		device.drop(func(record *domain.IdempotencyRecord) bool { return record.Expired(now) })
+		device.pruneAt = max(2*len(device.records), minIdempotencyPrune)
+	}
+}
+
+// find returns a copy of the record of idempotency key @key of device @id unless it expired before @now
+func (index idempotencyIndex) find(id string, key string, now time.Time) (*domain.IdempotencyRecord, bool) {
+	device, ok := index[id]
+	if !ok {
+		return nil, false
+	}
+	record, ok := device.records[key]
+	if !ok || record.Expired(now) {
+		return nil, false
+	}
+	found := *record
+	return &found, true
+}
+
+// list returns copies of the records of device @id not expired before @now, ordered by counter
+func (index idempotencyIndex) list(id string, now time.Time) []*domain.IdempotencyRecord {
+	records := []*domain.IdempotencyRecord{}
+	if device, ok := index[id]; ok {
+		for _, record := range device.records {
+			if !record.Expired(now) {
+				found := *record
+				records = append(records, &found)
+			}
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Counter < records[j].Counter })
+	return records
+}
+
+// truncate drops the records of device @id of transactions from counter @counter on, which were discarded
+func (index idempotencyIndex) truncate(id string, counter int) {
+	device, ok := index[id]
+	if !ok || device.maxCounter < counter {
+		return
+	}
+	device.drop(func(record *domain.IdempotencyRecord) bool { return record.Counter >= counter })
+	if len(device.records) == 0 {
+		delete(index, id)
+	}
+}
+
+// all returns the records of every device, expired or not, ordered by counter, for snapshots. Expired ones
+// go as their device signs.
+func (index idempotencyIndex) all() map[string][]*domain.IdempotencyRecord {
+	all := make(map[string][]*domain.IdempotencyRecord)
+	for id := range index {
+		if records := index.list(id, time.Time{}); len(records) > 0 {
+			all[id] = records
+		}
+	}
+	return all
+}
+
+// drop removes every record @matches, recomputing the highest counter
+func (device *deviceIdempotency) drop(matches func(record *domain.IdempotencyRecord) bool) {
+	device.maxCounter = 0
+	for key, record := range device.records {
+		if matches(record) {
+			delete(device.records, key)
+		} else {
+			device.maxCounter = max(device.maxCounter, record.Counter)
+		}
+	}
+}
+
+// checkIdempotencyRecord verifies @record, unless it is nil, belongs to @transaction of device @id
+func checkIdempotencyRecord(id string, transaction *domain.Transaction, record *domain.IdempotencyRecord) error {
+	if record != nil && record.Counter != transaction.Counter {
+		return fmt.Errorf("Idempotency record of key %s of device %s does not belong to transaction %d", record.Key, id, transaction.Counter)
+	}
+	return nil
+}
+
+func idempotencyRecordNotFound(id string, key string) error {
+	return fmt.Errorf("%w: %s of device %s", ErrIdempotencyKeyNotFound, key, id)
+}
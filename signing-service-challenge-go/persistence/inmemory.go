@@ -2,38 +2,165 @@ package persistence
 
 import (
 	"errors"
+	"sync"
+	"time"
+
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
 )
 
+// InMemoryDB keeps copies of devices in a map, so callers must Save to make changes visible
 type InMemoryDB struct {
-	DeviceMap map[string]*domain.Device
+	DeviceMap   map[string]*domain.Device
+	ledgers     map[string]ledger
+	idempotency idempotencyIndex
+	mu          sync.RWMutex
+}
+
+// saveLocked stores a copy of @data as the next version, caller must hold the write lock
+func (db *InMemoryDB) saveLocked(id string, data *domain.Device, currentVersion int) {
+	data.Version = currentVersion + 1
+	device := *data
+	db.DeviceMap[id] = &device
+	updateLedger(db.ledgers, id, &device, nil)
+	db.idempotency.truncate(id, device.SignatureCounter)
 }
 
 func (db *InMemoryDB) Save(id string, data *domain.Device) error {
-	db.DeviceMap[id] = data
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	currentVersion := 0
+	if current, ok := db.DeviceMap[id]; ok {
+		currentVersion = current.Version
+	}
+	db.saveLocked(id, data, currentVersion)
+	return nil
+}
+
+func (db *InMemoryDB) CompareAndSave(id string, data *domain.Device, expectedVersion int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	currentVersion := 0
+	if current, ok := db.DeviceMap[id]; ok {
+		currentVersion = current.Version
+	}
+	if currentVersion != expectedVersion {
+		return &VersionConflictError{ID: id, Expected: expectedVersion, Actual: currentVersion}
+	}
+	db.saveLocked(id, data, currentVersion)
+	return nil
+}
+
+func (db *InMemoryDB) CompareAndSaveWithTransaction(id string, data *domain.Device, expectedVersion int, transaction *domain.Transaction, idempotency *domain.IdempotencyRecord) error {
+	if err := checkTransaction(id, data, transaction); err != nil {
+		return err
+	}
+	if err := checkIdempotencyRecord(id, transaction, idempotency); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	currentVersion := 0
+	if current, ok := db.DeviceMap[id]; ok {
+		currentVersion = current.Version
+	}
+	if currentVersion != expectedVersion {
+		return &VersionConflictError{ID: id, Expected: expectedVersion, Actual: currentVersion}
+	}
+	db.saveLocked(id, data, currentVersion)
+	updateLedger(db.ledgers, id, data, transaction)
+	if idempotency != nil {
+		db.idempotency.put(id, idempotency, transaction.CreatedAt)
+	}
 	return nil
 }
 
 func (db *InMemoryDB) Load(id string) (*domain.Device, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	if data, ok := db.DeviceMap[id]; ok {
-		return data, nil
+		device := *data
+		return &device, nil
 	} else {
 		return nil, errors.New("Device with id " + id + " not found")
 	}
 }
 
 func (db *InMemoryDB) List() []*domain.Device {
-	devices := []*domain.Device{}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return sortedDevices(db.DeviceMap)
+}
+
+func (db *InMemoryDB) ListDevices(query domain.DeviceQuery) ([]*domain.Device, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return queryDevices(db.DeviceMap, query), nil
+}
+
+func (db *InMemoryDB) Delete(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.DeviceMap[id]; !ok {
+		return errors.New("Device with id " + id + " not found")
+	}
+	delete(db.DeviceMap, id)
+	delete(db.ledgers, id)
+	delete(db.idempotency, id)
+	return nil
+}
 
-	for _, device := range db.DeviceMap {
-		devices = append(devices, device)
+func (db *InMemoryDB) LoadTransaction(id string, counter int) (*domain.Transaction, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if transaction, ok := db.ledgers[id].find(counter); ok {
+		return transaction, nil
 	}
+	return nil, transactionNotFound(id, counter)
+}
+
+func (db *InMemoryDB) ListTransactions(id string, from int, limit int) ([]*domain.Transaction, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
-	return devices
+	if _, ok := db.DeviceMap[id]; !ok {
+		return nil, errors.New("Device with id " + id + " not found")
+	}
+	return db.ledgers[id].page(from, limit), nil
+}
+
+func (db *InMemoryDB) LoadIdempotencyRecord(id string, key string, now time.Time) (*domain.IdempotencyRecord, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if record, ok := db.idempotency.find(id, key, now); ok {
+		return record, nil
+	}
+	return nil, idempotencyRecordNotFound(id, key)
+}
+
+func (db *InMemoryDB) ListIdempotencyRecords(id string, now time.Time) ([]*domain.IdempotencyRecord, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if _, ok := db.DeviceMap[id]; !ok {
+		return nil, errors.New("Device with id " + id + " not found")
+	}
+	return db.idempotency.list(id, now), nil
 }
 
 func NewInMemoryDB() *InMemoryDB {
 	return &InMemoryDB{
-		DeviceMap: make(map[string]*domain.Device),
+		DeviceMap:   make(map[string]*domain.Device),
+		ledgers:     make(map[string]ledger),
+		idempotency: make(idempotencyIndex),
 	}
 }
@@ -77,3 +77,161 @@ func TestList(t *testing.T) {
 		})
 	})
 }
+
+func TestCompareAndSave(t *testing.T) {
+	Convey("Given an InMemoryDB instance", t, func() {
+		db := NewInMemoryDB()
+
+		Convey("a new device can only be saved expecting version 0", func() {
+			d := &domain.Device{ID: "a"}
+
+			err := db.CompareAndSave(d.ID, d, 1)
+			So(err, ShouldHaveSameTypeAs, &VersionConflictError{})
+
+			err = db.CompareAndSave(d.ID, d, 0)
+			So(err, ShouldBeNil)
+			So(d.Version, ShouldEqual, 1)
+		})
+
+		Convey("and once saved", func() {
+			d := &domain.Device{ID: "a"}
+			So(db.Save(d.ID, d), ShouldBeNil)
+
+			Convey("saving with the loaded version succeeds and bumps the version", func() {
+				loaded, _ := db.Load(d.ID)
+				loaded.SignatureCounter++
+
+				So(db.CompareAndSave(d.ID, loaded, loaded.Version), ShouldBeNil)
+				So(loaded.Version, ShouldEqual, 2)
+
+				stored, _ := db.Load(d.ID)
+				So(stored.SignatureCounter, ShouldEqual, 1)
+			})
+
+			Convey("saving with a stale version fails with a conflict and leaves the device untouched", func() {
+				first, _ := db.Load(d.ID)
+				second, _ := db.Load(d.ID)
+
+				first.SignatureCounter = 1
+				So(db.CompareAndSave(d.ID, first, first.Version), ShouldBeNil)
+
+				second.SignatureCounter = 2
+				err := db.CompareAndSave(d.ID, second, second.Version)
+				So(err, ShouldNotBeNil)
+
+				conflict, ok := err.(*VersionConflictError)
+				So(ok, ShouldBeTrue)
+				So(conflict.Expected, ShouldEqual, 1)
+				So(conflict.Actual, ShouldEqual, 2)
+
+				stored, _ := db.Load(d.ID)
+				So(stored.SignatureCounter, ShouldEqual, 1)
+			})
+
+			Convey("modifying the loaded device without saving is not visible", func() {
+				loaded, _ := db.Load(d.ID)
+				loaded.SignatureCounter = 100
+
+				stored, _ := db.Load(d.ID)
+				So(stored.SignatureCounter, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestDelete(t *testing.T) {
+	Convey("Given an InMemoryDB instance with a saved device", t, func() {
+		db := NewInMemoryDB()
+		So(db.Save("a", &domain.Device{ID: "a"}), ShouldBeNil)
+
+		Convey("deleting it makes it unloadable", func() {
+			So(db.Delete("a"), ShouldBeNil)
+
+			_, err := db.Load("a")
+			So(err, ShouldNotBeNil)
+			So(db.List(), ShouldBeEmpty)
+
+			Convey("and it can be created again from version 0", func() {
+				So(db.CompareAndSave("a", &domain.Device{ID: "a"}, 0), ShouldBeNil)
+			})
+		})
+
+		Convey("deleting an unknown id returns an error", func() {
+			So(db.Delete("b"), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestTransactions(t *testing.T) {
+	Convey("Given an InMemoryDB instance with a device that signed twice", t, func() {
+		db := NewInMemoryDB()
+		d := &domain.Device{ID: "a"}
+		So(db.CompareAndSave(d.ID, d, 0), ShouldBeNil)
+		for counter := 0; counter < 2; counter++ {
+			expectedVersion := d.Version
+			d.SignatureCounter++
+			So(db.CompareAndSaveWithTransaction(d.ID, d, expectedVersion, &domain.Transaction{DeviceID: "a", Counter: counter, Data: "data"}, nil), ShouldBeNil)
+		}
+
+		Convey("every transaction can be loaded by its counter", func() {
+			transaction, err := db.LoadTransaction("a", 1)
+			So(err, ShouldBeNil)
+			So(transaction.Counter, ShouldEqual, 1)
+
+			_, err = db.LoadTransaction("a", 2)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("and listed in pages", func() {
+			transactions, err := db.ListTransactions("a", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldHaveLength, 2)
+
+			transactions, err = db.ListTransactions("a", 1, 1)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldHaveLength, 1)
+			So(transactions[0].Counter, ShouldEqual, 1)
+
+			transactions, err = db.ListTransactions("a", 5, 1)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldBeEmpty)
+
+			_, err = db.ListTransactions("b", 0, 0)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a transaction not matching the signature counter is rejected and nothing is saved", func() {
+			expectedVersion := d.Version
+			d.SignatureCounter++
+			err := db.CompareAndSaveWithTransaction(d.ID, d, expectedVersion, &domain.Transaction{DeviceID: "a", Counter: 7}, nil)
+			So(err, ShouldNotBeNil)
+
+			stored, _ := db.Load("a")
+			So(stored.SignatureCounter, ShouldEqual, 2)
+		})
+
+		Convey("a conflicting save stores no transaction", func() {
+			d.SignatureCounter++
+			err := db.CompareAndSaveWithTransaction(d.ID, d, 1, &domain.Transaction{DeviceID: "a", Counter: 2}, nil)
+			So(err, ShouldHaveSameTypeAs, &VersionConflictError{})
+
+			_, err = db.LoadTransaction("a", 2)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("replacing the device with a reset counter discards its transactions", func() {
+			So(db.Save("a", &domain.Device{ID: "a"}), ShouldBeNil)
+
+			transactions, err := db.ListTransactions("a", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldBeEmpty)
+		})
+
+		Convey("deleting the device deletes its transactions", func() {
+			So(db.Delete("a"), ShouldBeNil)
+
+			_, err := db.LoadTransaction("a", 0)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -2,6 +2,13 @@ package persistence
 
 var instance Storage
 
+var keyStore KeyStore = NewInMemoryKeyStore()
+
+var tenantStore TenantStore = NewInMemoryTenantStore()
+
+// Process-wide LockManager, shared by every request touching a device
+var lockManager = NewLockManager()
+
 // Return the Storage instance
 func GetInstance() Storage {
 	return instance
@@ -12,6 +19,31 @@ func SetInstance(newInstance Storage) {
 	instance = newInstance
 }
 
+// Return the KeyStore instance
+func GetKeyStore() KeyStore {
+	return keyStore
+}
+
+// Replace the KeyStore instance
+func SetKeyStore(newKeyStore KeyStore) {
+	keyStore = newKeyStore
+}
+
+// Return the TenantStore instance
+func GetTenantStore() TenantStore {
+	return tenantStore
+}
+
+// Replace the TenantStore instance
+func SetTenantStore(newTenantStore TenantStore) {
+	tenantStore = newTenantStore
+}
+
+// Return the process-wide LockManager
+func GetLockManager() *LockManager {
+	return lockManager
+}
+
 func init() {
 	// If you need to change the Storage implementation, change this
 	instance = NewInMemoryDB()
@@ -1,14 +1,46 @@
 package persistence
 
 import (
+	"time"
+
 	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
 )
 
 type Storage interface {
-	// Save Device @data to underlying storage with id @id, may return an error on failure
+	// Save Device @data to underlying storage with id @id unconditionally, may return an error on failure.
+	// @data.Version is set to the new stored version. Transactions of the device from @data.SignatureCounter
+	// on are discarded, they belong to a signature chain the device does not continue (e.g. its counter was reset).
 	Save(id string, data *domain.Device) error
+	// CompareAndSave saves Device @data with id @id only if the stored version is still @expectedVersion
+	// (0 if it must not exist yet), otherwise returns a *VersionConflictError. On success @data.Version is
+	// set to the new stored version. Transactions are discarded as in Save.
+	CompareAndSave(id string, data *domain.Device, expectedVersion int) error
+	// CompareAndSaveWithTransaction is CompareAndSave that also appends @transaction to the transactions of
+	// the device and, unless it is nil, stores @idempotency next to it: all are stored or none. @transaction
+	// must be the one that brought the device to its signature counter, i.e. its counter is
+	// @data.SignatureCounter - 1, @idempotency the record of the request that signed it. A record of the same
+	// idempotency key is replaced, ones expired before @transaction was created may be dropped.
+	CompareAndSaveWithTransaction(id string, data *domain.Device, expectedVersion int, transaction *domain.Transaction, idempotency *domain.IdempotencyRecord) error
 	// Load Device from underlying storage with id @id, may return an error on failure such as no Device with given id exists
 	Load(id string) (*domain.Device, error)
-	// List all Device-s
+	// List all Device-s ordered by id
 	List() []*domain.Device
+	// ListDevices lists the Device-s selected by @query ordered by device ID, see domain.DeviceQuery
+	ListDevices(query domain.DeviceQuery) ([]*domain.Device, error)
+	// Delete Device with id @id and its transactions from underlying storage, returns an error if no Device
+	// with given id exists
+	Delete(id string) error
+	// LoadTransaction loads the transaction with counter @counter of Device with id @id, returns an error if
+	// it does not exist
+	LoadTransaction(id string, counter int) (*domain.Transaction, error)
+	// ListTransactions lists up to @limit (0 = no limit) transactions of Device with id @id ordered by counter,
+	// starting at counter @from. Returns an error if no Device with given id exists.
+	ListTransactions(id string, from int, limit int) ([]*domain.Transaction, error)
+	// LoadIdempotencyRecord loads the idempotency record of key @key of Device with id @id, returns an error
+	// wrapping ErrIdempotencyKeyNotFound if there is none or it expired before @now. Records go along with
+	// their transactions when these are discarded or the device is deleted.
+	LoadIdempotencyRecord(id string, key string, now time.Time) (*domain.IdempotencyRecord, error)
+	// ListIdempotencyRecords lists the idempotency records of Device with id @id not expired before @now,
+	// ordered by counter
+	ListIdempotencyRecords(id string, now time.Time) ([]*domain.IdempotencyRecord, error)
 }
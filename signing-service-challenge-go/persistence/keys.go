@@ -0,0 +1,164 @@
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+)
+
+// KeyStore keeps API keys, which hold only the hash of the key itself
+type KeyStore interface {
+	// SaveKey saves API key @key, replacing the one with the same ID if any
+	SaveKey(key *domain.APIKey) error
+	// LoadKey loads the API key with ID @id, returns an error if it does not exist
+	LoadKey(id string) (*domain.APIKey, error)
+	// ListKeys lists all API keys ordered by ID
+	ListKeys() []*domain.APIKey
+	// DeleteKey deletes the API key with ID @id, returns an error if it does not exist
+	DeleteKey(id string) error
+}
+
+// InMemoryKeyStore keeps copies of API keys in a map, lost on restart
+type InMemoryKeyStore struct {
+	keys map[string]*domain.APIKey
+	mu   sync.RWMutex
+}
+
+func NewInMemoryKeyStore() *InMemoryKeyStore {
+	return &InMemoryKeyStore{
+		keys: make(map[string]*domain.APIKey),
+	}
+}
+
+func (s *InMemoryKeyStore) SaveKey(key *domain.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key.ID] = copyKey(key)
+	return nil
+}
+
+func (s *InMemoryKeyStore) LoadKey(id string) (*domain.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, errors.New("API key with id " + id + " not found")
+	}
+	return copyKey(key), nil
+}
+
+func (s *InMemoryKeyStore) ListKeys() []*domain.APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]*domain.APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, copyKey(key))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+func (s *InMemoryKeyStore) DeleteKey(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[id]; !ok {
+		return errors.New("API key with id " + id + " not found")
+	}
+	delete(s.keys, id)
+	return nil
+}
+
+// FileKeyStore is an InMemoryKeyStore that rewrites a JSON file on every change. Keys change rarely, so
+// rewriting them all is simpler than keeping a log like FileDB does.
+type FileKeyStore struct {
+	InMemoryKeyStore
+	path string
+}
+
+// NewFileKeyStore opens the key store kept in file @path, which is created on the first change if missing
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	s := &FileKeyStore{
+		InMemoryKeyStore: *NewInMemoryKeyStore(),
+		path:             path,
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*domain.APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, errors.New("Corrupt API key file " + path + ": " + err.Error())
+	}
+	for _, key := range keys {
+		s.keys[key.ID] = key
+	}
+	return s, nil
+}
+
+func (s *FileKeyStore) SaveKey(key *domain.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.keys[key.ID]
+	s.keys[key.ID] = copyKey(key)
+	if err := s.persistLocked(); err != nil {
+		if existed {
+			s.keys[key.ID] = previous
+		} else {
+			delete(s.keys, key.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileKeyStore) DeleteKey(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, ok := s.keys[id]
+	if !ok {
+		return errors.New("API key with id " + id + " not found")
+	}
+	delete(s.keys, id)
+	if err := s.persistLocked(); err != nil {
+		s.keys[id] = previous
+		return err
+	}
+	return nil
+}
+
+// persistLocked replaces the file with the current keys, caller must hold the write lock
+func (s *FileKeyStore) persistLocked() error {
+	keys := make([]*domain.APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(s.path, data)
+}
+
+func copyKey(key *domain.APIKey) *domain.APIKey {
+	copied := *key
+	copied.DeviceIDs = append([]string(nil), key.DeviceIDs...)
+	copied.Operations = append([]string(nil), key.Operations...)
+	return &copied
+}
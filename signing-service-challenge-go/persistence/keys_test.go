@@ -0,0 +1,85 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKeyStores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	stores := map[string]func() KeyStore{
+		"InMemoryKeyStore": func() KeyStore { return NewInMemoryKeyStore() },
+		"FileKeyStore": func() KeyStore {
+			os.Remove(path)
+			s, err := NewFileKeyStore(path)
+			So(err, ShouldBeNil)
+			return s
+		},
+	}
+
+	for name, newStore := range stores {
+		Convey("Given an empty "+name, t, func() {
+			s := newStore()
+
+			Convey("saved keys are loaded and listed as copies", func() {
+				key := &domain.APIKey{ID: "b", Hash: "hash", DeviceIDs: []string{"a"}, Operations: []string{"sign"}}
+				So(s.SaveKey(key), ShouldBeNil)
+				So(s.SaveKey(&domain.APIKey{ID: "a", Hash: "other"}), ShouldBeNil)
+				key.DeviceIDs[0] = "changed"
+
+				loaded, err := s.LoadKey("b")
+				So(err, ShouldBeNil)
+				So(loaded.DeviceIDs, ShouldResemble, []string{"a"})
+				loaded.Operations[0] = "changed"
+
+				keys := s.ListKeys()
+				So(keys, ShouldHaveLength, 2)
+				So(keys[0].ID, ShouldEqual, "a")
+				So(keys[1].Operations, ShouldResemble, []string{"sign"})
+			})
+
+			Convey("deleted keys are gone", func() {
+				So(s.SaveKey(&domain.APIKey{ID: "a"}), ShouldBeNil)
+				So(s.DeleteKey("a"), ShouldBeNil)
+				_, err := s.LoadKey("a")
+				So(err, ShouldNotBeNil)
+				So(s.DeleteKey("a"), ShouldNotBeNil)
+			})
+		})
+	}
+
+	Convey("Given a FileKeyStore with keys", t, func() {
+		os.Remove(path)
+		s, err := NewFileKeyStore(path)
+		So(err, ShouldBeNil)
+		So(s.SaveKey(&domain.APIKey{ID: "a", Hash: "hash-a"}), ShouldBeNil)
+		So(s.SaveKey(&domain.APIKey{ID: "b", Hash: "hash-b"}), ShouldBeNil)
+		So(s.DeleteKey("b"), ShouldBeNil)
+
+		Convey("reopening it restores them", func() {
+			reopened, err := NewFileKeyStore(path)
+			So(err, ShouldBeNil)
+			keys := reopened.ListKeys()
+			So(keys, ShouldHaveLength, 1)
+			So(keys[0].Hash, ShouldEqual, "hash-a")
+		})
+
+		Convey("a corrupt file is reported", func() {
+			So(os.WriteFile(path, []byte("{"), 0o600), ShouldBeNil)
+			_, err := NewFileKeyStore(path)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a failed write leaves the keys as they were", func() {
+			s.path = filepath.Join(path, "not-a-directory", "api_keys.json")
+			So(s.SaveKey(&domain.APIKey{ID: "c"}), ShouldNotBeNil)
+			So(s.DeleteKey("a"), ShouldNotBeNil)
+			So(s.ListKeys(), ShouldHaveLength, 1)
+			So(s.ListKeys()[0].ID, ShouldEqual, "a")
+		})
+	})
+}
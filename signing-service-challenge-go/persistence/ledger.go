@@ -0,0 +1,86 @@
+package persistence
+
+import (
+	"fmt"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+)
+
+// ledger is the append-only transaction history of a single device, ordered by counter without gaps. It starts
+// past counter 0 for devices that already signed before transactions were kept.
+type ledger []*domain.Transaction
+
+// truncate drops every transaction from counter @counter on
+func (l ledger) truncate(counter int) ledger {
+	for len(l) > 0 && l[len(l)-1].Counter >= counter {
+		l[len(l)-1] = nil
+		l = l[:len(l)-1]
+	}
+	return l
+}
+
+// put appends a copy of @transaction, replacing any transaction from its counter on, so applying the same
+// transaction twice is harmless
+func (l ledger) put(transaction *domain.Transaction) ledger {
+	stored := *transaction
+	return append(l.truncate(stored.Counter), &stored)
+}
+
+// find returns a copy of the transaction with counter @counter
+func (l ledger) find(counter int) (*domain.Transaction, bool) {
+	if len(l) == 0 {
+		return nil, false
+	}
+	i := counter - l[0].Counter
+	if i < 0 || i >= len(l) {
+		return nil, false
+	}
+	transaction := *l[i]
+	return &transaction, true
+}
+
+// page returns copies of up to @limit (0 = no limit) transactions starting at counter @from
+func (l ledger) page(from int, limit int) []*domain.Transaction {
+	start := 0
+	if len(l) > 0 && from > l[0].Counter {
+		start = min(from-l[0].Counter, len(l))
+	}
+	end := len(l)
+	if limit > 0 {
+		end = min(start+limit, end)
+	}
+
+	transactions := make([]*domain.Transaction, 0, end-start)
+	for _, stored := range l[start:end] {
+		transaction := *stored
+		transactions = append(transactions, &transaction)
+	}
+	return transactions
+}
+
+// updateLedger stores the ledger of device @id in @ledgers after it was saved as @device, discarding
+// transactions past its signature counter and appending @transaction unless it is nil
+func updateLedger(ledgers map[string]ledger, id string, device *domain.Device, transaction *domain.Transaction) {
+	transactions := ledgers[id].truncate(device.SignatureCounter)
+	if transaction != nil {
+		transactions = transactions.put(transaction)
+	}
+
+	if len(transactions) > 0 {
+		ledgers[id] = transactions
+	} else {
+		delete(ledgers, id)
+	}
+}
+
+// checkTransaction verifies @transaction is the one that brought device @data with id @id to its signature counter
+func checkTransaction(id string, data *domain.Device, transaction *domain.Transaction) error {
+	if transaction.DeviceID != data.ID || transaction.Counter != data.SignatureCounter-1 {
+		return fmt.Errorf("Transaction %d of device %s does not match its signature counter %d", transaction.Counter, id, data.SignatureCounter)
+	}
+	return nil
+}
+
+func transactionNotFound(id string, counter int) error {
+	return fmt.Errorf("Transaction %d of device %s not found", counter, id)
+}
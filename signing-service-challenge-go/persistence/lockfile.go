@@ -0,0 +1,26 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrStorageLocked is wrapped by the error of opening a durable Storage another one, possibly of another
+// process such as a running server, holds open
+var ErrStorageLocked = errors.New("Storage is in use by another process")
+
+// lockStorageFile creates (if necessary) and exclusively locks file @path, so a single Storage at a time
+// works on the files next to it. The lock is released by closing the returned file, or when the process
+// ends, so a crash leaves no stale lock behind.
+func lockStorageFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w, %s is locked: %v", ErrStorageLocked, path, err)
+	}
+	return file, nil
+}
@@ -0,0 +1,108 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultLockTimeout bounds how long a request waits for a busy device before giving up
+const DefaultLockTimeout = 5 * time.Second
+
+// lockEntry is a per id binary semaphore, channel based so acquisition can be abandoned
+type lockEntry struct {
+	sem  chan struct{}
+	refs int // number of goroutines holding or waiting for the lock
+}
+
+// LockManager hands out per id locks. Entries only live while someone holds or waits for them, so ids
+// that go idle take no memory. A single LockManager must be shared by everyone touching the same ids.
+type LockManager struct {
+	mu      sync.Mutex
+	entries map[string]*lockEntry
+}
+
+// NewLockManager creates an empty LockManager
+func NewLockManager() *LockManager {
+	return &LockManager{
+		entries: make(map[string]*lockEntry),
+	}
+}
+
+// acquireEntry returns the entry of @id, creating it if needed, and registers interest in it
+func (lm *LockManager) acquireEntry(id string) *lockEntry {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	entry, ok := lm.entries[id]
+	if !ok {
+		entry = &lockEntry{sem: make(chan struct{}, 1)}
+		lm.entries[id] = entry
+	}
+	entry.refs++
+	return entry
+}
+
+// releaseEntry drops interest in the entry of @id, removing it once nobody needs it anymore
+func (lm *LockManager) releaseEntry(id string, entry *lockEntry) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	entry.refs--
+	if entry.refs == 0 {
+		delete(lm.entries, id)
+	}
+}
+
+// Lock blocks until @id is locked by the caller
+func (lm *LockManager) Lock(id string) {
+	lm.LockContext(context.Background(), id)
+}
+
+// LockContext blocks until @id is locked by the caller or @ctx is done, in which case the lock is not
+// held and ctx.Err() is returned
+func (lm *LockManager) LockContext(ctx context.Context, id string) error {
+	entry := lm.acquireEntry(id)
+
+	select {
+	case entry.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		lm.releaseEntry(id, entry)
+		return ctx.Err()
+	}
+}
+
+// LockTimeout is LockContext with a deadline of @timeout from now
+func (lm *LockManager) LockTimeout(id string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return lm.LockContext(ctx, id)
+}
+
+// Unlock releases @id previously locked by Lock or a successful LockContext
+func (lm *LockManager) Unlock(id string) {
+	lm.mu.Lock()
+	entry, ok := lm.entries[id]
+	lm.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	select {
+	case <-entry.sem:
+		lm.releaseEntry(id, entry)
+	default:
+		// not locked, only waited for, nothing to release
+	}
+}
+
+// Len returns the number of ids currently locked or waited for
+func (lm *LockManager) Len() int {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	return len(lm.entries)
+}
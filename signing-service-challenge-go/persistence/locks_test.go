@@ -0,0 +1,82 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLockManager(t *testing.T) {
+	Convey("Given a LockManager", t, func() {
+		lm := NewLockManager()
+
+		Convey("LockContext gives up when the context is done", func() {
+			lm.Lock("a")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			err := lm.LockContext(ctx, "a")
+
+			So(err, ShouldEqual, context.DeadlineExceeded)
+
+			Convey("and the abandoned attempt does not hold the lock", func() {
+				lm.Unlock("a")
+				So(lm.LockTimeout("a", 20*time.Millisecond), ShouldBeNil)
+				lm.Unlock("a")
+			})
+		})
+
+		Convey("LockTimeout succeeds once the holder unlocks", func() {
+			lm.Lock("a")
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				lm.Unlock("a")
+			}()
+
+			So(lm.LockTimeout("a", time.Second), ShouldBeNil)
+			lm.Unlock("a")
+		})
+
+		Convey("entries of idle ids are removed", func() {
+			lm.Lock("a")
+			lm.Lock("b")
+			So(lm.Len(), ShouldEqual, 2)
+
+			lm.Unlock("a")
+			lm.Unlock("b")
+			So(lm.Len(), ShouldEqual, 0)
+		})
+
+		Convey("Unlock of an id that is not locked is a no-op", func() {
+			lm.Unlock("nobody")
+			So(lm.Len(), ShouldEqual, 0)
+		})
+
+		Convey("concurrent critical sections over the same id never overlap", func() {
+			var wg sync.WaitGroup
+			inside, counter := 0, 0
+
+			for i := 0; i < 200; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					lm.Lock("a")
+					defer lm.Unlock("a")
+
+					inside++
+					if inside == 1 {
+						counter++
+					}
+					inside--
+				}()
+			}
+			wg.Wait()
+
+			So(counter, ShouldEqual, 200)
+			So(lm.Len(), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,252 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/chain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+)
+
+// DefaultMigrationBatchSize is the number of transactions Migrate reads at once when no batch size is given
+const DefaultMigrationBatchSize = 1000
+
+// MigrateOptions tunes Migrate
+type MigrateOptions struct {
+	// Number of transactions read from the source at once, 0 = DefaultMigrationBatchSize
+	BatchSize int
+	// Verify checks @device as copied to the destination, with its last transaction unless it has none. A
+	// device failing it is reported as a mismatch. nil = no check.
+	Verify func(device *domain.Device, last *domain.Transaction) error
+	// Progress is called after every device with the report so far, nil = not called
+	Progress func(report *MigrationReport)
+	// Idempotency records expired before Now are not copied, zero = when Migrate starts
+	Now time.Time
+}
+
+// MigrationStores are the stores Migrate copies from or into
+type MigrationStores struct {
+	Storage Storage
+	Tenants TenantStore
+	Keys    KeyStore
+}
+
+// MigrationMismatch is a device Migrate could not copy or verify
+type MigrationMismatch struct {
+	// Storage key, see domain.DeviceKey
+	Key    string
+	Reason string
+}
+
+// MigrationReport tells what Migrate did
+type MigrationReport struct {
+	// Number of devices of the source
+	Devices int
+	// Devices copied, from scratch or from where an earlier migration stopped
+	Copied int
+	// Of Copied, the ones an earlier migration had started
+	Resumed int
+	// Devices an earlier migration had already copied entirely
+	Skipped int
+	// Transactions copied
+	Transactions int
+	// Tenants and API keys copied, every one of the source each time
+	Tenants int
+	APIKeys int
+	// Devices not copied or failing verification, they are left in the destination as they are
+	Mismatches []MigrationMismatch
+}
+
+// Migrate copies every tenant, every device, transactions included, and every API key of @source into
+// @destination, reading transactions in batches. Devices keep their storage keys and signature chains. Each
+// device is copied under its lock, so the source may keep being used meanwhile. Migrate can be run again after
+// an interruption: devices already copied are skipped and a device copied partly is continued, tenants and API
+// keys are copied again, replacing the ones with the same ID. A device that cannot be copied, e.g. because the
+// destination has another device under its key, is reported as a mismatch and the others are still copied.
+// Returns an error when a store is missing or a tenant or API key cannot be saved, API keys are then not
+// copied before every tenant and device was.
+func Migrate(source MigrationStores, destination MigrationStores, options MigrateOptions) (*MigrationReport, error) {
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultMigrationBatchSize
+	}
+	if options.Now.IsZero() {
+		options.Now = time.Now()
+	}
+	for _, stores := range []MigrationStores{source, destination} {
+		if stores.Storage == nil || stores.Tenants == nil || stores.Keys == nil {
+			return nil, errors.New("Migrating needs a storage, a tenant store and an API key store to copy from and into")
+		}
+	}
+
+	report := &MigrationReport{Mismatches: []MigrationMismatch{}}
+	// tenants go first and API keys last, so a key is never usable in the destination before its devices are
+	for _, tenant := range source.Tenants.ListTenants() {
+		if err := destination.Tenants.SaveTenant(tenant); err != nil {
+			return nil, errors.New("Could not copy tenant " + tenant.ID + ": " + err.Error())
+		}
+		report.Tenants++
+	}
+
+	as := NewAtomicStorage(source.Storage)
+	for _, listed := range source.Storage.List() {
+		report.Devices++
+		if err := migrateDevice(as, destination.Storage, listed.Key(), options, report); err != nil {
+			report.Mismatches = append(report.Mismatches, MigrationMismatch{Key: listed.Key(), Reason: err.Error()})
+		}
+		if options.Progress != nil {
+			options.Progress(report)
+		}
+	}
+	for _, key := range source.Keys.ListKeys() {
+		if err := destination.Keys.SaveKey(key); err != nil {
+			return nil, errors.New("Could not copy API key " + key.ID + ": " + err.Error())
+		}
+		report.APIKeys++
+	}
+	return report, nil
+}
+
+// migrateDevice copies device @key of @source into @destination, continuing what is there already, and
+// counts it in @report
+func migrateDevice(source *AtomicStorage, destination Storage, key string, options MigrateOptions, report *MigrationReport) error {
+	source.Lock(key)
+	defer source.Unlock(key)
+
+	device, err := source.Load(key)
+	if err != nil {
+		return err
+	}
+	first, err := source.ListTransactions(key, 0, 1)
+	if err != nil {
+		return err
+	}
+	records, err := source.ListIdempotencyRecords(key, options.Now)
+	if err != nil {
+		return err
+	}
+	idempotency := make(map[int]*domain.IdempotencyRecord, len(records))
+	for _, record := range records {
+		idempotency[record.Counter] = record
+	}
+
+	// the device as it is in the destination, saved once per transaction as it was when it signed it
+	step := *device
+	existing, err := destination.Load(key)
+	if err != nil {
+		step.Version = 0
+		if len(first) > 0 {
+			step.SignatureCounter = first[0].Counter
+			if previous, ok := chain.PreviousSignatureOf(first[0]); ok {
+				step.LastSignature = previous
+			}
+		}
+		if err := destination.CompareAndSave(key, &step, 0); err != nil {
+			return err
+		}
+	} else {
+		if err := checkResumable(source, key, existing, device); err != nil {
+			return err
+		}
+		if sameDevice(existing, device) {
+			report.Skipped++
+			return verifyMigratedDevice(destination, key, options)
+		}
+		step.Version = existing.Version
+		step.SignatureCounter = existing.SignatureCounter
+		step.LastSignature = existing.LastSignature
+		report.Resumed++
+	}
+
+	for step.SignatureCounter < device.SignatureCounter {
+		transactions, err := source.ListTransactions(key, step.SignatureCounter, options.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(transactions) == 0 || transactions[0].Counter != step.SignatureCounter {
+			return fmt.Errorf("Transaction %d is missing in the source", step.SignatureCounter)
+		}
+		for _, transaction := range transactions {
+			if err := replayTransaction(destination, key, &step, transaction, idempotency[transaction.Counter]); err != nil {
+				return err
+			}
+			report.Transactions++
+		}
+	}
+
+	copied := *device
+	if err := destination.CompareAndSave(key, &copied, step.Version); err != nil {
+		return err
+	}
+	report.Copied++
+	return verifyMigratedDevice(destination, key, options)
+}
+
+// checkResumable checks @existing, in the destination under @key, is @device of @source copied partly: the
+// same device, whose signature chain the source continues where the destination stopped. Only the
+// transactions copied so far must match, the key or anything else of the device may have changed since,
+// e.g. by a rotation or a rewrap, as the device is copied again anyway.
+func checkResumable(source Storage, key string, existing *domain.Device, device *domain.Device) error {
+	if existing.ID != device.ID || existing.TenantID != device.TenantID {
+		return errors.New("The destination has another device under this key")
+	}
+	if existing.SignatureCounter > device.SignatureCounter {
+		return fmt.Errorf("The destination is at counter %d, past counter %d of the source", existing.SignatureCounter, device.SignatureCounter)
+	}
+	diverges := fmt.Errorf("The destination does not continue the signature chain of the source at counter %d", existing.SignatureCounter)
+	if existing.SignatureCounter == device.SignatureCounter {
+		if existing.LastSignature != device.LastSignature {
+			return diverges
+		}
+		return nil
+	}
+
+	// either transaction may be missing where the ledger of the source starts later
+	if next, err := source.LoadTransaction(key, existing.SignatureCounter); err == nil {
+		if previous, ok := chain.PreviousSignatureOf(next); ok && previous != existing.LastSignature {
+			return diverges
+		}
+	}
+	if existing.SignatureCounter > 0 {
+		if last, err := source.LoadTransaction(key, existing.SignatureCounter-1); err == nil && last.Signature != existing.LastSignature {
+			return diverges
+		}
+	}
+	return nil
+}
+
+// sameDevice tells whether @a and @b are the same device record, whatever their storage version
+func sameDevice(a *domain.Device, b *domain.Device) bool {
+	aCopy, bCopy := *a, *b
+	aCopy.Version, bCopy.Version = 0, 0
+	aJSON, aErr := json.Marshal(&aCopy)
+	bJSON, bErr := json.Marshal(&bCopy)
+	return aErr == nil && bErr == nil && bytes.Equal(aJSON, bJSON)
+}
+
+// verifyMigratedDevice loads device @key from @destination and checks it with options.Verify
+func verifyMigratedDevice(destination Storage, key string, options MigrateOptions) error {
+	if options.Verify == nil {
+		return nil
+	}
+	device, err := destination.Load(key)
+	if err != nil {
+		return err
+	}
+	var last *domain.Transaction
+	if device.SignatureCounter > 0 {
+		last, _ = destination.LoadTransaction(key, device.SignatureCounter-1) // none for ledgers that start later
+	}
+	return options.Verify(device, last)
+}
+
+// replayTransaction appends @transaction with its @idempotency record, unless it is nil, to device @key of
+// @db, *@step being the device as stored before it, which is advanced to the state the device had right after
+// signing it
+func replayTransaction(db Storage, key string, step *domain.Device, transaction *domain.Transaction, idempotency *domain.IdempotencyRecord) error {
+	step.SignatureCounter = transaction.Counter + 1
+	step.LastSignature = transaction.Signature
+	return db.CompareAndSaveWithTransaction(key, step, step.Version, transaction, idempotency)
+}
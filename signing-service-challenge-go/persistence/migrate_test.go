@@ -0,0 +1,189 @@
+package persistence
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMigrate(t *testing.T) {
+	Convey("Given a Storage with devices of two tenants", t, func() {
+		source := NewInMemoryDB()
+		saveSigned(source, "", "a", 5)
+		saveSigned(source, "shop", "a", 0)
+		saveSigned(source, "", "b", 1)
+
+		destination, err := NewSQLiteDB(filepath.Join(t.TempDir(), "signing.db"))
+		So(err, ShouldBeNil)
+		defer destination.Close()
+		tenants := NewInMemoryTenantStore()
+		So(tenants.SaveTenant(&domain.Tenant{ID: "shop", Name: "Shop"}), ShouldBeNil)
+		keys := NewInMemoryKeyStore()
+		So(keys.SaveKey(&domain.APIKey{ID: "key-1", TenantID: "shop", DeviceIDs: []string{"a"}}), ShouldBeNil)
+		from := MigrationStores{Storage: source, Tenants: tenants, Keys: keys}
+		to := MigrationStores{Storage: destination, Tenants: NewInMemoryTenantStore(), Keys: NewInMemoryKeyStore()}
+
+		sameAsSource := func() {
+			for _, listed := range source.List() {
+				device, err := destination.Load(listed.Key())
+				So(err, ShouldBeNil)
+				expected := *listed
+				expected.Version = device.Version
+				So(device, ShouldResemble, &expected)
+
+				transactions, err := source.ListTransactions(listed.Key(), 0, 0)
+				So(err, ShouldBeNil)
+				copied, err := destination.ListTransactions(listed.Key(), 0, 0)
+				So(err, ShouldBeNil)
+				So(copied, ShouldHaveLength, len(transactions))
+				for i := range transactions {
+					So(copied[i], ShouldResemble, transactions[i])
+				}
+			}
+		}
+
+		Convey("every device is copied with its transactions, in batches", func() {
+			var verified []string
+			report, err := Migrate(from, to, MigrateOptions{
+				BatchSize: 2,
+				Verify: func(device *domain.Device, last *domain.Transaction) error {
+					if device.SignatureCounter > 0 {
+						So(last.Counter, ShouldEqual, device.SignatureCounter-1)
+					}
+					verified = append(verified, device.Key())
+					return nil
+				},
+			})
+			So(err, ShouldBeNil)
+			So(report, ShouldResemble, &MigrationReport{Tenants: 1, APIKeys: 1, Devices: 3, Copied: 3, Transactions: 6, Mismatches: []MigrationMismatch{}})
+			So(verified, ShouldResemble, []string{"a", "b", "shop/a"})
+			sameAsSource()
+			So(to.Tenants.ListTenants(), ShouldResemble, tenants.ListTenants())
+			So(to.Keys.ListKeys(), ShouldResemble, keys.ListKeys())
+
+			Convey("and migrating again copies nothing more", func() {
+				report, err := Migrate(from, to, MigrateOptions{})
+				So(err, ShouldBeNil)
+				So(report, ShouldResemble, &MigrationReport{Tenants: 1, APIKeys: 1, Devices: 3, Skipped: 3, Mismatches: []MigrationMismatch{}})
+				sameAsSource()
+			})
+
+			Convey("and migrating again copies what was signed since", func() {
+				device, err := source.Load("b")
+				So(err, ShouldBeNil)
+				transaction := &domain.Transaction{DeviceID: "b", Counter: 1, SignedData: "1_data_signature 0", Signature: "signature 1"}
+				device.SignatureCounter, device.LastSignature = 2, "signature 1"
+				So(source.CompareAndSaveWithTransaction("b", device, device.Version, transaction, nil), ShouldBeNil)
+
+				report, err := Migrate(from, to, MigrateOptions{})
+				So(err, ShouldBeNil)
+				So(report, ShouldResemble, &MigrationReport{Tenants: 1, APIKeys: 1, Devices: 3, Copied: 1, Resumed: 1, Skipped: 2, Transactions: 1, Mismatches: []MigrationMismatch{}})
+				sameAsSource()
+			})
+		})
+
+		Convey("a migration interrupted in the middle of a device", func() {
+			transactions, err := source.ListTransactions("a", 0, 2)
+			So(err, ShouldBeNil)
+			partial, err := source.Load("a")
+			So(err, ShouldBeNil)
+			step := *partial
+			step.SignatureCounter, step.LastSignature = 0, "seed"
+			So(destination.CompareAndSave("a", &step, 0), ShouldBeNil)
+			for _, transaction := range transactions {
+				So(replayTransaction(destination, "a", &step, transaction, nil), ShouldBeNil)
+			}
+
+			Convey("is resumed", func() {
+				report, err := Migrate(from, to, MigrateOptions{BatchSize: 2})
+				So(err, ShouldBeNil)
+				So(report, ShouldResemble, &MigrationReport{Tenants: 1, APIKeys: 1, Devices: 3, Copied: 3, Resumed: 1, Transactions: 4, Mismatches: []MigrationMismatch{}})
+				sameAsSource()
+			})
+
+			Convey("is resumed after the key of the device was rotated meanwhile", func() {
+				rotated, err := source.Load("a")
+				So(err, ShouldBeNil)
+				rotated.PreviousKeys = []domain.KeyVersion{{Version: 0, Algorithm: "ecc", PublicKey: rotated.PublicKey, LastCounter: 4}}
+				rotated.PublicKey, rotated.PrivateKey, rotated.KeyVersion, rotated.KeyFirstCounter = []byte("new public key"), []byte("new private key"), 1, 5
+				So(source.Save("a", rotated), ShouldBeNil)
+
+				report, err := Migrate(from, to, MigrateOptions{BatchSize: 2})
+				So(err, ShouldBeNil)
+				So(report, ShouldResemble, &MigrationReport{Tenants: 1, APIKeys: 1, Devices: 3, Copied: 3, Resumed: 1, Transactions: 4, Mismatches: []MigrationMismatch{}})
+				sameAsSource()
+			})
+		})
+
+		Convey("a device rewrapped after it was copied is copied again", func() {
+			_, err := Migrate(from, to, MigrateOptions{})
+			So(err, ShouldBeNil)
+			rewrapped, err := source.Load("b")
+			So(err, ShouldBeNil)
+			rewrapped.PrivateKey, rewrapped.WrappedDataKey, rewrapped.MasterKeyID = []byte("ciphertext"), []byte("wrapped"), "master-2"
+			So(source.Save("b", rewrapped), ShouldBeNil)
+
+			report, err := Migrate(from, to, MigrateOptions{})
+			So(err, ShouldBeNil)
+			So(report, ShouldResemble, &MigrationReport{Tenants: 1, APIKeys: 1, Devices: 3, Copied: 1, Resumed: 1, Skipped: 2, Mismatches: []MigrationMismatch{}})
+			sameAsSource()
+		})
+
+		Convey("a device diverging in the destination is reported and left alone", func() {
+			other := &domain.Device{ID: "a", Algorithm: "ecc", PublicKey: []byte("other public key"), LastSignature: "other seed"}
+			So(destination.CompareAndSave("a", other, 0), ShouldBeNil)
+			forked := &domain.Device{ID: "b", Algorithm: "ecc", PublicKey: []byte("public key"), SignatureCounter: 1, LastSignature: "forked"}
+			So(destination.CompareAndSave("b", forked, 0), ShouldBeNil)
+			misplaced := &domain.Device{ID: "a", Algorithm: "ecc", LastSignature: "seed"}
+			So(destination.CompareAndSave("shop/a", misplaced, 0), ShouldBeNil)
+
+			report, err := Migrate(from, to, MigrateOptions{})
+			So(err, ShouldBeNil)
+			So(report.Copied, ShouldEqual, 0)
+			So(report.Mismatches, ShouldHaveLength, 3)
+			So(report.Mismatches[0].Key, ShouldEqual, "a")
+			So(report.Mismatches[0].Reason, ShouldContainSubstring, "does not continue the signature chain of the source at counter 0")
+			So(report.Mismatches[1].Key, ShouldEqual, "b")
+			So(report.Mismatches[1].Reason, ShouldContainSubstring, "does not continue the signature chain")
+			So(report.Mismatches[2].Key, ShouldEqual, "shop/a")
+			So(report.Mismatches[2].Reason, ShouldContainSubstring, "another device")
+
+			device, err := destination.Load("a")
+			So(err, ShouldBeNil)
+			So(device.PublicKey, ShouldResemble, []byte("other public key"))
+		})
+
+		Convey("a device failing verification is reported", func() {
+			report, err := Migrate(from, to, MigrateOptions{
+				Verify: func(device *domain.Device, last *domain.Transaction) error {
+					if device.ID == "b" {
+						return errors.New("Broken key")
+					}
+					return nil
+				},
+			})
+			So(err, ShouldBeNil)
+			So(report.Copied, ShouldEqual, 3)
+			So(report.Mismatches, ShouldResemble, []MigrationMismatch{{Key: "b", Reason: "Broken key"}})
+		})
+
+		Convey("nothing is copied without the tenant and API key stores", func() {
+			_, err := Migrate(from, MigrationStores{Storage: destination}, MigrateOptions{})
+			So(err, ShouldNotBeNil)
+			So(destination.List(), ShouldBeEmpty)
+		})
+
+		Convey("a device whose ledger starts later is copied from there", func() {
+			legacy := &domain.Device{ID: "legacy", Algorithm: "rsa", SignatureCounter: 5, LastSignature: "old"}
+			So(source.Save("legacy", legacy), ShouldBeNil)
+
+			report, err := Migrate(from, to, MigrateOptions{})
+			So(err, ShouldBeNil)
+			So(report.Mismatches, ShouldBeEmpty)
+			sameAsSource()
+		})
+	})
+}
@@ -0,0 +1,41 @@
+package persistence
+
+import (
+	"sort"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+)
+
+// queryDevices returns copies of the devices of @devices selected by @query, for storages keeping every
+// device in a map
+func queryDevices(devices map[string]*domain.Device, query domain.DeviceQuery) []*domain.Device {
+	selected := []*domain.Device{}
+	for _, data := range devices {
+		if data.ID > query.After && query.Matches(data) {
+			device := *data
+			selected = append(selected, &device)
+		}
+	}
+
+	sort.Slice(selected, func(i, j int) bool { return selected[i].ID < selected[j].ID })
+	if query.Limit > 0 && len(selected) > query.Limit {
+		selected = selected[:query.Limit]
+	}
+	return selected
+}
+
+// sortedDevices returns copies of all devices of @devices ordered by storage key
+func sortedDevices(devices map[string]*domain.Device) []*domain.Device {
+	keys := make([]string, 0, len(devices))
+	for key := range devices {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sorted := make([]*domain.Device, 0, len(keys))
+	for _, key := range keys {
+		device := *devices[key]
+		sorted = append(sorted, &device)
+	}
+	return sorted
+}
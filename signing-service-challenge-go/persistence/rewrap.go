@@ -0,0 +1,47 @@
+package persistence
+
+import (
+	"errors"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+)
+
+// RewrapPrivateKeys makes the private key of every device in @db encrypted under the current master key of
+// @envelope, encrypting plain ones along the way. Signing keys themselves are left untouched, so signatures
+// made before keep verifying. Returns the number of devices rewritten; on error, devices rewritten so far
+// stay rewritten and running it again continues with the rest.
+func RewrapPrivateKeys(db Storage, envelope *crypto.Envelope) (int, error) {
+	if envelope == nil {
+		return 0, errors.New("Rewrapping private keys requires a master key")
+	}
+
+	as := NewAtomicStorage(db)
+	rewrapped := 0
+	for _, listed := range db.List() {
+		changed, err := rewrapPrivateKey(as, listed.Key(), envelope)
+		if err != nil {
+			return rewrapped, errors.New("Could not rewrap private key of device " + listed.Key() + ": " + err.Error())
+		}
+		if changed {
+			rewrapped++
+		}
+	}
+	return rewrapped, nil
+}
+
+func rewrapPrivateKey(as *AtomicStorage, key string, envelope *crypto.Envelope) (bool, error) {
+	as.Lock(key)
+	defer as.Unlock(key)
+
+	// reload under the lock, the listed copy may already be stale
+	device, err := as.Load(key)
+	if err != nil {
+		return false, err
+	}
+
+	changed, err := device.RewrapPrivateKey(envelope)
+	if err != nil || !changed {
+		return false, err
+	}
+	return true, as.CompareAndSave(key, device, device.Version)
+}
@@ -0,0 +1,113 @@
+package persistence
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestEnvelope(previous ...*crypto.MasterKey) (*crypto.Envelope, *crypto.MasterKey) {
+	encoded, err := crypto.GenerateMasterKey()
+	So(err, ShouldBeNil)
+	key, err := crypto.ParseMasterKey(encoded)
+	So(err, ShouldBeNil)
+	return crypto.NewEnvelope(key, previous...), key
+}
+
+func TestRewrapPrivateKeys(t *testing.T) {
+	Convey("Given a storage with a device stored in plain and a signature made by it", t, func() {
+		db := NewInMemoryDB()
+		algo := crypto.GetAlgorithm("ed25519")
+		kp, err := algo.GenerateKeyPair()
+		So(err, ShouldBeNil)
+		publicKey, privateKey, err := kp.Serialize()
+		So(err, ShouldBeNil)
+		So(db.Save("a", &domain.Device{ID: "a", Algorithm: "ed25519", PrivateKey: privateKey, PublicKey: publicKey}), ShouldBeNil)
+
+		signature, err := algo.Sign(kp.PrivateKey(), []byte("data"))
+		So(err, ShouldBeNil)
+
+		envelope, oldKey := newTestEnvelope()
+
+		Convey("rewrapping encrypts the private key and keeps the public key", func() {
+			count, err := RewrapPrivateKeys(db, envelope)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+
+			device, _ := db.Load("a")
+			So(device.MasterKeyID, ShouldEqual, oldKey.ID)
+			So(bytes.Contains(device.PrivateKey, []byte("PRIVATE KEY")), ShouldBeFalse)
+			So(device.PublicKey, ShouldResemble, publicKey)
+
+			Convey("and running it again changes nothing", func() {
+				count, err := RewrapPrivateKeys(db, envelope)
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 0)
+			})
+
+			Convey("and after a master key rotation, the signing key is unchanged", func() {
+				rotated, newKey := newTestEnvelope(oldKey)
+				count, err := RewrapPrivateKeys(db, rotated)
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 1)
+
+				device, _ := db.Load("a")
+				So(device.MasterKeyID, ShouldEqual, newKey.ID)
+
+				// the old master key is no longer needed
+				opened, err := device.OpenPrivateKey(crypto.NewEnvelope(newKey))
+				So(err, ShouldBeNil)
+				So(opened, ShouldResemble, privateKey)
+
+				reconstructed, err := algo.ConstructKeyPair(opened)
+				So(err, ShouldBeNil)
+				So(algo.Verify(reconstructed.PublicKey(), []byte("data"), signature), ShouldBeNil)
+			})
+
+			Convey("but a rotation that forgot the previous key fails", func() {
+				unrelated, _ := newTestEnvelope()
+				_, err := RewrapPrivateKeys(db, unrelated)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("rewrapping without an envelope fails", func() {
+			_, err := RewrapPrivateKeys(db, nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a device of a tenant with the same ID as one of the default tenant", t, func() {
+		db := NewInMemoryDB()
+		algo := crypto.GetAlgorithm("ed25519")
+		for _, device := range []*domain.Device{{ID: "a"}, {ID: "a", TenantID: "shop"}} {
+			kp, err := algo.GenerateKeyPair()
+			So(err, ShouldBeNil)
+			publicKey, privateKey, err := kp.Serialize()
+			So(err, ShouldBeNil)
+			device.Algorithm, device.PrivateKey, device.PublicKey = "ed25519", privateKey, publicKey
+			So(db.Save(device.Key(), device), ShouldBeNil)
+		}
+		envelope, _ := newTestEnvelope()
+
+		Convey("both are rewrapped, each bound to its own storage key", func() {
+			count, err := RewrapPrivateKeys(db, envelope)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+
+			plain, _ := db.Load("a")
+			tenant, _ := db.Load("shop/a")
+			So(tenant.TenantID, ShouldEqual, "shop")
+			_, err = tenant.OpenPrivateKey(envelope)
+			So(err, ShouldBeNil)
+
+			// a private key moved to the device with the same ID of another tenant cannot be opened there
+			plain.PrivateKey, plain.WrappedDataKey = tenant.PrivateKey, tenant.WrappedDataKey
+			_, err = plain.OpenPrivateKey(envelope)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,475 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver, pure Go
+)
+
+// sqliteMigrations are the schema changes of SQLiteDB, migration i brings the schema to version i + 1.
+// Released migrations must never change, new ones are appended.
+var sqliteMigrations = []string{
+	// 1: devices and their transactions
+	`CREATE TABLE devices (
+		key               TEXT PRIMARY KEY, -- storage key, see domain.DeviceKey
+		id                TEXT NOT NULL,
+		tenant_id         TEXT NOT NULL,
+		algorithm         TEXT NOT NULL,
+		label             TEXT NOT NULL,
+		status            TEXT NOT NULL,
+		signature_counter INTEGER NOT NULL,
+		last_signature    TEXT NOT NULL,
+		version           INTEGER NOT NULL,
+		created_at        TEXT NOT NULL,
+		updated_at        TEXT NOT NULL,
+		device            TEXT NOT NULL -- the whole domain.Device as JSON, the columns above are copies for querying
+	);
+	CREATE TABLE transactions (
+		device_key  TEXT NOT NULL REFERENCES devices (key) ON DELETE CASCADE,
+		counter     INTEGER NOT NULL,
+		data        TEXT NOT NULL,
+		signed_data TEXT NOT NULL,
+		signature   TEXT NOT NULL,
+		created_at  TEXT NOT NULL,
+		PRIMARY KEY (device_key, counter)
+	) WITHOUT ROWID;`,
+	// 2: what devices are usually looked up by
+	`CREATE INDEX devices_tenant_id ON devices (tenant_id, id);
+	CREATE INDEX devices_status ON devices (status);`,
+	// 3: idempotency keys of signing requests, stored next to the transaction they signed
+	`CREATE TABLE idempotency_keys (
+		device_key   TEXT NOT NULL REFERENCES devices (key) ON DELETE CASCADE,
+		key          TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		counter      INTEGER NOT NULL,
+		expires_at   TEXT NOT NULL,
+		PRIMARY KEY (device_key, key)
+	) WITHOUT ROWID;
+	CREATE INDEX idempotency_keys_counter ON idempotency_keys (device_key, counter);
+	CREATE INDEX idempotency_keys_expires_at ON idempotency_keys (device_key, expires_at);`,
+}
+
+// SQLiteDB is a durable Storage backed by an embedded SQLite database file, which can be queried with SQL
+// by any SQLite client. Devices are kept in table devices, their transactions in table transactions and the
+// idempotency keys of the requests that signed them in table idempotency_keys. Every save is a single SQL
+// transaction, so a signature counter and the transaction it counts are committed together or not at all. The schema is migrated to the latest version on open.
+type SQLiteDB struct {
+	db   *sql.DB
+	lock *os.File // held while open, see lockStorageFile
+}
+
+// NewSQLiteDB opens (creating if necessary) the SQLite database file @path and migrates its schema. Only one
+// SQLiteDB opens a file at a time, another one fails with ErrStorageLocked until it is closed. SQLite clients
+// may still read it meanwhile.
+func NewSQLiteDB(path string) (*SQLiteDB, error) {
+	lock, err := lockStorageFile(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+
+	// transactions take the write lock right away, so concurrent writers wait for each other instead of
+	// failing to upgrade a read lock
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() +
+		"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=synchronous(FULL)&_pragma=busy_timeout(10000)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	s := &SQLiteDB{db: db, lock: lock}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		lock.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// SchemaVersion returns the version the schema is migrated to
+func (s *SQLiteDB) SchemaVersion() (int, error) {
+	var version int
+	err := s.db.QueryRow(`SELECT coalesce(max(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// migrate applies every migration the schema lacks, each in a transaction of its own
+func (s *SQLiteDB) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return err
+	}
+
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("Database schema version %d is newer than the latest known version %d", version, len(sqliteMigrations))
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		err := s.inTransaction(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version+1, formatTime(time.Now().UTC()))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Could not migrate database schema to version %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
+// inTransaction runs @fn in a transaction, committed if @fn succeeds and rolled back otherwise
+func (s *SQLiteDB) inTransaction(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close releases the database, the SQLiteDB is unusable afterwards
+func (s *SQLiteDB) Close() error {
+	closeErr := s.db.Close()
+	return errors.Join(closeErr, s.lock.Close())
+}
+
+func (s *SQLiteDB) Save(id string, data *domain.Device) error {
+	return s.save(id, data, -1, nil, nil)
+}
+
+func (s *SQLiteDB) CompareAndSave(id string, data *domain.Device, expectedVersion int) error {
+	return s.save(id, data, expectedVersion, nil, nil)
+}
+
+func (s *SQLiteDB) CompareAndSaveWithTransaction(id string, data *domain.Device, expectedVersion int, transaction *domain.Transaction, idempotency *domain.IdempotencyRecord) error {
+	if err := checkTransaction(id, data, transaction); err != nil {
+		return err
+	}
+	if err := checkIdempotencyRecord(id, transaction, idempotency); err != nil {
+		return err
+	}
+	return s.save(id, data, expectedVersion, transaction, idempotency)
+}
+
+// save stores @data as the next version of device @id together with @transaction and @idempotency unless they
+// are nil, only if the stored version is @expectedVersion unless it is negative
+func (s *SQLiteDB) save(id string, data *domain.Device, expectedVersion int, transaction *domain.Transaction, idempotency *domain.IdempotencyRecord) error {
+	device := *data
+	err := s.inTransaction(func(tx *sql.Tx) error {
+		currentVersion := 0
+		err := tx.QueryRow(`SELECT version FROM devices WHERE key = ?`, id).Scan(&currentVersion)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if expectedVersion >= 0 && currentVersion != expectedVersion {
+			return &VersionConflictError{ID: id, Expected: expectedVersion, Actual: currentVersion}
+		}
+
+		device.Version = currentVersion + 1
+		encoded, err := json.Marshal(&device)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO devices (key, id, tenant_id, algorithm, label, status, signature_counter, last_signature, version, created_at, updated_at, device)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (key) DO UPDATE SET id = excluded.id, tenant_id = excluded.tenant_id, algorithm = excluded.algorithm,
+				label = excluded.label, status = excluded.status, signature_counter = excluded.signature_counter,
+				last_signature = excluded.last_signature, version = excluded.version, created_at = excluded.created_at,
+				updated_at = excluded.updated_at, device = excluded.device`,
+			id, device.ID, device.TenantID, device.Algorithm, device.Label, string(device.Status), device.SignatureCounter,
+			device.LastSignature, device.Version, formatTime(device.CreatedAt), formatTime(device.UpdatedAt), string(encoded))
+		if err != nil {
+			return err
+		}
+
+		// transactions past the signature counter belong to a chain the device does not continue
+		if _, err := tx.Exec(`DELETE FROM transactions WHERE device_key = ? AND counter >= ?`, id, device.SignatureCounter); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE device_key = ? AND counter >= ?`, id, device.SignatureCounter); err != nil {
+			return err
+		}
+		if transaction != nil {
+			_, err := tx.Exec(`INSERT OR REPLACE INTO transactions (device_key, counter, data, signed_data, signature, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+				id, transaction.Counter, transaction.Data, transaction.SignedData, transaction.Signature, formatTime(transaction.CreatedAt))
+			if err != nil {
+				return err
+			}
+		}
+		if idempotency != nil {
+			// expired keys of the device go as it signs, so they never pile up
+			if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE device_key = ? AND expires_at <= ?`, id, formatTime(transaction.CreatedAt)); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`INSERT OR REPLACE INTO idempotency_keys (device_key, key, request_hash, counter, expires_at) VALUES (?, ?, ?, ?, ?)`,
+				id, idempotency.Key, idempotency.RequestHash, idempotency.Counter, formatTime(idempotency.ExpiresAt))
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	data.Version = device.Version
+	return nil
+}
+
+func (s *SQLiteDB) Load(id string) (*domain.Device, error) {
+	var encoded string
+	err := s.db.QueryRow(`SELECT device FROM devices WHERE key = ?`, id).Scan(&encoded)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("Device with id " + id + " not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeDevice(encoded)
+}
+
+// List returns every device readable, the Storage interface leaves no way to report the others
+func (s *SQLiteDB) List() []*domain.Device {
+	devices := []*domain.Device{}
+
+	rows, err := s.db.Query(`SELECT device FROM devices ORDER BY key`)
+	if err != nil {
+		return devices
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var encoded string
+		if err := rows.Scan(&encoded); err != nil {
+			continue
+		}
+		if device, err := decodeDevice(encoded); err == nil {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+func (s *SQLiteDB) ListDevices(query domain.DeviceQuery) ([]*domain.Device, error) {
+	conditions := []string{"tenant_id = ?", "id > ?"}
+	args := []any{query.TenantID, query.After}
+	if query.Algorithm != "" {
+		conditions = append(conditions, "algorithm = ?")
+		args = append(args, query.Algorithm)
+	}
+	if query.LabelPrefix != "" {
+		// LIKE ignores case, comparing the start keeps the prefix case sensitive as in domain.DeviceQuery.Matches
+		conditions = append(conditions, "substr(label, 1, ?) = ?")
+		args = append(args, utf8.RuneCountInString(query.LabelPrefix), query.LabelPrefix)
+	}
+	if query.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, string(query.Status))
+	}
+	if !query.CreatedFrom.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, formatTime(query.CreatedFrom))
+	}
+	if !query.CreatedTo.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, formatTime(query.CreatedTo))
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = -1 // no limit for SQLite
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.Query(`SELECT device FROM devices WHERE `+strings.Join(conditions, " AND ")+` ORDER BY id LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []*domain.Device{}
+	for rows.Next() {
+		var encoded string
+		if err := rows.Scan(&encoded); err != nil {
+			return nil, err
+		}
+		device, err := decodeDevice(encoded)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+func (s *SQLiteDB) Delete(id string) error {
+	result, err := s.db.Exec(`DELETE FROM devices WHERE key = ?`, id)
+	if err != nil {
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err != nil || deleted == 0 {
+		return errors.New("Device with id " + id + " not found")
+	}
+	return nil
+}
+
+func (s *SQLiteDB) LoadTransaction(id string, counter int) (*domain.Transaction, error) {
+	rows, err := s.db.Query(`SELECT t.counter, t.data, t.signed_data, t.signature, t.created_at, d.id
+		FROM transactions t JOIN devices d ON d.key = t.device_key
+		WHERE t.device_key = ? AND t.counter = ?`, id, counter)
+	if err != nil {
+		return nil, err
+	}
+	transactions, err := scanTransactions(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(transactions) == 0 {
+		return nil, transactionNotFound(id, counter)
+	}
+	return transactions[0], nil
+}
+
+func (s *SQLiteDB) ListTransactions(id string, from int, limit int) ([]*domain.Transaction, error) {
+	var deviceID string
+	err := s.db.QueryRow(`SELECT id FROM devices WHERE key = ?`, id).Scan(&deviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("Device with id " + id + " not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = -1 // no limit for SQLite
+	}
+	rows, err := s.db.Query(`SELECT counter, data, signed_data, signature, created_at, ?
+		FROM transactions WHERE device_key = ? AND counter >= ? ORDER BY counter LIMIT ?`, deviceID, id, from, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanTransactions(rows)
+}
+
+func (s *SQLiteDB) LoadIdempotencyRecord(id string, key string, now time.Time) (*domain.IdempotencyRecord, error) {
+	rows, err := s.db.Query(`SELECT key, request_hash, counter, expires_at FROM idempotency_keys
+		WHERE device_key = ? AND key = ? AND expires_at > ?`, id, key, formatTime(now))
+	if err != nil {
+		return nil, err
+	}
+	records, err := scanIdempotencyRecords(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, idempotencyRecordNotFound(id, key)
+	}
+	return records[0], nil
+}
+
+func (s *SQLiteDB) ListIdempotencyRecords(id string, now time.Time) ([]*domain.IdempotencyRecord, error) {
+	var exists int
+	err := s.db.QueryRow(`SELECT 1 FROM devices WHERE key = ?`, id).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("Device with id " + id + " not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT key, request_hash, counter, expires_at FROM idempotency_keys
+		WHERE device_key = ? AND expires_at > ? ORDER BY counter`, id, formatTime(now))
+	if err != nil {
+		return nil, err
+	}
+	return scanIdempotencyRecords(rows)
+}
+
+// scanIdempotencyRecords reads every idempotency record of @rows, selected as key, request hash, counter and
+// expiry, and closes them
+func scanIdempotencyRecords(rows *sql.Rows) ([]*domain.IdempotencyRecord, error) {
+	defer rows.Close()
+
+	records := []*domain.IdempotencyRecord{}
+	for rows.Next() {
+		var record domain.IdempotencyRecord
+		var expiresAt string
+		if err := rows.Scan(&record.Key, &record.RequestHash, &record.Counter, &expiresAt); err != nil {
+			return nil, err
+		}
+		var err error
+		if record.ExpiresAt, err = parseTime(expiresAt); err != nil {
+			return nil, err
+		}
+		records = append(records, &record)
+	}
+	return records, rows.Err()
+}
+
+// scanTransactions reads every transaction of @rows, selected as counter, data, signed data, signature,
+// creation time and device ID, and closes them
+func scanTransactions(rows *sql.Rows) ([]*domain.Transaction, error) {
+	defer rows.Close()
+
+	transactions := []*domain.Transaction{}
+	for rows.Next() {
+		var transaction domain.Transaction
+		var createdAt string
+		if err := rows.Scan(&transaction.Counter, &transaction.Data, &transaction.SignedData, &transaction.Signature, &createdAt, &transaction.DeviceID); err != nil {
+			return nil, err
+		}
+		var err error
+		if transaction.CreatedAt, err = parseTime(createdAt); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, &transaction)
+	}
+	return transactions, rows.Err()
+}
+
+func decodeDevice(encoded string) (*domain.Device, error) {
+	var device domain.Device
+	if err := json.Unmarshal([]byte(encoded), &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// sqliteTimeFormat is RFC 3339 with a fixed number of fractional digits, so that times sort as text
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// formatTime writes @t in UTC as sqliteTimeFormat, empty if it is zero
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+// parseTime reads what formatTime wrote
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
@@ -0,0 +1,354 @@
+package persistence
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func openSQLiteDB(path string) *SQLiteDB {
+	db, err := NewSQLiteDB(path)
+	So(err, ShouldBeNil)
+	return db
+}
+
+func TestSQLiteDBLock(t *testing.T) {
+	Convey("Given an SQLiteDB open on a file", t, func() {
+		path := filepath.Join(t.TempDir(), "signing.db")
+		db := openSQLiteDB(path)
+
+		Convey("another one cannot open it until it is closed", func() {
+			_, err := NewSQLiteDB(path)
+			So(errors.Is(err, ErrStorageLocked), ShouldBeTrue)
+
+			So(db.Close(), ShouldBeNil)
+			db := openSQLiteDB(path)
+			So(db.Close(), ShouldBeNil)
+		})
+	})
+}
+
+func TestSQLiteDBSaveLoad(t *testing.T) {
+	Convey("Given an SQLiteDB instance", t, func() {
+		db := openSQLiteDB(filepath.Join(t.TempDir(), "signing.db"))
+		defer db.Close()
+
+		Convey(`and a device with ID "hello" and other fields set`, func() {
+			now := time.Now().UTC().Round(0)
+			d := &domain.Device{
+				ID:               "hello",
+				Algorithm:        "ecc",
+				PrivateKey:       []byte("private"),
+				SignatureCounter: 3,
+				LastSignature:    "c2ln",
+				Status:           domain.DeviceActive,
+				StatusHistory:    []domain.StatusChange{{Action: domain.ActionActivate, To: domain.DeviceActive, At: now}},
+				CreatedAt:        now,
+				UpdatedAt:        now,
+			}
+
+			Convey("when the device is saved", func() {
+				err := db.Save(d.ID, d)
+
+				Convey("it returns no error", func() {
+					So(err, ShouldBeNil)
+				})
+
+				Convey("and the same device will be loaded", func() {
+					d1, err := db.Load(d.ID)
+					So(err, ShouldBeNil)
+					So(d1, ShouldResemble, d)
+				})
+
+				Convey("and it can be queried with SQL", func() {
+					var status string
+					var counter int
+					So(db.db.QueryRow(`SELECT status, signature_counter FROM devices WHERE id = ?`, "hello").Scan(&status, &counter), ShouldBeNil)
+					So(status, ShouldEqual, "active")
+					So(counter, ShouldEqual, 3)
+				})
+
+				Convey("but loading a different id returns an error", func() {
+					_, err := db.Load(d.ID + ", world")
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+}
+
+func TestSQLiteDBList(t *testing.T) {
+	Convey("Given an SQLiteDB instance with 3 saved devices", t, func() {
+		db := openSQLiteDB(filepath.Join(t.TempDir(), "signing.db"))
+		defer db.Close()
+		for _, id := range []string{"c", "a", "shop/b"} {
+			So(db.Save(id, &domain.Device{ID: id}), ShouldBeNil)
+		}
+
+		Convey("List returns all of them", func() {
+			ds := db.List()
+			So(ds, ShouldHaveLength, 3)
+			So(ds[0].ID, ShouldEqual, "a")
+		})
+	})
+}
+
+func TestSQLiteDBCompareAndSave(t *testing.T) {
+	Convey("Given an SQLiteDB instance", t, func() {
+		path := filepath.Join(t.TempDir(), "signing.db")
+		db := openSQLiteDB(path)
+		defer func() { db.Close() }()
+
+		Convey("a new device can only be saved expecting version 0", func() {
+			d := &domain.Device{ID: "a"}
+
+			err := db.CompareAndSave(d.ID, d, 1)
+			So(err, ShouldHaveSameTypeAs, &VersionConflictError{})
+
+			err = db.CompareAndSave(d.ID, d, 0)
+			So(err, ShouldBeNil)
+			So(d.Version, ShouldEqual, 1)
+		})
+
+		Convey("and once saved", func() {
+			d := &domain.Device{ID: "a"}
+			So(db.Save(d.ID, d), ShouldBeNil)
+
+			Convey("saving with a stale version fails with a conflict and leaves the device untouched", func() {
+				first, _ := db.Load(d.ID)
+				second, _ := db.Load(d.ID)
+
+				first.SignatureCounter = 1
+				So(db.CompareAndSave(d.ID, first, first.Version), ShouldBeNil)
+
+				second.SignatureCounter = 2
+				err := db.CompareAndSave(d.ID, second, second.Version)
+				conflict, ok := err.(*VersionConflictError)
+				So(ok, ShouldBeTrue)
+				So(conflict.Expected, ShouldEqual, 1)
+				So(conflict.Actual, ShouldEqual, 2)
+
+				stored, _ := db.Load(d.ID)
+				So(stored.SignatureCounter, ShouldEqual, 1)
+			})
+
+			Convey("the version survives reopening", func() {
+				So(db.Close(), ShouldBeNil)
+				db = openSQLiteDB(path)
+
+				So(db.CompareAndSave(d.ID, d, 0), ShouldHaveSameTypeAs, &VersionConflictError{})
+				So(db.CompareAndSave(d.ID, d, 1), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestSQLiteDBDelete(t *testing.T) {
+	Convey("Given an SQLiteDB instance with a saved device", t, func() {
+		db := openSQLiteDB(filepath.Join(t.TempDir(), "signing.db"))
+		defer db.Close()
+		So(db.Save("a", &domain.Device{ID: "a"}), ShouldBeNil)
+
+		Convey("deleting it makes it unloadable", func() {
+			So(db.Delete("a"), ShouldBeNil)
+
+			_, err := db.Load("a")
+			So(err, ShouldNotBeNil)
+			So(db.List(), ShouldBeEmpty)
+
+			Convey("and it can be created again from version 0", func() {
+				So(db.CompareAndSave("a", &domain.Device{ID: "a"}, 0), ShouldBeNil)
+			})
+		})
+
+		Convey("deleting an unknown id returns an error", func() {
+			So(db.Delete("b"), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestSQLiteDBTransactions(t *testing.T) {
+	Convey("Given an SQLiteDB instance with a device that signed twice", t, func() {
+		db := openSQLiteDB(filepath.Join(t.TempDir(), "signing.db"))
+		defer db.Close()
+		d := &domain.Device{ID: "a", TenantID: "shop"}
+		So(db.CompareAndSave("shop/a", d, 0), ShouldBeNil)
+		createdAt := time.Now().UTC()
+		for counter := 0; counter < 2; counter++ {
+			expectedVersion := d.Version
+			d.SignatureCounter++
+			So(db.CompareAndSaveWithTransaction("shop/a", d, expectedVersion, &domain.Transaction{DeviceID: "a", Counter: counter, Data: "data", CreatedAt: createdAt}, nil), ShouldBeNil)
+		}
+
+		Convey("every transaction can be loaded by its counter", func() {
+			transaction, err := db.LoadTransaction("shop/a", 1)
+			So(err, ShouldBeNil)
+			So(transaction, ShouldResemble, &domain.Transaction{DeviceID: "a", Counter: 1, Data: "data", CreatedAt: createdAt.Round(0)})
+
+			_, err = db.LoadTransaction("shop/a", 2)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("and listed in pages", func() {
+			transactions, err := db.ListTransactions("shop/a", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldHaveLength, 2)
+
+			transactions, err = db.ListTransactions("shop/a", 1, 1)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldHaveLength, 1)
+			So(transactions[0].Counter, ShouldEqual, 1)
+			So(transactions[0].DeviceID, ShouldEqual, "a")
+
+			transactions, err = db.ListTransactions("shop/a", 5, 1)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldBeEmpty)
+
+			_, err = db.ListTransactions("b", 0, 0)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a transaction not matching the signature counter is rejected and nothing is saved", func() {
+			expectedVersion := d.Version
+			d.SignatureCounter++
+			err := db.CompareAndSaveWithTransaction("shop/a", d, expectedVersion, &domain.Transaction{DeviceID: "a", Counter: 7}, nil)
+			So(err, ShouldNotBeNil)
+
+			stored, _ := db.Load("shop/a")
+			So(stored.SignatureCounter, ShouldEqual, 2)
+		})
+
+		Convey("a conflicting save stores neither the device nor the transaction", func() {
+			d.SignatureCounter++
+			err := db.CompareAndSaveWithTransaction("shop/a", d, 1, &domain.Transaction{DeviceID: "a", Counter: 2}, nil)
+			So(err, ShouldHaveSameTypeAs, &VersionConflictError{})
+
+			_, err = db.LoadTransaction("shop/a", 2)
+			So(err, ShouldNotBeNil)
+			stored, _ := db.Load("shop/a")
+			So(stored.SignatureCounter, ShouldEqual, 2)
+		})
+
+		Convey("a failing transaction insert rolls the device back as well", func() {
+			_, err := db.db.Exec(`CREATE TRIGGER fail BEFORE INSERT ON transactions BEGIN SELECT RAISE(ABORT, 'disk full'); END`)
+			So(err, ShouldBeNil)
+
+			expectedVersion := d.Version
+			d.SignatureCounter++
+			err = db.CompareAndSaveWithTransaction("shop/a", d, expectedVersion, &domain.Transaction{DeviceID: "a", Counter: 2}, nil)
+			So(err, ShouldNotBeNil)
+
+			stored, _ := db.Load("shop/a")
+			So(stored.SignatureCounter, ShouldEqual, 2)
+			So(stored.Version, ShouldEqual, expectedVersion)
+		})
+
+		Convey("concurrent writers never lose a transaction", func() {
+			var wg sync.WaitGroup
+			errs := make(chan error, 4)
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for signed := 0; signed < 5; {
+						device, err := db.Load("shop/a")
+						if err != nil {
+							errs <- err
+							return
+						}
+						expectedVersion := device.Version
+						transaction := &domain.Transaction{DeviceID: "a", Counter: device.SignatureCounter}
+						device.SignatureCounter++
+						err = db.CompareAndSaveWithTransaction("shop/a", device, expectedVersion, transaction, nil)
+						var conflict *VersionConflictError
+						if errors.As(err, &conflict) {
+							continue
+						}
+						if err != nil {
+							errs <- err
+							return
+						}
+						signed++
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				So(err, ShouldBeNil)
+			}
+
+			transactions, err := db.ListTransactions("shop/a", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldHaveLength, 22)
+		})
+
+		Convey("replacing the device with a reset counter discards its transactions", func() {
+			So(db.Save("shop/a", &domain.Device{ID: "a", TenantID: "shop"}), ShouldBeNil)
+
+			transactions, err := db.ListTransactions("shop/a", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldBeEmpty)
+		})
+
+		Convey("deleting the device deletes its transactions", func() {
+			So(db.Delete("shop/a"), ShouldBeNil)
+
+			var count int
+			So(db.db.QueryRow(`SELECT count(*) FROM transactions`).Scan(&count), ShouldBeNil)
+			So(count, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestSQLiteDBMigrations(t *testing.T) {
+	Convey("Given a new SQLite database", t, func() {
+		path := filepath.Join(t.TempDir(), "signing.db")
+		db := openSQLiteDB(path)
+
+		Convey("every migration is applied", func() {
+			version, err := db.SchemaVersion()
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, len(sqliteMigrations))
+			So(db.Close(), ShouldBeNil)
+
+			Convey("and reopening applies none again", func() {
+				db := openSQLiteDB(path)
+				defer db.Close()
+				var count int
+				So(db.db.QueryRow(`SELECT count(*) FROM schema_migrations`).Scan(&count), ShouldBeNil)
+				So(count, ShouldEqual, len(sqliteMigrations))
+			})
+		})
+
+		Convey("a database only partly migrated is migrated the rest of the way", func() {
+			So(db.Save("a", &domain.Device{ID: "a"}), ShouldBeNil)
+			_, err := db.db.Exec(`DROP TABLE idempotency_keys; DROP INDEX devices_status; DROP INDEX devices_tenant_id;
+				DELETE FROM schema_migrations WHERE version >= 2`)
+			So(err, ShouldBeNil)
+			So(db.Close(), ShouldBeNil)
+
+			db := openSQLiteDB(path)
+			defer db.Close()
+			version, err := db.SchemaVersion()
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, len(sqliteMigrations))
+			_, err = db.Load("a")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("a database of a newer schema is refused", func() {
+			_, err := db.db.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, '')`, len(sqliteMigrations)+1)
+			So(err, ShouldBeNil)
+			So(db.Close(), ShouldBeNil)
+
+			_, err = NewSQLiteDB(path)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
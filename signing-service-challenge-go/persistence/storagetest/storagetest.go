@@ -0,0 +1,722 @@
+// Package storagetest holds the contract every persistence.Storage must fulfill, as a test suite any backend
+// runs against itself with RunConformance
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// Factory returns a new, empty Storage. It is called for every case of the suite, anything to release
+// afterwards should be registered with @t.Cleanup.
+type Factory func(t *testing.T) persistence.Storage
+
+// Options tunes RunConformance for backends that are slow to write
+type Options struct {
+	// Number of devices of the large dataset, 0 = 1000
+	LargeDevices int
+	// Number of transactions of the single device of the large dataset, 0 = 1000
+	LargeTransactions int
+}
+
+// RunConformance runs the whole contract against Storage-s made by @newStorage
+func RunConformance(t *testing.T, newStorage Factory) {
+	RunConformanceWithOptions(t, newStorage, Options{})
+}
+
+// RunConformanceWithOptions is RunConformance tuned by @options
+func RunConformanceWithOptions(t *testing.T, newStorage Factory, options Options) {
+	if options.LargeDevices == 0 {
+		options.LargeDevices = 1000
+	}
+	if options.LargeTransactions == 0 {
+		options.LargeTransactions = 1000
+	}
+
+	t.Run("SaveLoad", func(t *testing.T) { testSaveLoad(t, newStorage) })
+	t.Run("NotFound", func(t *testing.T) { testNotFound(t, newStorage) })
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, newStorage) })
+	t.Run("CompareAndSave", func(t *testing.T) { testCompareAndSave(t, newStorage) })
+	t.Run("List", func(t *testing.T) { testList(t, newStorage) })
+	t.Run("ListDevices", func(t *testing.T) { testListDevices(t, newStorage) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newStorage) })
+	t.Run("Transactions", func(t *testing.T) { testTransactions(t, newStorage) })
+	t.Run("IdempotencyRecords", func(t *testing.T) { testIdempotencyRecords(t, newStorage) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, newStorage) })
+	t.Run("LargeDataset", func(t *testing.T) { testLargeDataset(t, newStorage, options) })
+}
+
+// sampleDevice returns a device with every field set, so that a field a backend loses is noticed
+func sampleDevice(id string) *domain.Device {
+	at := time.Date(2024, 5, 17, 10, 30, 0, 123456789, time.UTC)
+	return &domain.Device{
+		ID:               id,
+		TenantID:         "shop",
+		Algorithm:        "ecc",
+		PrivateKey:       []byte("private key"),
+		WrappedDataKey:   []byte("wrapped data key"),
+		MasterKeyID:      "master-1",
+		PublicKey:        []byte("public key"),
+		KeyVersion:       2,
+		KeyFirstCounter:  1,
+		PreviousKeys:     []domain.KeyVersion{{Version: 1, Algorithm: "rsa", PublicKey: []byte("old public key"), LastCounter: 0, CreatedAt: at, RotatedAt: at}},
+		Label:            "till",
+		SignatureCounter: 2,
+		LastSignature:    "c2lnbmF0dXJl",
+		Status:           domain.DeviceActive,
+		StatusHistory:    []domain.StatusChange{{Action: domain.ActionActivate, To: domain.DeviceActive, At: at, By: "CN=till"}},
+		CreatedAt:        at,
+		UpdatedAt:        at,
+	}
+}
+
+// sign stores the next transaction of @device, saved with @id, returning it
+func sign(storage persistence.Storage, id string, device *domain.Device) (*domain.Transaction, error) {
+	return signIdempotent(storage, id, device, "", time.Time{})
+}
+
+// signIdempotent is sign that also stores a record of idempotency key @key expiring at @expiresAt, unless @key
+// is empty
+func signIdempotent(storage persistence.Storage, id string, device *domain.Device, key string, expiresAt time.Time) (*domain.Transaction, error) {
+	expectedVersion := device.Version
+	transaction := &domain.Transaction{
+		DeviceID:   device.ID,
+		Counter:    device.SignatureCounter,
+		Data:       fmt.Sprintf("data %d", device.SignatureCounter),
+		SignedData: fmt.Sprintf("%d_data %d_%s", device.SignatureCounter, device.SignatureCounter, device.LastSignature),
+		Signature:  fmt.Sprintf("signature %d", device.SignatureCounter),
+		CreatedAt:  time.Date(2024, 5, 17, 10, 30, device.SignatureCounter, 0, time.UTC),
+	}
+	var record *domain.IdempotencyRecord
+	if key != "" {
+		record = &domain.IdempotencyRecord{Key: key, RequestHash: "hash of " + transaction.Data, Counter: transaction.Counter, ExpiresAt: expiresAt}
+	}
+	device.SignatureCounter++
+	device.LastSignature = transaction.Signature
+	if err := storage.CompareAndSaveWithTransaction(id, device, expectedVersion, transaction, record); err != nil {
+		device.SignatureCounter--
+		return nil, err
+	}
+	return transaction, nil
+}
+
+func testSaveLoad(t *testing.T, newStorage Factory) {
+	Convey("Given an empty Storage", t, func() {
+		storage := newStorage(t)
+
+		Convey("a saved device is loaded with every field as it was saved, at version 1", func() {
+			device := sampleDevice("a")
+			So(storage.Save("shop/a", device), ShouldBeNil)
+			So(device.Version, ShouldEqual, 1)
+
+			loaded, err := storage.Load("shop/a")
+			So(err, ShouldBeNil)
+			So(loaded, ShouldResemble, device)
+		})
+
+		Convey("the stored device is a copy, changing either side afterwards does not change it", func() {
+			device := sampleDevice("a")
+			So(storage.Save("a", device), ShouldBeNil)
+			device.Label = "changed"
+			device.SignatureCounter = 100
+
+			loaded, err := storage.Load("a")
+			So(err, ShouldBeNil)
+			So(loaded.Label, ShouldEqual, "till")
+			loaded.SignatureCounter = 200
+
+			again, err := storage.Load("a")
+			So(err, ShouldBeNil)
+			So(again.SignatureCounter, ShouldEqual, 2)
+		})
+
+		Convey("storage keys of different tenants are different devices", func() {
+			So(storage.Save("a", &domain.Device{ID: "a", Label: "default"}), ShouldBeNil)
+			So(storage.Save("shop/a", &domain.Device{ID: "a", TenantID: "shop", Label: "shop"}), ShouldBeNil)
+
+			loaded, err := storage.Load("a")
+			So(err, ShouldBeNil)
+			So(loaded.Label, ShouldEqual, "default")
+			loaded, err = storage.Load("shop/a")
+			So(err, ShouldBeNil)
+			So(loaded.Label, ShouldEqual, "shop")
+		})
+	})
+}
+
+func testNotFound(t *testing.T, newStorage Factory) {
+	Convey("Given a Storage with device a only", t, func() {
+		storage := newStorage(t)
+		device := &domain.Device{ID: "a"}
+		So(storage.Save("a", device), ShouldBeNil)
+
+		Convey("every operation on another device fails", func() {
+			_, err := storage.Load("b")
+			So(err, ShouldNotBeNil)
+			So(storage.Delete("b"), ShouldNotBeNil)
+			_, err = storage.ListTransactions("b", 0, 0)
+			So(err, ShouldNotBeNil)
+			_, err = storage.LoadTransaction("b", 0)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("loading a transaction the device does not have fails", func() {
+			_, err := storage.LoadTransaction("a", 0)
+			So(err, ShouldNotBeNil)
+			_, err = storage.LoadTransaction("a", -1)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a device without transactions lists none", func() {
+			transactions, err := storage.ListTransactions("a", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldBeEmpty)
+		})
+	})
+}
+
+func testOverwrite(t *testing.T, newStorage Factory) {
+	Convey("Given a Storage with a saved device", t, func() {
+		storage := newStorage(t)
+		So(storage.Save("a", sampleDevice("a")), ShouldBeNil)
+
+		Convey("saving it again replaces it and bumps its version, whatever version is given", func() {
+			replacement := &domain.Device{ID: "a", Label: "replaced", Version: 42}
+			So(storage.Save("a", replacement), ShouldBeNil)
+			So(replacement.Version, ShouldEqual, 2)
+
+			loaded, err := storage.Load("a")
+			So(err, ShouldBeNil)
+			So(loaded, ShouldResemble, replacement)
+			So(loaded.PrivateKey, ShouldBeNil)
+			So(storage.List(), ShouldHaveLength, 1)
+		})
+	})
+}
+
+func testCompareAndSave(t *testing.T, newStorage Factory) {
+	Convey("Given an empty Storage", t, func() {
+		storage := newStorage(t)
+
+		Convey("a new device can only be saved expecting version 0", func() {
+			device := &domain.Device{ID: "a"}
+			So(storage.CompareAndSave("a", device, 1), ShouldHaveSameTypeAs, &persistence.VersionConflictError{})
+			_, err := storage.Load("a")
+			So(err, ShouldNotBeNil)
+
+			So(storage.CompareAndSave("a", device, 0), ShouldBeNil)
+			So(device.Version, ShouldEqual, 1)
+			So(storage.CompareAndSave("a", &domain.Device{ID: "a"}, 0), ShouldHaveSameTypeAs, &persistence.VersionConflictError{})
+		})
+
+		Convey("of two writers of the same version only the first succeeds", func() {
+			So(storage.Save("a", &domain.Device{ID: "a"}), ShouldBeNil)
+			first, _ := storage.Load("a")
+			second, _ := storage.Load("a")
+
+			first.SignatureCounter = 1
+			So(storage.CompareAndSave("a", first, first.Version), ShouldBeNil)
+			So(first.Version, ShouldEqual, 2)
+
+			second.SignatureCounter = 2
+			err := storage.CompareAndSave("a", second, second.Version)
+			var conflict *persistence.VersionConflictError
+			So(errors.As(err, &conflict), ShouldBeTrue)
+			So(conflict.ID, ShouldEqual, "a")
+			So(conflict.Expected, ShouldEqual, 1)
+			So(conflict.Actual, ShouldEqual, 2)
+			So(second.Version, ShouldEqual, 1)
+
+			stored, _ := storage.Load("a")
+			So(stored.SignatureCounter, ShouldEqual, 1)
+		})
+	})
+}
+
+func testList(t *testing.T, newStorage Factory) {
+	Convey("Given an empty Storage", t, func() {
+		storage := newStorage(t)
+
+		Convey("it lists no device", func() {
+			So(storage.List(), ShouldBeEmpty)
+		})
+
+		Convey("it lists every saved device once ordered by id, as copies", func() {
+			for _, id := range []string{"c", "a", "shop/b"} {
+				So(storage.Save(id, sampleDevice(id)), ShouldBeNil)
+			}
+			So(storage.Save("a", sampleDevice("a")), ShouldBeNil)
+
+			devices := storage.List()
+			So(devices, ShouldHaveLength, 3)
+			ids := []string{}
+			for _, device := range devices {
+				ids = append(ids, device.ID)
+				device.Label = "changed"
+			}
+			So(ids, ShouldResemble, []string{"a", "c", "shop/b"})
+
+			loaded, _ := storage.Load("a")
+			So(loaded.Label, ShouldEqual, "till")
+			So(loaded.Version, ShouldEqual, 2)
+		})
+	})
+}
+
+func testListDevices(t *testing.T, newStorage Factory) {
+	Convey("Given a Storage with devices of two tenants", t, func() {
+		storage := newStorage(t)
+		at := time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC)
+		for i, device := range []*domain.Device{
+			{ID: "e", Algorithm: "ecc", Label: "Till 5", Status: domain.DeviceRetired},
+			{ID: "b", Algorithm: "rsa", Label: "Till 2", Status: domain.DeviceActive},
+			{ID: "d", Algorithm: "ecc", Label: "till 4", Status: domain.DeviceSuspended},
+			{ID: "a", Algorithm: "ecc", Label: "Till 1", Status: domain.DeviceActive},
+			{ID: "c", Algorithm: "ed25519", Label: "Kiosk", Status: domain.DeviceActive},
+		} {
+			device.CreatedAt = at.Add(time.Duration(i) * time.Hour)
+			So(storage.Save(device.ID, device), ShouldBeNil)
+		}
+		So(storage.Save("shop/a", &domain.Device{ID: "a", TenantID: "shop", Algorithm: "ecc", Label: "Till 1", Status: domain.DeviceActive}), ShouldBeNil)
+
+		ids := func(query domain.DeviceQuery) []string {
+			devices, err := storage.ListDevices(query)
+			So(err, ShouldBeNil)
+			ids := []string{}
+			for _, device := range devices {
+				ids = append(ids, device.ID)
+			}
+			return ids
+		}
+
+		Convey("devices of the tenant are listed ordered by ID", func() {
+			So(ids(domain.DeviceQuery{}), ShouldResemble, []string{"a", "b", "c", "d", "e"})
+			devices, err := storage.ListDevices(domain.DeviceQuery{TenantID: "shop"})
+			So(err, ShouldBeNil)
+			So(devices, ShouldHaveLength, 1)
+			So(devices[0].TenantID, ShouldEqual, "shop")
+			So(ids(domain.DeviceQuery{TenantID: "other"}), ShouldBeEmpty)
+		})
+
+		Convey("pages follow each other without gaps nor repetitions", func() {
+			So(ids(domain.DeviceQuery{Limit: 2}), ShouldResemble, []string{"a", "b"})
+			So(ids(domain.DeviceQuery{After: "b", Limit: 2}), ShouldResemble, []string{"c", "d"})
+			So(ids(domain.DeviceQuery{After: "d", Limit: 2}), ShouldResemble, []string{"e"})
+			So(ids(domain.DeviceQuery{After: "e", Limit: 2}), ShouldBeEmpty)
+			So(ids(domain.DeviceQuery{After: "bb"}), ShouldResemble, []string{"c", "d", "e"})
+		})
+
+		Convey("filters select devices by algorithm, label prefix, status and creation time", func() {
+			So(ids(domain.DeviceQuery{Algorithm: "ecc"}), ShouldResemble, []string{"a", "d", "e"})
+			So(ids(domain.DeviceQuery{LabelPrefix: "Till"}), ShouldResemble, []string{"a", "b", "e"})
+			So(ids(domain.DeviceQuery{LabelPrefix: "Till 1"}), ShouldResemble, []string{"a"})
+			So(ids(domain.DeviceQuery{Status: domain.DeviceActive}), ShouldResemble, []string{"a", "b", "c"})
+			So(ids(domain.DeviceQuery{Status: domain.DeviceRetired}), ShouldResemble, []string{"e"})
+			So(ids(domain.DeviceQuery{CreatedFrom: at.Add(time.Hour)}), ShouldResemble, []string{"a", "b", "c", "d"})
+			So(ids(domain.DeviceQuery{CreatedTo: at.Add(time.Hour)}), ShouldResemble, []string{"e"})
+			So(ids(domain.DeviceQuery{CreatedFrom: at.Add(time.Hour), CreatedTo: at.Add(3 * time.Hour)}), ShouldResemble, []string{"b", "d"})
+		})
+
+		Convey("filters combine with each other and with paging", func() {
+			query := domain.DeviceQuery{Algorithm: "ecc", LabelPrefix: "Till", Limit: 1}
+			So(ids(query), ShouldResemble, []string{"a"})
+			query.After = "a"
+			So(ids(query), ShouldResemble, []string{"e"})
+		})
+
+		Convey("listed devices are copies", func() {
+			devices, err := storage.ListDevices(domain.DeviceQuery{Limit: 1})
+			So(err, ShouldBeNil)
+			devices[0].Label = "changed"
+			loaded, _ := storage.Load("a")
+			So(loaded.Label, ShouldEqual, "Till 1")
+		})
+	})
+}
+
+func testDelete(t *testing.T, newStorage Factory) {
+	Convey("Given a Storage with a device that signed", t, func() {
+		storage := newStorage(t)
+		device := &domain.Device{ID: "a"}
+		So(storage.Save("a", device), ShouldBeNil)
+		_, err := sign(storage, "a", device)
+		So(err, ShouldBeNil)
+		So(storage.Save("b", &domain.Device{ID: "b"}), ShouldBeNil)
+
+		Convey("deleting it removes it and its transactions only", func() {
+			So(storage.Delete("a"), ShouldBeNil)
+
+			_, err := storage.Load("a")
+			So(err, ShouldNotBeNil)
+			_, err = storage.LoadTransaction("a", 0)
+			So(err, ShouldNotBeNil)
+			So(storage.List(), ShouldHaveLength, 1)
+			So(storage.Delete("a"), ShouldNotBeNil)
+
+			Convey("and it can be created again from version 0, without transactions", func() {
+				So(storage.CompareAndSave("a", &domain.Device{ID: "a"}, 0), ShouldBeNil)
+				transactions, err := storage.ListTransactions("a", 0, 0)
+				So(err, ShouldBeNil)
+				So(transactions, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func testTransactions(t *testing.T, newStorage Factory) {
+	Convey("Given a Storage with a device that signed 3 times", t, func() {
+		storage := newStorage(t)
+		device := &domain.Device{ID: "a", TenantID: "shop"}
+		So(storage.CompareAndSave("shop/a", device, 0), ShouldBeNil)
+		var signed []*domain.Transaction
+		for i := 0; i < 3; i++ {
+			transaction, err := sign(storage, "shop/a", device)
+			So(err, ShouldBeNil)
+			signed = append(signed, transaction)
+		}
+
+		Convey("every transaction is loaded by its counter as it was stored", func() {
+			for _, transaction := range signed {
+				loaded, err := storage.LoadTransaction("shop/a", transaction.Counter)
+				So(err, ShouldBeNil)
+				So(loaded, ShouldResemble, transaction)
+			}
+			_, err := storage.LoadTransaction("shop/a", 3)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("transactions are listed in pages ordered by counter", func() {
+			transactions, err := storage.ListTransactions("shop/a", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldResemble, signed)
+
+			transactions, err = storage.ListTransactions("shop/a", 1, 1)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldResemble, signed[1:2])
+
+			transactions, err = storage.ListTransactions("shop/a", 2, 10)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldResemble, signed[2:])
+
+			transactions, err = storage.ListTransactions("shop/a", 5, 1)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldBeEmpty)
+		})
+
+		Convey("a transaction not matching the signature counter is rejected and nothing is saved", func() {
+			expectedVersion := device.Version
+			device.SignatureCounter++
+			err := storage.CompareAndSaveWithTransaction("shop/a", device, expectedVersion, &domain.Transaction{DeviceID: "a", Counter: 7}, nil)
+			So(err, ShouldNotBeNil)
+			So(err, ShouldNotHaveSameTypeAs, &persistence.VersionConflictError{})
+
+			stored, _ := storage.Load("shop/a")
+			So(stored.SignatureCounter, ShouldEqual, 3)
+			So(stored.Version, ShouldEqual, expectedVersion)
+		})
+
+		Convey("a conflicting save stores neither the device nor the transaction", func() {
+			stale, _ := storage.Load("shop/a")
+			stale.Version--
+			_, err := sign(storage, "shop/a", stale)
+			So(err, ShouldHaveSameTypeAs, &persistence.VersionConflictError{})
+
+			_, err = storage.LoadTransaction("shop/a", 3)
+			So(err, ShouldNotBeNil)
+			stored, _ := storage.Load("shop/a")
+			So(stored.SignatureCounter, ShouldEqual, 3)
+		})
+
+		Convey("saving the device with a lower counter discards the transactions from it on", func() {
+			device.SignatureCounter = 1
+			So(storage.Save("shop/a", device), ShouldBeNil)
+
+			transactions, err := storage.ListTransactions("shop/a", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldResemble, signed[:1])
+
+			Convey("and signing again continues from there", func() {
+				transaction, err := sign(storage, "shop/a", device)
+				So(err, ShouldBeNil)
+				So(transaction.Counter, ShouldEqual, 1)
+				transactions, err := storage.ListTransactions("shop/a", 0, 0)
+				So(err, ShouldBeNil)
+				So(transactions, ShouldHaveLength, 2)
+				So(transactions[1], ShouldResemble, transaction)
+			})
+		})
+
+		Convey("saving without signing keeps the transactions", func() {
+			device.Label = "relabeled"
+			So(storage.CompareAndSave("shop/a", device, device.Version), ShouldBeNil)
+
+			transactions, err := storage.ListTransactions("shop/a", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldHaveLength, 3)
+		})
+	})
+
+	Convey("Given a device that signed before transactions were kept", t, func() {
+		storage := newStorage(t)
+		device := &domain.Device{ID: "a", SignatureCounter: 5}
+		So(storage.Save("a", device), ShouldBeNil)
+
+		Convey("its transactions start at its counter", func() {
+			transaction, err := sign(storage, "a", device)
+			So(err, ShouldBeNil)
+			So(transaction.Counter, ShouldEqual, 5)
+
+			transactions, err := storage.ListTransactions("a", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldResemble, []*domain.Transaction{transaction})
+			_, err = storage.LoadTransaction("a", 4)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func testIdempotencyRecords(t *testing.T, newStorage Factory) {
+	Convey("Given a device that signed with idempotency keys", t, func() {
+		storage := newStorage(t)
+		now := time.Date(2024, 5, 17, 11, 0, 0, 0, time.UTC)
+		device := &domain.Device{ID: "a", TenantID: "shop"}
+		So(storage.Save("shop/a", device), ShouldBeNil)
+		for i, key := range []string{"receipt-0", "receipt-1", "receipt-2"} {
+			_, err := signIdempotent(storage, "shop/a", device, key, now.Add(time.Duration(i)*time.Hour))
+			So(err, ShouldBeNil)
+		}
+		_, err := sign(storage, "shop/a", device)
+		So(err, ShouldBeNil)
+
+		Convey("the record of a key is loaded until it expires", func() {
+			record, err := storage.LoadIdempotencyRecord("shop/a", "receipt-1", now)
+			So(err, ShouldBeNil)
+			So(record, ShouldResemble, &domain.IdempotencyRecord{Key: "receipt-1", RequestHash: "hash of data 1", Counter: 1, ExpiresAt: now.Add(time.Hour)})
+
+			_, err = storage.LoadIdempotencyRecord("shop/a", "receipt-1", now.Add(time.Hour))
+			So(errors.Is(err, persistence.ErrIdempotencyKeyNotFound), ShouldBeTrue)
+			_, err = storage.LoadIdempotencyRecord("shop/a", "receipt-3", now)
+			So(errors.Is(err, persistence.ErrIdempotencyKeyNotFound), ShouldBeTrue)
+			_, err = storage.LoadIdempotencyRecord("shop/b", "receipt-1", now)
+			So(errors.Is(err, persistence.ErrIdempotencyKeyNotFound), ShouldBeTrue)
+		})
+
+		Convey("the records not expired are listed ordered by counter", func() {
+			records, err := storage.ListIdempotencyRecords("shop/a", now)
+			So(err, ShouldBeNil)
+			So(records, ShouldHaveLength, 2)
+			So(records[0].Key, ShouldEqual, "receipt-1")
+			So(records[1].Key, ShouldEqual, "receipt-2")
+
+			_, err = storage.ListIdempotencyRecords("shop/b", now)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a record of another transaction is rejected and nothing is saved", func() {
+			expectedVersion := device.Version
+			transaction := &domain.Transaction{DeviceID: "a", Counter: device.SignatureCounter}
+			device.SignatureCounter++
+			record := &domain.IdempotencyRecord{Key: "receipt-4", Counter: 2, ExpiresAt: now.Add(time.Hour)}
+			err := storage.CompareAndSaveWithTransaction("shop/a", device, expectedVersion, transaction, record)
+			So(err, ShouldNotBeNil)
+
+			stored, _ := storage.Load("shop/a")
+			So(stored.Version, ShouldEqual, expectedVersion)
+			_, err = storage.LoadIdempotencyRecord("shop/a", "receipt-4", now)
+			So(errors.Is(err, persistence.ErrIdempotencyKeyNotFound), ShouldBeTrue)
+		})
+
+		Convey("reusing a key replaces its record", func() {
+			_, err := signIdempotent(storage, "shop/a", device, "receipt-1", now.Add(2*time.Hour))
+			So(err, ShouldBeNil)
+
+			record, err := storage.LoadIdempotencyRecord("shop/a", "receipt-1", now)
+			So(err, ShouldBeNil)
+			So(record.Counter, ShouldEqual, 4)
+		})
+
+		Convey("saving the device with a lower counter discards the records of the transactions from it on", func() {
+			device.SignatureCounter = 2
+			So(storage.Save("shop/a", device), ShouldBeNil)
+
+			records, err := storage.ListIdempotencyRecords("shop/a", now)
+			So(err, ShouldBeNil)
+			So(records, ShouldHaveLength, 1)
+			So(records[0].Key, ShouldEqual, "receipt-1")
+		})
+
+		Convey("deleting the device deletes them", func() {
+			So(storage.Delete("shop/a"), ShouldBeNil)
+			So(storage.Save("shop/a", &domain.Device{ID: "a", TenantID: "shop"}), ShouldBeNil)
+
+			_, err := storage.LoadIdempotencyRecord("shop/a", "receipt-2", now)
+			So(errors.Is(err, persistence.ErrIdempotencyKeyNotFound), ShouldBeTrue)
+		})
+	})
+}
+
+func testConcurrency(t *testing.T, newStorage Factory) {
+	const writers = 8
+	const signaturesEach = 10
+
+	Convey("Given a Storage with a device", t, func() {
+		storage := newStorage(t)
+		So(storage.Save("a", &domain.Device{ID: "a"}), ShouldBeNil)
+
+		Convey("writers racing to sign with it lose no transaction, retrying on conflicts", func() {
+			var wg sync.WaitGroup
+			errs := make(chan error, writers)
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for signed := 0; signed < signaturesEach; {
+						device, err := storage.Load("a")
+						if err != nil {
+							errs <- err
+							return
+						}
+						_, err = sign(storage, "a", device)
+						var conflict *persistence.VersionConflictError
+						if errors.As(err, &conflict) {
+							continue
+						}
+						if err != nil {
+							errs <- err
+							return
+						}
+						signed++
+					}
+				}()
+			}
+
+			// readers meanwhile always see a consistent device
+			stop := make(chan struct{})
+			read := make(chan error, 1)
+			go func() {
+				defer close(read)
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					for _, device := range storage.List() {
+						if device.SignatureCounter > 0 && device.LastSignature != fmt.Sprintf("signature %d", device.SignatureCounter-1) {
+							read <- fmt.Errorf("Device at counter %d has last signature %s", device.SignatureCounter, device.LastSignature)
+							return
+						}
+					}
+				}
+			}()
+
+			wg.Wait()
+			close(stop)
+			close(errs)
+			for err := range errs {
+				So(err, ShouldBeNil)
+			}
+			So(<-read, ShouldBeNil)
+
+			device, err := storage.Load("a")
+			So(err, ShouldBeNil)
+			So(device.SignatureCounter, ShouldEqual, writers*signaturesEach)
+			transactions, err := storage.ListTransactions("a", 0, 0)
+			So(err, ShouldBeNil)
+			So(transactions, ShouldHaveLength, writers*signaturesEach)
+			for i, transaction := range transactions {
+				So(transaction.Counter, ShouldEqual, i)
+			}
+		})
+
+		Convey("writers of different devices do not interfere", func() {
+			var wg sync.WaitGroup
+			errs := make(chan error, writers)
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(id string) {
+					defer wg.Done()
+					device := &domain.Device{ID: id}
+					if err := storage.CompareAndSave(id, device, 0); err != nil {
+						errs <- err
+						return
+					}
+					for j := 0; j < signaturesEach; j++ {
+						if _, err := sign(storage, id, device); err != nil {
+							errs <- err
+							return
+						}
+					}
+				}(fmt.Sprintf("device-%d", i))
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				So(err, ShouldBeNil)
+			}
+
+			So(storage.List(), ShouldHaveLength, writers+1)
+			for i := 0; i < writers; i++ {
+				transactions, err := storage.ListTransactions(fmt.Sprintf("device-%d", i), 0, 0)
+				So(err, ShouldBeNil)
+				So(transactions, ShouldHaveLength, signaturesEach)
+			}
+		})
+	})
+}
+
+func testLargeDataset(t *testing.T, newStorage Factory, options Options) {
+	Convey(fmt.Sprintf("Given a Storage with %d devices, one of which signed %d times", options.LargeDevices, options.LargeTransactions), t, func() {
+		storage := newStorage(t)
+		for i := 0; i < options.LargeDevices; i++ {
+			id := fmt.Sprintf("device-%05d", i)
+			So(storage.Save(id, sampleDevice(id)), ShouldBeNil)
+		}
+		device := &domain.Device{ID: "busy"}
+		So(storage.Save("busy", device), ShouldBeNil)
+		for i := 0; i < options.LargeTransactions; i++ {
+			_, err := sign(storage, "busy", device)
+			So(err, ShouldBeNil)
+		}
+
+		Convey("every device is listed and loaded", func() {
+			So(storage.List(), ShouldHaveLength, options.LargeDevices+1)
+			last := fmt.Sprintf("device-%05d", options.LargeDevices-1)
+			loaded, err := storage.Load(last)
+			So(err, ShouldBeNil)
+			So(loaded, ShouldResemble, func() *domain.Device {
+				expected := sampleDevice(last)
+				expected.Version = 1
+				return expected
+			}())
+		})
+
+		Convey("paging through every transaction finds each once, in order", func() {
+			const pageSize = 100
+			counter := 0
+			for from := 0; ; from += pageSize {
+				page, err := storage.ListTransactions("busy", from, pageSize)
+				So(err, ShouldBeNil)
+				if len(page) == 0 {
+					break
+				}
+				for _, transaction := range page {
+					So(transaction.Counter, ShouldEqual, counter)
+					counter++
+				}
+			}
+			So(counter, ShouldEqual, options.LargeTransactions)
+
+			transaction, err := storage.LoadTransaction("busy", options.LargeTransactions-1)
+			So(err, ShouldBeNil)
+			So(transaction.Signature, ShouldEqual, fmt.Sprintf("signature %d", options.LargeTransactions-1))
+		})
+	})
+}
@@ -0,0 +1,162 @@
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+)
+
+// TenantStore keeps tenants, their devices are kept by the Storage
+type TenantStore interface {
+	// SaveTenant saves @tenant, replacing the one with the same ID if any
+	SaveTenant(tenant *domain.Tenant) error
+	// LoadTenant loads the tenant with ID @id, returns an error if it does not exist
+	LoadTenant(id string) (*domain.Tenant, error)
+	// ListTenants lists all tenants ordered by ID
+	ListTenants() []*domain.Tenant
+	// DeleteTenant deletes the tenant with ID @id, returns an error if it does not exist
+	DeleteTenant(id string) error
+}
+
+// InMemoryTenantStore keeps copies of tenants in a map, lost on restart
+type InMemoryTenantStore struct {
+	tenants map[string]*domain.Tenant
+	mu      sync.RWMutex
+}
+
+func NewInMemoryTenantStore() *InMemoryTenantStore {
+	return &InMemoryTenantStore{
+		tenants: make(map[string]*domain.Tenant),
+	}
+}
+
+func (s *InMemoryTenantStore) SaveTenant(tenant *domain.Tenant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *tenant
+	s.tenants[tenant.ID] = &copied
+	return nil
+}
+
+func (s *InMemoryTenantStore) LoadTenant(id string) (*domain.Tenant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tenant, ok := s.tenants[id]
+	if !ok {
+		return nil, errors.New("Tenant with id " + id + " not found")
+	}
+	copied := *tenant
+	return &copied, nil
+}
+
+func (s *InMemoryTenantStore) ListTenants() []*domain.Tenant {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sortedLocked(true)
+}
+
+func (s *InMemoryTenantStore) DeleteTenant(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tenants[id]; !ok {
+		return errors.New("Tenant with id " + id + " not found")
+	}
+	delete(s.tenants, id)
+	return nil
+}
+
+// sortedLocked returns the tenants ordered by ID, copied if @copied, caller must hold the lock
+func (s *InMemoryTenantStore) sortedLocked(copied bool) []*domain.Tenant {
+	tenants := make([]*domain.Tenant, 0, len(s.tenants))
+	for _, tenant := range s.tenants {
+		if copied {
+			tenantCopy := *tenant
+			tenant = &tenantCopy
+		}
+		tenants = append(tenants, tenant)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+	return tenants
+}
+
+// FileTenantStore is an InMemoryTenantStore that rewrites a JSON file on every change, like FileKeyStore
+type FileTenantStore struct {
+	InMemoryTenantStore
+	path string
+}
+
+// NewFileTenantStore opens the tenant store kept in file @path, which is created on the first change if missing
+func NewFileTenantStore(path string) (*FileTenantStore, error) {
+	s := &FileTenantStore{
+		InMemoryTenantStore: *NewInMemoryTenantStore(),
+		path:                path,
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tenants []*domain.Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, errors.New("Corrupt tenant file " + path + ": " + err.Error())
+	}
+	for _, tenant := range tenants {
+		s.tenants[tenant.ID] = tenant
+	}
+	return s, nil
+}
+
+func (s *FileTenantStore) SaveTenant(tenant *domain.Tenant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.tenants[tenant.ID]
+	copied := *tenant
+	s.tenants[tenant.ID] = &copied
+	if err := s.persistLocked(); err != nil {
+		if existed {
+			s.tenants[tenant.ID] = previous
+		} else {
+			delete(s.tenants, tenant.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileTenantStore) DeleteTenant(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, ok := s.tenants[id]
+	if !ok {
+		return errors.New("Tenant with id " + id + " not found")
+	}
+	delete(s.tenants, id)
+	if err := s.persistLocked(); err != nil {
+		s.tenants[id] = previous
+		return err
+	}
+	return nil
+}
+
+// persistLocked replaces the file with the current tenants, caller must hold the write lock
+func (s *FileTenantStore) persistLocked() error {
+	data, err := json.MarshalIndent(s.sortedLocked(false), "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(s.path, data)
+}
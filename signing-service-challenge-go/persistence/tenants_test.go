@@ -0,0 +1,78 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTenantStores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	stores := map[string]func() TenantStore{
+		"InMemoryTenantStore": func() TenantStore { return NewInMemoryTenantStore() },
+		"FileTenantStore": func() TenantStore {
+			os.Remove(path)
+			s, err := NewFileTenantStore(path)
+			So(err, ShouldBeNil)
+			return s
+		},
+	}
+
+	for name, newStore := range stores {
+		Convey("Given an empty "+name, t, func() {
+			s := newStore()
+
+			Convey("saved tenants are loaded and listed as copies", func() {
+				tenant := &domain.Tenant{ID: "b", Status: domain.TenantActive}
+				So(s.SaveTenant(tenant), ShouldBeNil)
+				So(s.SaveTenant(&domain.Tenant{ID: "a", Status: domain.TenantSuspended}), ShouldBeNil)
+				tenant.Status = domain.TenantSuspended
+
+				loaded, err := s.LoadTenant("b")
+				So(err, ShouldBeNil)
+				So(loaded.Status, ShouldEqual, domain.TenantActive)
+				loaded.Name = "changed"
+
+				tenants := s.ListTenants()
+				So(tenants, ShouldHaveLength, 2)
+				So(tenants[0].ID, ShouldEqual, "a")
+				So(tenants[1].Name, ShouldBeEmpty)
+			})
+
+			Convey("deleted tenants are gone", func() {
+				So(s.SaveTenant(&domain.Tenant{ID: "a"}), ShouldBeNil)
+				So(s.DeleteTenant("a"), ShouldBeNil)
+				_, err := s.LoadTenant("a")
+				So(err, ShouldNotBeNil)
+				So(s.DeleteTenant("a"), ShouldNotBeNil)
+			})
+		})
+	}
+
+	Convey("Given a FileTenantStore with tenants", t, func() {
+		os.Remove(path)
+		s, err := NewFileTenantStore(path)
+		So(err, ShouldBeNil)
+		So(s.SaveTenant(&domain.Tenant{ID: "a", Status: domain.TenantSuspended}), ShouldBeNil)
+		So(s.SaveTenant(&domain.Tenant{ID: "b"}), ShouldBeNil)
+		So(s.DeleteTenant("b"), ShouldBeNil)
+
+		Convey("reopening it restores them", func() {
+			reopened, err := NewFileTenantStore(path)
+			So(err, ShouldBeNil)
+			tenants := reopened.ListTenants()
+			So(tenants, ShouldHaveLength, 1)
+			So(tenants[0].Status, ShouldEqual, domain.TenantSuspended)
+		})
+
+		Convey("a failed write leaves the tenants as they were", func() {
+			s.path = filepath.Join(path, "not-a-directory", "tenants.json")
+			So(s.SaveTenant(&domain.Tenant{ID: "c"}), ShouldNotBeNil)
+			So(s.DeleteTenant("a"), ShouldNotBeNil)
+			So(s.ListTenants(), ShouldHaveLength, 1)
+		})
+	})
+}
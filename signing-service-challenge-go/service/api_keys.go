@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+// CreateAPIKeyInput describes the API key to create
+type CreateAPIKeyInput struct {
+	Name string
+	// Tenant the key belongs to, domain.DefaultTenant for a key of the operator of the service
+	TenantID string
+	// IDs of the devices of its tenant the key may use, auth.AllDevices for every device
+	DeviceIDs []string
+	// Operations the key is permitted, see auth.Operation
+	Operations []string
+}
+
+// CreateAPIKey creates an API key as described by @input, returning it along with the key itself, which is
+// not kept anywhere and cannot be recovered later
+func CreateAPIKey(ctx context.Context, input CreateAPIKeyInput) (*domain.APIKey, string, error) {
+	if err := authorizeAdmin(ctx); err != nil {
+		return nil, "", err
+	}
+
+	if len(input.DeviceIDs) == 0 {
+		return nil, "", &InvalidInputError{Message: `At least one device ID or "` + auth.AllDevices + `" is required`}
+	}
+	if len(input.Operations) == 0 {
+		return nil, "", &InvalidInputError{Message: "At least one operation is required"}
+	}
+	for _, name := range input.Operations {
+		operation, ok := auth.ParseOperation(name)
+		if !ok {
+			return nil, "", &InvalidInputError{Message: "Unknown operation " + name}
+		}
+		if operation == auth.OperationAdmin && input.TenantID != domain.DefaultTenant {
+			return nil, "", &InvalidInputError{Message: "API keys of a tenant cannot be permitted " + name}
+		}
+		if operation == auth.OperationAdmin && (len(input.DeviceIDs) != 1 || input.DeviceIDs[0] != auth.AllDevices) {
+			return nil, "", &InvalidInputError{Message: `API keys permitted ` + name + ` must be permitted every device, "` + auth.AllDevices + `"`}
+		}
+	}
+	if input.TenantID != domain.DefaultTenant {
+		if _, err := persistence.GetTenantStore().LoadTenant(input.TenantID); err != nil {
+			return nil, "", &InvalidInputError{Message: err.Error()}
+		}
+	}
+
+	id, secret, err := auth.GenerateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	key := &domain.APIKey{
+		ID:         id,
+		Name:       input.Name,
+		TenantID:   input.TenantID,
+		Hash:       auth.HashAPIKey(secret),
+		DeviceIDs:  input.DeviceIDs,
+		Operations: input.Operations,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := persistence.GetKeyStore().SaveKey(key); err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+// GetAPIKey returns the API key with ID @id
+func GetAPIKey(ctx context.Context, id string) (*domain.APIKey, error) {
+	if err := authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	key, err := persistence.GetKeyStore().LoadKey(id)
+	if err != nil {
+		return nil, &NotFoundError{Message: err.Error()}
+	}
+	return key, nil
+}
+
+// ListAPIKeys returns all API keys
+func ListAPIKeys(ctx context.Context) ([]*domain.APIKey, error) {
+	if err := authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return persistence.GetKeyStore().ListKeys(), nil
+}
+
+// DeleteAPIKey deletes the API key with ID @id, requests using it are rejected from now on
+func DeleteAPIKey(ctx context.Context, id string) error {
+	if err := authorizeAdmin(ctx); err != nil {
+		return err
+	}
+
+	if err := persistence.GetKeyStore().DeleteKey(id); err != nil {
+		return &NotFoundError{Message: err.Error()}
+	}
+	return nil
+}
+
+// Authenticate returns the principal holding API key @key, ok is false if there is no such key
+func Authenticate(ctx context.Context, key string) (principal *auth.Principal, ok bool) {
+	id, ok := auth.APIKeyID(key)
+	if !ok {
+		return nil, false
+	}
+	stored, err := persistence.GetKeyStore().LoadKey(id)
+	if err != nil || !auth.MatchesAPIKeyHash(key, stored.Hash) {
+		return nil, false
+	}
+
+	operations := make([]auth.Operation, 0, len(stored.Operations))
+	for _, name := range stored.Operations {
+		if operation, ok := auth.ParseOperation(name); ok {
+			operations = append(operations, operation)
+		}
+	}
+	// the devices of an API key are those of its tenant
+	deviceKeys := make([]string, 0, len(stored.DeviceIDs))
+	for _, id := range stored.DeviceIDs {
+		if id != auth.AllDevices {
+			id = domain.DeviceKey(stored.TenantID, id)
+		}
+		deviceKeys = append(deviceKeys, id)
+	}
+	principal = auth.NewPrincipal("API key "+stored.ID, deviceKeys, operations)
+	principal.Tenant = stored.TenantID
+	return principal, true
+}
+
+// authorizeAdmin fails with a *ForbiddenError unless the caller of @ctx may manage API keys and tenants, see
+// auth.CanAdminister, which callers belonging to a tenant never may
+func authorizeAdmin(ctx context.Context) error {
+	tenantID, ok := auth.Tenant(ctx)
+	if !auth.CanAdminister(ctx) || !ok || tenantID != domain.DefaultTenant {
+		return &ForbiddenError{Message: "Not allowed to " + string(auth.OperationAdmin)}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+)
+
+func TestAPIKeys(t *testing.T) {
+	Convey("Given an administrator", t, func() {
+		persistence.SetInstance(persistence.NewInMemoryDB())
+		persistence.SetKeyStore(persistence.NewInMemoryKeyStore())
+		admin := auth.WithPrincipal(context.Background(), auth.NewPrincipal("admin", []string{auth.AllDevices}, auth.AdminOperations))
+
+		Convey("a created key authenticates as a principal restricted like the key", func() {
+			key, secret, err := service.CreateAPIKey(admin, service.CreateAPIKeyInput{
+				Name:       "till 1",
+				DeviceIDs:  []string{"till-1"},
+				Operations: []string{"sign", "list"},
+			})
+			So(err, ShouldBeNil)
+			So(key.Hash, ShouldEqual, auth.HashAPIKey(secret))
+			So(persistence.GetKeyStore().ListKeys()[0].Hash, ShouldNotContainSubstring, secret)
+
+			principal, ok := service.Authenticate(context.Background(), secret)
+			So(ok, ShouldBeTrue)
+			So(principal.CanAccessDevice("till-1"), ShouldBeTrue)
+			So(principal.CanAccessDevice("till-2"), ShouldBeFalse)
+			So(principal.CanPerform(auth.OperationSign), ShouldBeTrue)
+			So(principal.CanPerform(auth.OperationCreate), ShouldBeFalse)
+
+			_, ok = service.Authenticate(context.Background(), key.ID+".wrong")
+			So(ok, ShouldBeFalse)
+			_, ok = service.Authenticate(context.Background(), "garbage")
+			So(ok, ShouldBeFalse)
+
+			Convey("and only the operations of the key are allowed", func() {
+				_, err := service.CreateDevice(admin, service.CreateDeviceInput{ID: "till-1", Algorithm: "ed25519"})
+				So(err, ShouldBeNil)
+
+				holder := auth.WithPrincipal(context.Background(), principal)
+				_, err = service.SignTransaction(holder, "till-1", "data")
+				So(err, ShouldBeNil)
+				devices, err := service.ListDevices(holder)
+				So(err, ShouldBeNil)
+				So(devices, ShouldHaveLength, 1)
+
+				_, err = service.CreateDevice(holder, service.CreateDeviceInput{ID: "till-1", Algorithm: "ed25519", Update: true})
+				So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+				So(err.Error(), ShouldEqual, "Not allowed to create")
+				_, err = service.VerifySignature(holder, "till-1", "data", "c2ln")
+				So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+				_, err = service.ListAPIKeys(holder)
+				So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+			})
+
+			Convey("and a deleted key no longer authenticates", func() {
+				So(service.DeleteAPIKey(admin, key.ID), ShouldBeNil)
+				_, ok := service.Authenticate(context.Background(), secret)
+				So(ok, ShouldBeFalse)
+				So(service.DeleteAPIKey(admin, key.ID), ShouldHaveSameTypeAs, &service.NotFoundError{})
+			})
+		})
+
+		Convey("keys without devices or with unknown operations are rejected", func() {
+			_, _, err := service.CreateAPIKey(admin, service.CreateAPIKeyInput{Operations: []string{"sign"}})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+			_, _, err = service.CreateAPIKey(admin, service.CreateAPIKeyInput{DeviceIDs: []string{"*"}})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+			_, _, err = service.CreateAPIKey(admin, service.CreateAPIKeyInput{DeviceIDs: []string{"*"}, Operations: []string{"fly"}})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+		})
+
+		Convey("admin keys must be permitted every device", func() {
+			_, _, err := service.CreateAPIKey(admin, service.CreateAPIKeyInput{DeviceIDs: []string{"till-1"}, Operations: []string{"admin"}})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+
+			_, secret, err := service.CreateAPIKey(admin, service.CreateAPIKeyInput{DeviceIDs: []string{"*"}, Operations: []string{"admin"}})
+			So(err, ShouldBeNil)
+			principal, ok := service.Authenticate(context.Background(), secret)
+			So(ok, ShouldBeTrue)
+			_, err = service.ListAPIKeys(auth.WithPrincipal(context.Background(), principal))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("callers that are not authenticated, or restricted to some devices, do not administer", func() {
+			_, _, err := service.CreateAPIKey(context.Background(), service.CreateAPIKeyInput{DeviceIDs: []string{"*"}, Operations: []string{"admin"}})
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+
+			scoped := auth.WithPrincipal(context.Background(), auth.NewPrincipal("legacy admin key", []string{"till-1"}, auth.AdminOperations))
+			_, err = service.ListAPIKeys(scoped)
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+
+			till := auth.WithPrincipal(admin, auth.NewPrincipal("CN=till-1", []string{"till-1"}, auth.AllOperations))
+			_, err = service.ListAPIKeys(till)
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+		})
+	})
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/chain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+// CreateBackup takes a snapshot of every device of every tenant with its transactions, of the tenants and of
+// the API keys, see persistence.CreateBackup
+func CreateBackup(ctx context.Context) (*persistence.Backup, error) {
+	if err := authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	return persistence.CreateBackup(persistence.GetInstance(), persistence.GetTenantStore(), persistence.GetKeyStore(), time.Now().UTC())
+}
+
+// RestoreBackup restores the backup archive read from @r into the storage, which must have no device, and the
+// tenant and API key stores, which must have none of the backup. The archive is checked as a whole before
+// anything is restored: besides what persistence.ReadBackup checks, the signature chain of every device must
+// be intact.
+func RestoreBackup(ctx context.Context, r io.Reader) (*persistence.BackupManifest, error) {
+	if err := authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	backup, err := persistence.ReadBackup(r)
+	if err != nil {
+		return nil, &InvalidInputError{Message: err.Error()}
+	}
+	for _, entry := range backup.Devices {
+		keys, err := newKeyring(entry.Device)
+		if err != nil {
+			return nil, &InvalidInputError{Message: "Backup device " + entry.Key + " is unusable: " + err.Error()}
+		}
+		report := chain.Audit(entry.Device, entry.Transactions, keys.verify)
+		if !report.Valid {
+			return nil, &InvalidInputError{Message: fmt.Sprintf("Signature chain of backup device %s is broken at transaction %d: %s",
+				entry.Key, report.Break.Counter, report.Break.Message)}
+		}
+	}
+
+	err = persistence.RestoreBackup(persistence.GetInstance(), persistence.GetTenantStore(), persistence.GetKeyStore(), backup)
+	if errors.Is(err, persistence.ErrStorageNotEmpty) {
+		return nil, &InvalidStateError{Message: err.Error()}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &backup.Manifest, nil
+}
@@ -0,0 +1,111 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+)
+
+func TestBackup(t *testing.T) {
+	Convey("Given devices that signed", t, func() {
+		persistence.SetInstance(persistence.NewInMemoryDB())
+		persistence.SetTenantStore(persistence.NewInMemoryTenantStore())
+		persistence.SetKeyStore(persistence.NewInMemoryKeyStore())
+		ctx := auth.WithPrincipal(context.Background(), auth.NewPrincipal("admin", []string{auth.AllDevices}, auth.AdminOperations))
+
+		for _, id := range []string{"a", "b"} {
+			_, err := service.CreateDevice(ctx, service.CreateDeviceInput{ID: id, Algorithm: "ecc"})
+			So(err, ShouldBeNil)
+		}
+		var signed []string
+		for i := 0; i < 3; i++ {
+			transaction, err := service.SignTransaction(ctx, "a", "receipt")
+			So(err, ShouldBeNil)
+			signed = append(signed, transaction.Signature)
+		}
+		_, err := service.RotateDeviceKey(ctx, "a", service.RotateKeyInput{Algorithm: "ed25519"})
+		So(err, ShouldBeNil)
+		_, err = service.SignTransaction(ctx, "a", "receipt")
+		So(err, ShouldBeNil)
+
+		backupOf := func() *bytes.Buffer {
+			backup, err := service.CreateBackup(ctx)
+			So(err, ShouldBeNil)
+			var archive bytes.Buffer
+			So(backup.Write(&archive), ShouldBeNil)
+			return &archive
+		}
+
+		Convey("their backup restores into an empty storage where they go on signing", func() {
+			_, err := service.CreateTenant(ctx, service.CreateTenantInput{ID: "shop", Name: "Shop"})
+			So(err, ShouldBeNil)
+			key, _, err := service.CreateAPIKey(ctx, service.CreateAPIKeyInput{TenantID: "shop", DeviceIDs: []string{auth.AllDevices}, Operations: []string{"sign"}})
+			So(err, ShouldBeNil)
+			archive := backupOf()
+			persistence.SetInstance(persistence.NewInMemoryDB())
+			persistence.SetTenantStore(persistence.NewInMemoryTenantStore())
+			persistence.SetKeyStore(persistence.NewInMemoryKeyStore())
+
+			manifest, err := service.RestoreBackup(ctx, archive)
+			So(err, ShouldBeNil)
+			So(manifest.Devices, ShouldEqual, 2)
+			So(manifest.Transactions, ShouldEqual, 4)
+			So(manifest.Tenants, ShouldEqual, 1)
+			So(manifest.APIKeys, ShouldEqual, 1)
+			_, err = persistence.GetTenantStore().LoadTenant("shop")
+			So(err, ShouldBeNil)
+			_, err = persistence.GetKeyStore().LoadKey(key.ID)
+			So(err, ShouldBeNil)
+
+			report, err := service.AuditChain(ctx, "a")
+			So(err, ShouldBeNil)
+			So(report.Valid, ShouldBeTrue)
+			So(report.Checked, ShouldEqual, 4)
+
+			transaction, err := service.SignTransaction(ctx, "a", "receipt")
+			So(err, ShouldBeNil)
+			So(transaction.Counter, ShouldEqual, 4)
+
+			Convey("but not a second time", func() {
+				_, err := service.RestoreBackup(ctx, backupOf())
+				So(err, ShouldHaveSameTypeAs, &service.InvalidStateError{})
+			})
+		})
+
+		Convey("a backup of a broken signature chain is not restored", func() {
+			device, err := persistence.GetInstance().Load("a")
+			So(err, ShouldBeNil)
+			device.LastSignature = signed[1]
+			So(persistence.GetInstance().Save("a", device), ShouldBeNil)
+			archive := backupOf()
+			persistence.SetInstance(persistence.NewInMemoryDB())
+
+			_, err = service.RestoreBackup(ctx, archive)
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+			So(err.Error(), ShouldContainSubstring, "Signature chain of backup device a is broken")
+			So(persistence.GetInstance().List(), ShouldBeEmpty)
+		})
+
+		Convey("anything but a backup archive is invalid input", func() {
+			persistence.SetInstance(persistence.NewInMemoryDB())
+			_, err := service.RestoreBackup(ctx, bytes.NewBufferString("{}"))
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+		})
+
+		Convey("only the admin backs up and restores", func() {
+			caller := auth.WithPrincipal(context.Background(), auth.NewPrincipal("CN=till", []string{auth.AllDevices}, []auth.Operation{auth.OperationCreate, auth.OperationSign, auth.OperationList}))
+			_, err := service.CreateBackup(caller)
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+			_, err = service.RestoreBackup(caller, backupOf())
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+			_, err = service.CreateBackup(context.Background())
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+		})
+	})
+}
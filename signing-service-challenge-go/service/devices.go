@@ -0,0 +1,394 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/chain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+// CreateDeviceInput describes the signature device to create
+type CreateDeviceInput struct {
+	ID         string
+	Algorithm  string
+	Parameters *crypto.Parameters // nil = crypto.DefaultParameters of the algorithm
+	Label      *string
+	// Rotate the key of an existing device with the same ID instead of failing
+	Update bool
+}
+
+// UpdateDeviceInput describes changes to an existing device, nil fields are left as they are
+type UpdateDeviceInput struct {
+	Label *string
+}
+
+// CreateDevice creates a signature device with a freshly generated key pair using the algorithm chosen in @input.
+// With @input.Update, an existing device gets a new key pair instead, see RotateDeviceKey.
+func CreateDevice(ctx context.Context, input CreateDeviceInput) (*domain.Device, error) {
+	key, err := authorize(ctx, auth.OperationCreate, input.ID)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(input.ID, "/") {
+		return nil, &InvalidInputError{Message: "Device ID must not contain '/'"}
+	}
+
+	db := persistence.GetInstance()
+	existing, err := db.Load(key)
+	if err == nil && !input.Update {
+		return nil, &AlreadyExistsError{ID: input.ID}
+	}
+
+	algo, err := configuredAlgorithm(input.Algorithm, input.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	device := existing
+	if existing != nil {
+		// replacing rotates the key, so the signature chain goes on and what was signed before stays verifiable
+		if err := rotateKey(ctx, device, input.Algorithm, algo, now); err != nil {
+			return nil, err
+		}
+		if input.Label != nil {
+			device.Label = *input.Label
+		}
+	} else {
+		device, err = newDevice(ctx, input, algo, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// creating must not overwrite a device that appeared meanwhile, updating must not overwrite a newer state
+	expectedVersion := 0
+	if existing != nil {
+		expectedVersion = existing.Version
+	}
+	err = db.CompareAndSave(key, device, expectedVersion)
+	var conflict *persistence.VersionConflictError
+	if errors.As(err, &conflict) {
+		return nil, &ConflictError{Message: "Device with ID " + input.ID + " has been modified concurrently, please try again"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return device, nil
+}
+
+// newDevice builds the device described by @input, active with a fresh key pair of @algo
+func newDevice(ctx context.Context, input CreateDeviceInput, algo crypto.Algorithm, now time.Time) (*domain.Device, error) {
+	publicKey, privateKey, err := generateKeyPair(algo)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantID, _ := auth.Tenant(ctx) // checked by authorize
+	label := ""
+	if input.Label != nil {
+		label = *input.Label
+	}
+	device := &domain.Device{
+		ID:               input.ID,
+		TenantID:         tenantID,
+		Algorithm:        input.Algorithm,
+		Parameters:       crypto.ParametersOf(algo),
+		PublicKey:        publicKey,
+		KeyVersion:       1,
+		Label:            label,
+		SignatureCounter: 0,
+		LastSignature:    chain.Seed(input.ID),
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if err := device.Transition(domain.ActionActivate, now, callerName(ctx), ""); err != nil {
+		return nil, err
+	}
+	if err := device.SealPrivateKey(crypto.GetEnvelope(), privateKey); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// GetDevice returns the device with ID @id
+func GetDevice(ctx context.Context, id string) (*domain.Device, error) {
+	key, err := authorize(ctx, auth.OperationList, id)
+	if err != nil {
+		return nil, err
+	}
+
+	device, err := persistence.GetInstance().Load(key)
+	if err != nil {
+		return nil, &NotFoundError{Message: err.Error()}
+	}
+	return device, nil
+}
+
+// ListDevicesInput filters and pages the devices ListDevicesPage returns, empty filters match any device
+type ListDevicesInput struct {
+	Algorithm   string
+	LabelPrefix string
+	Status      domain.DeviceStatus
+	// Creation time range, CreatedFrom inclusive and CreatedTo exclusive, zero = unbounded
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// NextCursor of the previous page, empty = first page
+	Cursor string
+	// Maximum number of devices, 0 = no limit
+	Limit int
+}
+
+// DevicePage is a page of devices ordered by ID
+type DevicePage struct {
+	Devices []*domain.Device
+	// Cursor of the next page, empty if this is the last one
+	NextCursor string
+}
+
+// ListDevices returns all devices of the tenant of the caller of @ctx it may use, ordered by ID
+func ListDevices(ctx context.Context) ([]*domain.Device, error) {
+	page, err := ListDevicesPage(ctx, ListDevicesInput{})
+	if err != nil {
+		return nil, err
+	}
+	return page.Devices, nil
+}
+
+// ListDevicesPage returns a page of the devices of the tenant of the caller of @ctx it may use, selected
+// by @input and ordered by ID
+func ListDevicesPage(ctx context.Context, input ListDevicesInput) (*DevicePage, error) {
+	if !auth.CanPerform(ctx, auth.OperationList) {
+		return nil, &ForbiddenError{Message: "Not allowed to " + string(auth.OperationList)}
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch input.Status {
+	case "", domain.DeviceActive, domain.DeviceSuspended, domain.DeviceRetired:
+	default:
+		return nil, &InvalidInputError{Message: "Unknown device status " + string(input.Status)}
+	}
+	after, err := decodeDeviceCursor(input.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	query := domain.DeviceQuery{
+		TenantID:    tenantID,
+		Algorithm:   input.Algorithm,
+		LabelPrefix: input.LabelPrefix,
+		Status:      input.Status,
+		CreatedFrom: input.CreatedFrom,
+		CreatedTo:   input.CreatedTo,
+		After:       after,
+	}
+	// one more than asked tells whether there is a next page
+	if input.Limit > 0 {
+		query.Limit = input.Limit + 1
+	}
+
+	// devices the caller may not use are skipped, so the storage is asked until the page is full
+	allowed := []*domain.Device{}
+	for {
+		devices, err := persistence.GetInstance().ListDevices(query)
+		if err != nil {
+			return nil, err
+		}
+		for _, device := range devices {
+			if auth.CanAccessDevice(ctx, device.Key()) {
+				allowed = append(allowed, device)
+			}
+		}
+		if query.Limit == 0 || len(devices) < query.Limit || len(allowed) > input.Limit {
+			break
+		}
+		query.After = devices[len(devices)-1].ID
+	}
+
+	page := &DevicePage{Devices: allowed}
+	if input.Limit > 0 && len(allowed) > input.Limit {
+		page.Devices = allowed[:input.Limit]
+		page.NextCursor = encodeDeviceCursor(page.Devices[input.Limit-1].ID)
+	}
+	return page, nil
+}
+
+// encodeDeviceCursor returns the cursor of the page following the device with ID @id
+func encodeDeviceCursor(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+// decodeDeviceCursor returns the ID of the device @cursor follows, empty for an empty cursor
+func decodeDeviceCursor(cursor string) (string, error) {
+	id, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", &InvalidInputError{Message: "Invalid cursor " + cursor}
+	}
+	return string(id), nil
+}
+
+// UpdateDevice applies @input to the device with ID @id, its key pair and signature chain stay as they are
+func UpdateDevice(ctx context.Context, id string, input UpdateDeviceInput) (*domain.Device, error) {
+	key, err := authorize(ctx, auth.OperationCreate, id)
+	if err != nil {
+		return nil, err
+	}
+
+	as := persistence.NewAtomicStorage(persistence.GetInstance())
+	if err := lockDevice(ctx, as, key); err != nil {
+		return nil, err
+	}
+	defer as.Unlock(key)
+
+	device, err := as.Load(key)
+	if err != nil {
+		return nil, &NotFoundError{Message: err.Error()}
+	}
+
+	if input.Label != nil {
+		device.Label = *input.Label
+	}
+	device.UpdatedAt = time.Now().UTC()
+
+	err = as.CompareAndSave(key, device, device.Version)
+	var conflict *persistence.VersionConflictError
+	if errors.As(err, &conflict) {
+		return nil, &ConflictError{Message: "Device with ID " + id + " has been modified concurrently, please try again"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return device, nil
+}
+
+// DeleteDevice removes the device with ID @id, its key pair is gone for good. Retired devices are kept, so
+// their signatures stay verifiable.
+func DeleteDevice(ctx context.Context, id string) error {
+	key, err := authorize(ctx, auth.OperationCreate, id)
+	if err != nil {
+		return err
+	}
+
+	return deleteDevice(ctx, persistence.NewAtomicStorage(persistence.GetInstance()), key)
+}
+
+// TransitionDevice does @action to the device with ID @id, recording it with @reason in its status history
+func TransitionDevice(ctx context.Context, id string, action domain.DeviceAction, reason string) (*domain.Device, error) {
+	key, err := authorize(ctx, auth.OperationCreate, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// activating and replacing happen by creating
+	if action != domain.ActionSuspend && action != domain.ActionResume && action != domain.ActionRetire {
+		return nil, &InvalidInputError{Message: "Device action " + string(action) + " is not available"}
+	}
+
+	as := persistence.NewAtomicStorage(persistence.GetInstance())
+	if err := lockDevice(ctx, as, key); err != nil {
+		return nil, err
+	}
+	defer as.Unlock(key)
+
+	device, err := as.Load(key)
+	if err != nil {
+		return nil, &NotFoundError{Message: err.Error()}
+	}
+
+	now := time.Now().UTC()
+	if err := device.Transition(action, now, callerName(ctx), reason); err != nil {
+		return nil, &InvalidStateError{Message: err.Error()}
+	}
+	device.UpdatedAt = now
+
+	err = as.CompareAndSave(key, device, device.Version)
+	var conflict *persistence.VersionConflictError
+	if errors.As(err, &conflict) {
+		return nil, &ConflictError{Message: "Device with ID " + id + " has been modified concurrently, please try again"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return device, nil
+}
+
+// deleteDevice deletes the device stored with @key from @as, failing with a *NotFoundError if it does not exist
+// and with an *InvalidStateError if it is retired
+func deleteDevice(ctx context.Context, as *persistence.AtomicStorage, key string) error {
+	if err := lockDevice(ctx, as, key); err != nil {
+		return err
+	}
+	defer as.Unlock(key)
+
+	device, err := as.Load(key)
+	if err != nil {
+		return &NotFoundError{Message: err.Error()}
+	}
+	if device.Status == domain.DeviceRetired {
+		return &InvalidStateError{Message: "Device " + device.ID + " is retired, it is kept so its signatures stay verifiable"}
+	}
+	return as.Delete(key)
+}
+
+// callerName describes the caller of @ctx by the names of its principals, empty if it is not authenticated
+func callerName(ctx context.Context) string {
+	var names []string
+	for _, principal := range auth.Principals(ctx) {
+		names = append(names, principal.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+var lockWait = persistence.DefaultLockTimeout
+
+// LockWait returns how long a request waits for a device locked by another request before giving up
+func LockWait() time.Duration {
+	return lockWait
+}
+
+// SetLockWait replaces how long a request waits for a device locked by another request, for requests from now on
+func SetLockWait(wait time.Duration) {
+	lockWait = wait
+}
+
+// lockDevice locks the device stored with @key in @as, giving up with a *BusyError after LockWait or once
+// @ctx is done
+func lockDevice(ctx context.Context, as *persistence.AtomicStorage, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, lockWait)
+	defer cancel()
+	if err := as.LockContext(ctx, key); err != nil {
+		return &BusyError{ID: key}
+	}
+	return nil
+}
+
+// authorize fails with a *ForbiddenError unless the caller of @ctx may do @operation with the device with ID @id
+// of its tenant, otherwise returns the key that device is stored with. It is checked before anything else, so a
+// caller cannot even tell whether a device it may not use exists. Access is checked by that key, so a grant of a
+// device of one tenant never reaches the device with the same ID of another.
+func authorize(ctx context.Context, operation auth.Operation, id string) (string, error) {
+	if !auth.CanPerform(ctx, operation) {
+		return "", &ForbiddenError{Message: "Not allowed to " + string(operation)}
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return "", err
+	}
+	key := domain.DeviceKey(tenantID, id)
+	if !auth.CanAccessDevice(ctx, key) {
+		return "", &ForbiddenError{Message: "Not allowed to use device " + id}
+	}
+	return key, nil
+}
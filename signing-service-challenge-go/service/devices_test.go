@@ -0,0 +1,224 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+)
+
+func TestDevices(t *testing.T) {
+	Convey("Given an empty InMemoryDB", t, func() {
+		persistence.SetInstance(persistence.NewInMemoryDB())
+		ctx := context.Background()
+
+		Convey("a created device can be fetched, updated and deleted", func() {
+			label := "till"
+			device, err := service.CreateDevice(ctx, service.CreateDeviceInput{ID: "a", Algorithm: "ecc", Label: &label})
+			So(err, ShouldBeNil)
+			So(device.Version, ShouldEqual, 1)
+
+			got, err := service.GetDevice(ctx, "a")
+			So(err, ShouldBeNil)
+			So(got.Label, ShouldEqual, "till")
+
+			label = "till 2"
+			updated, err := service.UpdateDevice(ctx, "a", service.UpdateDeviceInput{Label: &label})
+			So(err, ShouldBeNil)
+			So(updated.Label, ShouldEqual, "till 2")
+			So(updated.PublicKey, ShouldResemble, device.PublicKey)
+
+			So(service.DeleteDevice(ctx, "a"), ShouldBeNil)
+			_, err = service.GetDevice(ctx, "a")
+			So(err, ShouldHaveSameTypeAs, &service.NotFoundError{})
+		})
+
+		Convey("creating an existing device fails unless updating", func() {
+			_, err := service.CreateDevice(ctx, service.CreateDeviceInput{ID: "a", Algorithm: "ecc"})
+			So(err, ShouldBeNil)
+
+			_, err = service.CreateDevice(ctx, service.CreateDeviceInput{ID: "a", Algorithm: "ecc"})
+			So(err, ShouldHaveSameTypeAs, &service.AlreadyExistsError{})
+
+			replaced, err := service.CreateDevice(ctx, service.CreateDeviceInput{ID: "a", Algorithm: "rsa", Update: true})
+			So(err, ShouldBeNil)
+			So(replaced.Algorithm, ShouldEqual, "rsa")
+		})
+
+		Convey("invalid input is reported as such", func() {
+			_, err := service.CreateDevice(ctx, service.CreateDeviceInput{ID: "a", Algorithm: "nope"})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+
+			_, err = service.CreateDevice(ctx, service.CreateDeviceInput{ID: "a", Algorithm: "ed25519"})
+			So(err, ShouldBeNil)
+			_, err = service.VerifySignature(ctx, "a", "data", "not base64!")
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+		})
+
+		Convey("devices created without parameters get the configured defaults", func() {
+			crypto.SetDefaultParameters(map[string]crypto.Parameters{"ecc": {Curve: "P-256"}})
+			defer crypto.SetDefaultParameters(nil)
+
+			device, err := service.CreateDevice(ctx, service.CreateDeviceInput{ID: "a", Algorithm: "ecc"})
+			So(err, ShouldBeNil)
+			So(device.Parameters.Curve, ShouldEqual, "P-256")
+
+			explicit, err := service.CreateDevice(ctx, service.CreateDeviceInput{ID: "b", Algorithm: "ecc", Parameters: &crypto.Parameters{Hash: "SHA-512"}})
+			So(err, ShouldBeNil)
+			So(explicit.Parameters, ShouldResemble, crypto.Parameters{Curve: "P-384", Hash: "SHA-512"})
+		})
+
+		Convey("a caller restricted to some devices can neither use nor see the others", func() {
+			_, err := service.CreateDevice(ctx, service.CreateDeviceInput{ID: "a", Algorithm: "ed25519"})
+			So(err, ShouldBeNil)
+			_, err = service.CreateDevice(ctx, service.CreateDeviceInput{ID: "b", Algorithm: "ed25519"})
+			So(err, ShouldBeNil)
+
+			restricted := auth.WithPrincipal(ctx, auth.NewPrincipal("CN=till-a", []string{"a", "c"}, auth.AllOperations))
+			_, err = service.SignTransaction(restricted, "a", "data")
+			So(err, ShouldBeNil)
+			_, err = service.CreateDevice(restricted, service.CreateDeviceInput{ID: "c", Algorithm: "ed25519"})
+			So(err, ShouldBeNil)
+
+			devices, err := service.ListDevices(restricted)
+			So(err, ShouldBeNil)
+			So(devices, ShouldHaveLength, 2)
+
+			forbidden := &service.ForbiddenError{}
+			_, err = service.SignTransaction(restricted, "b", "data")
+			So(err, ShouldHaveSameTypeAs, forbidden)
+			_, err = service.GetDevice(restricted, "b")
+			So(err, ShouldHaveSameTypeAs, forbidden)
+			_, err = service.GetDevice(restricted, "unknown")
+			So(err, ShouldHaveSameTypeAs, forbidden)
+			_, err = service.CreateDevice(restricted, service.CreateDeviceInput{ID: "d", Algorithm: "ed25519"})
+			So(err, ShouldHaveSameTypeAs, forbidden)
+			_, err = service.ListTransactions(restricted, "b", 0, 0)
+			So(err, ShouldHaveSameTypeAs, forbidden)
+			_, err = service.VerifySignature(restricted, "b", "data", "c2ln")
+			So(err, ShouldHaveSameTypeAs, forbidden)
+			_, err = service.AuditChain(restricted, "b")
+			So(err, ShouldHaveSameTypeAs, forbidden)
+			So(service.DeleteDevice(restricted, "b"), ShouldHaveSameTypeAs, forbidden)
+		})
+
+		Convey("devices are listed in pages ordered by ID, skipping those the caller may not use", func() {
+			for _, id := range []string{"f", "b", "e", "a", "d", "c"} {
+				_, err := service.CreateDevice(ctx, service.CreateDeviceInput{ID: id, Algorithm: "ed25519"})
+				So(err, ShouldBeNil)
+			}
+			_, err := service.TransitionDevice(ctx, "e", domain.ActionSuspend, "")
+			So(err, ShouldBeNil)
+
+			restricted := auth.WithPrincipal(ctx, auth.NewPrincipal("CN=till", []string{"a", "e", "f"}, auth.AllOperations))
+			page, err := service.ListDevicesPage(restricted, service.ListDevicesInput{Limit: 2})
+			So(err, ShouldBeNil)
+			So(page.Devices, ShouldHaveLength, 2)
+			So(page.Devices[0].ID, ShouldEqual, "a")
+			So(page.Devices[1].ID, ShouldEqual, "e")
+			So(page.NextCursor, ShouldNotBeEmpty)
+
+			page, err = service.ListDevicesPage(restricted, service.ListDevicesInput{Cursor: page.NextCursor, Limit: 2})
+			So(err, ShouldBeNil)
+			So(page.Devices, ShouldHaveLength, 1)
+			So(page.Devices[0].ID, ShouldEqual, "f")
+			So(page.NextCursor, ShouldBeEmpty)
+
+			page, err = service.ListDevicesPage(ctx, service.ListDevicesInput{Status: domain.DeviceActive, Limit: 10})
+			So(err, ShouldBeNil)
+			So(page.Devices, ShouldHaveLength, 5)
+			So(page.NextCursor, ShouldBeEmpty)
+
+			_, err = service.ListDevicesPage(ctx, service.ListDevicesInput{Status: "lost"})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+			_, err = service.ListDevicesPage(ctx, service.ListDevicesInput{Cursor: "not a cursor"})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+		})
+
+		Convey("a device goes through its lifecycle, every status change recorded", func() {
+			caller := auth.WithPrincipal(ctx, auth.NewPrincipal("CN=backoffice", []string{auth.AllDevices}, auth.AllOperations))
+			device, err := service.CreateDevice(caller, service.CreateDeviceInput{ID: "a", Algorithm: "ed25519"})
+			So(err, ShouldBeNil)
+			So(device.Status, ShouldEqual, domain.DeviceActive)
+			transaction, err := service.SignTransaction(ctx, "a", "data")
+			So(err, ShouldBeNil)
+
+			suspended, err := service.TransitionDevice(caller, "a", domain.ActionSuspend, "till stolen")
+			So(err, ShouldBeNil)
+			So(suspended.Status, ShouldEqual, domain.DeviceSuspended)
+			_, err = service.SignTransaction(ctx, "a", "data")
+			So(err, ShouldHaveSameTypeAs, &service.InvalidStateError{})
+			So(err.Error(), ShouldEqual, "Device a is suspended, only active devices can sign")
+			_, err = service.CreateDevice(ctx, service.CreateDeviceInput{ID: "a", Algorithm: "ed25519", Update: true})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidStateError{})
+
+			_, err = service.TransitionDevice(ctx, "a", domain.ActionResume, "")
+			So(err, ShouldBeNil)
+			_, err = service.SignTransaction(ctx, "a", "data")
+			So(err, ShouldBeNil)
+
+			retired, err := service.TransitionDevice(ctx, "a", domain.ActionRetire, "till decommissioned")
+			So(err, ShouldBeNil)
+			So(retired.Status, ShouldEqual, domain.DeviceRetired)
+
+			history := retired.StatusHistory
+			So(history, ShouldHaveLength, 4)
+			So(history[0].Action, ShouldEqual, domain.ActionActivate)
+			So(history[0].By, ShouldEqual, "CN=backoffice")
+			So(history[1].From, ShouldEqual, domain.DeviceActive)
+			So(history[1].To, ShouldEqual, domain.DeviceSuspended)
+			So(history[1].Reason, ShouldEqual, "till stolen")
+			So(history[3].Action, ShouldEqual, domain.ActionRetire)
+			So(history[3].At.IsZero(), ShouldBeFalse)
+
+			Convey("and once retired it never signs again, but stays verifiable", func() {
+				_, err := service.SignTransaction(ctx, "a", "data")
+				So(err, ShouldHaveSameTypeAs, &service.InvalidStateError{})
+				for _, action := range []domain.DeviceAction{domain.ActionResume, domain.ActionSuspend, domain.ActionRetire} {
+					_, err = service.TransitionDevice(ctx, "a", action, "")
+					So(err, ShouldHaveSameTypeAs, &service.InvalidStateError{})
+				}
+				_, err = service.CreateDevice(ctx, service.CreateDeviceInput{ID: "a", Algorithm: "ed25519", Update: true})
+				So(err, ShouldHaveSameTypeAs, &service.InvalidStateError{})
+				So(service.DeleteDevice(ctx, "a"), ShouldHaveSameTypeAs, &service.InvalidStateError{})
+
+				result, err := service.VerifyTransaction(ctx, "a", 0, "data", transaction.Signature)
+				So(err, ShouldBeNil)
+				So(result.Verified, ShouldBeTrue)
+				report, err := service.AuditChain(ctx, "a")
+				So(err, ShouldBeNil)
+				So(report.Valid, ShouldBeTrue)
+
+				got, err := service.GetDevice(ctx, "a")
+				So(err, ShouldBeNil)
+				So(got.StatusHistory, ShouldResemble, history)
+			})
+		})
+
+		Convey("only suspending, resuming and retiring are available as transitions", func() {
+			_, err := service.CreateDevice(ctx, service.CreateDeviceInput{ID: "a", Algorithm: "ed25519"})
+			So(err, ShouldBeNil)
+			_, err = service.TransitionDevice(ctx, "a", domain.ActionActivate, "")
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+			_, err = service.TransitionDevice(ctx, "b", domain.ActionSuspend, "")
+			So(err, ShouldHaveSameTypeAs, &service.NotFoundError{})
+		})
+
+		Convey("operations on a locked device give up once the context is done", func() {
+			persistence.GetLockManager().Lock("a")
+			defer persistence.GetLockManager().Unlock("a")
+
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err := service.SignTransaction(cancelled, "a", "data")
+			So(err, ShouldHaveSameTypeAs, &service.BusyError{})
+			So(service.DeleteDevice(cancelled, "a"), ShouldHaveSameTypeAs, &service.BusyError{})
+		})
+	})
+}
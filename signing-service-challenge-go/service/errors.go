@@ -0,0 +1,65 @@
+package service
+
+// NotFoundError is returned when the requested device or transaction does not exist
+type NotFoundError struct {
+	Message string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Message
+}
+
+// InvalidInputError is returned when the caller supplied input the operation cannot work with
+type InvalidInputError struct {
+	Message string
+}
+
+func (e *InvalidInputError) Error() string {
+	return e.Message
+}
+
+// AlreadyExistsError is returned when creating a device whose ID is already taken
+type AlreadyExistsError struct {
+	ID string
+}
+
+func (e *AlreadyExistsError) Error() string {
+	return "Device with ID " + e.ID + " already exists"
+}
+
+// ConflictError is returned when a device kept being modified concurrently, the caller may simply try again
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+// InvalidStateError is returned when the device is not in a status allowing the operation, e.g. signing
+// with a suspended device. Trying again does not help until its status changes.
+type InvalidStateError struct {
+	Message string
+}
+
+func (e *InvalidStateError) Error() string {
+	return e.Message
+}
+
+// BusyError is returned when a device stayed locked by other operations for too long
+type BusyError struct {
+	ID string
+}
+
+func (e *BusyError) Error() string {
+	return "Device " + e.ID + " is busy, please try again in a few moments"
+}
+
+// ForbiddenError is returned when the authenticated caller may not do the operation or not use the device
+type ForbiddenError struct {
+	Message string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+	"unicode"
+)
+
+// DefaultIdempotencyWindow is how long an idempotency key is remembered unless configured otherwise
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// MaxIdempotencyKeyLength bounds the length of idempotency keys, in bytes
+const MaxIdempotencyKeyLength = 255
+
+var idempotencyWindow = DefaultIdempotencyWindow
+
+// IdempotencyWindow returns how long an idempotency key is remembered after the request that used it
+func IdempotencyWindow() time.Duration {
+	return idempotencyWindow
+}
+
+// SetIdempotencyWindow replaces how long an idempotency key is remembered, for requests signing from now on
+func SetIdempotencyWindow(window time.Duration) {
+	idempotencyWindow = window
+}
+
+// checkIdempotencyKey tells whether @key may serve as an idempotency key
+func checkIdempotencyKey(key string) error {
+	if len(key) > MaxIdempotencyKeyLength {
+		return &InvalidInputError{Message: "Idempotency key must not be longer than 255 characters"}
+	}
+	for _, r := range key {
+		if !unicode.IsPrint(r) {
+			return &InvalidInputError{Message: "Idempotency key must consist of printable characters only"}
+		}
+	}
+	return nil
+}
+
+// requestHash identifies a signing request of @data, the device being the same for every request of a key
+func requestHash(data string) string {
+	hash := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(hash[:])
+}
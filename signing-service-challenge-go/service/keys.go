@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+// RotateKeyInput describes the new key of a device
+type RotateKeyInput struct {
+	// Algorithm of the new key, empty = the algorithm of the current key
+	Algorithm string
+	// nil = the parameters of the current key if the algorithm stays, otherwise crypto.DefaultParameters
+	Parameters *crypto.Parameters
+}
+
+// RotateDeviceKey gives the device with ID @id a freshly generated key pair as described by @input. Its signature
+// chain goes on: the first transaction signed with the new key chains from the last one signed with the old key,
+// whose public key is kept to verify the transactions it signed.
+func RotateDeviceKey(ctx context.Context, id string, input RotateKeyInput) (*domain.Device, error) {
+	key, err := authorize(ctx, auth.OperationCreate, id)
+	if err != nil {
+		return nil, err
+	}
+
+	as := persistence.NewAtomicStorage(persistence.GetInstance())
+	if err := lockDevice(ctx, as, key); err != nil {
+		return nil, err
+	}
+	defer as.Unlock(key)
+
+	device, err := as.Load(key)
+	if err != nil {
+		return nil, &NotFoundError{Message: err.Error()}
+	}
+
+	algorithm, parameters := input.Algorithm, input.Parameters
+	if algorithm == "" || algorithm == device.Algorithm {
+		algorithm = device.Algorithm
+		if parameters == nil && !device.Parameters.IsZero() {
+			parameters = &device.Parameters
+		}
+	}
+	algo, err := configuredAlgorithm(algorithm, parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rotateKey(ctx, device, algorithm, algo, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	err = as.CompareAndSave(key, device, device.Version)
+	var conflict *persistence.VersionConflictError
+	if errors.As(err, &conflict) {
+		return nil, &ConflictError{Message: "Device with ID " + id + " has been modified concurrently, please try again"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return device, nil
+}
+
+// ListDeviceKeys returns every key of the device with ID @id, oldest first, the current one last
+func ListDeviceKeys(ctx context.Context, id string) ([]domain.KeyVersion, error) {
+	device, err := GetDevice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return device.Keys(), nil
+}
+
+// rotateKey gives @device a fresh key pair of @algo, named @algorithm, recording it in its status history
+func rotateKey(ctx context.Context, device *domain.Device, algorithm string, algo crypto.Algorithm, now time.Time) error {
+	if err := device.Transition(domain.ActionRotateKey, now, callerName(ctx), "Rotated to key version "+strconv.Itoa(device.KeyVersion+1)); err != nil {
+		return &InvalidStateError{Message: err.Error()}
+	}
+
+	// the public key of the old key is all that is kept of it
+	previousPublicKey := device.PublicKey
+
+	publicKey, privateKey, err := generateKeyPair(algo)
+	if err != nil {
+		return err
+	}
+	if err := device.RotateKey(crypto.GetEnvelope(), algorithm, crypto.ParametersOf(algo), publicKey, privateKey, previousPublicKey, now); err != nil {
+		return err
+	}
+	device.UpdatedAt = now
+	return nil
+}
+
+// configuredAlgorithm returns algorithm @name configured with @parameters, crypto.DefaultParameters if nil
+func configuredAlgorithm(name string, parameters *crypto.Parameters) (crypto.Algorithm, error) {
+	algo := crypto.GetAlgorithm(name)
+	if algo == nil {
+		return nil, &InvalidInputError{Message: "Algorithm " + name + " not available"}
+	}
+
+	params := crypto.DefaultParameters(name)
+	if parameters != nil {
+		params = *parameters
+	}
+	algo, err := crypto.Configure(algo, params)
+	if err != nil {
+		return nil, &InvalidInputError{Message: "Invalid parameters for algorithm " + name + ": " + err.Error()}
+	}
+	return algo, nil
+}
+
+// generateKeyPair generates a key pair of @algo, PEM encoded
+func generateKeyPair(algo crypto.Algorithm) (publicKey []byte, privateKey []byte, err error) {
+	keyPair, err := algo.GenerateKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKey, privateKey, err = keyPair.Serialize()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(publicKey) == 0 {
+		return nil, nil, errors.New("Key pair has no public key")
+	}
+	return publicKey, privateKey, nil
+}
+
+// keyring verifies signatures of a device with whichever of its keys made them
+type keyring struct {
+	device *domain.Device
+	keys   map[int]verificationKey // by version
+}
+
+type verificationKey struct {
+	algo      crypto.Algorithm
+	publicKey crypto.Key
+}
+
+// newKeyring prepares verifying signatures of @device with every one of its keys
+func newKeyring(device *domain.Device) (*keyring, error) {
+	k := &keyring{device: device, keys: make(map[int]verificationKey)}
+	for _, version := range device.Keys() {
+		if version.Version == device.KeyVersion {
+			algo, err := deviceAlgorithm(device)
+			if err != nil {
+				return nil, err
+			}
+			publicKey, err := devicePublicKey(algo, device)
+			if err != nil {
+				return nil, err
+			}
+			k.keys[version.Version] = verificationKey{algo: algo, publicKey: publicKey}
+			continue
+		}
+
+		algo := crypto.GetAlgorithm(version.Algorithm)
+		if algo == nil {
+			return nil, fmt.Errorf("Algorithm %s of key version %d of device %s is not available", version.Algorithm, version.Version, device.ID)
+		}
+		algo, err := crypto.Configure(algo, version.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		parser, ok := algo.(crypto.PublicKeyParser)
+		if !ok {
+			return nil, fmt.Errorf("Public key of key version %d of device %s cannot be parsed", version.Version, device.ID)
+		}
+		publicKey, err := parser.ParsePublicKey(version.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[version.Version] = verificationKey{algo: algo, publicKey: publicKey}
+	}
+	return k, nil
+}
+
+// verify checks @signature over @signedData with the key that signed transaction @counter, it is a chain.Verifier
+func (k *keyring) verify(counter int, signedData []byte, signature []byte) error {
+	version, ok := k.device.KeyFor(counter)
+	if !ok {
+		return fmt.Errorf("No key of device %s signed transaction %d", k.device.ID, counter)
+	}
+	key := k.keys[version.Version]
+	return key.algo.Verify(key.publicKey, signedData, signature)
+}
+
+// verifyAny checks @signature over @signedData with every key until one verifies it, for signatures whose
+// transaction is not known. If none does, why the current key does not is reported.
+func (k *keyring) verifyAny(signedData []byte, signature []byte) error {
+	current := k.keys[k.device.KeyVersion]
+	err := current.algo.Verify(current.publicKey, signedData, signature)
+	if err == nil {
+		return nil
+	}
+	for version, key := range k.keys {
+		if version != k.device.KeyVersion && key.algo.Verify(key.publicKey, signedData, signature) == nil {
+			return nil
+		}
+	}
+	return err
+}
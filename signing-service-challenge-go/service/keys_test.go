@@ -0,0 +1,127 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/chain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+)
+
+func TestKeyRotation(t *testing.T) {
+	Convey("Given a device that signed a transaction", t, func() {
+		persistence.SetInstance(persistence.NewInMemoryDB())
+		ctx := context.Background()
+
+		device, err := service.CreateDevice(ctx, service.CreateDeviceInput{ID: "a", Algorithm: "ecc"})
+		So(err, ShouldBeNil)
+		So(device.KeyVersion, ShouldEqual, 1)
+		first, err := service.SignTransaction(ctx, "a", "first")
+		So(err, ShouldBeNil)
+
+		Convey("rotating its key keeps the signature chain going", func() {
+			rotated, err := service.RotateDeviceKey(ctx, "a", service.RotateKeyInput{Algorithm: "ed25519"})
+			So(err, ShouldBeNil)
+			So(rotated.KeyVersion, ShouldEqual, 2)
+			So(rotated.Algorithm, ShouldEqual, "ed25519")
+			So(rotated.PublicKey, ShouldNotResemble, device.PublicKey)
+			So(rotated.SignatureCounter, ShouldEqual, 1)
+			So(rotated.StatusHistory[len(rotated.StatusHistory)-1].Action, ShouldEqual, domain.ActionRotateKey)
+
+			second, err := service.SignTransaction(ctx, "a", "second")
+			So(err, ShouldBeNil)
+			So(second.Counter, ShouldEqual, 1)
+			So(second.SignedData, ShouldEqual, chain.FormatSignedData(1, "second", first.Signature))
+
+			keys, err := service.ListDeviceKeys(ctx, "a")
+			So(err, ShouldBeNil)
+			So(keys, ShouldHaveLength, 2)
+			So(keys[0].Algorithm, ShouldEqual, "ecc")
+			So(keys[0].PublicKey, ShouldResemble, device.PublicKey)
+			So(keys[0].FirstCounter, ShouldEqual, 0)
+			So(keys[0].LastCounter, ShouldEqual, 0)
+			So(keys[0].RotatedAt.IsZero(), ShouldBeFalse)
+			So(keys[1].FirstCounter, ShouldEqual, 1)
+			So(keys[1].LastCounter, ShouldEqual, 1)
+
+			Convey("and every transaction verifies with the key that signed it", func() {
+				for _, transaction := range []*domain.Transaction{first, second} {
+					result, err := service.VerifyTransaction(ctx, "a", transaction.Counter, transaction.Data, transaction.Signature)
+					So(err, ShouldBeNil)
+					So(result.Verified, ShouldBeTrue)
+
+					result, err = service.VerifySignature(ctx, "a", transaction.SignedData, transaction.Signature)
+					So(err, ShouldBeNil)
+					So(result.Verified, ShouldBeTrue)
+				}
+
+				result, err := service.VerifyTransaction(ctx, "a", 1, "first", first.Signature)
+				So(err, ShouldBeNil)
+				So(result.Verified, ShouldBeFalse)
+
+				report, err := service.AuditChain(ctx, "a")
+				So(err, ShouldBeNil)
+				So(report.Valid, ShouldBeTrue)
+				So(report.Checked, ShouldEqual, 2)
+			})
+
+			Convey("and a key rotated away before signing anything is not kept", func() {
+				_, err := service.RotateDeviceKey(ctx, "a", service.RotateKeyInput{})
+				So(err, ShouldBeNil)
+				again, err := service.RotateDeviceKey(ctx, "a", service.RotateKeyInput{})
+				So(err, ShouldBeNil)
+				So(again.KeyVersion, ShouldEqual, 4)
+				So(again.Algorithm, ShouldEqual, "ed25519")
+
+				keys, err := service.ListDeviceKeys(ctx, "a")
+				So(err, ShouldBeNil)
+				So(keys, ShouldHaveLength, 3)
+				So(keys[2].Version, ShouldEqual, 4)
+
+				report, err := service.AuditChain(ctx, "a")
+				So(err, ShouldBeNil)
+				So(report.Valid, ShouldBeTrue)
+			})
+		})
+
+		Convey("updating it through CreateDevice rotates its key as well", func() {
+			updated, err := service.CreateDevice(ctx, service.CreateDeviceInput{ID: "a", Algorithm: "rsa", Update: true})
+			So(err, ShouldBeNil)
+			So(updated.SignatureCounter, ShouldEqual, 1)
+			So(updated.KeyVersion, ShouldEqual, 2)
+			So(updated.CreatedAt, ShouldEqual, device.CreatedAt)
+
+			_, err = service.SignTransaction(ctx, "a", "second")
+			So(err, ShouldBeNil)
+			report, err := service.AuditChain(ctx, "a")
+			So(err, ShouldBeNil)
+			So(report.Valid, ShouldBeTrue)
+		})
+
+		Convey("the parameters stay unless the algorithm changes", func() {
+			rotated, err := service.RotateDeviceKey(ctx, "a", service.RotateKeyInput{})
+			So(err, ShouldBeNil)
+			So(rotated.Parameters, ShouldResemble, device.Parameters)
+
+			_, err = service.RotateDeviceKey(ctx, "a", service.RotateKeyInput{Algorithm: "nope"})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+			_, err = service.RotateDeviceKey(ctx, "a", service.RotateKeyInput{Parameters: &crypto.Parameters{Curve: "P-1"}})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+		})
+
+		Convey("only an active device gets a new key", func() {
+			_, err := service.TransitionDevice(ctx, "a", domain.ActionSuspend, "")
+			So(err, ShouldBeNil)
+			_, err = service.RotateDeviceKey(ctx, "a", service.RotateKeyInput{})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidStateError{})
+
+			_, err = service.RotateDeviceKey(ctx, "unknown", service.RotateKeyInput{})
+			So(err, ShouldHaveSameTypeAs, &service.NotFoundError{})
+		})
+	})
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+const (
+	// migrationProbe is what a migrated device signs to prove its key still works, nothing is stored
+	migrationProbe = "migration probe"
+
+	// MigratedTenantsFile is the file in the path of its destination MigrateTo keeps the tenants in
+	MigratedTenantsFile = "tenants.json"
+	// MigratedAPIKeysFile is the file in the path of its destination MigrateTo keeps the API keys in
+	MigratedAPIKeysFile = "api_keys.json"
+)
+
+// StorageOpener opens the storage of backend @backend, "file" or "sqlite", kept in directory @path
+type StorageOpener func(backend string, path string) (persistence.Storage, error)
+
+var storageOpener StorageOpener
+
+// SetStorageOpener sets what MigrateTo opens its destination with, nil = MigrateTo is not available
+func SetStorageOpener(opener StorageOpener) {
+	storageOpener = opener
+}
+
+// MigrateToInput is the storage MigrateTo copies into
+type MigrateToInput struct {
+	// "file" or "sqlite"
+	Backend string
+	// Directory the storage, its tenants and its API keys are kept in
+	Path string
+}
+
+// MigrateTo copies every tenant, device and API key the service works on into the storage described by @input,
+// opened with the StorageOpener set, see MigrateStorage. The tenants and API keys are kept in
+// MigratedTenantsFile and MigratedAPIKeysFile in its path, to be configured when serving from it. This is how
+// the memory storage, which nothing else can open, moves to a durable one.
+func MigrateTo(ctx context.Context, input MigrateToInput, progress func(report *persistence.MigrationReport)) (*persistence.MigrationReport, error) {
+	if err := authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if input.Backend != "file" && input.Backend != "sqlite" {
+		return nil, &InvalidInputError{Message: `Storage backend to migrate into must be "file" or "sqlite"`}
+	}
+	if input.Path == "" {
+		return nil, &InvalidInputError{Message: "Storage path to migrate into is required"}
+	}
+	if storageOpener == nil {
+		return nil, &InvalidStateError{Message: "No storage can be opened to migrate into"}
+	}
+
+	storage, err := storageOpener(input.Backend, input.Path)
+	if errors.Is(err, persistence.ErrStorageLocked) {
+		return nil, &InvalidStateError{Message: err.Error()}
+	}
+	if err != nil {
+		return nil, err
+	}
+	tenants, err := persistence.NewFileTenantStore(filepath.Join(input.Path, MigratedTenantsFile))
+	if err != nil {
+		closeDestination(storage)
+		return nil, err
+	}
+	keys, err := persistence.NewFileKeyStore(filepath.Join(input.Path, MigratedAPIKeysFile))
+	if err != nil {
+		closeDestination(storage)
+		return nil, err
+	}
+
+	source := persistence.MigrationStores{
+		Storage: persistence.GetInstance(),
+		Tenants: persistence.GetTenantStore(),
+		Keys:    persistence.GetKeyStore(),
+	}
+	report, err := MigrateStorage(ctx, source, persistence.MigrationStores{Storage: storage, Tenants: tenants, Keys: keys}, progress)
+	if closeErr := closeDestination(storage); err == nil && closeErr != nil {
+		return nil, errors.New("Could not close the storage migrated into: " + closeErr.Error())
+	}
+	return report, err
+}
+
+// closeDestination flushes and releases @storage if it holds anything
+func closeDestination(storage persistence.Storage) error {
+	if closer, ok := storage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// MigrateStorage copies every tenant, every device of every tenant, transactions included, and every API key
+// from @source to @destination, see persistence.Migrate. Every device copied is checked in @destination: its
+// last signature must verify and its private key must still sign what its public key verifies, otherwise it
+// is reported as a mismatch. @progress, unless nil, is called after every device.
+func MigrateStorage(ctx context.Context, source persistence.MigrationStores, destination persistence.MigrationStores,
+	progress func(report *persistence.MigrationReport)) (*persistence.MigrationReport, error) {
+	if err := authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	return persistence.Migrate(source, destination, persistence.MigrateOptions{
+		Verify:   verifyMigratedDevice,
+		Progress: progress,
+	})
+}
+
+// verifyMigratedDevice checks @device, as copied, still verifies @last, its last transaction if known, and
+// still signs with its current key
+func verifyMigratedDevice(device *domain.Device, last *domain.Transaction) error {
+	keys, err := newKeyring(device)
+	if err != nil {
+		return err
+	}
+	if last != nil {
+		signature, err := base64.StdEncoding.DecodeString(last.Signature)
+		if err != nil {
+			return fmt.Errorf("Signature of transaction %d is not base64 encoded", last.Counter)
+		}
+		if err := keys.verify(last.Counter, []byte(last.SignedData), signature); err != nil {
+			return fmt.Errorf("Signature of transaction %d does not verify: %w", last.Counter, err)
+		}
+	}
+
+	algo, err := deviceAlgorithm(device)
+	if err != nil {
+		return err
+	}
+	privateKey, err := device.OpenPrivateKey(crypto.GetEnvelope())
+	if err != nil {
+		return err
+	}
+	kp, err := algo.ConstructKeyPair(privateKey)
+	if err != nil {
+		return err
+	}
+	signature, err := algo.Sign(kp.PrivateKey(), []byte(migrationProbe))
+	if err != nil {
+		return err
+	}
+	publicKey, err := devicePublicKey(algo, device)
+	if err != nil {
+		return err
+	}
+	if err := algo.Verify(publicKey, []byte(migrationProbe), signature); err != nil {
+		return errors.New("Private key does not match public key: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package service_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+)
+
+func TestMigrateStorage(t *testing.T) {
+	Convey("Given devices that signed", t, func() {
+		sourceDB := persistence.NewInMemoryDB()
+		persistence.SetInstance(sourceDB)
+		persistence.SetTenantStore(persistence.NewInMemoryTenantStore())
+		persistence.SetKeyStore(persistence.NewInMemoryKeyStore())
+		source := persistence.MigrationStores{Storage: sourceDB, Tenants: persistence.GetTenantStore(), Keys: persistence.GetKeyStore()}
+		ctx := auth.WithPrincipal(context.Background(), auth.NewPrincipal("admin", []string{auth.AllDevices}, auth.AdminOperations))
+
+		for _, id := range []string{"a", "b", "c"} {
+			_, err := service.CreateDevice(ctx, service.CreateDeviceInput{ID: id, Algorithm: "ecc"})
+			So(err, ShouldBeNil)
+		}
+		for i := 0; i < 2; i++ {
+			_, err := service.SignTransaction(ctx, "a", "receipt")
+			So(err, ShouldBeNil)
+		}
+		_, err := service.RotateDeviceKey(ctx, "a", service.RotateKeyInput{Algorithm: "ed25519"})
+		So(err, ShouldBeNil)
+		_, err = service.SignTransaction(ctx, "a", "receipt")
+		So(err, ShouldBeNil)
+		destinationDB := persistence.NewInMemoryDB()
+		destination := persistence.MigrationStores{Storage: destinationDB, Tenants: persistence.NewInMemoryTenantStore(), Keys: persistence.NewInMemoryKeyStore()}
+
+		Convey("they are migrated to another storage where they go on signing", func() {
+			report, err := service.MigrateStorage(ctx, source, destination, nil)
+			So(err, ShouldBeNil)
+			So(report.Devices, ShouldEqual, 3)
+			So(report.Copied, ShouldEqual, 3)
+			So(report.Transactions, ShouldEqual, 3)
+			So(report.Mismatches, ShouldBeEmpty)
+
+			persistence.SetInstance(destinationDB)
+			audit, err := service.AuditChain(ctx, "a")
+			So(err, ShouldBeNil)
+			So(audit.Valid, ShouldBeTrue)
+			transaction, err := service.SignTransaction(ctx, "a", "receipt")
+			So(err, ShouldBeNil)
+			So(transaction.Counter, ShouldEqual, 3)
+		})
+
+		Convey("a device whose key does not survive the copy is a mismatch", func() {
+			b, err := sourceDB.Load("b")
+			So(err, ShouldBeNil)
+			c, err := sourceDB.Load("c")
+			So(err, ShouldBeNil)
+			b.PublicKey = c.PublicKey
+			So(sourceDB.Save("b", b), ShouldBeNil)
+
+			var progressed []int
+			report, err := service.MigrateStorage(ctx, source, destination, func(report *persistence.MigrationReport) {
+				progressed = append(progressed, report.Devices)
+			})
+			So(err, ShouldBeNil)
+			So(progressed, ShouldResemble, []int{1, 2, 3})
+			So(report.Mismatches, ShouldHaveLength, 1)
+			So(report.Mismatches[0].Key, ShouldEqual, "b")
+			So(report.Mismatches[0].Reason, ShouldContainSubstring, "Private key does not match public key")
+		})
+
+		Convey("they and the tenants and API keys are migrated into a storage opened by path", func() {
+			_, err := service.CreateTenant(ctx, service.CreateTenantInput{ID: "shop", Name: "Shop"})
+			So(err, ShouldBeNil)
+			key, _, err := service.CreateAPIKey(ctx, service.CreateAPIKeyInput{DeviceIDs: []string{"a"}, Operations: []string{"sign"}})
+			So(err, ShouldBeNil)
+			service.SetStorageOpener(func(backend string, path string) (persistence.Storage, error) {
+				So(backend, ShouldEqual, "file")
+				return persistence.NewFileDB(path, persistence.DefaultFileDBOptions())
+			})
+			defer service.SetStorageOpener(nil)
+			dir := t.TempDir()
+
+			report, err := service.MigrateTo(ctx, service.MigrateToInput{Backend: "file", Path: dir}, nil)
+			So(err, ShouldBeNil)
+			So(report.Copied, ShouldEqual, 3)
+			So(report.Tenants, ShouldEqual, 1)
+			So(report.APIKeys, ShouldEqual, 1)
+			So(report.Mismatches, ShouldBeEmpty)
+
+			migrated, err := persistence.NewFileDB(dir, persistence.DefaultFileDBOptions())
+			So(err, ShouldBeNil)
+			So(migrated.List(), ShouldHaveLength, 3)
+			So(migrated.Close(), ShouldBeNil)
+			tenants, err := persistence.NewFileTenantStore(filepath.Join(dir, service.MigratedTenantsFile))
+			So(err, ShouldBeNil)
+			_, err = tenants.LoadTenant("shop")
+			So(err, ShouldBeNil)
+			keys, err := persistence.NewFileKeyStore(filepath.Join(dir, service.MigratedAPIKeysFile))
+			So(err, ShouldBeNil)
+			_, err = keys.LoadKey(key.ID)
+			So(err, ShouldBeNil)
+
+			Convey("but not into one in use", func() {
+				inUse, err := persistence.NewFileDB(dir, persistence.DefaultFileDBOptions())
+				So(err, ShouldBeNil)
+				defer inUse.Close()
+				_, err = service.MigrateTo(ctx, service.MigrateToInput{Backend: "file", Path: dir}, nil)
+				So(err, ShouldHaveSameTypeAs, &service.InvalidStateError{})
+			})
+		})
+
+		Convey("a storage is only migrated into by a durable backend and path", func() {
+			_, err := service.MigrateTo(ctx, service.MigrateToInput{Backend: "memory", Path: "data"}, nil)
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+			_, err = service.MigrateTo(ctx, service.MigrateToInput{Backend: "file"}, nil)
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+			_, err = service.MigrateTo(ctx, service.MigrateToInput{Backend: "file", Path: "data"}, nil)
+			So(err, ShouldHaveSameTypeAs, &service.InvalidStateError{})
+		})
+
+		Convey("only the admin migrates", func() {
+			caller := auth.WithPrincipal(context.Background(), auth.NewPrincipal("CN=till", []string{auth.AllDevices}, []auth.Operation{auth.OperationCreate, auth.OperationSign, auth.OperationList}))
+			_, err := service.MigrateStorage(caller, source, destination, nil)
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+			_, err = service.MigrateStorage(context.Background(), source, destination, nil)
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+			_, err = service.MigrateTo(caller, service.MigrateToInput{Backend: "file", Path: t.TempDir()}, nil)
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+			So(destinationDB.List(), ShouldBeEmpty)
+		})
+	})
+}
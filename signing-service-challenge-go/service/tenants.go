@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// CreateTenantInput describes the tenant to create
+type CreateTenantInput struct {
+	ID   string
+	Name string
+}
+
+// CreateTenant creates an active tenant as described by @input, its devices are created by API keys of the tenant
+func CreateTenant(ctx context.Context, input CreateTenantInput) (*domain.Tenant, error) {
+	if err := authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if !tenantIDPattern.MatchString(input.ID) {
+		return nil, &InvalidInputError{Message: "Tenant ID must be 1 to 64 letters, digits, '-', '_' or '.'"}
+	}
+
+	store := persistence.GetTenantStore()
+	if _, err := store.LoadTenant(input.ID); err == nil {
+		return nil, &ConflictError{Message: "Tenant with ID " + input.ID + " already exists"}
+	}
+
+	now := time.Now().UTC()
+	tenant := &domain.Tenant{
+		ID:        input.ID,
+		Name:      input.Name,
+		Status:    domain.TenantActive,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := store.SaveTenant(tenant); err != nil {
+		return nil, err
+	}
+	return tenant, nil
+}
+
+// GetTenant returns the tenant with ID @id
+func GetTenant(ctx context.Context, id string) (*domain.Tenant, error) {
+	if err := authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	tenant, err := persistence.GetTenantStore().LoadTenant(id)
+	if err != nil {
+		return nil, &NotFoundError{Message: err.Error()}
+	}
+	return tenant, nil
+}
+
+// ListTenants returns all tenants
+func ListTenants(ctx context.Context) ([]*domain.Tenant, error) {
+	if err := authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return persistence.GetTenantStore().ListTenants(), nil
+}
+
+// SuspendTenant suspends the tenant with ID @id: nothing can be done with its devices until it is resumed
+func SuspendTenant(ctx context.Context, id string) (*domain.Tenant, error) {
+	return setTenantStatus(ctx, id, domain.TenantSuspended)
+}
+
+// ResumeTenant makes the devices of the suspended tenant with ID @id usable again
+func ResumeTenant(ctx context.Context, id string) (*domain.Tenant, error) {
+	return setTenantStatus(ctx, id, domain.TenantActive)
+}
+
+// DeleteTenant deletes the tenant with ID @id along with all its devices and API keys. A tenant with retired
+// devices fails with an *InvalidStateError, their signatures must stay verifiable. The tenant is suspended
+// first, so if deleting fails halfway nothing can be done with what is left, and deleting again continues.
+func DeleteTenant(ctx context.Context, id string) error {
+	if err := authorizeAdmin(ctx); err != nil {
+		return err
+	}
+	as := persistence.NewAtomicStorage(persistence.GetInstance())
+	for _, device := range as.List() {
+		if device.TenantID == id && device.Status == domain.DeviceRetired {
+			return &InvalidStateError{Message: "Tenant " + id + " has retired device " + device.ID + ", it is kept so its signatures stay verifiable"}
+		}
+	}
+
+	if _, err := setTenantStatus(ctx, id, domain.TenantSuspended); err != nil {
+		return err
+	}
+	for _, device := range as.List() {
+		if device.TenantID != id {
+			continue
+		}
+		// a device deleted meanwhile is just as fine, one retired meanwhile stops deleting
+		var notFound *NotFoundError
+		if err := deleteDevice(ctx, as, device.Key()); err != nil && !errors.As(err, &notFound) {
+			return err
+		}
+	}
+
+	keys := persistence.GetKeyStore()
+	for _, key := range keys.ListKeys() {
+		if key.TenantID != id {
+			continue
+		}
+		if err := keys.DeleteKey(key.ID); err != nil {
+			return err
+		}
+	}
+
+	return persistence.GetTenantStore().DeleteTenant(id)
+}
+
+// setTenantStatus gives the tenant with ID @id @status
+func setTenantStatus(ctx context.Context, id string, status domain.TenantStatus) (*domain.Tenant, error) {
+	if err := authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	store := persistence.GetTenantStore()
+	tenant, err := store.LoadTenant(id)
+	if err != nil {
+		return nil, &NotFoundError{Message: err.Error()}
+	}
+	if tenant.Status == status {
+		return tenant, nil
+	}
+
+	tenant.Status = status
+	tenant.UpdatedAt = time.Now().UTC()
+	if err := store.SaveTenant(tenant); err != nil {
+		return nil, err
+	}
+	return tenant, nil
+}
+
+// callerTenant returns the ID of the tenant the caller of @ctx belongs to, failing with a *ForbiddenError if
+// that tenant is suspended or gone
+func callerTenant(ctx context.Context) (string, error) {
+	tenantID, ok := auth.Tenant(ctx)
+	if !ok {
+		return "", &ForbiddenError{Message: "Credentials of different tenants given"}
+	}
+	if tenantID == domain.DefaultTenant {
+		return tenantID, nil
+	}
+
+	tenant, err := persistence.GetTenantStore().LoadTenant(tenantID)
+	if err != nil {
+		return "", &ForbiddenError{Message: "Tenant " + tenantID + " does not exist"}
+	}
+	if tenant.Status != domain.TenantActive {
+		return "", &ForbiddenError{Message: "Tenant " + tenantID + " is " + string(tenant.Status)}
+	}
+	return tenantID, nil
+}
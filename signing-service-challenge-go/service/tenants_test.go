@@ -0,0 +1,175 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+)
+
+func TestTenants(t *testing.T) {
+	Convey("Given two tenants each with an API key", t, func() {
+		persistence.SetInstance(persistence.NewInMemoryDB())
+		persistence.SetKeyStore(persistence.NewInMemoryKeyStore())
+		persistence.SetTenantStore(persistence.NewInMemoryTenantStore())
+		admin := auth.WithPrincipal(context.Background(), auth.NewPrincipal("admin", []string{auth.AllDevices}, auth.AdminOperations))
+
+		callers := map[string]context.Context{}
+		keyIDs := map[string]string{}
+		for _, id := range []string{"shop-1", "shop-2"} {
+			tenant, err := service.CreateTenant(admin, service.CreateTenantInput{ID: id, Name: "Shop"})
+			So(err, ShouldBeNil)
+			So(tenant.Status, ShouldEqual, domain.TenantActive)
+
+			key, secret, err := service.CreateAPIKey(admin, service.CreateAPIKeyInput{
+				TenantID:   id,
+				DeviceIDs:  []string{auth.AllDevices},
+				Operations: []string{"create", "sign", "verify", "list"},
+			})
+			So(err, ShouldBeNil)
+			principal, ok := service.Authenticate(context.Background(), secret)
+			So(ok, ShouldBeTrue)
+			So(principal.Tenant, ShouldEqual, id)
+			callers[id] = auth.WithPrincipal(context.Background(), principal)
+			keyIDs[id] = key.ID
+		}
+
+		for _, caller := range []context.Context{callers["shop-1"], callers["shop-2"], context.Background()} {
+			_, err := service.CreateDevice(caller, service.CreateDeviceInput{ID: "a", Algorithm: "ed25519"})
+			So(err, ShouldBeNil)
+		}
+
+		Convey("the same device ID does not collide across tenants", func() {
+			transaction, err := service.SignTransaction(callers["shop-1"], "a", "receipt")
+			So(err, ShouldBeNil)
+			So(transaction.DeviceID, ShouldEqual, "a")
+
+			other, err := service.GetDevice(callers["shop-2"], "a")
+			So(err, ShouldBeNil)
+			So(other.TenantID, ShouldEqual, "shop-2")
+			So(other.SignatureCounter, ShouldEqual, 0)
+
+			result, err := service.VerifyTransaction(callers["shop-2"], "a", 0, "receipt", transaction.Signature)
+			So(err, ShouldBeNil)
+			So(result.Outcome, ShouldEqual, service.VerifyUnknownCounter)
+			result, err = service.VerifyTransaction(callers["shop-1"], "a", 0, "receipt", transaction.Signature)
+			So(err, ShouldBeNil)
+			So(result.Verified, ShouldBeTrue)
+
+			_, err = service.CreateDevice(callers["shop-1"], service.CreateDeviceInput{ID: "a", Algorithm: "ed25519"})
+			So(err, ShouldHaveSameTypeAs, &service.AlreadyExistsError{})
+		})
+
+		Convey("devices are only listed to their tenant", func() {
+			for id, caller := range callers {
+				devices, err := service.ListDevices(caller)
+				So(err, ShouldBeNil)
+				So(devices, ShouldHaveLength, 1)
+				So(devices[0].TenantID, ShouldEqual, id)
+			}
+			devices, err := service.ListDevices(admin)
+			So(err, ShouldBeNil)
+			So(devices, ShouldHaveLength, 1)
+			So(devices[0].TenantID, ShouldEqual, domain.DefaultTenant)
+		})
+
+		Convey("a device grant of a client certificate only reaches the tenant it names", func() {
+			bare := auth.NewPrincipal("CN=till-a", []string{"a"}, auth.AllOperations)
+			qualified := auth.NewPrincipal("CN=till-shop-1-a", []string{domain.DeviceKey("shop-1", "a")}, auth.AllOperations)
+
+			_, err := service.GetDevice(auth.WithPrincipal(callers["shop-1"], bare), "a")
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+			device, err := service.GetDevice(auth.WithPrincipal(context.Background(), bare), "a")
+			So(err, ShouldBeNil)
+			So(device.TenantID, ShouldEqual, domain.DefaultTenant)
+
+			device, err = service.GetDevice(auth.WithPrincipal(callers["shop-1"], qualified), "a")
+			So(err, ShouldBeNil)
+			So(device.TenantID, ShouldEqual, "shop-1")
+			_, err = service.GetDevice(auth.WithPrincipal(callers["shop-2"], qualified), "a")
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+			devices, err := service.ListDevices(auth.WithPrincipal(callers["shop-2"], qualified))
+			So(err, ShouldBeNil)
+			So(devices, ShouldBeEmpty)
+		})
+
+		Convey("nothing can be done with the devices of a suspended tenant until it is resumed", func() {
+			tenant, err := service.SuspendTenant(admin, "shop-1")
+			So(err, ShouldBeNil)
+			So(tenant.Status, ShouldEqual, domain.TenantSuspended)
+
+			_, err = service.SignTransaction(callers["shop-1"], "a", "receipt")
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+			So(err.Error(), ShouldEqual, "Tenant shop-1 is suspended")
+			_, err = service.ListDevices(callers["shop-1"])
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+			_, err = service.SignTransaction(callers["shop-2"], "a", "receipt")
+			So(err, ShouldBeNil)
+
+			_, err = service.ResumeTenant(admin, "shop-1")
+			So(err, ShouldBeNil)
+			_, err = service.SignTransaction(callers["shop-1"], "a", "receipt")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("deleting a tenant deletes its devices and API keys", func() {
+			So(service.DeleteTenant(admin, "shop-1"), ShouldBeNil)
+
+			_, err := service.GetTenant(admin, "shop-1")
+			So(err, ShouldHaveSameTypeAs, &service.NotFoundError{})
+			_, err = persistence.GetKeyStore().LoadKey(keyIDs["shop-1"])
+			So(err, ShouldNotBeNil)
+			_, err = persistence.GetInstance().Load("shop-1/a")
+			So(err, ShouldNotBeNil)
+			_, err = service.GetDevice(callers["shop-1"], "a")
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+
+			So(persistence.GetInstance().List(), ShouldHaveLength, 2)
+			_, err = persistence.GetKeyStore().LoadKey(keyIDs["shop-2"])
+			So(err, ShouldBeNil)
+			So(service.DeleteTenant(admin, "shop-1"), ShouldHaveSameTypeAs, &service.NotFoundError{})
+		})
+
+		Convey("a tenant with a retired device is not deleted, its signatures stay verifiable", func() {
+			transaction, err := service.SignTransaction(callers["shop-1"], "a", "receipt")
+			So(err, ShouldBeNil)
+			_, err = service.TransitionDevice(callers["shop-1"], "a", domain.ActionRetire, "shop closed")
+			So(err, ShouldBeNil)
+
+			So(service.DeleteTenant(admin, "shop-1"), ShouldHaveSameTypeAs, &service.InvalidStateError{})
+
+			tenant, err := service.GetTenant(admin, "shop-1")
+			So(err, ShouldBeNil)
+			So(tenant.Status, ShouldEqual, domain.TenantActive)
+			_, err = persistence.GetKeyStore().LoadKey(keyIDs["shop-1"])
+			So(err, ShouldBeNil)
+			result, err := service.VerifyTransaction(callers["shop-1"], "a", 0, "receipt", transaction.Signature)
+			So(err, ShouldBeNil)
+			So(result.Verified, ShouldBeTrue)
+		})
+
+		Convey("only callers not belonging to any tenant may administrate", func() {
+			_, err := service.CreateTenant(callers["shop-1"], service.CreateTenantInput{ID: "shop-3"})
+			So(err, ShouldHaveSameTypeAs, &service.ForbiddenError{})
+
+			_, _, err = service.CreateAPIKey(admin, service.CreateAPIKeyInput{TenantID: "shop-1", DeviceIDs: []string{"*"}, Operations: []string{"admin"}})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+			_, _, err = service.CreateAPIKey(admin, service.CreateAPIKeyInput{TenantID: "shop-9", DeviceIDs: []string{"*"}, Operations: []string{"sign"}})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+		})
+
+		Convey("tenant IDs are unique and restricted", func() {
+			_, err := service.CreateTenant(admin, service.CreateTenantInput{ID: "shop-1"})
+			So(err, ShouldHaveSameTypeAs, &service.ConflictError{})
+			_, err = service.CreateTenant(admin, service.CreateTenantInput{ID: "shop/3"})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+			_, err = service.CreateDevice(callers["shop-1"], service.CreateDeviceInput{ID: "b/c", Algorithm: "ed25519"})
+			So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+		})
+	})
+}
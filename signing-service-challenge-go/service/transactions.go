@@ -0,0 +1,382 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/auth"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/chain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/crypto"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+)
+
+// MaxSignAttempts bounds how often signing is retried when the device was saved by someone else meanwhile
+const MaxSignAttempts = 5
+
+// VerifyOutcome classifies the result of verifying a signature
+type VerifyOutcome string
+
+const (
+	// The signature is valid, at the given position if one was given
+	VerifyOK VerifyOutcome = "verified"
+	// The signature was not made by the device over the (reconstructed) signed data
+	VerifyBadSignature VerifyOutcome = "bad_signature"
+	// The signature is one the device made, but for another transaction than the one at the given position
+	VerifyWrongPosition VerifyOutcome = "wrong_position"
+	// The device has no transaction at the given position
+	VerifyUnknownCounter VerifyOutcome = "unknown_counter"
+)
+
+// VerifyResult tells whether a signature is valid and if not, why
+type VerifyResult struct {
+	Verified bool
+	Outcome  VerifyOutcome
+	Reason   string
+	// What the signature was checked against, only set when verifying a transaction
+	SignedData string
+	// Counter of the transaction the signature actually belongs to, only set for VerifyWrongPosition
+	ActualCounter int
+}
+
+// SignTransaction signs @data with the device with ID @id, chaining the signature to the previous one of the device
+func SignTransaction(ctx context.Context, id string, data string) (*domain.Transaction, error) {
+	transaction, _, err := SignTransactionOnce(ctx, id, data, "")
+	return transaction, err
+}
+
+// SignTransactionOnce is SignTransaction that signs at most once per @idempotencyKey (empty = no key) within
+// IdempotencyWindow. Repeating a request returns the transaction it signed, with @replayed set, even if the
+// device may no longer sign. Reusing the key for other @data is a ConflictError.
+func SignTransactionOnce(ctx context.Context, id string, data string, idempotencyKey string) (transaction *domain.Transaction, replayed bool, err error) {
+	key, err := authorize(ctx, auth.OperationSign, id)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := checkIdempotencyKey(idempotencyKey); err != nil {
+		return nil, false, err
+	}
+
+	as := persistence.NewAtomicStorage(persistence.GetInstance())
+	if err := lockDevice(ctx, as, key); err != nil {
+		return nil, false, err
+	}
+	defer as.Unlock(key)
+
+	for attempt := 1; ; attempt++ {
+		device, err := as.Load(key)
+		if err != nil {
+			return nil, false, &NotFoundError{Message: err.Error()}
+		}
+
+		now := time.Now().UTC()
+		if idempotencyKey != "" {
+			record, err := as.LoadIdempotencyRecord(key, idempotencyKey, now)
+			if err != nil && !errors.Is(err, persistence.ErrIdempotencyKeyNotFound) {
+				return nil, false, err
+			}
+			if err == nil {
+				if record.RequestHash != requestHash(data) {
+					return nil, false, &ConflictError{Message: "Idempotency key " + idempotencyKey + " was already used with other data for device " + id}
+				}
+				transaction, err := as.LoadTransaction(key, record.Counter)
+				if err != nil {
+					return nil, false, err
+				}
+				return transaction, true, nil
+			}
+		}
+
+		if status := device.Status; status != domain.DeviceActive {
+			return nil, false, &InvalidStateError{Message: "Device " + id + " is " + string(status) + ", only active devices can sign"}
+		}
+
+		algo, err := deviceAlgorithm(device)
+		if err != nil {
+			return nil, false, err
+		}
+
+		privateKey, err := device.OpenPrivateKey(crypto.GetEnvelope())
+		if err != nil {
+			return nil, false, err
+		}
+
+		kp, err := algo.ConstructKeyPair(privateKey)
+		if err != nil {
+			return nil, false, err
+		}
+
+		signedData := chain.FormatSignedData(device.SignatureCounter, data, device.LastSignature)
+		signature, err := algo.Sign(kp.PrivateKey(), []byte(signedData))
+		if err != nil {
+			return nil, false, err
+		}
+
+		transaction := &domain.Transaction{
+			DeviceID:   device.ID,
+			Counter:    device.SignatureCounter,
+			Data:       data,
+			SignedData: signedData,
+			Signature:  base64.StdEncoding.EncodeToString(signature),
+			CreatedAt:  now,
+		}
+
+		expectedVersion := device.Version
+		device.SignatureCounter++
+		device.LastSignature = transaction.Signature
+		device.UpdatedAt = now
+		var record *domain.IdempotencyRecord
+		if idempotencyKey != "" {
+			record = &domain.IdempotencyRecord{
+				Key:         idempotencyKey,
+				RequestHash: requestHash(data),
+				Counter:     transaction.Counter,
+				ExpiresAt:   now.Add(IdempotencyWindow()),
+			}
+		}
+
+		err = as.CompareAndSaveWithTransaction(key, device, expectedVersion, transaction, record)
+		var conflict *persistence.VersionConflictError
+		if errors.As(err, &conflict) {
+			// another replica signed with this device in between, start over from its latest state
+			if attempt < MaxSignAttempts {
+				continue
+			}
+			return nil, false, &ConflictError{Message: "Device " + id + " is being modified concurrently, please try again in a few moments"}
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		return transaction, false, nil
+	}
+}
+
+// GetTransaction returns transaction number @counter of the device with ID @id
+func GetTransaction(ctx context.Context, id string, counter int) (*domain.Transaction, error) {
+	key, err := authorize(ctx, auth.OperationList, id)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction, err := persistence.GetInstance().LoadTransaction(key, counter)
+	if err != nil {
+		return nil, &NotFoundError{Message: err.Error()}
+	}
+	return transaction, nil
+}
+
+// ListTransactions returns up to @limit (0 = no limit) transactions of the device with ID @id ordered by
+// counter, starting at counter @from
+func ListTransactions(ctx context.Context, id string, from int, limit int) ([]*domain.Transaction, error) {
+	key, err := authorize(ctx, auth.OperationList, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if from < 0 || limit < 0 {
+		return nil, &InvalidInputError{Message: "Transaction counter and limit must not be negative"}
+	}
+
+	transactions, err := persistence.GetInstance().ListTransactions(key, from, limit)
+	if err != nil {
+		return nil, &NotFoundError{Message: err.Error()}
+	}
+	return transactions, nil
+}
+
+// AuditChain verifies the whole signature chain of the device with ID @id, from its seed through every stored
+// transaction to its last signature
+func AuditChain(ctx context.Context, id string) (*chain.Report, error) {
+	key, err := authorize(ctx, auth.OperationVerify, id)
+	if err != nil {
+		return nil, err
+	}
+
+	as := persistence.NewAtomicStorage(persistence.GetInstance())
+	if err := lockDevice(ctx, as, key); err != nil {
+		return nil, err
+	}
+	defer as.Unlock(key)
+
+	device, err := as.Load(key)
+	if err != nil {
+		return nil, &NotFoundError{Message: err.Error()}
+	}
+
+	transactions, err := as.ListTransactions(key, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// every transaction is verified with the key that was current when it was signed
+	keys, err := newKeyring(device)
+	if err != nil {
+		return nil, err
+	}
+
+	return chain.Audit(device, transactions, keys.verify), nil
+}
+
+// VerifySignature checks whether base64 encoded @signature is a signature of @data made by the device with ID @id
+func VerifySignature(ctx context.Context, id string, data string, signature string) (*VerifyResult, error) {
+	key, err := authorize(ctx, auth.OperationVerify, id)
+	if err != nil {
+		return nil, err
+	}
+
+	as := persistence.NewAtomicStorage(persistence.GetInstance())
+	if err := lockDevice(ctx, as, key); err != nil {
+		return nil, err
+	}
+	defer as.Unlock(key)
+
+	v, err := newVerification(as, key, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	err = v.keys.verifyAny([]byte(data), v.signature)
+	result := &VerifyResult{
+		Verified: err == nil,
+		Outcome:  VerifyOK,
+	}
+	if err != nil {
+		result.Outcome = VerifyBadSignature
+		result.Reason = err.Error()
+	}
+	return result, nil
+}
+
+// VerifyTransaction checks whether base64 encoded @signature is the signature of transaction @counter over the
+// original @data of the device with ID @id. The signed data is reconstructed from the ledger, previous signature
+// included, so a genuine signature replayed at another position of the chain does not pass.
+func VerifyTransaction(ctx context.Context, id string, counter int, data string, signature string) (*VerifyResult, error) {
+	key, err := authorize(ctx, auth.OperationVerify, id)
+	if err != nil {
+		return nil, err
+	}
+
+	as := persistence.NewAtomicStorage(persistence.GetInstance())
+	if err := lockDevice(ctx, as, key); err != nil {
+		return nil, err
+	}
+	defer as.Unlock(key)
+
+	v, err := newVerification(as, key, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := as.LoadTransaction(key, counter)
+	if err != nil {
+		return &VerifyResult{
+			Outcome: VerifyUnknownCounter,
+			Reason:  err.Error(),
+		}, nil
+	}
+
+	previousSignature, err := previousSignatureOf(as, key, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &VerifyResult{
+		SignedData: chain.FormatSignedData(counter, data, previousSignature),
+	}
+	err = v.keys.verify(counter, []byte(result.SignedData), v.signature)
+	if err == nil {
+		result.Verified, result.Outcome = true, VerifyOK
+		return result, nil
+	}
+	result.Reason = err.Error()
+
+	// a signature the device made elsewhere in the chain is genuine, just not for this position
+	transactions, err := as.ListTransactions(key, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, transaction := range transactions {
+		if transaction.Counter != counter && transaction.Signature == signature {
+			result.Outcome, result.ActualCounter = VerifyWrongPosition, transaction.Counter
+			result.Reason = fmt.Sprintf("Signature belongs to transaction %d, not %d", transaction.Counter, counter)
+			return result, nil
+		}
+	}
+
+	result.Outcome = VerifyBadSignature
+	return result, nil
+}
+
+// verification holds what verifying a signature of a device needs
+type verification struct {
+	keys      *keyring
+	signature []byte
+}
+
+// newVerification loads the device stored with @key from @as and prepares verifying base64 encoded @signature
+func newVerification(as *persistence.AtomicStorage, key string, signature string) (*verification, error) {
+	device, err := as.Load(key)
+	if err != nil {
+		return nil, &NotFoundError{Message: err.Error()}
+	}
+
+	decodedSignature, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, &InvalidInputError{Message: err.Error()}
+	}
+
+	keys, err := newKeyring(device)
+	if err != nil {
+		return nil, err
+	}
+
+	return &verification{keys: keys, signature: decodedSignature}, nil
+}
+
+// previousSignatureOf returns the signature @transaction of the device stored with @key chains from: the seed
+// for the first transaction, otherwise the signature of the transaction before it, or for the first transaction
+// kept of a device that signed before transactions were kept, what its own signed data says
+func previousSignatureOf(as *persistence.AtomicStorage, key string, transaction *domain.Transaction) (string, error) {
+	if transaction.Counter == 0 {
+		return chain.Seed(transaction.DeviceID), nil
+	}
+	if previous, err := as.LoadTransaction(key, transaction.Counter-1); err == nil {
+		return previous.Signature, nil
+	}
+	if previousSignature, ok := chain.PreviousSignatureOf(transaction); ok {
+		return previousSignature, nil
+	}
+	return "", fmt.Errorf("Signed data of transaction %d of device %s is corrupt", transaction.Counter, transaction.DeviceID)
+}
+
+// deviceAlgorithm returns the algorithm of @device configured with its parameters. Failing is not the caller's
+// fault, as the device was created with an algorithm that used to be available.
+func deviceAlgorithm(device *domain.Device) (crypto.Algorithm, error) {
+	algo := crypto.GetAlgorithm(device.Algorithm)
+	if algo == nil {
+		return nil, errors.New("Algorithm " + device.Algorithm + " of device " + device.ID + " is not available")
+	}
+	return crypto.Configure(algo, device.Parameters)
+}
+
+// devicePublicKey returns the public key of @device usable by @algo. The stored public key is parsed so that
+// verifying does not need to decrypt the private key, which is only done for algorithms unable to parse one.
+func devicePublicKey(algo crypto.Algorithm, device *domain.Device) (crypto.Key, error) {
+	if parser, ok := algo.(crypto.PublicKeyParser); ok {
+		return parser.ParsePublicKey(device.PublicKey)
+	}
+
+	privateKey, err := device.OpenPrivateKey(crypto.GetEnvelope())
+	if err != nil {
+		return nil, err
+	}
+	kp, err := algo.ConstructKeyPair(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return kp.PublicKey(), nil
+}
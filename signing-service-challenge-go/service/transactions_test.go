@@ -0,0 +1,165 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/persistence"
+	"github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/service"
+)
+
+func TestTransactions(t *testing.T) {
+	Convey("Given a device", t, func() {
+		persistence.SetInstance(persistence.NewInMemoryDB())
+		ctx := context.Background()
+		_, err := service.CreateDevice(ctx, service.CreateDeviceInput{ID: "a", Algorithm: "ecc"})
+		So(err, ShouldBeNil)
+
+		Convey("signing chains every signature into the next one", func() {
+			first, err := service.SignTransaction(ctx, "a", "one")
+			So(err, ShouldBeNil)
+			So(first.Counter, ShouldEqual, 0)
+			So(first.SignedData, ShouldEqual, "0_one_YQ==")
+
+			second, err := service.SignTransaction(ctx, "a", "two")
+			So(err, ShouldBeNil)
+			So(second.Counter, ShouldEqual, 1)
+			So(second.SignedData, ShouldEqual, "1_two_"+first.Signature)
+
+			result, err := service.VerifySignature(ctx, "a", second.SignedData, second.Signature)
+			So(err, ShouldBeNil)
+			So(result.Verified, ShouldBeTrue)
+
+			result, err = service.VerifySignature(ctx, "a", first.SignedData, second.Signature)
+			So(err, ShouldBeNil)
+			So(result.Verified, ShouldBeFalse)
+			So(result.Reason, ShouldNotBeEmpty)
+
+			Convey("and verifying at a counter tells a replayed signature from a bad one", func() {
+				result, err := service.VerifyTransaction(ctx, "a", 1, "two", second.Signature)
+				So(err, ShouldBeNil)
+				So(result.Verified, ShouldBeTrue)
+				So(result.Outcome, ShouldEqual, service.VerifyOK)
+				So(result.SignedData, ShouldEqual, second.SignedData)
+
+				// a genuine signature that plain verification accepts, but not at this position
+				result, err = service.VerifySignature(ctx, "a", first.SignedData, first.Signature)
+				So(err, ShouldBeNil)
+				So(result.Verified, ShouldBeTrue)
+				result, err = service.VerifyTransaction(ctx, "a", 1, "one", first.Signature)
+				So(err, ShouldBeNil)
+				So(result.Verified, ShouldBeFalse)
+				So(result.Outcome, ShouldEqual, service.VerifyWrongPosition)
+				So(result.ActualCounter, ShouldEqual, 0)
+
+				result, err = service.VerifyTransaction(ctx, "a", 0, "two", second.Signature)
+				So(err, ShouldBeNil)
+				So(result.Verified, ShouldBeFalse)
+				So(result.Outcome, ShouldEqual, service.VerifyWrongPosition)
+				So(result.ActualCounter, ShouldEqual, 1)
+
+				// the right signature for the position, but over altered data
+				result, err = service.VerifyTransaction(ctx, "a", 0, "two", first.Signature)
+				So(err, ShouldBeNil)
+				So(result.Outcome, ShouldEqual, service.VerifyBadSignature)
+
+				result, err = service.VerifyTransaction(ctx, "a", 0, "forged", "Zm9yZ2Vk")
+				So(err, ShouldBeNil)
+				So(result.Verified, ShouldBeFalse)
+				So(result.Outcome, ShouldEqual, service.VerifyBadSignature)
+				So(result.Reason, ShouldNotBeEmpty)
+
+				result, err = service.VerifyTransaction(ctx, "a", 2, "three", second.Signature)
+				So(err, ShouldBeNil)
+				So(result.Verified, ShouldBeFalse)
+				So(result.Outcome, ShouldEqual, service.VerifyUnknownCounter)
+
+				_, err = service.VerifyTransaction(ctx, "b", 0, "one", first.Signature)
+				So(err, ShouldHaveSameTypeAs, &service.NotFoundError{})
+			})
+
+			Convey("and both transactions are kept in the ledger", func() {
+				stored, err := service.GetTransaction(ctx, "a", 0)
+				So(err, ShouldBeNil)
+				So(stored.SignedData, ShouldEqual, first.SignedData)
+				So(stored.Signature, ShouldEqual, first.Signature)
+
+				_, err = service.GetTransaction(ctx, "a", 2)
+				So(err, ShouldHaveSameTypeAs, &service.NotFoundError{})
+
+				transactions, err := service.ListTransactions(ctx, "a", 1, 10)
+				So(err, ShouldBeNil)
+				So(transactions, ShouldHaveLength, 1)
+				So(transactions[0].Data, ShouldEqual, "two")
+			})
+		})
+
+		Convey("a request repeated with the same idempotency key signs only once", func() {
+			first, replayed, err := service.SignTransactionOnce(ctx, "a", "one", "receipt-1")
+			So(err, ShouldBeNil)
+			So(replayed, ShouldBeFalse)
+
+			again, replayed, err := service.SignTransactionOnce(ctx, "a", "one", "receipt-1")
+			So(err, ShouldBeNil)
+			So(replayed, ShouldBeTrue)
+			So(again, ShouldResemble, first)
+
+			_, _, err = service.SignTransactionOnce(ctx, "a", "two", "receipt-1")
+			So(err, ShouldHaveSameTypeAs, &service.ConflictError{})
+
+			second, replayed, err := service.SignTransactionOnce(ctx, "a", "one", "receipt-2")
+			So(err, ShouldBeNil)
+			So(replayed, ShouldBeFalse)
+			So(second.Counter, ShouldEqual, 1)
+
+			device, err := service.GetDevice(ctx, "a")
+			So(err, ShouldBeNil)
+			So(device.SignatureCounter, ShouldEqual, 2)
+
+			Convey("even once the device may no longer sign", func() {
+				_, err := service.TransitionDevice(ctx, "a", domain.ActionSuspend, "")
+				So(err, ShouldBeNil)
+				again, replayed, err := service.SignTransactionOnce(ctx, "a", "one", "receipt-1")
+				So(err, ShouldBeNil)
+				So(replayed, ShouldBeTrue)
+				So(again.Counter, ShouldEqual, 0)
+			})
+
+			Convey("until the key expires", func() {
+				service.SetIdempotencyWindow(time.Nanosecond)
+				defer service.SetIdempotencyWindow(service.DefaultIdempotencyWindow)
+				_, _, err := service.SignTransactionOnce(ctx, "a", "three", "receipt-3")
+				So(err, ShouldBeNil)
+				time.Sleep(time.Millisecond)
+
+				third, replayed, err := service.SignTransactionOnce(ctx, "a", "other", "receipt-3")
+				So(err, ShouldBeNil)
+				So(replayed, ShouldBeFalse)
+				So(third.Counter, ShouldEqual, 3)
+
+				// the expired record of the key was replaced
+				records, err := persistence.GetInstance().ListIdempotencyRecords("a", time.Time{})
+				So(err, ShouldBeNil)
+				So(records, ShouldHaveLength, 3)
+				So(records[2].Counter, ShouldEqual, 3)
+			})
+
+			Convey("and malformed keys are rejected", func() {
+				_, _, err := service.SignTransactionOnce(ctx, "a", "one", strings.Repeat("k", 256))
+				So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+				_, _, err = service.SignTransactionOnce(ctx, "a", "one", "receipt\n1")
+				So(err, ShouldHaveSameTypeAs, &service.InvalidInputError{})
+			})
+		})
+
+		Convey("signing with an unknown device is not found", func() {
+			_, err := service.SignTransaction(ctx, "b", "one")
+			So(err, ShouldHaveSameTypeAs, &service.NotFoundError{})
+		})
+	})
+}
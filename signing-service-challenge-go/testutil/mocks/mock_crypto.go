@@ -173,3 +173,41 @@ func (mr *MockAlgorithmMockRecorder) Verify(pub, data, signature interface{}) *g
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Verify", reflect.TypeOf((*MockAlgorithm)(nil).Verify), pub, data, signature)
 }
+
+// MockPublicKeyParser is a mock of PublicKeyParser interface.
+type MockPublicKeyParser struct {
+	ctrl     *gomock.Controller
+	recorder *MockPublicKeyParserMockRecorder
+}
+
+// MockPublicKeyParserMockRecorder is the mock recorder for MockPublicKeyParser.
+type MockPublicKeyParserMockRecorder struct {
+	mock *MockPublicKeyParser
+}
+
+// NewMockPublicKeyParser creates a new mock instance.
+func NewMockPublicKeyParser(ctrl *gomock.Controller) *MockPublicKeyParser {
+	mock := &MockPublicKeyParser{ctrl: ctrl}
+	mock.recorder = &MockPublicKeyParserMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPublicKeyParser) EXPECT() *MockPublicKeyParserMockRecorder {
+	return m.recorder
+}
+
+// ParsePublicKey mocks base method.
+func (m *MockPublicKeyParser) ParsePublicKey(pub []byte) (crypto.Key, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ParsePublicKey", pub)
+	ret0, _ := ret[0].(crypto.Key)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ParsePublicKey indicates an expected call of ParsePublicKey.
+func (mr *MockPublicKeyParserMockRecorder) ParsePublicKey(pub interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ParsePublicKey", reflect.TypeOf((*MockPublicKeyParser)(nil).ParsePublicKey), pub)
+}
@@ -6,6 +6,7 @@ package mocks
 
 import (
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	domain "github.com/leledumbo/fiskaly-coding-challenge/signing-service-challenge-go/domain"
@@ -34,6 +35,48 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 	return m.recorder
 }
 
+// CompareAndSave mocks base method.
+func (m *MockStorage) CompareAndSave(id string, data *domain.Device, expectedVersion int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompareAndSave", id, data, expectedVersion)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CompareAndSave indicates an expected call of CompareAndSave.
+func (mr *MockStorageMockRecorder) CompareAndSave(id, data, expectedVersion interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompareAndSave", reflect.TypeOf((*MockStorage)(nil).CompareAndSave), id, data, expectedVersion)
+}
+
+// CompareAndSaveWithTransaction mocks base method.
+func (m *MockStorage) CompareAndSaveWithTransaction(id string, data *domain.Device, expectedVersion int, transaction *domain.Transaction, idempotency *domain.IdempotencyRecord) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompareAndSaveWithTransaction", id, data, expectedVersion, transaction, idempotency)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CompareAndSaveWithTransaction indicates an expected call of CompareAndSaveWithTransaction.
+func (mr *MockStorageMockRecorder) CompareAndSaveWithTransaction(id, data, expectedVersion, transaction, idempotency interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompareAndSaveWithTransaction", reflect.TypeOf((*MockStorage)(nil).CompareAndSaveWithTransaction), id, data, expectedVersion, transaction, idempotency)
+}
+
+// Delete mocks base method.
+func (m *MockStorage) Delete(id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockStorageMockRecorder) Delete(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockStorage)(nil).Delete), id)
+}
+
 // List mocks base method.
 func (m *MockStorage) List() []*domain.Device {
 	m.ctrl.T.Helper()
@@ -48,6 +91,51 @@ func (mr *MockStorageMockRecorder) List() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockStorage)(nil).List))
 }
 
+// ListDevices mocks base method.
+func (m *MockStorage) ListDevices(query domain.DeviceQuery) ([]*domain.Device, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListDevices", query)
+	ret0, _ := ret[0].([]*domain.Device)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListDevices indicates an expected call of ListDevices.
+func (mr *MockStorageMockRecorder) ListDevices(query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListDevices", reflect.TypeOf((*MockStorage)(nil).ListDevices), query)
+}
+
+// ListIdempotencyRecords mocks base method.
+func (m *MockStorage) ListIdempotencyRecords(id string, now time.Time) ([]*domain.IdempotencyRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListIdempotencyRecords", id, now)
+	ret0, _ := ret[0].([]*domain.IdempotencyRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListIdempotencyRecords indicates an expected call of ListIdempotencyRecords.
+func (mr *MockStorageMockRecorder) ListIdempotencyRecords(id, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListIdempotencyRecords", reflect.TypeOf((*MockStorage)(nil).ListIdempotencyRecords), id, now)
+}
+
+// ListTransactions mocks base method.
+func (m *MockStorage) ListTransactions(id string, from, limit int) ([]*domain.Transaction, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListTransactions", id, from, limit)
+	ret0, _ := ret[0].([]*domain.Transaction)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListTransactions indicates an expected call of ListTransactions.
+func (mr *MockStorageMockRecorder) ListTransactions(id, from, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTransactions", reflect.TypeOf((*MockStorage)(nil).ListTransactions), id, from, limit)
+}
+
 // Load mocks base method.
 func (m *MockStorage) Load(id string) (*domain.Device, error) {
 	m.ctrl.T.Helper()
@@ -63,6 +151,36 @@ func (mr *MockStorageMockRecorder) Load(id interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Load", reflect.TypeOf((*MockStorage)(nil).Load), id)
 }
 
+// LoadIdempotencyRecord mocks base method.
+func (m *MockStorage) LoadIdempotencyRecord(id, key string, now time.Time) (*domain.IdempotencyRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadIdempotencyRecord", id, key, now)
+	ret0, _ := ret[0].(*domain.IdempotencyRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadIdempotencyRecord indicates an expected call of LoadIdempotencyRecord.
+func (mr *MockStorageMockRecorder) LoadIdempotencyRecord(id, key, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadIdempotencyRecord", reflect.TypeOf((*MockStorage)(nil).LoadIdempotencyRecord), id, key, now)
+}
+
+// LoadTransaction mocks base method.
+func (m *MockStorage) LoadTransaction(id string, counter int) (*domain.Transaction, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadTransaction", id, counter)
+	ret0, _ := ret[0].(*domain.Transaction)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadTransaction indicates an expected call of LoadTransaction.
+func (mr *MockStorageMockRecorder) LoadTransaction(id, counter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadTransaction", reflect.TypeOf((*MockStorage)(nil).LoadTransaction), id, counter)
+}
+
 // Save mocks base method.
 func (m *MockStorage) Save(id string, data *domain.Device) error {
 	m.ctrl.T.Helper()